@@ -0,0 +1,158 @@
+// Package backoff implements the strategies sinks use to space out the
+// retries of requests that fail.
+package backoff
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff decides how long to wait before retrying something that failed.
+type Backoff interface {
+	// NextDelay returns how long to wait before the retry following the
+	// given (zero-based) attempt.
+	NextDelay(attempt int) time.Duration
+	// Reset forgets the delays returned so far, once what was retried
+	// succeeds. Strategies that don't remember them ignore it.
+	Reset()
+}
+
+// The strategies New knows about.
+const (
+	StrategyConstant           = "constant"
+	StrategyExponential        = "exponential"
+	StrategyFullJitter         = "full-jitter"
+	StrategyDecorrelatedJitter = "decorrelated-jitter"
+)
+
+// New returns the named strategy, with the given base and maximum delays.
+// jitter only applies to StrategyExponential, and the maximum doesn't
+// apply to StrategyConstant. The empty strategy means
+// StrategyExponential.
+func New(strategy string, base, max time.Duration, jitter float64) (Backoff, error) {
+	switch strategy {
+	case StrategyConstant:
+		return Constant{Delay: base}, nil
+	case "", StrategyExponential:
+		return Exponential{Base: base, Max: max, Jitter: jitter}, nil
+	case StrategyFullJitter:
+		return FullJitter{Base: base, Max: max}, nil
+	case StrategyDecorrelatedJitter:
+		return &DecorrelatedJitter{Base: base, Max: max}, nil
+	default:
+		return nil, fmt.Errorf("unknown backoff strategy %q", strategy)
+	}
+}
+
+// Wait blocks until it's time for the retry following the given attempt,
+// according to b, returning early with the context's error if ctx is
+// cancelled.
+func Wait(ctx context.Context, b Backoff, attempt int) error {
+	timer := time.NewTimer(b.NextDelay(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Constant waits the same Delay before every retry.
+type Constant struct {
+	Delay time.Duration
+}
+
+// NextDelay returns Delay.
+func (c Constant) NextDelay(attempt int) time.Duration {
+	return c.Delay
+}
+
+// Reset does nothing.
+func (Constant) Reset() {}
+
+// Exponential waits Base before the first retry, and twice as long before
+// every retry after that, up to Max (zero meaning no cap). If Jitter is
+// set, up to that fraction of every delay (at most all of it) is taken
+// off at random, so that clients failing at the same time don't all retry
+// at the same time.
+type Exponential struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter float64
+}
+
+// NextDelay returns the delay before the retry following attempt.
+func (e Exponential) NextDelay(attempt int) time.Duration {
+	delay := capped(float64(e.Base)*math.Pow(2, float64(attempt)), e.Max)
+	if e.Jitter > 0 {
+		jitter := delay * math.Min(e.Jitter, 1)
+		delay = delay - jitter + rand.Float64()*jitter
+	}
+	return time.Duration(delay)
+}
+
+// Reset does nothing.
+func (Exponential) Reset() {}
+
+// FullJitter waits a random duration of up to the delay Exponential would
+// wait without jitter: it spreads retries out the most.
+type FullJitter struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// NextDelay returns the delay before the retry following attempt.
+func (f FullJitter) NextDelay(attempt int) time.Duration {
+	delay := capped(float64(f.Base)*math.Pow(2, float64(attempt)), f.Max)
+	return time.Duration(rand.Float64() * delay)
+}
+
+// Reset does nothing.
+func (FullJitter) Reset() {}
+
+// DecorrelatedJitter waits a random duration between Base and three times
+// its previous delay, up to Max (zero meaning no cap). Its delays grow
+// like Exponential's on average, but each depends on the last one rather
+// than on the attempt. The previous delay is shared by everything using
+// the same DecorrelatedJitter, until Reset.
+type DecorrelatedJitter struct {
+	Base time.Duration
+	Max  time.Duration
+
+	mtx  sync.Mutex
+	prev time.Duration
+}
+
+// NextDelay returns the delay before the next retry; attempt is ignored.
+func (d *DecorrelatedJitter) NextDelay(attempt int) time.Duration {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	prev := d.prev
+	if prev < d.Base {
+		prev = d.Base
+	}
+	upper := float64(prev) * 3
+	delay := float64(d.Base) + rand.Float64()*(upper-float64(d.Base))
+	d.prev = time.Duration(capped(delay, d.Max))
+	return d.prev
+}
+
+// Reset makes the next delay start from Base again.
+func (d *DecorrelatedJitter) Reset() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.prev = 0
+}
+
+// capped caps delay at max, unless max is zero.
+func capped(delay float64, max time.Duration) float64 {
+	if max > 0 && delay > float64(max) {
+		return float64(max)
+	}
+	return delay
+}
@@ -0,0 +1,83 @@
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstant(t *testing.T) {
+	b := Constant{Delay: 10 * time.Millisecond}
+	for attempt := 0; attempt < 5; attempt++ {
+		assert.Equal(t, 10*time.Millisecond, b.NextDelay(attempt))
+	}
+}
+
+func TestExponential(t *testing.T) {
+	b := Exponential{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, b.NextDelay(0))
+	assert.Equal(t, 20*time.Millisecond, b.NextDelay(1))
+	assert.Equal(t, 40*time.Millisecond, b.NextDelay(2))
+	assert.Equal(t, 50*time.Millisecond, b.NextDelay(3))
+
+	b.Max = 0
+	assert.Equal(t, 80*time.Millisecond, b.NextDelay(3), "no max should mean no cap")
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := b.NextDelay(1)
+		assert.True(t, delay >= 10*time.Millisecond && delay <= 20*time.Millisecond, "delay %v out of bounds", delay)
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	b := FullJitter{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		delay := b.NextDelay(1)
+		assert.True(t, delay >= 0 && delay <= 20*time.Millisecond, "delay %v out of bounds", delay)
+		delay = b.NextDelay(10)
+		assert.True(t, delay >= 0 && delay <= 50*time.Millisecond, "delay %v out of bounds", delay)
+	}
+}
+
+func TestDecorrelatedJitter(t *testing.T) {
+	b := &DecorrelatedJitter{Base: 10 * time.Millisecond, Max: time.Second}
+	prev := b.Base
+	for i := 0; i < 100; i++ {
+		delay := b.NextDelay(i)
+		assert.True(t, delay >= b.Base, "delay %v is below the base", delay)
+		assert.True(t, delay <= 3*prev && delay <= b.Max, "delay %v grew too much from %v", delay, prev)
+		prev = delay
+	}
+
+	b.Reset()
+	assert.True(t, b.NextDelay(0) <= 30*time.Millisecond, "Reset should start again from the base")
+}
+
+func TestNew(t *testing.T) {
+	for strategy, expected := range map[string]Backoff{
+		"":                         Exponential{Base: time.Second, Max: time.Minute, Jitter: 0.1},
+		StrategyExponential:        Exponential{Base: time.Second, Max: time.Minute, Jitter: 0.1},
+		StrategyConstant:           Constant{Delay: time.Second},
+		StrategyFullJitter:         FullJitter{Base: time.Second, Max: time.Minute},
+		StrategyDecorrelatedJitter: &DecorrelatedJitter{Base: time.Second, Max: time.Minute},
+	} {
+		b, err := New(strategy, time.Second, time.Minute, 0.1)
+		require.NoError(t, err)
+		assert.Equal(t, expected, b, "strategy %q", strategy)
+	}
+
+	_, err := New("linear", time.Second, time.Minute, 0)
+	assert.Error(t, err)
+}
+
+func TestWait(t *testing.T) {
+	assert.NoError(t, Wait(context.Background(), Constant{}, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, Wait(ctx, Constant{Delay: time.Hour}, 0))
+}
@@ -1,5 +1,7 @@
 package veneur
 
+import "github.com/stripe/veneur/sinks/generic"
+
 type Config struct {
 	Aggregates                             []string `yaml:"aggregates"`
 	AwsAccessKeyID                         string   `yaml:"aws_access_key_id"`
@@ -14,78 +16,128 @@ type Config struct {
 		MetricPrefix string   `yaml:"metric_prefix"`
 		Tags         []string `yaml:"tags"`
 	} `yaml:"datadog_exclude_tags_prefix_by_prefix_metric"`
-	DatadogFlushMaxPerBody                    int       `yaml:"datadog_flush_max_per_body"`
-	DatadogMetricNamePrefixDrops              []string  `yaml:"datadog_metric_name_prefix_drops"`
-	DatadogSpanBufferSize                     int       `yaml:"datadog_span_buffer_size"`
-	DatadogTraceAPIAddress                    string    `yaml:"datadog_trace_api_address"`
-	Debug                                     bool      `yaml:"debug"`
-	DebugFlushedMetrics                       bool      `yaml:"debug_flushed_metrics"`
-	DebugIngestedSpans                        bool      `yaml:"debug_ingested_spans"`
-	EnableProfiling                           bool      `yaml:"enable_profiling"`
-	FalconerAddress                           string    `yaml:"falconer_address"`
-	FlushFile                                 string    `yaml:"flush_file"`
-	FlushMaxPerBody                           int       `yaml:"flush_max_per_body"`
-	FlushWatchdogMissedFlushes                int       `yaml:"flush_watchdog_missed_flushes"`
-	ForwardAddress                            string    `yaml:"forward_address"`
-	ForwardUseGrpc                            bool      `yaml:"forward_use_grpc"`
-	GenericEndpoint                           string    `yaml:"generic_endpoint"`
-	GenericBatchSize                          int       `yaml:"generic_batch_size"`
-	GenericSource                             string    `yaml:"generic_source"`
-	GenericEnvironment                        string    `yaml:"generic_environment"`
-	GenericNamespace                          string    `yaml:"generic_namespace"`
-	GrpcAddress                               string    `yaml:"grpc_address"`
-	Hostname                                  string    `yaml:"hostname"`
-	HTTPAddress                               string    `yaml:"http_address"`
-	HTTPQuit                                  bool      `yaml:"http_quit"`
-	IndicatorSpanTimerName                    string    `yaml:"indicator_span_timer_name"`
-	Interval                                  string    `yaml:"interval"`
-	KafkaBroker                               string    `yaml:"kafka_broker"`
-	KafkaCheckTopic                           string    `yaml:"kafka_check_topic"`
-	KafkaEventTopic                           string    `yaml:"kafka_event_topic"`
-	KafkaMetricBufferBytes                    int       `yaml:"kafka_metric_buffer_bytes"`
-	KafkaMetricBufferFrequency                string    `yaml:"kafka_metric_buffer_frequency"`
-	KafkaMetricBufferMessages                 int       `yaml:"kafka_metric_buffer_messages"`
-	KafkaMetricRequireAcks                    string    `yaml:"kafka_metric_require_acks"`
-	KafkaMetricTopic                          string    `yaml:"kafka_metric_topic"`
-	KafkaPartitioner                          string    `yaml:"kafka_partitioner"`
-	KafkaRetryMax                             int       `yaml:"kafka_retry_max"`
-	KafkaSpanBufferBytes                      int       `yaml:"kafka_span_buffer_bytes"`
-	KafkaSpanBufferFrequency                  string    `yaml:"kafka_span_buffer_frequency"`
-	KafkaSpanBufferMesages                    int       `yaml:"kafka_span_buffer_mesages"`
-	KafkaSpanRequireAcks                      string    `yaml:"kafka_span_require_acks"`
-	KafkaSpanSampleRatePercent                float64   `yaml:"kafka_span_sample_rate_percent"`
-	KafkaSpanSampleTag                        string    `yaml:"kafka_span_sample_tag"`
-	KafkaSpanSerializationFormat              string    `yaml:"kafka_span_serialization_format"`
-	KafkaSpanTopic                            string    `yaml:"kafka_span_topic"`
-	LightstepAccessToken                      string    `yaml:"lightstep_access_token"`
-	LightstepCollectorHost                    string    `yaml:"lightstep_collector_host"`
-	LightstepMaximumSpans                     int       `yaml:"lightstep_maximum_spans"`
-	LightstepNumClients                       int       `yaml:"lightstep_num_clients"`
-	LightstepReconnectPeriod                  string    `yaml:"lightstep_reconnect_period"`
-	MetricMaxLength                           int       `yaml:"metric_max_length"`
-	MutexProfileFraction                      int       `yaml:"mutex_profile_fraction"`
-	NumReaders                                int       `yaml:"num_readers"`
-	NumSpanWorkers                            int       `yaml:"num_span_workers"`
-	NumWorkers                                int       `yaml:"num_workers"`
-	ObjectiveSpanTimerName                    string    `yaml:"objective_span_timer_name"`
-	OmitEmptyHostname                         bool      `yaml:"omit_empty_hostname"`
-	Percentiles                               []float64 `yaml:"percentiles"`
-	ReadBufferSizeBytes                       int       `yaml:"read_buffer_size_bytes"`
-	SentryDsn                                 string    `yaml:"sentry_dsn"`
-	SignalfxAPIKey                            string    `yaml:"signalfx_api_key"`
-	SignalfxDynamicPerTagAPIKeysEnable        bool      `yaml:"signalfx_dynamic_per_tag_api_keys_enable"`
-	SignalfxDynamicPerTagAPIKeysRefreshPeriod string    `yaml:"signalfx_dynamic_per_tag_api_keys_refresh_period"`
-	SignalfxEndpointAPI                       string    `yaml:"signalfx_endpoint_api"`
-	SignalfxEndpointBase                      string    `yaml:"signalfx_endpoint_base"`
-	SignalfxFlushMaxPerBody                   int       `yaml:"signalfx_flush_max_per_body"`
-	SignalfxHostnameTag                       string    `yaml:"signalfx_hostname_tag"`
-	SignalfxMetricNamePrefixDrops             []string  `yaml:"signalfx_metric_name_prefix_drops"`
-	SignalfxMetricTagPrefixDrops              []string  `yaml:"signalfx_metric_tag_prefix_drops"`
+	DatadogFlushMaxPerBody        int      `yaml:"datadog_flush_max_per_body"`
+	DatadogMetricNamePrefixDrops  []string `yaml:"datadog_metric_name_prefix_drops"`
+	DatadogSpanBufferSize         int      `yaml:"datadog_span_buffer_size"`
+	DatadogTraceAPIAddress        string   `yaml:"datadog_trace_api_address"`
+	Debug                         bool     `yaml:"debug"`
+	DebugFlushedMetrics           bool     `yaml:"debug_flushed_metrics"`
+	DebugIngestedSpans            bool     `yaml:"debug_ingested_spans"`
+	EnableProfiling               bool     `yaml:"enable_profiling"`
+	Exemplars                     bool     `yaml:"exemplars"`
+	FalconerAddress               string   `yaml:"falconer_address"`
+	FileSinkMaxBackups            int      `yaml:"file_sink_max_backups"`
+	FileSinkMaxSize               int64    `yaml:"file_sink_max_size"`
+	FileSinkPath                  string   `yaml:"file_sink_path"`
+	FlushFile                     string   `yaml:"flush_file"`
+	FlushMaxPerBody               int      `yaml:"flush_max_per_body"`
+	FlushSetErrorBounds           bool     `yaml:"flush_set_error_bounds"`
+	FlushWatchdogMissedFlushes    int      `yaml:"flush_watchdog_missed_flushes"`
+	ForwardAddress                string   `yaml:"forward_address"`
+	ForwardUseGrpc                bool     `yaml:"forward_use_grpc"`
+	generic.GenericSinkConfig     `yaml:",inline"`
+	GenericGrpcTarget             string            `yaml:"generic_grpc_target"`
+	GenericGrpcBatchSize          int               `yaml:"generic_grpc_batch_size"`
+	GenericGrpcMaxRetries         int               `yaml:"generic_grpc_max_retries"`
+	GenericGrpcReconnectBaseDelay string            `yaml:"generic_grpc_reconnect_base_delay"`
+	GenericGrpcReconnectMaxDelay  string            `yaml:"generic_grpc_reconnect_max_delay"`
+	GrpcAddress                   string            `yaml:"grpc_address"`
+	HistogramCountAndSum          bool              `yaml:"histogram_count_and_sum"`
+	Hostname                      string            `yaml:"hostname"`
+	HTTPAddress                   string            `yaml:"http_address"`
+	HTTPQuit                      bool              `yaml:"http_quit"`
+	ImportHeaderTags              map[string]string `yaml:"import_header_tags"`
+	IndicatorSpanTimerName        string            `yaml:"indicator_span_timer_name"`
+	InfluxdbAddress               string            `yaml:"influxdb_address"`
+	InfluxdbBatchSize             int               `yaml:"influxdb_batch_size"`
+	InfluxdbDatabase              string            `yaml:"influxdb_database"`
+	InfluxdbMaxRetries            int               `yaml:"influxdb_max_retries"`
+	InfluxdbPrecision             string            `yaml:"influxdb_precision"`
+	InfluxdbRetentionPolicy       string            `yaml:"influxdb_retention_policy"`
+	InfluxdbRetryBaseDelay        string            `yaml:"influxdb_retry_base_delay"`
+	InfluxdbRetryMaxDelay         string            `yaml:"influxdb_retry_max_delay"`
+	Interval                      string            `yaml:"interval"`
+	KafkaBroker                   string            `yaml:"kafka_broker"`
+	KafkaCheckTopic               string            `yaml:"kafka_check_topic"`
+	KafkaEventTopic               string            `yaml:"kafka_event_topic"`
+	KafkaMetricBufferBytes        int               `yaml:"kafka_metric_buffer_bytes"`
+	KafkaMetricBufferFrequency    string            `yaml:"kafka_metric_buffer_frequency"`
+	KafkaMetricBufferMessages     int               `yaml:"kafka_metric_buffer_messages"`
+	KafkaMetricRequireAcks        string            `yaml:"kafka_metric_require_acks"`
+	KafkaMetricTopic              string            `yaml:"kafka_metric_topic"`
+	KafkaPartitioner              string            `yaml:"kafka_partitioner"`
+	KafkaRetryMax                 int               `yaml:"kafka_retry_max"`
+	KafkaSpanBufferBytes          int               `yaml:"kafka_span_buffer_bytes"`
+	KafkaSpanBufferFrequency      string            `yaml:"kafka_span_buffer_frequency"`
+	KafkaSpanBufferMesages        int               `yaml:"kafka_span_buffer_mesages"`
+	KafkaSpanRequireAcks          string            `yaml:"kafka_span_require_acks"`
+	KafkaSpanSampleRatePercent    float64           `yaml:"kafka_span_sample_rate_percent"`
+	KafkaSpanSampleTag            string            `yaml:"kafka_span_sample_tag"`
+	KafkaSpanSerializationFormat  string            `yaml:"kafka_span_serialization_format"`
+	KafkaSpanTopic                string            `yaml:"kafka_span_topic"`
+	LightstepAccessToken          string            `yaml:"lightstep_access_token"`
+	LightstepCollectorHost        string            `yaml:"lightstep_collector_host"`
+	LightstepMaximumSpans         int               `yaml:"lightstep_maximum_spans"`
+	LightstepNumClients           int               `yaml:"lightstep_num_clients"`
+	LightstepReconnectPeriod      string            `yaml:"lightstep_reconnect_period"`
+	LocalOnlyHistograms           []string          `yaml:"local_only_histograms"`
+	MaxSampleAge                  string            `yaml:"max_sample_age"`
+	MetricDescriptions            map[string]string `yaml:"metric_descriptions"`
+	MetricMaxLength               int               `yaml:"metric_max_length"`
+	MetricUnits                   map[string]string `yaml:"metric_units"`
+	MetricSinkDiskBuffers         map[string]struct {
+		Dir     string `yaml:"dir"`
+		MaxSize int64  `yaml:"max_size"`
+	} `yaml:"metric_sink_disk_buffers"`
+	MetricSinkFlushTimeouts map[string]string `yaml:"metric_sink_flush_timeouts"`
+	MetricSinkRateLimits    map[string]struct {
+		Burst int     `yaml:"burst"`
+		Limit float64 `yaml:"limit"`
+	} `yaml:"metric_sink_rate_limits"`
+	MetricSinkSamplingRates                   map[string]map[string]float64 `yaml:"metric_sink_sampling_rates"`
+	MutexProfileFraction                      int                           `yaml:"mutex_profile_fraction"`
+	NumReaders                                int                           `yaml:"num_readers"`
+	NumSpanWorkers                            int                           `yaml:"num_span_workers"`
+	NumWorkers                                int                           `yaml:"num_workers"`
+	ObjectiveSpanTimerName                    string                        `yaml:"objective_span_timer_name"`
+	OmitEmptyHostname                         bool                          `yaml:"omit_empty_hostname"`
+	OtlpBatchSize                             int                           `yaml:"otlp_batch_size"`
+	OtlpEndpoint                              string                        `yaml:"otlp_endpoint"`
+	Percentiles                               []float64                     `yaml:"percentiles"`
+	PercentilesByMetric                       map[string][]float64          `yaml:"percentiles_by_metric"`
+	PrometheusRemoteWriteBatchSize            int                           `yaml:"prometheus_remote_write_batch_size"`
+	PrometheusRemoteWriteCounterMode          string                        `yaml:"prometheus_remote_write_counter_mode"`
+	PrometheusRemoteWriteEndpoint             string                        `yaml:"prometheus_remote_write_endpoint"`
+	PrometheusRemoteWriteMaxRetries           int                           `yaml:"prometheus_remote_write_max_retries"`
+	PrometheusRemoteWriteRetryBaseDelay       string                        `yaml:"prometheus_remote_write_retry_base_delay"`
+	PrometheusRemoteWriteRetryMaxDelay        string                        `yaml:"prometheus_remote_write_retry_max_delay"`
+	PrometheusScrapeAddress                   string                        `yaml:"prometheus_scrape_address"`
+	PrometheusScrapePath                      string                        `yaml:"prometheus_scrape_path"`
+	RateGauges                                []string                      `yaml:"rate_gauges"`
+	ReadBufferSizeBytes                       int                           `yaml:"read_buffer_size_bytes"`
+	S3SinkAccessKeyID                         string                        `yaml:"s3_sink_access_key_id"`
+	S3SinkBucket                              string                        `yaml:"s3_sink_bucket"`
+	S3SinkFormat                              string                        `yaml:"s3_sink_format"`
+	S3SinkPrefix                              string                        `yaml:"s3_sink_prefix"`
+	S3SinkRegion                              string                        `yaml:"s3_sink_region"`
+	S3SinkSecretAccessKey                     string                        `yaml:"s3_sink_secret_access_key"`
+	S3SinkTimestampFormat                     string                        `yaml:"s3_sink_timestamp_format"`
+	SentryDsn                                 string                        `yaml:"sentry_dsn"`
+	SignalfxAPIKey                            string                        `yaml:"signalfx_api_key"`
+	SignalfxDynamicPerTagAPIKeysEnable        bool                          `yaml:"signalfx_dynamic_per_tag_api_keys_enable"`
+	SignalfxDynamicPerTagAPIKeysRefreshPeriod string                        `yaml:"signalfx_dynamic_per_tag_api_keys_refresh_period"`
+	SignalfxEndpointAPI                       string                        `yaml:"signalfx_endpoint_api"`
+	SignalfxEndpointBase                      string                        `yaml:"signalfx_endpoint_base"`
+	SignalfxFlushMaxPerBody                   int                           `yaml:"signalfx_flush_max_per_body"`
+	SignalfxHostnameTag                       string                        `yaml:"signalfx_hostname_tag"`
+	SignalfxMetricNamePrefixDrops             []string                      `yaml:"signalfx_metric_name_prefix_drops"`
+	SignalfxMetricTagPrefixDrops              []string                      `yaml:"signalfx_metric_tag_prefix_drops"`
 	SignalfxPerTagAPIKeys                     []struct {
 		APIKey string `yaml:"api_key"`
 		Name   string `yaml:"name"`
 	} `yaml:"signalfx_per_tag_api_keys"`
 	SignalfxVaryKeyBy                 string   `yaml:"signalfx_vary_key_by"`
+	SketchHistograms                  []string `yaml:"sketch_histograms"`
 	SpanChannelCapacity               int      `yaml:"span_channel_capacity"`
 	SplunkHecAddress                  string   `yaml:"splunk_hec_address"`
 	SplunkHecBatchSize                int      `yaml:"splunk_hec_batch_size"`
@@ -229,3 +229,28 @@ func (c *Config) applyDefaults() {
 func (c Config) ParseInterval() (time.Duration, error) {
 	return time.ParseDuration(c.Interval)
 }
+
+// redacted returns a copy of the config that is safe to log, with
+// credentials replaced by REDACTED. The generic sink's redaction salt
+// counts as one, since hashes of low-entropy tag values are easy to
+// reverse with it.
+func (c Config) redacted() Config {
+	for _, secret := range []*string{
+		&c.SentryDsn,
+		&c.TLSKey,
+		&c.DatadogAPIKey,
+		&c.SignalfxAPIKey,
+		&c.LightstepAccessToken,
+		&c.AwsAccessKeyID,
+		&c.AwsSecretAccessKey,
+		&c.S3SinkAccessKeyID,
+		&c.S3SinkSecretAccessKey,
+		&c.GenericBearerToken,
+		&c.GenericBasicAuthPassword,
+		&c.GenericSigningSecret,
+		&c.GenericRedactionSalt,
+	} {
+		*secret = REDACTED
+	}
+	return c
+}
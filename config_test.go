@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/sinks/generic"
 )
 
 func TestReadConfig(t *testing.T) {
@@ -176,3 +178,39 @@ trace_lightstep_num_clients: 2
 	assert.Equal(t, 1, c.LightstepMaximumSpans)
 	assert.Equal(t, 2, c.LightstepNumClients)
 }
+
+func TestReadConfigGenericSink(t *testing.T) {
+	c, err := readConfig(strings.NewReader(`
+generic_endpoint: "http://localhost:8080/metrics"
+generic_batch_size: 500
+generic_routes:
+  - metric_prefix: "api."
+    endpoint: "http://localhost:8080/api"
+generic_grpc_target: "localhost:9090"
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/metrics", c.GenericEndpoint)
+	assert.Equal(t, 500, c.GenericBatchSize)
+	require.Len(t, c.GenericRoutes, 1)
+	assert.Equal(t, "api.", c.GenericRoutes[0].MetricPrefix)
+	assert.Equal(t, "localhost:9090", c.GenericGrpcTarget)
+}
+
+func TestConfigRedacted(t *testing.T) {
+	c := Config{GenericSinkConfig: generic.GenericSinkConfig{
+		GenericBearerToken:       "hunter2",
+		GenericBasicAuthUsername: "admin",
+		GenericBasicAuthPassword: "hunter3",
+		GenericSigningSecret:     "hunter4",
+		GenericRedactionSalt:     "hunter5",
+	}}
+	c.DatadogAPIKey = "hunter6"
+	redacted := c.redacted()
+	assert.Equal(t, REDACTED, redacted.GenericBearerToken)
+	assert.Equal(t, "admin", redacted.GenericBasicAuthUsername)
+	assert.Equal(t, REDACTED, redacted.GenericBasicAuthPassword)
+	assert.Equal(t, REDACTED, redacted.GenericSigningSecret)
+	assert.Equal(t, REDACTED, redacted.GenericRedactionSalt)
+	assert.Equal(t, REDACTED, redacted.DatadogAPIKey)
+	assert.Equal(t, "hunter2", c.GenericBearerToken, "redacting must not modify the original config")
+}
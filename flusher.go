@@ -102,17 +102,22 @@ func (s *Server) Flush(ctx context.Context) {
 		return
 	}
 
+	// only the sinks that can flush sketches get them
+	withoutSketches := sinks.WithoutSketches(finalMetrics)
 	for _, sink := range s.metricSinks {
 		wg.Add(1)
 		go func(ms sinks.MetricSink) {
-			err := ms.Flush(span.Attach(ctx), finalMetrics)
-			if err != nil {
-				log.WithError(err).WithField("sink", ms.Name()).Warn("Error flushing sink")
+			if sinks.FlushesSketches(ms) {
+				s.flushSink(span.Attach(ctx), ms, finalMetrics)
+			} else {
+				s.flushSink(span.Attach(ctx), ms, withoutSketches)
 			}
 			wg.Done()
 		}(sink)
 	}
 	wg.Wait()
+	// plugins can't flush sketches either
+	finalMetrics = withoutSketches
 
 	go func() {
 		samples := &ssf.Samples{}
@@ -131,6 +136,32 @@ func (s *Server) Flush(ctx context.Context) {
 	}()
 }
 
+// flushSink flushes metrics to a single metric sink, giving up once the
+// sink's configured flush timeout, if any, has passed.
+func (s *Server) flushSink(ctx context.Context, sink sinks.MetricSink, metrics []samplers.InterMetric) error {
+	timeout, ok := s.metricSinkFlushTimeouts[sink.Name()]
+	if ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := sink.Flush(ctx, metrics)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded && ok {
+		s.Statsd.Count("flush.sink_timeouts_total", 1, []string{fmt.Sprintf("sink:%s", sink.Name())}, 1.0)
+		log.WithError(err).WithFields(logrus.Fields{
+			"sink":    sink.Name(),
+			"timeout": timeout,
+		}).Warn("Timed out flushing sink")
+		return err
+	}
+	log.WithError(err).WithField("sink", sink.Name()).Warn("Error flushing sink")
+	return err
+}
+
 func (s *Server) tallyTimeseries() int64 {
 	allTimeseries := hyperloglog.New()
 	for _, w := range s.Workers {
@@ -167,34 +198,7 @@ type metricsSummary struct {
 // a slice of the correct length instead of constantly appending
 // for performance
 func (s *Server) tallyMetrics(percentiles []samplers.Percentile) ([]WorkerMetrics, metricsSummary) {
-	// allocating this long array to count up the sizes is cheaper than appending
-	// the []WorkerMetrics together one at a time
-	tempMetrics := make([]WorkerMetrics, 0, len(s.Workers))
-
-	ms := metricsSummary{}
-
-	for i, w := range s.Workers {
-		log.WithField("worker", i).Debug("Flushing")
-		wm := w.Flush()
-		tempMetrics = append(tempMetrics, wm)
-
-		ms.totalCounters += len(wm.counters)
-		ms.totalGauges += len(wm.gauges)
-		ms.totalHistograms += len(wm.histograms)
-		ms.totalSets += len(wm.sets)
-		ms.totalTimers += len(wm.timers)
-
-		ms.totalGlobalCounters += len(wm.globalCounters)
-		ms.totalGlobalGauges += len(wm.globalGauges)
-		ms.totalGlobalHistograms += len(wm.globalHistograms)
-		ms.totalGlobalTimers += len(wm.globalTimers)
-
-		ms.totalLocalHistograms += len(wm.localHistograms)
-		ms.totalLocalSets += len(wm.localSets)
-		ms.totalLocalTimers += len(wm.localTimers)
-
-		ms.totalLocalStatusChecks += len(wm.localStatusChecks)
-	}
+	tempMetrics, ms := s.Workers.Flush()
 
 	ms.totalLength = ms.totalCounters + ms.totalGauges +
 		// histograms and timers each report a metric point for each percentile
@@ -234,40 +238,46 @@ func (s *Server) generateInterMetrics(
 	defer span.ClientFinish(s.TraceClient)
 
 	finalMetrics := make([]samplers.InterMetric, 0, ms.totalLength)
+	if len(s.RateGauges) > 0 {
+		s.gaugeRates.startFlush()
+	}
 	for _, wm := range tempMetrics {
-		for _, c := range wm.counters {
-			finalMetrics = append(finalMetrics, c.Flush(s.interval)...)
+		for key, c := range wm.counters {
+			finalMetrics = append(finalMetrics, s.annotate(wm, key, s.describe(c.Name, c.Flush(s.interval)))...)
 		}
-		for _, g := range wm.gauges {
-			finalMetrics = append(finalMetrics, g.Flush()...)
+		for key, g := range wm.gauges {
+			finalMetrics = append(finalMetrics, s.withRate(key, s.annotate(wm, key, s.describe(g.Name, g.Flush())))...)
 		}
 		// if we're a local veneur, then percentiles=nil, and only the local
 		// parts (count, min, max) will be flushed
 		//
 		// if we're a global veneur, aggregates will be nil.
-		for _, h := range wm.histograms {
-			finalMetrics = append(finalMetrics, h.Flush(s.interval, percentiles, s.HistogramAggregates, false)...)
+		for key, h := range wm.histograms {
+			finalMetrics = append(finalMetrics, s.annotate(wm, key, s.flushHistogram(h, percentiles, false))...)
 		}
-		for _, t := range wm.timers {
-			finalMetrics = append(finalMetrics, t.Flush(s.interval, percentiles, s.HistogramAggregates, false)...)
+		for key, t := range wm.timers {
+			finalMetrics = append(finalMetrics, s.annotate(wm, key, s.flushHistogram(t, percentiles, false))...)
 		}
 
 		// local-only samplers should be flushed in their entirety, since they
 		// will not be forwarded
 		// we still want percentiles for these, even if we're a local veneur, so
 		// we use the original percentile list when flushing them
-		for _, h := range wm.localHistograms {
-			finalMetrics = append(finalMetrics, h.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, false)...)
+		for key, h := range wm.localHistograms {
+			finalMetrics = append(finalMetrics, s.annotate(wm, key, s.flushHistogram(h, s.HistogramPercentiles, false))...)
 		}
-		for _, s := range wm.localSets {
-			finalMetrics = append(finalMetrics, s.Flush()...)
+		for _, set := range wm.localSets {
+			finalMetrics = append(finalMetrics, s.describe(set.Name, set.Flush())...)
+			if s.FlushSetErrorBounds {
+				finalMetrics = append(finalMetrics, set.FlushErrorBound()...)
+			}
 		}
-		for _, t := range wm.localTimers {
-			finalMetrics = append(finalMetrics, t.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, false)...)
+		for key, t := range wm.localTimers {
+			finalMetrics = append(finalMetrics, s.annotate(wm, key, s.flushHistogram(t, s.HistogramPercentiles, false))...)
 		}
 
 		for _, status := range wm.localStatusChecks {
-			finalMetrics = append(finalMetrics, status.Flush()...)
+			finalMetrics = append(finalMetrics, s.describe(status.Name, status.Flush())...)
 		}
 
 		// TODO (aditya) refactor this out so we don't
@@ -275,27 +285,30 @@ func (s *Server) generateInterMetrics(
 		if !s.IsLocal() {
 			// sets have no local parts, so if we're a local veneur, there's
 			// nothing to flush at all
-			for _, s := range wm.sets {
-				finalMetrics = append(finalMetrics, s.Flush()...)
+			for _, set := range wm.sets {
+				finalMetrics = append(finalMetrics, s.describe(set.Name, set.Flush())...)
+				if s.FlushSetErrorBounds {
+					finalMetrics = append(finalMetrics, set.FlushErrorBound()...)
+				}
 			}
 
 			// also do this for global counters
 			// global counters have no local parts, so if we're a local veneur,
 			// there's nothing to flush
-			for _, gc := range wm.globalCounters {
-				finalMetrics = append(finalMetrics, gc.Flush(s.interval)...)
+			for key, gc := range wm.globalCounters {
+				finalMetrics = append(finalMetrics, s.annotate(wm, key, s.describe(gc.Name, gc.Flush(s.interval)))...)
 			}
 
 			// and global gauges
-			for _, gg := range wm.globalGauges {
-				finalMetrics = append(finalMetrics, gg.Flush()...)
+			for key, gg := range wm.globalGauges {
+				finalMetrics = append(finalMetrics, s.withRate(key, s.annotate(wm, key, s.describe(gg.Name, gg.Flush())))...)
 			}
 
-			for _, h := range wm.globalHistograms {
-				finalMetrics = append(finalMetrics, h.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, true)...)
+			for key, h := range wm.globalHistograms {
+				finalMetrics = append(finalMetrics, s.annotate(wm, key, s.flushHistogram(h, s.HistogramPercentiles, true))...)
 			}
-			for _, h := range wm.globalTimers {
-				finalMetrics = append(finalMetrics, h.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, true)...)
+			for key, h := range wm.globalTimers {
+				finalMetrics = append(finalMetrics, s.annotate(wm, key, s.flushHistogram(h, s.HistogramPercentiles, true))...)
 			}
 		}
 	}
@@ -303,6 +316,72 @@ func (s *Server) generateInterMetrics(
 	return finalMetrics
 }
 
+// flushHistogram flushes a histogram or timer with the given percentiles,
+// or the ones configured for it. Histograms in SketchHistograms are
+// flushed with their t-digest instead, wherever percentiles would be
+// computed from it: not on a local veneur, which forwards its digests.
+func (s *Server) flushHistogram(h *samplers.Histo, percentiles []samplers.Percentile, global bool) []samplers.InterMetric {
+	percentiles = s.percentilesFor(h.Name, percentiles)
+	if _, ok := s.SketchHistograms[h.Name]; !ok || len(percentiles) == 0 {
+		return s.describe(h.Name, h.Flush(s.interval, percentiles, s.HistogramAggregates, global))
+	}
+	metrics := h.Flush(s.interval, nil, s.HistogramAggregates, global)
+	sketch, err := h.FlushSketch()
+	if err != nil {
+		log.WithError(err).WithField("histogram", h.Name).Error("Could not flush histogram sketch")
+		return s.describe(h.Name, metrics)
+	}
+	return s.describe(h.Name, append(metrics, sketch...))
+}
+
+// percentilesFor returns the percentiles the named histogram or timer
+// should be flushed with: those configured for it specifically, if any,
+// or else the given defaults. Overrides never apply when the defaults are
+// empty, since that means the percentiles will be computed elsewhere.
+func (s *Server) percentilesFor(name string, defaults []samplers.Percentile) []samplers.Percentile {
+	if len(defaults) == 0 {
+		return defaults
+	}
+	if percentiles, ok := s.HistogramPercentilesByMetric[name]; ok {
+		return percentiles
+	}
+	return defaults
+}
+
+// describe sets the description configured for the named sampler on the
+// metrics it flushed.
+func (s *Server) describe(name string, metrics []samplers.InterMetric) []samplers.InterMetric {
+	if description, ok := s.MetricDescriptions[name]; ok {
+		for i := range metrics {
+			metrics[i].Description = description
+		}
+	}
+	return metrics
+}
+
+// annotate sets the exemplar and unit of the metric whose sampler flushed
+// metrics. The unit is the one the metric's samples were sent with, or
+// else the one configured for its name; histogram and timer counts, which
+// count samples, get none.
+func (s *Server) annotate(wm WorkerMetrics, mk samplers.MetricKey, metrics []samplers.InterMetric) []samplers.InterMetric {
+	metrics = wm.withExemplar(mk, metrics)
+	unit, ok := wm.units[mk]
+	if !ok {
+		unit = s.MetricUnits[mk.Name]
+	}
+	if unit == "" {
+		return metrics
+	}
+	sampled := mk.Type == histogramTypeName || mk.Type == timerTypeName
+	for i := range metrics {
+		if sampled && metrics[i].Type == samplers.CounterMetric {
+			continue
+		}
+		metrics[i].Unit = unit
+	}
+	return metrics
+}
+
 const flushTotalMetric = "worker.metrics_flushed_total"
 
 // reportMetricsFlushCounts reports the counts of
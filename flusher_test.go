@@ -2,6 +2,9 @@ package veneur
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,10 +12,13 @@ import (
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/internal/forwardtest"
 	"github.com/stripe/veneur/samplers/metricpb"
+	"github.com/stripe/veneur/sinks/generic"
+	"github.com/stripe/veneur/tdigest"
 )
 
 func TestServerFlushGRPC(t *testing.T) {
@@ -246,3 +252,272 @@ func TestTallyTimeseries(t *testing.T) {
 	summary := f.server.tallyTimeseries()
 	assert.Equal(t, int64(2), summary)
 }
+
+func TestFlushPercentilesByMetric(t *testing.T) {
+	cfg := globalConfig()
+	cfg.PercentilesByMetric = map[string][]float64{"a.b.c": {0.5, 0.999}}
+	s, err := NewFromConfig(logrus.New(), cfg)
+	require.NoError(t, err)
+
+	wm := NewWorkerMetrics()
+	for _, name := range []string{"a.b.c", "a.b.d"} {
+		key := samplers.MetricKey{Name: name, Type: histogramTypeName}
+		wm.Upsert(key, samplers.LocalOnly, nil)
+		wm.localHistograms[key].Sample(1.0, 1.0)
+	}
+
+	var names []string
+	for _, m := range s.generateInterMetrics(context.Background(), s.HistogramPercentiles, s.HistogramAggregates, []WorkerMetrics{wm}, metricsSummary{}) {
+		if strings.HasSuffix(m.Name, "percentile") {
+			names = append(names, m.Name)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"a.b.c.50percentile",
+		"a.b.c.999percentile",
+		"a.b.d.50percentile",
+		"a.b.d.75percentile",
+		"a.b.d.99percentile",
+	}, names)
+}
+
+func TestFlushMetricDescriptions(t *testing.T) {
+	cfg := globalConfig()
+	cfg.MetricDescriptions = map[string]string{"a.b.c": "How long requests took."}
+	s, err := NewFromConfig(logrus.New(), cfg)
+	require.NoError(t, err)
+
+	wm := NewWorkerMetrics()
+	for _, name := range []string{"a.b.c", "a.b.d"} {
+		key := samplers.MetricKey{Name: name, Type: histogramTypeName}
+		wm.Upsert(key, samplers.LocalOnly, nil)
+		wm.localHistograms[key].Sample(1.0, 1.0)
+	}
+
+	metrics := s.generateInterMetrics(context.Background(), s.HistogramPercentiles, s.HistogramAggregates, []WorkerMetrics{wm}, metricsSummary{})
+	require.NotEmpty(t, metrics)
+	for _, m := range metrics {
+		if strings.HasPrefix(m.Name, "a.b.c.") {
+			assert.Equal(t, "How long requests took.", m.Description, "%s should be described", m.Name)
+		} else {
+			assert.Empty(t, m.Description, "%s shouldn't be described", m.Name)
+		}
+	}
+}
+
+func TestFlushExemplars(t *testing.T) {
+	s, err := NewFromConfig(logrus.New(), globalConfig())
+	require.NoError(t, err)
+
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.Exemplars = true
+	for _, sample := range []struct {
+		value   float64
+		traceID int64
+	}{{100, 0}, {5, 1}, {30, 2}, {10, 3}} {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: timerTypeName},
+			Value:      sample.value,
+			SampleRate: 1.0,
+			Scope:      samplers.LocalOnly,
+			TraceID:    sample.traceID,
+		})
+	}
+	w.ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.d", Type: counterTypeName},
+		Value:      1.0,
+		SampleRate: 1.0,
+	})
+
+	metrics := s.generateInterMetrics(context.Background(), s.HistogramPercentiles, s.HistogramAggregates, []WorkerMetrics{w.Flush()}, metricsSummary{})
+	require.NotEmpty(t, metrics)
+	for _, m := range metrics {
+		if strings.HasPrefix(m.Name, "a.b.c.") {
+			assert.Equal(t, int64(2), m.Exemplar, "%s should link to the trace of its largest sample sent with a span", m.Name)
+		} else {
+			assert.Zero(t, m.Exemplar, "%s wasn't sent with a span", m.Name)
+		}
+	}
+}
+
+func TestFlushExemplarsOff(t *testing.T) {
+	s, err := NewFromConfig(logrus.New(), globalConfig())
+	require.NoError(t, err)
+
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName},
+		Value:      1.0,
+		SampleRate: 1.0,
+		TraceID:    1,
+	})
+	for _, m := range s.generateInterMetrics(context.Background(), s.HistogramPercentiles, s.HistogramAggregates, []WorkerMetrics{w.Flush()}, metricsSummary{}) {
+		assert.Zero(t, m.Exemplar, "exemplars should be off by default")
+	}
+}
+
+func TestFlushUnits(t *testing.T) {
+	cfg := globalConfig()
+	cfg.MetricUnits = map[string]string{"a.b.c": "seconds", "a.b.d": "requests"}
+	s, err := NewFromConfig(logrus.New(), cfg)
+	require.NoError(t, err)
+
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: timerTypeName},
+		Value:      1.0,
+		SampleRate: 1.0,
+		Scope:      samplers.LocalOnly,
+		Unit:       "milliseconds",
+	})
+	for _, name := range []string{"a.b.d", "a.b.e"} {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: counterTypeName},
+			Value:      1.0,
+			SampleRate: 1.0,
+		})
+	}
+
+	metrics := s.generateInterMetrics(context.Background(), s.HistogramPercentiles, s.HistogramAggregates, []WorkerMetrics{w.Flush()}, metricsSummary{})
+	require.NotEmpty(t, metrics)
+	for _, m := range metrics {
+		switch {
+		case m.Name == "a.b.c.count":
+			assert.Empty(t, m.Unit, "a timer's count shouldn't have its unit")
+		case strings.HasPrefix(m.Name, "a.b.c."):
+			assert.Equal(t, "milliseconds", m.Unit, "%s should have the unit it was sent with, over the configured one", m.Name)
+		case m.Name == "a.b.d":
+			assert.Equal(t, "requests", m.Unit, "a.b.d should have its configured unit")
+		default:
+			assert.Empty(t, m.Unit, "%s has no unit", m.Name)
+		}
+	}
+}
+
+func TestFlushSketchHistograms(t *testing.T) {
+	cfg := globalConfig()
+	cfg.SketchHistograms = []string{"a.b.c"}
+	s, err := NewFromConfig(logrus.New(), cfg)
+	require.NoError(t, err)
+
+	wm := NewWorkerMetrics()
+	for _, name := range []string{"a.b.c", "a.b.d"} {
+		key := samplers.MetricKey{Name: name, Type: histogramTypeName}
+		wm.Upsert(key, samplers.LocalOnly, nil)
+		wm.localHistograms[key].Sample(1.0, 1.0)
+		wm.localHistograms[key].Sample(3.0, 1.0)
+	}
+
+	metrics := s.generateInterMetrics(context.Background(), s.HistogramPercentiles, s.HistogramAggregates, []WorkerMetrics{wm}, metricsSummary{})
+	var sketches, percentiles []string
+	for _, m := range metrics {
+		if m.Type == samplers.SketchMetric {
+			sketches = append(sketches, m.Name)
+			assert.Equal(t, float64(2), m.Value)
+			var data tdigest.MergingDigestData
+			require.NoError(t, data.Unmarshal(m.Sketch))
+			assert.Equal(t, float64(2), tdigest.NewMergingFromData(&data).Quantile(0.5))
+		}
+		if strings.HasSuffix(m.Name, "percentile") {
+			percentiles = append(percentiles, m.Name)
+		}
+	}
+	assert.Equal(t, []string{"a.b.c"}, sketches)
+	require.NotEmpty(t, percentiles)
+	for _, name := range percentiles {
+		assert.True(t, strings.HasPrefix(name, "a.b.d."), "%s shouldn't be flushed, a.b.c is sketched", name)
+	}
+}
+
+func TestFlushSetErrorBounds(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cfg := globalConfig()
+		cfg.FlushSetErrorBounds = enabled
+		s, err := NewFromConfig(logrus.New(), cfg)
+		require.NoError(t, err)
+
+		wm := NewWorkerMetrics()
+		key := samplers.MetricKey{Name: "a.b.c", Type: setTypeName}
+		wm.Upsert(key, samplers.LocalOnly, []string{"foo:bar"})
+		wm.localSets[key].Sample("fnord")
+
+		metrics := s.generateInterMetrics(context.Background(), s.HistogramPercentiles, s.HistogramAggregates, []WorkerMetrics{wm}, metricsSummary{})
+		if !enabled {
+			assert.Len(t, metrics, 1, "only the set's estimate should be flushed")
+			continue
+		}
+		if assert.Len(t, metrics, 2) {
+			assert.Equal(t, "a.b.c.cardinality_error_percent", metrics[1].Name)
+			assert.InDelta(t, 0.8125, metrics[1].Value, 0.0001)
+			assert.Equal(t, []string{"foo:bar"}, metrics[1].Tags)
+		}
+	}
+}
+
+func TestFlushHistogramCountAndSum(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cfg := globalConfig()
+		cfg.HistogramCountAndSum = enabled
+		s, err := NewFromConfig(logrus.New(), cfg)
+		require.NoError(t, err)
+
+		w := NewWorker(1, true, false, nil, logrus.New(), nil)
+		for _, value := range []float64{-2, 2, 3, -3} {
+			w.ProcessMetric(&samplers.UDPMetric{
+				MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: histogramTypeName},
+				Value:      value,
+				SampleRate: 1.0,
+				Scope:      samplers.LocalOnly,
+			})
+		}
+
+		flushed := map[string]samplers.InterMetric{}
+		for _, m := range s.generateInterMetrics(context.Background(), s.HistogramPercentiles, s.HistogramAggregates, []WorkerMetrics{w.Flush()}, metricsSummary{}) {
+			flushed[m.Name] = m
+		}
+		if !enabled {
+			assert.NotContains(t, flushed, "a.b.c.sum", "sum isn't in the configured aggregates")
+			continue
+		}
+		if assert.Contains(t, flushed, "a.b.c.count") {
+			assert.Equal(t, float64(4), flushed["a.b.c.count"].Value)
+			assert.Equal(t, samplers.CounterMetric, flushed["a.b.c.count"].Type)
+		}
+		if assert.Contains(t, flushed, "a.b.c.sum", "the sum should be flushed even if it's zero") {
+			assert.Equal(t, float64(0), flushed["a.b.c.sum"].Value)
+			assert.Equal(t, samplers.GaugeMetric, flushed["a.b.c.sum"].Type)
+		}
+		assert.Contains(t, flushed, "a.b.c.min", "the configured aggregates should still be flushed")
+	}
+}
+
+func TestFlushSinkTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer endpoint.Close()
+	defer close(unblock)
+
+	cfg := globalConfig()
+	cfg.MetricSinkFlushTimeouts = map[string]string{"generic": "20ms"}
+	s, err := NewFromConfig(logrus.New(), cfg)
+	require.NoError(t, err)
+
+	sink, err := generic.NewGenericMetricSinkFromConfig(logrus.New(), &http.Client{}, nil, "", generic.GenericSinkConfig{
+		GenericEndpoint:  endpoint.URL,
+		GenericBatchSize: 10,
+	})
+	require.NoError(t, err)
+
+	metrics := []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: time.Now().Unix(),
+		Value:     1,
+		Type:      samplers.CounterMetric,
+	}}
+	start := time.Now()
+	err = s.flushSink(context.Background(), sink, metrics)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second, "the sink should have given up after its timeout")
+}
@@ -65,6 +65,7 @@ func handleImport(s *Server) http.Handler {
 			span.Add(ssf.Count("import.unmarshal.errors_total", 1, nil))
 			return
 		}
+		s.tagFromHeaders(jsonMetrics, r.Header)
 		// the server usually waits for this to return before finalizing the
 		// response, so this part must be done asynchronously
 		go s.ImportMetrics(span.Attach(ctx), jsonMetrics)
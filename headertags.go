@@ -0,0 +1,55 @@
+package veneur
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/stripe/veneur/samplers"
+)
+
+// tagFromHeaders tags the metrics of an /import request with the values
+// of the request's headers in ImportHeaderTags, e.g. to tell which
+// service sent them. A metric that already has a tag with one of the keys
+// keeps its own. The tags become part of the metrics' keys, so that
+// metrics sent with different headers are aggregated separately.
+func (s *Server) tagFromHeaders(jsonMetrics []samplers.JSONMetric, header http.Header) {
+	if len(s.ImportHeaderTags) == 0 {
+		return
+	}
+	var headerTags []string
+	for name, key := range s.ImportHeaderTags {
+		if value := header.Get(name); value != "" {
+			headerTags = append(headerTags, key+":"+value)
+		}
+	}
+	if len(headerTags) == 0 {
+		return
+	}
+
+	for i := range jsonMetrics {
+		metric := &jsonMetrics[i]
+		tags := metric.Tags
+		for _, tag := range headerTags {
+			if !hasTagKey(metric.Tags, tag[:strings.IndexByte(tag, ':')]) {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) == len(metric.Tags) {
+			continue
+		}
+		sort.Strings(tags)
+		metric.Tags = tags
+		metric.JoinedTags = strings.Join(tags, ",")
+	}
+}
+
+// hasTagKey returns whether tags has a tag with the given key.
+func hasTagKey(tags []string, key string) bool {
+	for _, tag := range tags {
+		if tag == key || strings.HasPrefix(tag, key+":") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package veneur
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestTagFromHeaders(t *testing.T) {
+	s := &Server{ImportHeaderTags: map[string]string{
+		"X-Service-Name": "service",
+		"X-Region":       "region",
+	}}
+	header := http.Header{}
+	header.Set("X-Service-Name", "checkout")
+
+	jsonMetrics := []samplers.JSONMetric{
+		{MetricKey: samplers.MetricKey{Name: "a", Type: "counter", JoinedTags: "zone:b"}, Tags: []string{"zone:b"}},
+		{MetricKey: samplers.MetricKey{Name: "b", Type: "counter", JoinedTags: "service:cart"}, Tags: []string{"service:cart"}},
+		{MetricKey: samplers.MetricKey{Name: "c", Type: "counter"}},
+	}
+	s.tagFromHeaders(jsonMetrics, header)
+
+	assert.Equal(t, []string{"service:checkout", "zone:b"}, jsonMetrics[0].Tags, "tags should stay sorted")
+	assert.Equal(t, "service:checkout,zone:b", jsonMetrics[0].JoinedTags)
+	assert.Equal(t, []string{"service:cart"}, jsonMetrics[1].Tags, "a metric's own tags shouldn't be overridden")
+	assert.Equal(t, "service:cart", jsonMetrics[1].JoinedTags)
+	assert.Equal(t, []string{"service:checkout"}, jsonMetrics[2].Tags)
+	assert.Equal(t, "service:checkout", jsonMetrics[2].JoinedTags)
+}
+
+func TestImportHeaderTags(t *testing.T) {
+	config := globalConfig()
+	config.ImportHeaderTags = map[string]string{"x-service-name": "service"}
+	metricsChan := make(chan []samplers.InterMetric, 10)
+	cms, _ := NewChannelMetricSink(metricsChan)
+	f := newFixture(t, config, cms, nil)
+	defer f.Close()
+
+	counter := samplers.NewCounter("a.b.c", []string{"foo:bar"})
+	counter.Sample(5, 1.0)
+	jsonMetric, err := counter.Export()
+	require.NoError(t, err)
+	body, err := json.Marshal([]samplers.JSONMetric{jsonMetric})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/import", bytes.NewReader(body))
+	r.Header.Set("X-Service-Name", "checkout")
+	w := httptest.NewRecorder()
+	handleImport(f.server).ServeHTTP(w, r)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	// the import is processed asynchronously, so flush until it shows up
+	deadline := time.After(5 * time.Second)
+	for {
+		f.server.Flush(context.TODO())
+		select {
+		case metrics := <-metricsChan:
+			for _, metric := range metrics {
+				if metric.Name == "a.b.c" {
+					assert.Equal(t, float64(5), metric.Value)
+					assert.Equal(t, []string{"foo:bar", "service:checkout"}, metric.Tags)
+					return
+				}
+			}
+		case <-deadline:
+			t.Fatal("the imported metric was never flushed")
+		}
+	}
+}
+
+func TestImportHeaderTagsConfig(t *testing.T) {
+	config := globalConfig()
+	config.ImportHeaderTags = map[string]string{"X-Service-Name": ""}
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
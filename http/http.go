@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptrace"
@@ -141,6 +142,43 @@ func (tripper *TraceRoundTripper) RoundTrip(req *http.Request) (*http.Response,
 	return tripper.inner.RoundTrip(req)
 }
 
+// sensitiveHeaders lists the request headers whose values must never
+// show up in logs.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization"}
+
+// redactHeaders returns a copy of headers that is safe to log.
+func redactHeaders(headers http.Header) http.Header {
+	ret := make(http.Header, len(headers))
+	for k, v := range headers {
+		ret[k] = v
+	}
+	for _, k := range sensitiveHeaders {
+		if _, ok := ret[k]; ok {
+			ret[k] = []string{"REDACTED"}
+		}
+	}
+	return ret
+}
+
+// StatusError is returned by PostHelper and PostRawHelper when the
+// endpoint answers with an unexpected HTTP status.
+type StatusError struct {
+	StatusCode int
+	// Body is the response body, if it could be read.
+	Body []byte
+}
+
+func (se *StatusError) Error() string {
+	return strconv.Itoa(se.StatusCode)
+}
+
+// Temporary reports whether the request might succeed if sent again,
+// i.e. whether the endpoint answered with a server error or asked us to
+// slow down.
+func (se *StatusError) Temporary() bool {
+	return se.StatusCode >= 500 || se.StatusCode == http.StatusTooManyRequests
+}
+
 func mergeTags(tags map[string]string, k, v string) map[string]string {
 	ret := make(map[string]string, len(tags)+1)
 	for k, v := range tags {
@@ -196,23 +234,76 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 	}
 	span.Add(ssf.Timing(action+".duration_ns", time.Since(marshalStart), time.Nanosecond, mergeTags(extraTags, "part", "json")))
 
+	headers := map[string]string{"Content-Type": "application/json"}
+	if compress {
+		headers["Content-Encoding"] = "deflate"
+	}
+	_, err := doPost(ctx, span, httpClient, tc, method, endpoint, &bodyBuffer, headers, action, extraTags, innerLogger)
+	return err
+}
+
+// PostRawHelper is like PostHelper, but for bodies that the caller has
+// already serialized (and possibly compressed) itself. The given headers
+// are set on the request as-is, so they should include the body's
+// Content-Type and, if applicable, Content-Encoding.
+func PostRawHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client, method string, endpoint string, body []byte, headers map[string]string, action string, extraTags map[string]string, log *logrus.Logger) error {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	span.SetTag("action", action)
+	for k, v := range extraTags {
+		span.SetTag(k, v)
+	}
+	defer span.ClientFinish(tc)
+
+	innerLogger := log.WithField("action", action)
+	_, err := doPost(ctx, span, httpClient, tc, method, endpoint, bytes.NewBuffer(body), headers, action, extraTags, innerLogger)
+	return err
+}
+
+// PostStreamHelper is like PostRawHelper, but reads the body from body
+// while the request is sent, e.g. so that it can be encoded on the fly.
+// The body's length isn't known up front, so it isn't reported.
+func PostStreamHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client, method string, endpoint string, body io.Reader, headers map[string]string, action string, extraTags map[string]string, log *logrus.Logger) error {
+	_, err := PostStreamResponseHelper(ctx, httpClient, tc, method, endpoint, body, headers, action, extraTags, log)
+	return err
+}
+
+// PostStreamResponseHelper is like PostStreamHelper, but also returns the
+// body of the response to a successful request, for endpoints that
+// answer with more than their status.
+func PostStreamResponseHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client, method string, endpoint string, body io.Reader, headers map[string]string, action string, extraTags map[string]string, log *logrus.Logger) ([]byte, error) {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	span.SetTag("action", action)
+	for k, v := range extraTags {
+		span.SetTag(k, v)
+	}
+	defer span.ClientFinish(tc)
+
+	innerLogger := log.WithField("action", action)
+	return doPost(ctx, span, httpClient, tc, method, endpoint, body, headers, action, extraTags, innerLogger)
+}
+
+// doPost sends an encoded body and reports on the outcome on span. It
+// returns the body of the response to a successful request.
+func doPost(ctx context.Context, span *trace.Span, httpClient *http.Client, tc *trace.Client, method string, endpoint string, body io.Reader, headers map[string]string, action string, extraTags map[string]string, innerLogger *logrus.Entry) ([]byte, error) {
 	// Len reports the unread length, so we have to record this before the
-	// http client consumes it
-	bodyLength := bodyBuffer.Len()
-	span.Add(ssf.Count(action+".content_length_bytes", float32(bodyLength), nil))
+	// http client consumes it. Streamed bodies have no length yet.
+	bodyLength := -1
+	if bodyBuffer, ok := body.(*bytes.Buffer); ok {
+		bodyLength = bodyBuffer.Len()
+		span.Add(ssf.Count(action+".content_length_bytes", float32(bodyLength), nil))
+	}
 
-	req, err := http.NewRequest(method, endpoint, &bodyBuffer)
+	req, err := http.NewRequest(method, endpoint, body)
 	if err != nil {
 		span.Error(err)
 		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "construct")))
 		innerLogger.WithError(err).Error("Could not construct request")
-		return err
+		return nil, err
 	}
 
 	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/json")
-	if compress {
-		req.Header.Set("Content-Encoding", "deflate")
+	for k, v := range headers {
+		req.Header.Set(k, v)
 	}
 
 	err = tracer.InjectRequest(span.Trace, req)
@@ -242,7 +333,7 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 			"host": req.URL.Host,
 			"path": req.URL.Path,
 		}).Warn("Could not execute request")
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -257,22 +348,23 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 	resultLogger := innerLogger.WithFields(logrus.Fields{
 		"endpoint":         endpoint,
 		"request_length":   bodyLength,
-		"request_headers":  req.Header,
+		"request_headers":  redactHeaders(req.Header),
 		"status":           resp.Status,
 		"response_headers": resp.Header,
 		"response":         string(responseBody),
 	})
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		err := fmt.Errorf("%v", resp.StatusCode)
+	// some endpoints, like InfluxDB's, answer with 204 No Content
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := &StatusError{StatusCode: resp.StatusCode, Body: responseBody}
 		span.Error(err)
 		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", strconv.Itoa(resp.StatusCode))))
 		resultLogger.WithError(err).Warn("Could not POST")
-		return err
+		return nil, err
 	}
 
 	// make sure the error metric isn't sparse
 	span.Add(ssf.Count(action+".error_total", 0, nil))
 	resultLogger.Debug("POSTed successfully")
-	return nil
+	return responseBody, nil
 }
@@ -656,6 +656,19 @@ func TestGlobalOnlyEscape(t *testing.T) {
 	assert.Contains(t, m.Tags, "tag2:quacks", "tag2 should be preserved in the list of tags after removing magic tags")
 }
 
+func TestUnitTag(t *testing.T) {
+	m, err := samplers.ParseMetric([]byte("a.b.c:1|ms|#tag1:quacks,veneurunit:milliseconds,veneurlocalonly"))
+	assert.NoError(t, err, "should have no error parsing")
+	assert.Equal(t, "milliseconds", m.Unit)
+	assert.Equal(t, []string{"tag1:quacks"}, m.Tags, "the unit and other magic tags should be removed from the list of tags")
+	assert.Equal(t, samplers.LocalOnly, m.Scope, "the unit tag shouldn't hide other magic tags")
+
+	withoutUnit, err := samplers.ParseMetric([]byte("a.b.c:1|ms|#tag1:quacks,veneurlocalonly"))
+	assert.NoError(t, err, "should have no error parsing")
+	assert.Equal(t, withoutUnit.MetricKey, m.MetricKey, "the unit shouldn't make a different metric")
+	assert.Equal(t, withoutUnit.Digest, m.Digest, "the unit shouldn't make a different metric")
+}
+
 func TestEvents(t *testing.T) {
 	evt, err := samplers.ParseEvent([]byte("_e{3,3}:foo|bar|k:foos|s:test|t:success|p:low|#foo:bar,baz:qux|d:1136239445|h:example.com"))
 	assert.NoError(t, err, "should have parsed correctly")
@@ -0,0 +1,78 @@
+package veneur
+
+import (
+	"sync"
+
+	"github.com/stripe/veneur/samplers"
+)
+
+// rateSuffix is appended to the name of a gauge in RateGauges to name its
+// rate of change.
+const rateSuffix = ".rate"
+
+// gaugeRates holds the value every gauge in Server.RateGauges was flushed
+// with, so that its rate of change can be worked out at the next flush.
+type gaugeRates struct {
+	mtx sync.Mutex
+	// previous holds the values of the last flush, and current those of
+	// the flush in progress.
+	previous map[samplers.MetricKey]float64
+	current  map[samplers.MetricKey]float64
+}
+
+// startFlush makes the values of the flush that just ended the ones rates
+// are computed from. Gauges that weren't flushed then have no rate at the
+// next flush, so that gauges that stop being reported aren't kept around.
+func (r *gaugeRates) startFlush() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.previous, r.current = r.current, map[samplers.MetricKey]float64{}
+}
+
+// observe records a gauge's value, and returns its per-second rate of
+// change since the previous flush, interval seconds ago, if it was
+// flushed then. A gauge that went down is taken to have been reset, e.g.
+// because whatever it measures restarted or wrapped around: it has no
+// rate until the next flush, rather than a bogus negative one.
+func (r *gaugeRates) observe(mk samplers.MetricKey, value float64, interval float64) (float64, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.current == nil {
+		r.current = map[samplers.MetricKey]float64{}
+	}
+	r.current[mk] = value
+	previous, ok := r.previous[mk]
+	if !ok || value < previous || interval <= 0 {
+		return 0, false
+	}
+	return (value - previous) / interval, true
+}
+
+// withRate adds the rate of change of the gauge flushed as metrics to
+// them, if it's in RateGauges, as a gauge named after it with rateSuffix.
+func (s *Server) withRate(mk samplers.MetricKey, metrics []samplers.InterMetric) []samplers.InterMetric {
+	if _, ok := s.RateGauges[mk.Name]; !ok {
+		return metrics
+	}
+	for _, metric := range metrics {
+		if metric.Type != samplers.GaugeMetric || metric.Name != mk.Name {
+			continue
+		}
+		rate, ok := s.gaugeRates.observe(mk, metric.Value, s.interval.Seconds())
+		if !ok {
+			continue
+		}
+		tags := make([]string, len(metric.Tags))
+		copy(tags, metric.Tags)
+		metrics = append(metrics, samplers.InterMetric{
+			Name:      metric.Name + rateSuffix,
+			Timestamp: metric.Timestamp,
+			Value:     rate,
+			Tags:      tags,
+			Type:      samplers.GaugeMetric,
+			Sinks:     metric.Sinks,
+		})
+		break
+	}
+	return metrics
+}
@@ -0,0 +1,76 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestGaugeRates(t *testing.T) {
+	var rates gaugeRates
+	mk := samplers.MetricKey{Name: "a.b.c", Type: gaugeTypeName}
+	other := samplers.MetricKey{Name: "a.b.d", Type: gaugeTypeName}
+
+	rates.startFlush()
+	_, ok := rates.observe(mk, 100, 10)
+	assert.False(t, ok, "there's no rate before a gauge's second flush")
+	rates.observe(other, 5, 10)
+
+	rates.startFlush()
+	rate, ok := rates.observe(mk, 150, 10)
+	assert.True(t, ok)
+	assert.Equal(t, float64(5), rate)
+
+	rates.startFlush()
+	_, ok = rates.observe(mk, 20, 10)
+	assert.False(t, ok, "a gauge going down should be taken as a reset")
+	_, ok = rates.observe(other, 10, 10)
+	assert.False(t, ok, "a gauge that wasn't flushed last time has no rate")
+
+	rates.startFlush()
+	rate, ok = rates.observe(mk, 40, 10)
+	assert.True(t, ok, "the rate should pick up after a reset")
+	assert.Equal(t, float64(2), rate)
+}
+
+func TestFlushRateGauges(t *testing.T) {
+	cfg := globalConfig()
+	cfg.RateGauges = []string{"a.b.c"}
+	s, err := NewFromConfig(logrus.New(), cfg)
+	require.NoError(t, err)
+
+	flush := func(value float64) map[string]samplers.InterMetric {
+		w := NewWorker(1, true, false, nil, logrus.New(), nil)
+		for _, name := range []string{"a.b.c", "a.b.d"} {
+			w.ProcessMetric(&samplers.UDPMetric{
+				MetricKey:  samplers.MetricKey{Name: name, Type: gaugeTypeName, JoinedTags: "foo:bar"},
+				Value:      value,
+				SampleRate: 1.0,
+				Tags:       []string{"foo:bar"},
+			})
+		}
+		metrics := s.generateInterMetrics(context.Background(), s.HistogramPercentiles, s.HistogramAggregates, []WorkerMetrics{w.Flush()}, metricsSummary{})
+		byName := map[string]samplers.InterMetric{}
+		for _, m := range metrics {
+			byName[m.Name] = m
+		}
+		return byName
+	}
+
+	metrics := flush(10)
+	assert.Contains(t, metrics, "a.b.c")
+	assert.NotContains(t, metrics, "a.b.c.rate")
+
+	metrics = flush(10 + 3*s.interval.Seconds())
+	if assert.Contains(t, metrics, "a.b.c.rate") {
+		rate := metrics["a.b.c.rate"]
+		assert.InDelta(t, 3, rate.Value, 0.001)
+		assert.Equal(t, samplers.GaugeMetric, rate.Type)
+		assert.Equal(t, []string{"foo:bar"}, rate.Tags)
+	}
+	assert.NotContains(t, metrics, "a.b.d.rate", "only the configured gauges should have rates")
+}
@@ -31,8 +31,20 @@ type UDPMetric struct {
 	Timestamp  int64
 	Message    string
 	HostName   string
+
+	// TraceID is the ID of the trace of the span the metric was sent
+	// with, if it was sent with a span that is part of a trace.
+	TraceID int64
+
+	// Unit is the unit of the metric's value, if the client gave one
+	// with the veneurunit tag.
+	Unit string
 }
 
+// unitTagPrefix starts the tag DogStatsD clients can give a metric's unit
+// with, e.g. "veneurunit:bytes". It isn't kept as a tag.
+const unitTagPrefix = "veneurunit:"
+
 // MetricScope describes where the metric will be emitted.
 type MetricScope int
 
@@ -111,6 +123,7 @@ func ConvertMetrics(m *ssf.SSFSpan) ([]UDPMetric, error) {
 			invalid = append(invalid, metricPacket)
 			continue
 		}
+		metric.TraceID = m.TraceId
 		metrics = append(metrics, metric)
 	}
 	if len(invalid) != 0 {
@@ -151,6 +164,7 @@ func ConvertIndicatorMetrics(span *ssf.SSFSpan, indicatorTimerName, objectiveTim
 		if err != nil {
 			return metrics, err
 		}
+		timer.TraceID = span.TraceId
 		metrics = append(metrics, timer)
 	}
 
@@ -174,6 +188,7 @@ func ConvertIndicatorMetrics(span *ssf.SSFSpan, indicatorTimerName, objectiveTim
 		if err != nil {
 			return metrics, err
 		}
+		timer.TraceID = span.TraceId
 		metrics = append(metrics, timer)
 	}
 
@@ -273,6 +288,8 @@ func ParseMetricSSF(metric *ssf.SSFSample) (UDPMetric, error) {
 		ret.Scope = GlobalOnly
 	}
 	ret.SampleRate = metric.SampleRate
+	// SSF timestamps are in nanoseconds
+	ret.Timestamp = metric.Timestamp / int64(time.Second)
 	tempTags := make([]string, 0, len(metric.Tags))
 	for key, value := range metric.Tags {
 		if key == "veneurlocalonly" {
@@ -391,6 +408,13 @@ func ParseMetric(packet []byte) (*UDPMetric, error) {
 			// see worker.go line 273
 			tags := strings.Split(string(pipeSplitter.Chunk()[1:]), ",")
 			sort.Strings(tags)
+			for i, tag := range tags {
+				if strings.HasPrefix(tag, unitTagPrefix) {
+					tags = append(tags[:i], tags[i+1:]...)
+					ret.Unit = strings.TrimPrefix(tag, unitTagPrefix)
+					break
+				}
+			}
 			for i, tag := range tags {
 				// we use this tag as an escape hatch for metrics that always
 				// want to be host-local
@@ -25,6 +25,10 @@ const (
 	GaugeMetric
 	// StatusMetric is a status (synonymous with a service check)
 	StatusMetric
+	// SketchMetric is a histogram's whole distribution, as a serialized
+	// t-digest in Sketch, with its weight as Value. Only sinks that can
+	// flush sketches ever receive them.
+	SketchMetric
 )
 
 // RouteInformation is a key-only map indicating sink names that are
@@ -76,6 +80,24 @@ type InterMetric struct {
 	Message   string
 	HostName  string
 
+	// Description is the metric's help text, if it has any. Sinks whose
+	// backends don't support help text ignore it.
+	Description string
+
+	// Sketch is the protobuf-encoded tdigest.MergingDigestData of a
+	// SketchMetric, which can be merged with other sketches of the same
+	// metric downstream.
+	Sketch []byte
+
+	// Unit is the unit of the metric's value, e.g. "bytes", if it has
+	// one. Sinks whose backends don't support units ignore it.
+	Unit string
+
+	// Exemplar is the ID of a trace whose spans were sent with samples
+	// of the metric during the interval, if the veneur that aggregated
+	// them keeps exemplars. It lets backends link the metric to a trace.
+	Exemplar int64
+
 	// Sinks, if non-nil, indicates which metric sinks a metric
 	// should be inserted into. If nil, that means the metric is
 	// meant to go to every sink.
@@ -402,7 +424,7 @@ func (s *Set) Sample(sample string) {
 func NewSet(Name string, Tags []string) *Set {
 	// error is only returned if precision is outside the 4-18 range
 	// TODO: this is the maximum precision, should it be configurable?
-	Hll := hyperloglog.New()
+	Hll := hyperloglog.New14()
 	return &Set{
 		Name: Name,
 		Tags: Tags,
@@ -424,6 +446,26 @@ func (s *Set) Flush() []InterMetric {
 	}}
 }
 
+// setPrecision is the precision of the HyperLogLog sketch behind every Set,
+// i.e. it uses 2^setPrecision registers.
+const setPrecision = 14
+
+// FlushErrorBound generates an InterMetric holding the relative standard
+// error of this Set's cardinality estimate, as a percentage. It's named
+// after the set, with a ".cardinality_error_percent" suffix.
+func (s *Set) FlushErrorBound() []InterMetric {
+	tags := make([]string, len(s.Tags))
+	copy(tags, s.Tags)
+	return []InterMetric{{
+		Name:      s.Name + ".cardinality_error_percent",
+		Timestamp: time.Now().Unix(),
+		Value:     100 * 1.04 / math.Sqrt(math.Exp2(setPrecision)),
+		Tags:      tags,
+		Type:      GaugeMetric,
+		Sinks:     routeInfo(tags),
+	}}
+}
+
 // Export converts a Set into a JSONMetric which reports the Tags in the set.
 func (s *Set) Export() (JSONMetric, error) {
 	val, err := s.Hll.MarshalBinary()
@@ -590,7 +632,9 @@ func (h *Histo) Flush(
 		})
 	}
 
-	if (aggregates.Value&AggregateSum) == AggregateSum && (h.LocalSum != 0 || global) {
+	if (aggregates.Value&AggregateSum) == AggregateSum && (h.LocalWeight != 0 || global) {
+		// like the count below, so that a sum of zero is still flushed
+		// next to its count and an average can be derived from them
 		tags := make([]string, len(h.Tags))
 		copy(tags, h.Tags)
 		val := float64(h.LocalSum)
@@ -700,6 +744,27 @@ func (h *Histo) Flush(
 	return metrics
 }
 
+// FlushSketch generates a SketchMetric holding the histogram's t-digest,
+// rather than percentiles computed from it, so that it can be merged
+// with other veneurs' digests downstream.
+func (h *Histo) FlushSketch() ([]InterMetric, error) {
+	sketch, err := h.Value.Data().Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the t-digest: %v", err)
+	}
+	tags := make([]string, len(h.Tags))
+	copy(tags, h.Tags)
+	return []InterMetric{{
+		Name:      h.Name,
+		Timestamp: time.Now().Unix(),
+		Value:     h.Value.Count(),
+		Tags:      tags,
+		Type:      SketchMetric,
+		Sketch:    sketch,
+		Sinks:     routeInfo(tags),
+	}}, nil
+}
+
 // Export converts a Histogram into a JSONMetric
 func (h *Histo) Export() (JSONMetric, error) {
 	val, err := h.Value.GobEncode()
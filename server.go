@@ -46,10 +46,18 @@ import (
 	"github.com/stripe/veneur/sinks"
 	"github.com/stripe/veneur/sinks/datadog"
 	"github.com/stripe/veneur/sinks/debug"
+	"github.com/stripe/veneur/sinks/diskbuffer"
 	"github.com/stripe/veneur/sinks/falconer"
+	"github.com/stripe/veneur/sinks/file"
 	"github.com/stripe/veneur/sinks/generic"
+	"github.com/stripe/veneur/sinks/influxdb"
 	"github.com/stripe/veneur/sinks/kafka"
 	"github.com/stripe/veneur/sinks/lightstep"
+	"github.com/stripe/veneur/sinks/otlp"
+	"github.com/stripe/veneur/sinks/prometheus"
+	"github.com/stripe/veneur/sinks/ratelimit"
+	s3sink "github.com/stripe/veneur/sinks/s3"
+	"github.com/stripe/veneur/sinks/sampling"
 	"github.com/stripe/veneur/sinks/signalfx"
 	"github.com/stripe/veneur/sinks/splunk"
 	"github.com/stripe/veneur/sinks/ssfmetrics"
@@ -82,7 +90,7 @@ const httpQuitEndpoint = "/quitquitquit"
 
 // A Server is the actual veneur instance that will be run.
 type Server struct {
-	Workers               []*Worker
+	Workers               WorkerPool
 	EventWorker           *EventWorker
 	SpanChan              chan *ssf.SSFSpan
 	SpanWorker            *SpanWorker
@@ -122,6 +130,32 @@ type Server struct {
 	httpQuit bool
 
 	HistogramPercentiles []samplers.Percentile
+	// HistogramPercentilesByMetric overrides HistogramPercentiles for the
+	// histograms and timers with the given names.
+	HistogramPercentilesByMetric map[string][]samplers.Percentile
+	// SketchHistograms holds the names of the histograms and timers that
+	// are flushed with their t-digest, as a samplers.SketchMetric, rather
+	// than with percentiles.
+	SketchHistograms map[string]struct{}
+	// RateGauges holds the names of the gauges whose per-second rate of
+	// change since the previous flush is flushed alongside them, as a
+	// gauge named after them with a ".rate" suffix.
+	RateGauges map[string]struct{}
+	gaugeRates gaugeRates
+	// ImportHeaderTags maps the headers of /import requests, in their
+	// canonical form, to the keys of the tags their values are added to
+	// the imported metrics under.
+	ImportHeaderTags map[string]string
+	// MetricDescriptions holds the help text of metrics, by the name of
+	// the sampler they're flushed from, for the sinks that support it.
+	MetricDescriptions map[string]string
+	// MetricUnits holds the units of metrics, by the name of the sampler
+	// they're flushed from, for metrics whose samples weren't sent with
+	// one.
+	MetricUnits map[string]string
+	// FlushSetErrorBounds makes every set flush the standard error of its
+	// cardinality estimate alongside the estimate itself.
+	FlushSetErrorBounds bool
 
 	plugins   []plugins.Plugin
 	pluginMtx sync.Mutex
@@ -146,6 +180,10 @@ type Server struct {
 
 	stuckIntervals int
 	lastFlushUnix  int64
+
+	// metricSinkFlushTimeouts bounds how long each metric sink, by name,
+	// may take to flush.
+	metricSinkFlushTimeouts map[string]time.Duration
 }
 
 // ssfServiceSpanMetrics refer to the span metrics that will
@@ -273,11 +311,53 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	for _, per := range conf.Percentiles {
 		ret.HistogramPercentiles = append(ret.HistogramPercentiles, samplers.Percentile{Value: per})
 	}
+	if len(conf.PercentilesByMetric) > 0 {
+		ret.HistogramPercentilesByMetric = make(map[string][]samplers.Percentile, len(conf.PercentilesByMetric))
+		for name, pers := range conf.PercentilesByMetric {
+			percentiles := make([]samplers.Percentile, 0, len(pers))
+			for _, per := range pers {
+				percentiles = append(percentiles, samplers.Percentile{Value: per})
+			}
+			ret.HistogramPercentilesByMetric[name] = percentiles
+		}
+	}
+	if len(conf.SketchHistograms) > 0 {
+		ret.SketchHistograms = make(map[string]struct{}, len(conf.SketchHistograms))
+		for _, name := range conf.SketchHistograms {
+			ret.SketchHistograms[name] = struct{}{}
+		}
+	}
+	if len(conf.RateGauges) > 0 {
+		ret.RateGauges = make(map[string]struct{}, len(conf.RateGauges))
+		for _, name := range conf.RateGauges {
+			ret.RateGauges[name] = struct{}{}
+		}
+	}
+	if len(conf.ImportHeaderTags) > 0 {
+		ret.ImportHeaderTags = make(map[string]string, len(conf.ImportHeaderTags))
+		for header, key := range conf.ImportHeaderTags {
+			if key == "" {
+				return ret, fmt.Errorf("the import header %q has no tag key to map to", header)
+			}
+			ret.ImportHeaderTags[http.CanonicalHeaderKey(header)] = key
+		}
+	}
+	ret.MetricDescriptions = conf.MetricDescriptions
+	ret.MetricUnits = conf.MetricUnits
+	ret.FlushSetErrorBounds = conf.FlushSetErrorBounds
 	ret.HistogramAggregates.Value = 0
 	for _, agg := range conf.Aggregates {
 		ret.HistogramAggregates.Value += samplers.AggregatesLookup[agg]
 	}
 	ret.HistogramAggregates.Count = len(conf.Aggregates)
+	if conf.HistogramCountAndSum {
+		for _, agg := range []samplers.Aggregate{samplers.AggregateCount, samplers.AggregateSum} {
+			if ret.HistogramAggregates.Value&agg != agg {
+				ret.HistogramAggregates.Value |= agg
+				ret.HistogramAggregates.Count++
+			}
+		}
+	}
 
 	var err error
 	ret.interval, err = conf.ParseInterval()
@@ -287,6 +367,16 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 
 	ret.stuckIntervals = conf.FlushWatchdogMissedFlushes
 
+	if len(conf.MetricSinkFlushTimeouts) > 0 {
+		ret.metricSinkFlushTimeouts = make(map[string]time.Duration, len(conf.MetricSinkFlushTimeouts))
+		for name, timeout := range conf.MetricSinkFlushTimeouts {
+			ret.metricSinkFlushTimeouts[name], err = time.ParseDuration(timeout)
+			if err != nil {
+				return ret, err
+			}
+		}
+	}
+
 	transport := &http.Transport{
 		IdleConnTimeout: ret.interval * 2, // If we're idle more than one interval something is up
 	}
@@ -376,8 +466,6 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		numWorkers = conf.NumWorkers
 	}
 	logger.WithField("number", numWorkers).Info("Preparing workers")
-	// Allocate the slice, we'll fill it with workers later.
-	ret.Workers = make([]*Worker, numWorkers)
 	ret.numReaders = conf.NumReaders
 
 	// This must come before worker initialization. We need to
@@ -389,16 +477,35 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	// slight performance hit to workers.
 	ret.CountUniqueTimeseries = conf.CountUniqueTimeseries
 
-	// Use the pre-allocated Workers slice to know how many to start.
-	for i := range ret.Workers {
-		ret.Workers[i] = NewWorker(i+1, ret.IsLocal(), ret.CountUniqueTimeseries, ret.TraceClient, log, ret.Statsd)
+	ret.Workers, err = NewWorkerPool(numWorkers, ret.IsLocal(), ret.CountUniqueTimeseries, ret.TraceClient, log, ret.Statsd)
+	if err != nil {
+		return ret, err
+	}
+	var localOnlyHistograms map[string]struct{}
+	if len(conf.LocalOnlyHistograms) > 0 {
+		localOnlyHistograms = make(map[string]struct{}, len(conf.LocalOnlyHistograms))
+		for _, name := range conf.LocalOnlyHistograms {
+			localOnlyHistograms[name] = struct{}{}
+		}
+	}
+	var maxSampleAge time.Duration
+	if conf.MaxSampleAge != "" {
+		maxSampleAge, err = time.ParseDuration(conf.MaxSampleAge)
+		if err != nil {
+			return ret, err
+		}
+	}
+	for _, w := range ret.Workers {
+		w.LocalOnlyHistograms = localOnlyHistograms
+		w.MaxSampleAge = maxSampleAge
+		w.Exemplars = conf.Exemplars
 		// do not close over loop index
 		go func(w *Worker) {
 			defer func() {
 				ConsumePanic(ret.Sentry, ret.TraceClient, ret.Hostname, recover())
 			}()
 			w.Work()
-		}(ret.Workers[i])
+		}(w)
 	}
 
 	ret.EventWorker = NewEventWorker(ret.TraceClient, ret.Statsd)
@@ -516,20 +623,186 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	}
 
 	if conf.GenericEndpoint != "" {
-		gmSink, err := generic.NewGenericMetricSink(
+		gmSink, err := generic.NewGenericMetricSinkFromConfig(log, ret.HTTPClient, ret.Tags, conf.Hostname, conf.GenericSinkConfig)
+		if err != nil {
+			return ret, err
+		}
+		if gmSink.FlushJitter > ret.interval {
+			logger.WithFields(logrus.Fields{
+				"jitter":   gmSink.FlushJitter,
+				"interval": ret.interval,
+			}).Warn("Generic sink's flush jitter is longer than the flush interval, capping it")
+			gmSink.FlushJitter = ret.interval
+		}
+		if conf.GenericInterval == generic.IntervalFlush {
+			gmSink.Interval = ret.interval
+		}
+		ret.metricSinks = append(ret.metricSinks, gmSink)
+
+		if conf.GenericSpansEndpoint != "" {
+			gsSink, err := generic.NewGenericSpanSink(gmSink, conf.GenericSpansEndpoint, conf.GenericSpanBufferSize)
+			if err != nil {
+				return ret, err
+			}
+			gsSink.FlushInterval = ret.interval
+			ret.spanSinks = append(ret.spanSinks, gsSink)
+			logger.Info("Configured generic span sink")
+		}
+	}
+
+	if conf.GenericGrpcTarget != "" {
+		var reconnectBaseDelay, reconnectMaxDelay time.Duration
+		if conf.GenericGrpcReconnectBaseDelay != "" {
+			reconnectBaseDelay, err = time.ParseDuration(conf.GenericGrpcReconnectBaseDelay)
+			if err != nil {
+				return ret, err
+			}
+		}
+		if conf.GenericGrpcReconnectMaxDelay != "" {
+			reconnectMaxDelay, err = time.ParseDuration(conf.GenericGrpcReconnectMaxDelay)
+			if err != nil {
+				return ret, err
+			}
+		}
+
+		grpcSink, err := generic.NewGenericGRPCSink(
+			log,
+			conf.GenericGrpcTarget,
+			ret.Tags,
+			conf.GenericGrpcBatchSize,
+			conf.GenericSource,
+			conf.GenericEnvironment,
+			conf.GenericNamespace,
+			conf.GenericGrpcMaxRetries,
+			reconnectBaseDelay,
+			reconnectMaxDelay,
+			grpc.WithInsecure(),
+		)
+		if err != nil {
+			return ret, err
+		}
+		ret.metricSinks = append(ret.metricSinks, grpcSink)
+	}
+
+	if conf.PrometheusRemoteWriteEndpoint != "" {
+		var retryBaseDelay, retryMaxDelay time.Duration
+		if conf.PrometheusRemoteWriteRetryBaseDelay != "" {
+			retryBaseDelay, err = time.ParseDuration(conf.PrometheusRemoteWriteRetryBaseDelay)
+			if err != nil {
+				return ret, err
+			}
+		}
+		if conf.PrometheusRemoteWriteRetryMaxDelay != "" {
+			retryMaxDelay, err = time.ParseDuration(conf.PrometheusRemoteWriteRetryMaxDelay)
+			if err != nil {
+				return ret, err
+			}
+		}
+
+		promSink, err := prometheus.NewRemoteWriteSink(
 			log,
 			ret.HTTPClient,
 			nil,
-			conf.GenericEndpoint,
-			conf.GenericBatchSize,
+			conf.PrometheusRemoteWriteEndpoint,
+			conf.PrometheusRemoteWriteBatchSize,
+			conf.PrometheusRemoteWriteMaxRetries,
+			retryBaseDelay,
+			retryMaxDelay,
+		)
+		if err != nil {
+			return ret, err
+		}
+		if err := sinks.CheckCounterMode(conf.PrometheusRemoteWriteCounterMode); err != nil {
+			return ret, err
+		}
+		promSink.CounterMode = conf.PrometheusRemoteWriteCounterMode
+		ret.metricSinks = append(ret.metricSinks, promSink)
+	}
+
+	if conf.PrometheusScrapeAddress != "" {
+		scrapeSink, err := prometheus.NewScrapeSink(log, nil, conf.PrometheusScrapeAddress, conf.PrometheusScrapePath)
+		if err != nil {
+			return ret, err
+		}
+		ret.metricSinks = append(ret.metricSinks, scrapeSink)
+	}
+
+	if conf.OtlpEndpoint != "" {
+		otlpSink, err := otlp.NewMetricSink(log, ret.HTTPClient, ret.Tags, ret.interval, conf.OtlpEndpoint, conf.OtlpBatchSize)
+		if err != nil {
+			return ret, err
+		}
+		ret.metricSinks = append(ret.metricSinks, otlpSink)
+	}
+
+	if conf.FileSinkPath != "" {
+		fileSink, err := file.NewFileSink(log, conf.FileSinkPath, conf.FileSinkMaxSize, conf.FileSinkMaxBackups)
+		if err != nil {
+			return ret, err
+		}
+		ret.metricSinks = append(ret.metricSinks, fileSink)
+	}
+
+	if conf.S3SinkBucket != "" {
+		awsConfig := &aws.Config{Region: aws.String(conf.S3SinkRegion)}
+		if conf.S3SinkAccessKeyID != "" && conf.S3SinkSecretAccessKey != "" {
+			awsConfig.Credentials = credentials.NewStaticCredentials(conf.S3SinkAccessKeyID, conf.S3SinkSecretAccessKey, "")
+		}
+		sess, err := session.NewSession(awsConfig)
+		if err != nil {
+			return ret, err
+		}
+		s3Sink, err := s3sink.NewS3Sink(
+			log,
+			s3.New(sess),
+			conf.S3SinkBucket,
+			conf.S3SinkPrefix,
+			ret.Hostname,
+			ret.Tags,
 			conf.GenericSource,
 			conf.GenericEnvironment,
 			conf.GenericNamespace,
+			conf.S3SinkFormat,
+			conf.S3SinkTimestampFormat,
 		)
 		if err != nil {
 			return ret, err
 		}
-		ret.metricSinks = append(ret.metricSinks, gmSink)
+		ret.metricSinks = append(ret.metricSinks, s3Sink)
+	}
+
+	if conf.InfluxdbAddress != "" {
+		var retryBaseDelay, retryMaxDelay time.Duration
+		if conf.InfluxdbRetryBaseDelay != "" {
+			retryBaseDelay, err = time.ParseDuration(conf.InfluxdbRetryBaseDelay)
+			if err != nil {
+				return ret, err
+			}
+		}
+		if conf.InfluxdbRetryMaxDelay != "" {
+			retryMaxDelay, err = time.ParseDuration(conf.InfluxdbRetryMaxDelay)
+			if err != nil {
+				return ret, err
+			}
+		}
+
+		influxSink, err := influxdb.NewInfluxDBSink(
+			log,
+			ret.HTTPClient,
+			ret.Tags,
+			conf.InfluxdbAddress,
+			conf.InfluxdbDatabase,
+			conf.InfluxdbRetentionPolicy,
+			conf.InfluxdbPrecision,
+			conf.InfluxdbBatchSize,
+			conf.InfluxdbMaxRetries,
+			retryBaseDelay,
+			retryMaxDelay,
+		)
+		if err != nil {
+			return ret, err
+		}
+		ret.metricSinks = append(ret.metricSinks, influxSink)
 	}
 
 	// Configure tracing sinks
@@ -697,6 +970,42 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		}
 	}
 
+	// Wrap the sinks whose failed flushes should be buffered on disk
+	for i, sink := range ret.metricSinks {
+		buffer, ok := conf.MetricSinkDiskBuffers[sink.Name()]
+		if !ok {
+			continue
+		}
+		ret.metricSinks[i], err = diskbuffer.NewDiskBufferSink(log, sink, buffer.Dir, buffer.MaxSize)
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	// Wrap the sinks whose rate of metrics should be limited
+	for i, sink := range ret.metricSinks {
+		limit, ok := conf.MetricSinkRateLimits[sink.Name()]
+		if !ok {
+			continue
+		}
+		ret.metricSinks[i], err = ratelimit.NewRateLimitSink(sink, limit.Limit, limit.Burst, ret.interval)
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	// Wrap the sinks that should only receive a sample of some metrics
+	for i, sink := range ret.metricSinks {
+		rates, ok := conf.MetricSinkSamplingRates[sink.Name()]
+		if !ok {
+			continue
+		}
+		ret.metricSinks[i], err = sampling.NewSamplingSink(sink, rates)
+		if err != nil {
+			return ret, err
+		}
+	}
+
 	// After all sinks are initialized, set the list of tags to exclude
 	setSinkExcludedTags(conf.TagsExclude, ret.metricSinks, ret.spanSinks)
 
@@ -758,13 +1067,7 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	}
 
 	// Don't emit keys into logs now that we're done with them.
-	conf.SentryDsn = REDACTED
-	conf.TLSKey = REDACTED
-	conf.DatadogAPIKey = REDACTED
-	conf.SignalfxAPIKey = REDACTED
-	conf.LightstepAccessToken = REDACTED
-	conf.AwsAccessKeyID = REDACTED
-	conf.AwsSecretAccessKey = REDACTED
+	conf = conf.redacted()
 
 	ret.forwardUseGRPC = conf.ForwardUseGrpc
 
@@ -990,7 +1293,7 @@ func (s *Server) HandleMetricPacket(packet []byte) error {
 			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "service_check", "reason": "parse"}))
 			return err
 		}
-		s.Workers[svcheck.Digest%uint32(len(s.Workers))].PacketChan <- *svcheck
+		s.Workers.IngestUDP(*svcheck)
 	} else {
 		metric, err := samplers.ParseMetric(packet)
 		if err != nil {
@@ -1001,7 +1304,7 @@ func (s *Server) HandleMetricPacket(packet []byte) error {
 			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "metric", "reason": "parse"}))
 			return err
 		}
-		s.Workers[metric.Digest%uint32(len(s.Workers))].PacketChan <- *metric
+		s.Workers.IngestUDP(*metric)
 	}
 	return nil
 }
@@ -1438,6 +1741,21 @@ func (s *Server) gRPCStop() {
 func (s *Server) Shutdown() {
 	// TODO(aditya) shut down workers and socket readers
 	log.Info("Shutting down server gracefully")
+
+	// Let the sinks that support it finish sending the flush in
+	// progress, before it gets cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	for _, sink := range s.metricSinks {
+		if drainer, ok := sink.(interface {
+			Drain(context.Context) error
+		}); ok {
+			if err := drainer.Drain(ctx); err != nil {
+				log.WithError(err).WithField("sink", sink.Name()).Warn("Could not drain sink")
+			}
+		}
+	}
+	cancel()
+
 	close(s.shutdown)
 	graceful.Shutdown()
 	s.gRPCStop()
@@ -1446,6 +1764,14 @@ func (s *Server) Shutdown() {
 	if s.grpcForwardConn != nil {
 		s.grpcForwardConn.Close()
 	}
+
+	// Tear down the connections held by sinks that keep them open
+	// between flushes
+	for _, sink := range s.metricSinks {
+		if stopper, ok := sink.(interface{ Stop() }); ok {
+			stopper.Stop()
+		}
+	}
 }
 
 // IsLocal indicates whether veneur is running as a local instance
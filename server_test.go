@@ -29,6 +29,7 @@ import (
 	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/sinks"
 	"github.com/stripe/veneur/sinks/blackhole"
+	"github.com/stripe/veneur/sinks/generic"
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/tdigest"
 	"github.com/stripe/veneur/trace"
@@ -1637,3 +1638,20 @@ func BenchmarkHandleSSF(b *testing.B) {
 		f.server.handleSSF(spans[i%LEN], "packet")
 	}
 }
+
+func TestGenericSinkFlushInterval(t *testing.T) {
+	config := globalConfig()
+	config.GenericEndpoint = "http://localhost:8080/metrics"
+	config.GenericBatchSize = 100
+	config.GenericInterval = generic.IntervalFlush
+	s, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+
+	for _, sink := range s.metricSinks {
+		if gmSink, ok := sink.(*generic.GenericMetricSink); ok {
+			assert.Equal(t, s.interval, gmSink.Interval)
+			return
+		}
+	}
+	t.Fatal("no generic sink was configured")
+}
@@ -0,0 +1,60 @@
+package sinks
+
+import (
+	"fmt"
+	"sync"
+)
+
+// The ways sinks can emit counters.
+const (
+	// CounterModeDelta emits the increment of each counter over the
+	// interval, which is how veneur flushes them.
+	CounterModeDelta = "delta"
+	// CounterModeCumulative emits the running total of each counter
+	// series since the sink started, for backends that expect counters
+	// to only ever go up, like Prometheus.
+	CounterModeCumulative = "cumulative"
+)
+
+// CheckCounterMode checks that a counter mode is known. The empty mode is
+// valid, and means whichever mode the sink defaults to.
+func CheckCounterMode(mode string) error {
+	switch mode {
+	case "", CounterModeDelta, CounterModeCumulative:
+		return nil
+	default:
+		return fmt.Errorf("unknown counter mode %q", mode)
+	}
+}
+
+// CounterTotals keeps the running totals of counter series for sinks in
+// CounterModeCumulative. The totals are only kept in memory, so they start
+// from zero again whenever veneur restarts: backends see that as the
+// counter being reset, like they would for any restarting process, and
+// functions like Prometheus' rate() account for it.
+//
+// The zero CounterTotals is ready to use, and it's safe to use
+// concurrently.
+type CounterTotals struct {
+	mtx    sync.Mutex
+	totals map[string]float64
+}
+
+// Add adds the increment of the series identified by key to its total,
+// and returns the new total.
+func (ct *CounterTotals) Add(key string, increment float64) float64 {
+	ct.mtx.Lock()
+	defer ct.mtx.Unlock()
+	if ct.totals == nil {
+		ct.totals = map[string]float64{}
+	}
+	ct.totals[key] += increment
+	return ct.totals[key]
+}
+
+// Len returns the number of series whose totals are kept.
+func (ct *CounterTotals) Len() int {
+	ct.mtx.Lock()
+	defer ct.mtx.Unlock()
+	return len(ct.totals)
+}
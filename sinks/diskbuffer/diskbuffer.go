@@ -0,0 +1,376 @@
+// Package diskbuffer implements a metric sink that buffers the metrics
+// another sink fails to flush on disk, and flushes them again once the
+// other sink recovers.
+package diskbuffer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// The metrics a disk buffering sink emits as counters of batches, tagged
+// with `sink:sink.Name()`: batches written to disk after the wrapped sink
+// failed to flush them, batches flushed again from disk, and batches
+// evicted to stay within MaxSize (or that were larger than MaxSize, or
+// unreadable) and so never flushed.
+const (
+	MetricKeyBufferedBatches = "sink.diskbuffer.buffered_batches_total"
+	MetricKeyReplayedBatches = "sink.diskbuffer.replayed_batches_total"
+	MetricKeyEvictedBatches  = "sink.diskbuffer.evicted_batches_total"
+)
+
+// batchSuffix ends the names of the files batches are buffered in, which
+// start with the batch's sequence number. Batches are written to a file
+// ending with tmpSuffix first.
+const (
+	batchSuffix = ".json"
+	tmpSuffix   = ".tmp"
+)
+
+// DiskBufferSink wraps another metric sink. When the wrapped sink fails
+// to flush metrics, they are written to a file in Dir, and flushed to the
+// wrapped sink again, oldest first, after its next successful flush. The
+// files take up at most MaxSize bytes: the oldest are evicted to make room
+// for new ones. The files are read back when the sink starts, so buffered
+// metrics survive a restart.
+//
+// Delivery is at least once: only the metrics the wrapped sink reports as
+// failed (see sinks.FailedMetrics) are buffered, but a sink that can't
+// tell which of its metrics failed has its whole flush buffered, so the
+// metrics it did flush are sent again.
+//
+// The sink takes on the name of the sink it wraps, so that routing, tag
+// exclusion and everything else keyed by sink name keep applying to it.
+type DiskBufferSink struct {
+	inner       sinks.MetricSink
+	log         *logrus.Logger
+	traceClient *trace.Client
+	Dir         string
+	MaxSize     int64
+
+	// mtx guards the queue of buffered batches, oldest first, since
+	// flushes can overlap.
+	mtx       sync.Mutex
+	queue     []bufferedBatch
+	size      int64
+	next      uint64
+	replaying bool
+}
+
+// bufferedBatch is a batch buffered in a file.
+type bufferedBatch struct {
+	name string
+	size int64
+}
+
+var _ sinks.MetricSink = &DiskBufferSink{}
+
+// NewDiskBufferSink returns a sink buffering the metrics inner fails to
+// flush in dir, in up to maxSize bytes.
+func NewDiskBufferSink(log *logrus.Logger, inner sinks.MetricSink, dir string, maxSize int64) (*DiskBufferSink, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("the disk buffer of sink %q needs a directory", inner.Name())
+	}
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("the disk buffer of sink %q needs a positive max size", inner.Name())
+	}
+	return &DiskBufferSink{
+		inner:   inner,
+		log:     log,
+		Dir:     dir,
+		MaxSize: maxSize,
+	}, nil
+}
+
+// Name returns the name of the wrapped sink.
+func (d *DiskBufferSink) Name() string {
+	return d.inner.Name()
+}
+
+// SetExcludedTags passes the excluded tag names on to the wrapped sink,
+// if it supports them.
+func (d *DiskBufferSink) SetExcludedTags(excludes []string) {
+	if excludable, ok := d.inner.(interface{ SetExcludedTags([]string) }); ok {
+		excludable.SetExcludedTags(excludes)
+	}
+}
+
+// Start creates Dir if it doesn't exist, picks up the batches buffered in
+// it, and starts the wrapped sink.
+func (d *DiskBufferSink) Start(client *trace.Client) error {
+	d.traceClient = client
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return err
+	}
+	if err := d.load(); err != nil {
+		return err
+	}
+	return d.inner.Start(client)
+}
+
+// Stop stops the wrapped sink, if it needs stopping.
+func (d *DiskBufferSink) Stop() {
+	if stopper, ok := d.inner.(interface{ Stop() }); ok {
+		stopper.Stop()
+	}
+}
+
+// FlushesSketches reports whether the wrapped sink flushes sketches.
+func (d *DiskBufferSink) FlushesSketches() bool {
+	return sinks.FlushesSketches(d.inner)
+}
+
+// Drain drains the wrapped sink, if it can be drained.
+func (d *DiskBufferSink) Drain(ctx context.Context) error {
+	if drainer, ok := d.inner.(interface {
+		Drain(context.Context) error
+	}); ok {
+		return drainer.Drain(ctx)
+	}
+	return nil
+}
+
+// Flush flushes the metrics meant for the wrapped sink to it. If that
+// fails, the ones that failed are buffered, and the wrapped sink's error
+// is returned. If it succeeds, the buffered batches are flushed again,
+// until one fails or ctx is done.
+func (d *DiskBufferSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	accepted := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if sinks.IsAcceptableMetric(metric, d) {
+			accepted = append(accepted, metric)
+		}
+	}
+
+	if err := d.inner.Flush(ctx, accepted); err != nil {
+		if failed := sinks.FailedMetrics(err, accepted); len(failed) > 0 {
+			d.buffer(failed)
+		}
+		return err
+	}
+	d.replay(ctx)
+	return nil
+}
+
+// FlushOtherSamples passes all samples on to the wrapped sink.
+func (d *DiskBufferSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+	d.inner.FlushOtherSamples(ctx, samples)
+}
+
+// load fills the queue with the batches buffered in Dir, ordered by their
+// sequence numbers.
+func (d *DiskBufferSink) load() error {
+	files, err := ioutil.ReadDir(d.Dir)
+	if err != nil {
+		return err
+	}
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.queue, d.size, d.next = nil, 0, 0
+	// ReadDir sorts the files by name, and sequence numbers are padded
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), tmpSuffix) {
+			// a batch that was being written when veneur stopped
+			os.Remove(filepath.Join(d.Dir, file.Name()))
+			continue
+		}
+		seq, ok := parseBatchName(file.Name())
+		if !ok || file.IsDir() {
+			continue
+		}
+		d.queue = append(d.queue, bufferedBatch{name: file.Name(), size: file.Size()})
+		d.size += file.Size()
+		d.next = seq + 1
+	}
+	if len(d.queue) > 0 {
+		d.log.WithFields(logrus.Fields{
+			"sink":    d.Name(),
+			"batches": len(d.queue),
+			"bytes":   d.size,
+		}).Info("Found metrics buffered on disk")
+	}
+	return nil
+}
+
+// buffer writes a batch to a new file, evicting the oldest batches to
+// stay within MaxSize.
+func (d *DiskBufferSink) buffer(batch []samplers.InterMetric) {
+	encoded, err := json.Marshal(batch)
+	if err != nil {
+		d.log.WithError(err).WithField("sink", d.Name()).Error("Could not encode metrics to buffer on disk")
+		return
+	}
+	size := int64(len(encoded))
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if size > d.MaxSize {
+		d.log.WithFields(logrus.Fields{
+			"sink":  d.Name(),
+			"bytes": size,
+		}).Warn("Metrics to buffer on disk are larger than the buffer, dropping them")
+		d.report(MetricKeyEvictedBatches, 1)
+		return
+	}
+	evicted := 0
+	for len(d.queue) > 0 && d.size+size > d.MaxSize {
+		d.removeLocked(d.queue[0].name)
+		evicted++
+	}
+	if evicted > 0 {
+		d.log.WithFields(logrus.Fields{
+			"sink":    d.Name(),
+			"batches": evicted,
+		}).Warn("Disk buffer is full, evicted the oldest metrics")
+		d.report(MetricKeyEvictedBatches, evicted)
+	}
+
+	name := batchName(d.next)
+	if err := writeFile(filepath.Join(d.Dir, name), encoded); err != nil {
+		d.log.WithError(err).WithField("sink", d.Name()).Error("Could not buffer metrics on disk")
+		return
+	}
+	d.next++
+	d.queue = append(d.queue, bufferedBatch{name: name, size: size})
+	d.size += size
+	d.report(MetricKeyBufferedBatches, 1)
+}
+
+// replay flushes the buffered batches to the wrapped sink, oldest first,
+// removing each once it's flushed. It stops at the first batch that
+// fails to flush, which is buffered again with only the metrics that
+// failed if some went through, or once ctx is done. Only one flush replays at a time.
+func (d *DiskBufferSink) replay(ctx context.Context) {
+	d.mtx.Lock()
+	if d.replaying {
+		d.mtx.Unlock()
+		return
+	}
+	d.replaying = true
+	d.mtx.Unlock()
+	defer func() {
+		d.mtx.Lock()
+		d.replaying = false
+		d.mtx.Unlock()
+	}()
+
+	replayed := 0
+	defer func() { d.report(MetricKeyReplayedBatches, replayed) }()
+	for ctx.Err() == nil {
+		d.mtx.Lock()
+		if len(d.queue) == 0 {
+			d.mtx.Unlock()
+			return
+		}
+		name := d.queue[0].name
+		d.mtx.Unlock()
+
+		batch, err := readBatch(filepath.Join(d.Dir, name))
+		if err != nil {
+			d.log.WithError(err).WithFields(logrus.Fields{
+				"sink": d.Name(),
+				"file": name,
+			}).Error("Could not read metrics buffered on disk, dropping them")
+			d.remove(name)
+			d.report(MetricKeyEvictedBatches, 1)
+			continue
+		}
+		if err := d.inner.Flush(ctx, batch); err != nil {
+			if failed := sinks.FailedMetrics(err, batch); len(failed) < len(batch) {
+				// buffer what's left of the batch instead
+				d.remove(name)
+				if len(failed) > 0 {
+					d.buffer(failed)
+				}
+			}
+			return
+		}
+		d.remove(name)
+		replayed++
+	}
+}
+
+// remove deletes a buffered batch, unless it was evicted already.
+func (d *DiskBufferSink) remove(name string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.removeLocked(name)
+}
+
+// removeLocked deletes a buffered batch, unless it was evicted already.
+// mtx must be held.
+func (d *DiskBufferSink) removeLocked(name string) {
+	for i, b := range d.queue {
+		if b.name != name {
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.Dir, name)); err != nil && !os.IsNotExist(err) {
+			d.log.WithError(err).WithField("file", name).Warn("Could not remove metrics buffered on disk")
+		}
+		d.queue = append(d.queue[:i], d.queue[i+1:]...)
+		d.size -= b.size
+		return
+	}
+}
+
+// report counts n batches in the named metric.
+func (d *DiskBufferSink) report(name string, n int) {
+	if n == 0 {
+		return
+	}
+	metrics.ReportOne(d.traceClient, ssf.Count(name, float32(n), map[string]string{"sink": d.Name()}))
+}
+
+// batchName returns the name of the file the batch with the given
+// sequence number is buffered in.
+func batchName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, batchSuffix)
+}
+
+// parseBatchName returns the sequence number of the batch buffered in the
+// named file, if it's the file of a batch.
+func parseBatchName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, batchSuffix) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, batchSuffix), 10, 64)
+	return seq, err == nil
+}
+
+// writeFile writes data to path through a temporary file, so that a crash
+// never leaves a partly written batch behind.
+func writeFile(path string, data []byte) error {
+	tmp := path + tmpSuffix
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readBatch reads the batch buffered at path.
+func readBatch(path string) ([]samplers.InterMetric, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var batch []samplers.InterMetric
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
@@ -0,0 +1,219 @@
+package diskbuffer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks/generic"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// flakySink fails its flushes while down, and remembers the metrics of
+// every successful flush.
+type flakySink struct {
+	down    bool
+	flushed [][]samplers.InterMetric
+}
+
+func (f *flakySink) Name() string              { return "flaky" }
+func (f *flakySink) Start(*trace.Client) error { return nil }
+func (f *flakySink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	if f.down {
+		return errors.New("down")
+	}
+	f.flushed = append(f.flushed, metrics)
+	return nil
+}
+func (f *flakySink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {}
+
+// names returns the names of the metrics of every successful flush.
+func (f *flakySink) names() [][]string {
+	var names [][]string
+	for _, metrics := range f.flushed {
+		var flushed []string
+		for _, m := range metrics {
+			flushed = append(flushed, m.Name)
+		}
+		names = append(names, flushed)
+	}
+	return names
+}
+
+func metricsNamed(names ...string) []samplers.InterMetric {
+	metrics := make([]samplers.InterMetric, len(names))
+	for i, name := range names {
+		metrics[i] = samplers.InterMetric{
+			Name:      name,
+			Timestamp: 1476119058,
+			Value:     float64(i),
+			Tags:      []string{"foo:bar"},
+			Type:      samplers.CounterMetric,
+		}
+	}
+	return metrics
+}
+
+func TestDiskBufferReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskbuffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	inner := &flakySink{down: true}
+	sink, err := NewDiskBufferSink(logrus.New(), inner, dir, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, "flaky", sink.Name())
+	require.NoError(t, sink.Start(nil))
+
+	metrics := append(metricsNamed("a"), samplers.InterMetric{
+		Name:  "elsewhere",
+		Sinks: samplers.RouteInformation{"other": struct{}{}},
+	})
+	assert.Error(t, sink.Flush(context.Background(), metrics))
+	assert.Error(t, sink.Flush(context.Background(), metricsNamed("b")))
+	assert.Len(t, sink.queue, 2)
+
+	inner.down = false
+	require.NoError(t, sink.Flush(context.Background(), metricsNamed("c")))
+	assert.Equal(t, [][]string{{"c"}, {"a"}, {"b"}}, inner.names(), "buffered batches should be replayed oldest first, without the metrics meant for other sinks")
+	assert.Equal(t, metricsNamed("a"), inner.flushed[1], "replayed metrics should be flushed as they were")
+	assert.Empty(t, sink.queue)
+	files, err := ioutil.ReadDir(sink.Dir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "replayed batches should be removed from disk")
+}
+
+func TestDiskBufferEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskbuffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	inner := &flakySink{down: true}
+	sink, err := NewDiskBufferSink(logrus.New(), inner, dir, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	sink.Flush(context.Background(), metricsNamed("a"))
+	// room for two batches of a single metric
+	sink.MaxSize = 2 * sink.size
+	sink.Flush(context.Background(), metricsNamed("b"))
+	sink.Flush(context.Background(), metricsNamed("c"))
+	assert.Len(t, sink.queue, 2)
+	assert.True(t, sink.size <= sink.MaxSize)
+
+	sink.Flush(context.Background(), metricsNamed("d", "e", "f", "g", "h"))
+	assert.Len(t, sink.queue, 2, "a batch larger than the buffer should be dropped")
+
+	inner.down = false
+	require.NoError(t, sink.Flush(context.Background(), nil))
+	assert.Equal(t, [][]string{{"b"}, {"c"}}, inner.names()[1:], "the oldest batch should have been evicted")
+}
+
+func TestDiskBufferRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskbuffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	inner := &flakySink{down: true}
+	sink, err := NewDiskBufferSink(logrus.New(), inner, dir, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	sink.Flush(context.Background(), metricsNamed("a"))
+	sink.Flush(context.Background(), metricsNamed("b"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, batchName(2)+tmpSuffix), []byte("[{"), 0644))
+
+	restarted, err := NewDiskBufferSink(logrus.New(), inner, dir, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Start(nil))
+	assert.Len(t, restarted.queue, 2, "the batches buffered before the restart should be picked up")
+	assert.Equal(t, uint64(2), restarted.next)
+
+	restarted.Flush(context.Background(), metricsNamed("c"))
+	inner.down = false
+	require.NoError(t, restarted.Flush(context.Background(), metricsNamed("d")))
+	assert.Equal(t, [][]string{{"d"}, {"a"}, {"b"}, {"c"}}, inner.names())
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "partly written batches should be cleaned up")
+}
+
+func TestDiskBufferReplayFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskbuffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	inner := &flakySink{down: true}
+	sink, err := NewDiskBufferSink(logrus.New(), inner, dir, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	sink.Flush(context.Background(), metricsNamed("a"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	inner.down = false
+	require.NoError(t, sink.Flush(ctx, metricsNamed("b")))
+	assert.Len(t, sink.queue, 1, "batches shouldn't be replayed once the flush's context is done")
+}
+
+func TestNewDiskBufferSinkInvalid(t *testing.T) {
+	_, err := NewDiskBufferSink(logrus.New(), &flakySink{}, "", 1<<20)
+	assert.Error(t, err)
+	_, err = NewDiskBufferSink(logrus.New(), &flakySink{}, "/tmp", 0)
+	assert.Error(t, err)
+}
+
+func TestDiskBufferPartialFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskbuffer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		mtx      sync.Mutex
+		received []string
+		down     = true
+	)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch generic.GenericMetrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, metric := range batch.Metrics {
+			if down && metric.Metric == "b" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		for _, metric := range batch.Metrics {
+			received = append(received, metric.Metric)
+		}
+	}))
+	defer endpoint.Close()
+
+	inner, err := generic.NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", generic.GenericSinkConfig{
+		GenericEndpoint:  endpoint.URL,
+		GenericBatchSize: 1,
+	})
+	require.NoError(t, err)
+	sink, err := NewDiskBufferSink(logrus.New(), inner, dir, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	assert.Error(t, sink.Flush(context.Background(), metricsNamed("a", "b", "c")))
+	assert.ElementsMatch(t, []string{"a", "c"}, received)
+	require.Len(t, sink.queue, 1)
+
+	mtx.Lock()
+	down, received = false, nil
+	mtx.Unlock()
+	require.NoError(t, sink.Flush(context.Background(), metricsNamed("d")))
+	assert.Equal(t, []string{"d", "b"}, received, "only the batch that failed should be replayed")
+	assert.Empty(t, sink.queue)
+}
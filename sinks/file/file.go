@@ -0,0 +1,198 @@
+// Package file implements a metric sink that writes everything veneur
+// flushes to a local file, for debugging and for replaying later.
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// Line is a single line of a file written by FileSink. Exactly one of
+// Metric or Sample is set, depending on whether it was written by Flush or
+// by FlushOtherSamples.
+type Line struct {
+	Metric *samplers.InterMetric `json:"metric,omitempty"`
+	Sample *ssf.SSFSample        `json:"sample,omitempty"`
+}
+
+// FileSink appends every flushed metric and every other sample as a line
+// of JSON to the file at Path.
+//
+// If MaxSize is set, the file is rotated before a flush would make it grow
+// beyond MaxSize bytes: Path is renamed to Path.1, Path.1 to Path.2 and so
+// on, keeping at most MaxBackups old files.
+type FileSink struct {
+	log        *logrus.Logger
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	// mtx guards the file, since metrics and other samples are flushed
+	// concurrently.
+	mtx  sync.Mutex
+	file *os.File
+	size int64
+}
+
+var _ sinks.MetricSink = &FileSink{}
+
+// NewFileSink returns a new file sink. A maxSize of zero disables
+// rotation, and maxBackups below 1 keeps a single old file.
+func NewFileSink(log *logrus.Logger, path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the file sink needs a path to write to")
+	}
+	if maxBackups < 1 {
+		maxBackups = 1
+	}
+	return &FileSink{
+		log:        log,
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}, nil
+}
+
+// Name returns the sink's name.
+func (f *FileSink) Name() string {
+	return "file"
+}
+
+// Start opens the file for appending, creating it if necessary.
+func (f *FileSink) Start(*trace.Client) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.open()
+}
+
+// Stop closes the file.
+func (f *FileSink) Stop() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// Flush appends each metric as a line of JSON.
+func (f *FileSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	lines := make([]Line, 0, len(metrics))
+	for i := range metrics {
+		if !sinks.IsAcceptableMetric(metrics[i], f) {
+			continue
+		}
+		lines = append(lines, Line{Metric: &metrics[i]})
+	}
+	if err := f.write(lines); err != nil {
+		f.log.WithError(err).WithField("path", f.Path).Error("Could not write metrics to file")
+		return err
+	}
+	return nil
+}
+
+// FlushOtherSamples appends each sample as a line of JSON.
+func (f *FileSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+	lines := make([]Line, len(samples))
+	for i := range samples {
+		lines[i].Sample = &samples[i]
+	}
+	if err := f.write(lines); err != nil {
+		f.log.WithError(err).WithField("path", f.Path).Error("Could not write samples to file")
+	}
+}
+
+// write encodes lines and appends them to the file in one go, rotating it
+// first if it would grow too large.
+func (f *FileSink) write(lines []Line) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	var buf []byte
+	for _, line := range lines {
+		encoded, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, encoded...)
+		buf = append(buf, '\n')
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.file == nil {
+		return fmt.Errorf("the file sink isn't started")
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(buf)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(buf)
+	f.size += int64(n)
+	return err
+}
+
+// open opens the file for appending. mtx must be held.
+func (f *FileSink) open() error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts the old files along, moves the current file out of the
+// way and opens a new one. mtx must be held.
+func (f *FileSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	for i := f.MaxBackups - 1; i > 0; i-- {
+		err := os.Rename(backupPath(f.Path, i), backupPath(f.Path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.Path, backupPath(f.Path, 1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// ReadLines decodes the lines of a file written by FileSink, e.g. to
+// replay them.
+func ReadLines(r io.Reader) ([]Line, error) {
+	var lines []Line
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		var line Line
+		if err := dec.Decode(&line); err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
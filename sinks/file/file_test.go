@@ -0,0 +1,83 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+func readFile(t *testing.T, path string) []Line {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	lines, err := ReadLines(f)
+	require.NoError(t, err)
+	return lines
+}
+
+func TestFlushAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flushed.jsonl")
+
+	sink, err := NewFileSink(logrus.New(), path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	metrics := []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     2,
+		Tags:      []string{"foo:bar"},
+		Type:      samplers.CounterMetric,
+	}, {
+		Name:  "a.b.d",
+		Value: 1,
+		Type:  samplers.GaugeMetric,
+		Sinks: samplers.RouteInformation{"datadog": struct{}{}},
+	}}
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	sink.FlushOtherSamples(context.Background(), []ssf.SSFSample{{Name: "an.event", Message: "hi"}})
+
+	lines := readFile(t, path)
+	require.Len(t, lines, 2, "metrics not routed to the sink shouldn't be written")
+	assert.Equal(t, &metrics[0], lines[0].Metric)
+	assert.Nil(t, lines[0].Sample)
+	assert.Nil(t, lines[1].Metric)
+	if assert.NotNil(t, lines[1].Sample) {
+		assert.Equal(t, "an.event", lines[1].Sample.Name)
+		assert.Equal(t, "hi", lines[1].Sample.Message)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flushed.jsonl")
+
+	sink, err := NewFileSink(logrus.New(), path, 10, 2)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	for _, name := range []string{"first", "second", "third", "fourth"} {
+		require.NoError(t, sink.Flush(context.Background(), []samplers.InterMetric{{Name: name}}))
+	}
+
+	assert.Equal(t, "fourth", readFile(t, path)[0].Metric.Name)
+	assert.Equal(t, "third", readFile(t, path+".1")[0].Metric.Name)
+	assert.Equal(t, "second", readFile(t, path+".2")[0].Metric.Name)
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "only MaxBackups old files should be kept")
+}
@@ -0,0 +1,134 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/sinks/generic"
+	"github.com/stripe/veneur/ssf"
+)
+
+// ReplayBatchSize is the number of metrics Replay flushes at a time, for
+// archives that don't record the batches they were flushed in.
+const ReplayBatchSize = 1000
+
+// Replay reads the metrics and other samples archived in r and flushes
+// them to sink, e.g. to backfill a new backend, or to test a sink against
+// production data. r may hold the lines written by FileSink, or batches
+// in the generic sink's FormatJSON or FormatNDJSON, like those the S3 sink
+// archives (see generic.GenericMetric.InterMetric for what it can't
+// convert back). Batches in FormatJSON are flushed as they were archived,
+// and other metrics up to ReplayBatchSize at a time. Replay stops at the
+// first error, from decoding r or from the sink.
+func Replay(ctx context.Context, r io.Reader, sink sinks.MetricSink) error {
+	var (
+		metrics []samplers.InterMetric
+		samples []ssf.SSFSample
+	)
+	flush := func() error {
+		if len(samples) > 0 {
+			sink.FlushOtherSamples(ctx, samples)
+			samples = nil
+		}
+		if len(metrics) == 0 {
+			return nil
+		}
+		err := sink.Flush(ctx, metrics)
+		metrics = nil
+		return err
+	}
+
+	dec := json.NewDecoder(r)
+	for n := 1; dec.More(); n++ {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return fmt.Errorf("could not decode archived value %d: %v", n, err)
+		}
+		replayed, err := replayValue(value)
+		if err != nil {
+			return fmt.Errorf("could not replay archived value %d: %v", n, err)
+		}
+		if replayed.batch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		metrics = append(metrics, replayed.metrics...)
+		samples = append(samples, replayed.samples...)
+		if replayed.batch || len(metrics) >= ReplayBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// replayed is what an archived value holds.
+type replayed struct {
+	metrics []samplers.InterMetric
+	samples []ssf.SSFSample
+	// batch is set if the metrics were flushed together
+	batch bool
+}
+
+// replayValue converts an archived JSON object into the metrics or
+// samples it holds.
+func replayValue(value json.RawMessage) (replayed, error) {
+	var (
+		r     replayed
+		probe struct {
+			Metrics json.RawMessage `json:"metrics"`
+			Metric  json.RawMessage `json:"metric"`
+			Sample  json.RawMessage `json:"sample"`
+		}
+	)
+	if err := json.Unmarshal(value, &probe); err != nil {
+		return r, err
+	}
+	switch {
+	case probe.Metrics != nil:
+		var batch generic.GenericMetrics
+		if err := json.Unmarshal(value, &batch); err != nil {
+			return r, err
+		}
+		r.batch = true
+		for _, genMetric := range batch.Metrics {
+			metric, err := genMetric.InterMetric()
+			if err != nil {
+				return r, err
+			}
+			r.metrics = append(r.metrics, metric)
+		}
+	case probe.Sample != nil:
+		var line Line
+		if err := json.Unmarshal(value, &line); err != nil {
+			return r, err
+		}
+		r.samples = append(r.samples, *line.Sample)
+	case len(probe.Metric) > 0 && probe.Metric[0] == '{':
+		var line Line
+		if err := json.Unmarshal(value, &line); err != nil {
+			return r, err
+		}
+		r.metrics = append(r.metrics, *line.Metric)
+	case probe.Metric != nil:
+		// a line of a batch in FormatNDJSON
+		var genMetric generic.GenericMetric
+		if err := json.Unmarshal(value, &genMetric); err != nil {
+			return r, err
+		}
+		metric, err := genMetric.InterMetric()
+		if err != nil {
+			return r, err
+		}
+		r.metrics = append(r.metrics, metric)
+	default:
+		return r, fmt.Errorf("neither a metric, a sample nor a batch of metrics")
+	}
+	return r, nil
+}
@@ -0,0 +1,130 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks/generic"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// recordingSink records what is flushed to it.
+type recordingSink struct {
+	flushes [][]samplers.InterMetric
+	samples []ssf.SSFSample
+}
+
+func (r *recordingSink) Name() string              { return "recording" }
+func (r *recordingSink) Start(*trace.Client) error { return nil }
+
+func (r *recordingSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	r.flushes = append(r.flushes, metrics)
+	return nil
+}
+
+func (r *recordingSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+	r.samples = append(r.samples, samples...)
+}
+
+func replayMetrics() []samplers.InterMetric {
+	return []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     2,
+		Tags:      []string{"foo:bar"},
+		Type:      samplers.CounterMetric,
+	}, {
+		Name:      "a.b.d",
+		Timestamp: 1476119058,
+		Value:     1,
+		Tags:      []string{},
+		Type:      samplers.GaugeMetric,
+	}}
+}
+
+func TestReplayFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flushed.jsonl")
+
+	sink, err := NewFileSink(logrus.New(), path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	require.NoError(t, sink.Flush(context.Background(), replayMetrics()))
+	sink.FlushOtherSamples(context.Background(), []ssf.SSFSample{{Name: "an.event", Message: "hi"}})
+	require.NoError(t, sink.Flush(context.Background(), replayMetrics()))
+	sink.Stop()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	replayTo := &recordingSink{}
+	require.NoError(t, Replay(context.Background(), f, replayTo))
+
+	require.Len(t, replayTo.flushes, 1, "lines should be flushed together")
+	assert.Equal(t, append(replayMetrics(), replayMetrics()...), replayTo.flushes[0])
+	if assert.Len(t, replayTo.samples, 1) {
+		assert.Equal(t, "an.event", replayTo.samples[0].Name)
+	}
+}
+
+func TestReplayGenericFormats(t *testing.T) {
+	for _, format := range []string{generic.FormatJSON, generic.FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			gmSink, err := generic.NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", generic.GenericSinkConfig{
+				GenericEndpoint:  "http://localhost:8080/metrics",
+				GenericBatchSize: 100,
+				GenericFormat:    format,
+			})
+			require.NoError(t, err)
+			var archive bytes.Buffer
+			require.NoError(t, gmSink.WriteMetrics(&archive, replayMetrics()))
+			require.NoError(t, gmSink.WriteMetrics(&archive, replayMetrics()))
+
+			replayTo := &recordingSink{}
+			require.NoError(t, Replay(context.Background(), &archive, replayTo))
+			var replayed []samplers.InterMetric
+			for _, flush := range replayTo.flushes {
+				replayed = append(replayed, flush...)
+			}
+			assert.Equal(t, append(replayMetrics(), replayMetrics()...), replayed)
+			if format == generic.FormatJSON {
+				assert.Len(t, replayTo.flushes, 2, "archived batches should be flushed as they were")
+			}
+		})
+	}
+}
+
+func TestReplayBatchSize(t *testing.T) {
+	var archive strings.Builder
+	for i := 0; i < ReplayBatchSize+1; i++ {
+		archive.WriteString(`{"metric": {"Name": "a.b.c", "Value": 1}}` + "\n")
+	}
+	replayTo := &recordingSink{}
+	require.NoError(t, Replay(context.Background(), strings.NewReader(archive.String()), replayTo))
+	if assert.Len(t, replayTo.flushes, 2) {
+		assert.Len(t, replayTo.flushes[0], ReplayBatchSize)
+		assert.Len(t, replayTo.flushes[1], 1)
+	}
+}
+
+func TestReplayInvalid(t *testing.T) {
+	for _, archive := range []string{
+		`{"metric": {"Name": "a.b.c"}}` + "\n" + `{"neither": true}`,
+		`{"metric": "a.b.c", "type": "count"}`,
+		`{"metric": `,
+	} {
+		assert.Error(t, Replay(context.Background(), strings.NewReader(archive), &recordingSink{}), "replaying %q", archive)
+	}
+}
@@ -0,0 +1,177 @@
+package generic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+)
+
+// MetricKeyAcceptedMetrics and MetricKeyRejectedMetrics are emitted as
+// counters of the metrics the endpoint said it accepted and rejected, if
+// the sink has a ResponseSchema, tagged with `sink:sink.Name()`.
+// MetricKeyUnreadableResponses counts the responses that didn't match
+// the ResponseSchema.
+const (
+	MetricKeyAcceptedMetrics     = "sink.generic.accepted_metrics_total"
+	MetricKeyRejectedMetrics     = "sink.generic.rejected_metrics_total"
+	MetricKeyUnreadableResponses = "sink.generic.unreadable_responses_total"
+)
+
+// maxLoggedRejections caps the number of rejected metrics logged for a
+// batch.
+const maxLoggedRejections = 20
+
+// ResponseSchema describes where, in the JSON object an endpoint answers
+// a batch with, it says which of the batch's metrics it accepted. Every
+// field is the path of a field of the object, its keys separated by dots
+// (e.g. "result.accepted"); empty fields are left out.
+type ResponseSchema struct {
+	// Accepted and Rejected are the paths of the numbers of metrics
+	// accepted and rejected. Either can be left out: it's worked out from
+	// the other and the size of the batch.
+	Accepted string `yaml:"accepted"`
+	Rejected string `yaml:"rejected"`
+	// RejectedMetrics is the path of the list of rejected metrics, as
+	// names, or as objects holding their names at the path
+	// RejectedMetricName. If Rejected is left out, rejected metrics are
+	// counted from it.
+	RejectedMetrics    string `yaml:"rejected_metrics"`
+	RejectedMetricName string `yaml:"rejected_metric_name"`
+}
+
+// checkResponseSchema checks that a schema can tell which metrics were
+// accepted.
+func checkResponseSchema(schema ResponseSchema) error {
+	if schema.Accepted == "" && schema.Rejected == "" && schema.RejectedMetrics == "" {
+		return fmt.Errorf("the response schema needs the path of the accepted or rejected metrics")
+	}
+	if schema.RejectedMetricName != "" && schema.RejectedMetrics == "" {
+		return fmt.Errorf("the response schema's rejected_metric_name needs rejected_metrics")
+	}
+	return nil
+}
+
+// acknowledgment is what an endpoint said of a batch it was sent.
+type acknowledgment struct {
+	accepted, rejected int
+	rejectedNames      []string
+}
+
+// parseAcknowledgment reads what the endpoint said of a batch of size
+// metrics from its response, according to ResponseSchema.
+func (gm *GenericMetricSink) parseAcknowledgment(response []byte, size int) (acknowledgment, error) {
+	schema := gm.ResponseSchema
+	var ack acknowledgment
+	var decoded interface{}
+	if err := json.Unmarshal(response, &decoded); err != nil {
+		return ack, err
+	}
+
+	if schema.RejectedMetrics != "" {
+		list, ok := lookupPath(decoded, schema.RejectedMetrics).([]interface{})
+		if !ok {
+			return ack, fmt.Errorf("no list of rejected metrics at %q", schema.RejectedMetrics)
+		}
+		for _, rejected := range list {
+			if schema.RejectedMetricName != "" {
+				rejected = lookupPath(rejected, schema.RejectedMetricName)
+			}
+			name, _ := rejected.(string)
+			ack.rejectedNames = append(ack.rejectedNames, name)
+		}
+	}
+
+	accepted, hasAccepted, err := lookupCount(decoded, schema.Accepted)
+	if err != nil {
+		return ack, err
+	}
+	rejected, hasRejected, err := lookupCount(decoded, schema.Rejected)
+	if err != nil {
+		return ack, err
+	}
+	if !hasRejected && schema.RejectedMetrics != "" {
+		rejected, hasRejected = len(ack.rejectedNames), true
+	}
+	switch {
+	case hasAccepted && hasRejected:
+		ack.accepted, ack.rejected = accepted, rejected
+	case hasAccepted:
+		ack.accepted, ack.rejected = accepted, size-accepted
+	case hasRejected:
+		ack.accepted, ack.rejected = size-rejected, rejected
+	default:
+		return ack, fmt.Errorf("no count of accepted or rejected metrics")
+	}
+	return ack, nil
+}
+
+// acknowledge reports what the endpoint said of a batch of size metrics
+// in its response, if the sink has a ResponseSchema. Rejected metrics are
+// logged if LogRejectedMetrics is set.
+func (gm *GenericMetricSink) acknowledge(response []byte, size int, endpoint string, samples *ssf.Samples, tags map[string]string) {
+	if gm.ResponseSchema == nil {
+		return
+	}
+	ack, err := gm.parseAcknowledgment(response, size)
+	if err != nil {
+		samples.Add(ssf.Count(MetricKeyUnreadableResponses, 1, tags))
+		if len(response) > maxLoggedResponse {
+			response = response[:maxLoggedResponse]
+		}
+		gm.log.WithFields(logrus.Fields{
+			"endpoint":      endpoint,
+			"response":      string(response),
+			logrus.ErrorKey: err,
+		}).Warn("Could not read which generic metrics the endpoint accepted")
+		return
+	}
+	samples.Add(
+		ssf.Count(MetricKeyAcceptedMetrics, float32(ack.accepted), tags),
+		ssf.Count(MetricKeyRejectedMetrics, float32(ack.rejected), tags),
+	)
+	if ack.rejected == 0 || !gm.LogRejectedMetrics {
+		return
+	}
+	names := ack.rejectedNames
+	if len(names) > maxLoggedRejections {
+		names = names[:maxLoggedRejections]
+	}
+	gm.log.WithFields(logrus.Fields{
+		"endpoint": endpoint,
+		"accepted": ack.accepted,
+		"rejected": ack.rejected,
+		"metrics":  names,
+	}).Warn("The endpoint rejected some generic metrics")
+}
+
+// lookupPath returns the value at a dot-separated path of keys in a
+// decoded JSON object, or nil if there is none.
+func lookupPath(value interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// lookupCount returns the number at path in a decoded JSON object, and
+// whether there is one. An empty path has no number.
+func lookupCount(value interface{}, path string) (int, bool, error) {
+	if path == "" {
+		return 0, false, nil
+	}
+	switch count := lookupPath(value, path).(type) {
+	case float64:
+		return int(count), true, nil
+	case nil:
+		return 0, false, nil
+	default:
+		return 0, false, fmt.Errorf("%q isn't a number", path)
+	}
+}
@@ -0,0 +1,130 @@
+package generic
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAcknowledgment(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		schema   ResponseSchema
+		response string
+		expected acknowledgment
+	}{
+		{
+			name:     "both counts",
+			schema:   ResponseSchema{Accepted: "accepted", Rejected: "rejected"},
+			response: `{"accepted": 8, "rejected": 2}`,
+			expected: acknowledgment{accepted: 8, rejected: 2},
+		},
+		{
+			name:     "accepted only",
+			schema:   ResponseSchema{Accepted: "result.accepted"},
+			response: `{"result": {"accepted": 7}}`,
+			expected: acknowledgment{accepted: 7, rejected: 3},
+		},
+		{
+			name:     "rejected names",
+			schema:   ResponseSchema{RejectedMetrics: "rejected"},
+			response: `{"rejected": ["a.b", "c.d"]}`,
+			expected: acknowledgment{accepted: 8, rejected: 2, rejectedNames: []string{"a.b", "c.d"}},
+		},
+		{
+			name:     "rejected objects",
+			schema:   ResponseSchema{Accepted: "ok", RejectedMetrics: "errors", RejectedMetricName: "metric.name"},
+			response: `{"ok": 9, "errors": [{"metric": {"name": "a.b"}, "reason": "bad tag"}]}`,
+			expected: acknowledgment{accepted: 9, rejected: 1, rejectedNames: []string{"a.b"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gmSink := defaultTestSink()
+			gmSink.ResponseSchema = &tc.schema
+			ack, err := gmSink.parseAcknowledgment([]byte(tc.response), 10)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ack)
+		})
+	}
+}
+
+func TestParseAcknowledgmentUnreadable(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.ResponseSchema = &ResponseSchema{Accepted: "accepted", RejectedMetrics: "rejected"}
+	for _, response := range []string{
+		``,
+		`OK`,
+		`{"accepted": "all"}`,
+		`{"accepted": 1, "rejected": {}}`,
+		`{"something": "else"}`,
+	} {
+		_, err := gmSink.parseAcknowledgment([]byte(response), 10)
+		assert.Error(t, err, "response %q", response)
+	}
+}
+
+func TestFlushAcknowledgment(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.ResponseSchema = &ResponseSchema{Accepted: "accepted", RejectedMetrics: "errors", RejectedMetricName: "metric"}
+	gmSink.LogRejectedMetrics = true
+	transport.Response = `{"accepted": 1, "errors": [{"metric": "gauge.bar", "reason": "out of range"}]}`
+	ch := startTraceClient(t, gmSink)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	samples := reportedSamples(ch)
+	if assert.Len(t, samples[MetricKeyAcceptedMetrics], 1) {
+		assert.Equal(t, float32(1), samples[MetricKeyAcceptedMetrics][0].Value)
+		assert.Equal(t, gmSink.Name(), samples[MetricKeyAcceptedMetrics][0].Tags["sink"])
+	}
+	if assert.Len(t, samples[MetricKeyRejectedMetrics], 1) {
+		assert.Equal(t, float32(1), samples[MetricKeyRejectedMetrics][0].Value)
+	}
+	assert.Empty(t, samples[MetricKeyUnreadableResponses])
+}
+
+func TestFlushAcknowledgmentUnreadable(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.ResponseSchema = &ResponseSchema{Accepted: "accepted"}
+	transport.Response = `<html>OK</html>`
+	ch := startTraceClient(t, gmSink)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()), "an unreadable response shouldn't fail the flush")
+	samples := reportedSamples(ch)
+	assert.Len(t, samples[MetricKeyUnreadableResponses], 1)
+	assert.Empty(t, samples[MetricKeyAcceptedMetrics])
+}
+
+func TestFlushAcknowledgmentDisabled(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	transport.Response = `{"accepted": 1}`
+	ch := startTraceClient(t, gmSink)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	samples := reportedSamples(ch)
+	assert.Empty(t, samples[MetricKeyAcceptedMetrics], "responses shouldn't be read without a schema")
+	assert.Empty(t, samples[MetricKeyUnreadableResponses])
+}
+
+func TestNewGenericMetricSinkFromConfigResponseSchema(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint:       "http://localhost:8080/metrics",
+		GenericBatchSize:      100,
+		GenericResponseSchema: ResponseSchema{Rejected: "rejected"},
+	}
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	require.NotNil(t, sink.ResponseSchema)
+	assert.Equal(t, "rejected", sink.ResponseSchema.Rejected)
+
+	conf.GenericResponseSchema = ResponseSchema{}
+	sink, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.Nil(t, sink.ResponseSchema, "responses shouldn't be read without a schema")
+
+	conf.GenericResponseSchema = ResponseSchema{RejectedMetricName: "name"}
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err)
+}
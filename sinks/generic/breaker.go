@@ -0,0 +1,142 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+)
+
+// MetricKeyCircuitBreakerTransitions is emitted as a counter every time
+// the circuit breaker changes state, tagged with `sink:sink.Name()` and
+// `state` (one of "open", "half_open" or "closed", the new state).
+const MetricKeyCircuitBreakerTransitions = "sink.generic.circuit_breaker_transitions_total"
+
+// MetricKeyBatchesSkipped is emitted as a counter for every batch that
+// isn't sent because the circuit breaker is open, tagged with
+// `sink:sink.Name()`.
+const MetricKeyBatchesSkipped = "sink.generic.batches_skipped_total"
+
+// DefaultCircuitBreakerCooldown is how long the circuit breaker stays
+// open, unless CircuitBreakerCooldown says otherwise.
+const DefaultCircuitBreakerCooldown = 30 * time.Second
+
+// ErrCircuitOpen is returned for batches that aren't sent because the
+// circuit breaker is open.
+var ErrCircuitOpen = fmt.Errorf("the generic sink's circuit breaker is open")
+
+// The states of the circuit breaker.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker tracks the consecutive failures of batches. It opens
+// after CircuitBreakerThreshold of them, and half-opens once it has been
+// open for the cooldown, letting a single batch through to probe the
+// endpoint: the breaker closes if it goes through, and opens again if it
+// doesn't.
+type circuitBreaker struct {
+	mtx      sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allowBatch reports whether a batch may be sent, and the state the
+// breaker moved to if that changed.
+func (gm *GenericMetricSink) allowBatch() (bool, string) {
+	if gm.CircuitBreakerThreshold < 1 {
+		return true, ""
+	}
+	cb := &gm.breaker
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if gm.clock().Sub(cb.openedAt) < gm.breakerCooldown() {
+			return false, ""
+		}
+		cb.state = breakerHalfOpen
+		cb.probing = true
+		return true, breakerHalfOpen
+	case breakerHalfOpen:
+		if cb.probing {
+			return false, ""
+		}
+		cb.probing = true
+		return true, ""
+	}
+	return true, ""
+}
+
+// breakerCooldown is how long the circuit breaker stays open.
+func (gm *GenericMetricSink) breakerCooldown() time.Duration {
+	if gm.CircuitBreakerCooldown <= 0 {
+		return DefaultCircuitBreakerCooldown
+	}
+	return gm.CircuitBreakerCooldown
+}
+
+// recordBatch records the outcome of sending a batch, and returns the
+// state the breaker moved to if that changed. Only failures that suggest
+// the endpoint is down count: an endpoint rejecting a batch is up, and a
+// flush running out of time, a batch failing to encode or being dropped
+// while it waits says nothing about the endpoint.
+func (gm *GenericMetricSink) recordBatch(ctx context.Context, err error) string {
+	if gm.CircuitBreakerThreshold < 1 {
+		return ""
+	}
+	cb := &gm.breaker
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	wasProbing := cb.probing
+	cb.probing = false
+	switch {
+	case err != nil && (ctx.Err() != nil || errors.Is(err, ErrSerialize) || err == errPendingDropped):
+		return ""
+	case err == nil || !retryable(err):
+		cb.failures = 0
+		if cb.state == breakerOpen || cb.state == breakerHalfOpen {
+			cb.state = breakerClosed
+			return breakerClosed
+		}
+		return ""
+	}
+	cb.failures++
+	if (cb.state == breakerHalfOpen && wasProbing) || (cb.state != breakerOpen && cb.failures >= gm.CircuitBreakerThreshold) {
+		cb.state = breakerOpen
+		cb.openedAt = gm.clock()
+		return breakerOpen
+	}
+	return ""
+}
+
+// reportBreaker records and logs the breaker moving to state, if it did.
+func (gm *GenericMetricSink) reportBreaker(state string, samples *ssf.Samples, tags map[string]string) {
+	if state == "" {
+		return
+	}
+	transitionTags := map[string]string{"state": state}
+	for k, v := range tags {
+		transitionTags[k] = v
+	}
+	samples.Add(ssf.Count(MetricKeyCircuitBreakerTransitions, 1, transitionTags))
+	switch state {
+	case breakerOpen:
+		gm.log.WithFields(logrus.Fields{
+			"threshold": gm.CircuitBreakerThreshold,
+			"cooldown":  gm.breakerCooldown(),
+		}).Warn("Generic sink's circuit breaker opened, skipping batches until the endpoint recovers")
+	case breakerHalfOpen:
+		gm.log.Info("Generic sink's circuit breaker half-opened, probing the endpoint")
+	case breakerClosed:
+		gm.log.Info("Generic sink's circuit breaker closed, the endpoint recovered")
+	}
+}
@@ -0,0 +1,115 @@
+package generic
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// breakerTestSink returns a sink whose circuit breaker opens after two
+// failed batches, and the function that moves its clock forward.
+func breakerTestSink() (*GenericMetricSink, *GenericRoundTripper, func(time.Duration)) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.CircuitBreakerThreshold = 2
+	gmSink.CircuitBreakerCooldown = time.Minute
+	now := time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	gmSink.now = func() time.Time { return now }
+	return gmSink, transport, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	gmSink, transport, _ := breakerTestSink()
+	transport.Failures = 10
+
+	for i := 0; i < 2; i++ {
+		assert.Error(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	}
+	require.Equal(t, 2, transport.Called)
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	if assert.IsType(t, &BatchErrors{}, err) {
+		assert.Equal(t, ErrCircuitOpen, err.(*BatchErrors).Errors[0])
+	}
+	assert.Equal(t, 2, transport.Called, "batches shouldn't be sent while the breaker is open")
+}
+
+func TestCircuitBreakerRecovers(t *testing.T) {
+	gmSink, transport, advance := breakerTestSink()
+	transport.Failures = 2
+
+	for i := 0; i < 3; i++ {
+		gmSink.Flush(context.TODO(), basicInterMetrics())
+	}
+	require.Equal(t, 2, transport.Called)
+
+	advance(time.Minute)
+	assert.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()), "the breaker should let a probe through after the cooldown")
+	assert.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()), "the breaker should close once a probe goes through")
+	assert.Equal(t, 4, transport.Called)
+	assert.Equal(t, breakerClosed, gmSink.breaker.state)
+}
+
+func TestCircuitBreakerProbeFails(t *testing.T) {
+	gmSink, transport, advance := breakerTestSink()
+	transport.Failures = 3
+
+	for i := 0; i < 2; i++ {
+		gmSink.Flush(context.TODO(), basicInterMetrics())
+	}
+	advance(time.Minute)
+	assert.Error(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	require.Equal(t, 3, transport.Called)
+	assert.Equal(t, breakerOpen, gmSink.breaker.state, "a failed probe should open the breaker again")
+
+	gmSink.Flush(context.TODO(), basicInterMetrics())
+	assert.Equal(t, 3, transport.Called, "the cooldown should start over after a failed probe")
+	advance(time.Minute)
+	assert.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	assert.Equal(t, 4, transport.Called)
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	gmSink, transport, _ := breakerTestSink()
+	transport.Failures = 3
+	transport.FailureCode = http.StatusBadRequest
+
+	for i := 0; i < 3; i++ {
+		gmSink.Flush(context.TODO(), basicInterMetrics())
+	}
+	assert.Equal(t, 3, transport.Called, "an endpoint rejecting batches isn't down")
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	transport.Failures = 10
+
+	for i := 0; i < 10; i++ {
+		gmSink.Flush(context.TODO(), basicInterMetrics())
+	}
+	assert.Equal(t, 10, transport.Called)
+}
+
+func TestCircuitBreakerReportsMetrics(t *testing.T) {
+	gmSink, transport, advance := breakerTestSink()
+	transport.Failures = 2
+	ch := startTraceClient(t, gmSink)
+
+	for i := 0; i < 3; i++ {
+		gmSink.Flush(context.TODO(), basicInterMetrics())
+	}
+	advance(time.Minute)
+	gmSink.Flush(context.TODO(), basicInterMetrics())
+
+	samples := reportedSamples(ch)
+	assert.Equal(t, float32(1), sampleTotal(samples[MetricKeyBatchesSkipped]))
+	var states []string
+	for _, sample := range samples[MetricKeyCircuitBreakerTransitions] {
+		assert.Equal(t, "generic", sample.Tags["sink"])
+		states = append(states, sample.Tags["state"])
+	}
+	assert.Equal(t, []string{breakerOpen, breakerHalfOpen, breakerClosed}, states)
+}
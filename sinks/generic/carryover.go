@@ -0,0 +1,118 @@
+package generic
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyCountersCarriedOver is emitted as a counter of the counters
+// from failed batches that are held onto until the next flush, tagged
+// with `sink:sink.Name()`.
+const MetricKeyCountersCarriedOver = "sink.generic.counters_carried_over_total"
+
+// MetricKeyCarryOverDropped is emitted as a counter of the counters from
+// failed batches that are dropped because too many are held onto
+// already, tagged with `sink:sink.Name()`.
+const MetricKeyCarryOverDropped = "sink.generic.carry_over_dropped_total"
+
+// maxCarriedOver is the number of counters CarryOverCounters holds onto
+// at most.
+const maxCarriedOver = 1 << 16
+
+// carryOver holds onto the counters of a batch that failed with err, so
+// that they're added to the next flush, if CarryOverCounters is set.
+// Batches the endpoint rejected aren't held onto, since their counters
+// would only be rejected again.
+func (gm *GenericMetricSink) carryOver(batch []samplers.InterMetric, err error) {
+	if !gm.CarryOverCounters || !retryable(err) {
+		return
+	}
+	gm.carryMtx.Lock()
+	if gm.carried == nil {
+		gm.carried = map[string]samplers.InterMetric{}
+	}
+	carried, dropped := 0, 0
+	for _, metric := range batch {
+		if metric.Type != samplers.CounterMetric {
+			continue
+		}
+		key := counterKey(metric)
+		if prev, ok := gm.carried[key]; ok {
+			metric.Value += prev.Value
+		} else if len(gm.carried) >= maxCarriedOver {
+			dropped++
+			continue
+		}
+		gm.carried[key] = metric
+		carried++
+	}
+	gm.carryMtx.Unlock()
+
+	samples := &ssf.Samples{}
+	defer metrics.Report(gm.traceClient, samples)
+	tags := map[string]string{"sink": gm.Name()}
+	if carried > 0 {
+		samples.Add(ssf.Count(MetricKeyCountersCarriedOver, float32(carried), tags))
+	}
+	if dropped > 0 {
+		samples.Add(ssf.Count(MetricKeyCarryOverDropped, float32(dropped), tags))
+		gm.log.WithFields(logrus.Fields{
+			"dropped": dropped,
+			"max":     maxCarriedOver,
+		}).Warn("Too many counters carried over, dropping some")
+	}
+}
+
+// mergeCarriedOver adds the counters held onto by carryOver to metrics:
+// to the value of the same counter (by name and tags) if metrics has it,
+// and as counters of their own otherwise. metrics is left untouched,
+// since it is shared with other sinks. The counters it took are returned
+// too, so that they can be carried over again if the flush is refused.
+func (gm *GenericMetricSink) mergeCarriedOver(metrics []samplers.InterMetric) (merged, taken []samplers.InterMetric) {
+	gm.carryMtx.Lock()
+	carried := gm.carried
+	gm.carried = nil
+	gm.carryMtx.Unlock()
+	if len(carried) == 0 {
+		return metrics, nil
+	}
+	taken = make([]samplers.InterMetric, 0, len(carried))
+	for _, metric := range carried {
+		taken = append(taken, metric)
+	}
+
+	merged = make([]samplers.InterMetric, 0, len(metrics)+len(carried))
+	for _, metric := range metrics {
+		if metric.Type == samplers.CounterMetric {
+			key := counterKey(metric)
+			if prev, ok := carried[key]; ok {
+				metric.Value += prev.Value
+				delete(carried, key)
+			}
+		}
+		merged = append(merged, metric)
+	}
+	keys := make([]string, 0, len(carried))
+	for key := range carried {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		merged = append(merged, carried[key])
+	}
+	return merged, taken
+}
+
+// counterKey identifies a counter by its name and tags, whatever order
+// its tags are in.
+func counterKey(metric samplers.InterMetric) string {
+	tags := make([]string, len(metric.Tags))
+	copy(tags, metric.Tags)
+	sort.Strings(tags)
+	return metric.Name + "|" + strings.Join(tags, ",")
+}
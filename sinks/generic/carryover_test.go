@@ -0,0 +1,147 @@
+package generic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func carryOverMetrics() []samplers.InterMetric {
+	return []samplers.InterMetric{{
+		Name:  "counter.foo",
+		Value: 2,
+		Tags:  []string{"a:b", "c:d"},
+		Type:  samplers.CounterMetric,
+	}, {
+		Name:  "counter.bar",
+		Value: 3,
+		Type:  samplers.CounterMetric,
+	}, {
+		Name:  "gauge.baz",
+		Value: 4,
+		Type:  samplers.GaugeMetric,
+	}}
+}
+
+// flushedValues decodes the metrics a round tripper received.
+func flushedValues(t *testing.T, transport *GenericRoundTripper) map[string]float64 {
+	values := map[string]float64{}
+	for _, content := range transport.Contents {
+		var batch GenericMetrics
+		require.NoError(t, json.Unmarshal([]byte(content), &batch))
+		for _, metric := range batch.Metrics {
+			values[metric.Metric] += metric.Value
+		}
+	}
+	return values
+}
+
+func TestCarryOverCounters(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.CarryOverCounters = true
+	transport.Failures = 1
+
+	assert.Error(t, gmSink.Flush(context.TODO(), carryOverMetrics()))
+
+	next := []samplers.InterMetric{{
+		Name:  "counter.foo",
+		Value: 5,
+		Tags:  []string{"c:d", "a:b"},
+		Type:  samplers.CounterMetric,
+	}}
+	require.NoError(t, gmSink.Flush(context.TODO(), next))
+	assert.Equal(t, map[string]float64{
+		"counter.foo": 7,
+		"counter.bar": 3,
+	}, flushedValues(t, transport), "counters of a failed batch should be added to the next flush, and gauges shouldn't")
+	assert.Equal(t, float64(5), next[0].Value, "the flushed metrics shouldn't be modified")
+
+	transport.Contents = nil
+	require.NoError(t, gmSink.Flush(context.TODO(), next))
+	assert.Equal(t, map[string]float64{"counter.foo": 5}, flushedValues(t, transport), "counters should only be carried over once")
+}
+
+func TestCarryOverCountersCancelled(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 1)
+	gmSink.CarryOverCounters = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, gmSink.Flush(ctx, carryOverMetrics()))
+	require.Equal(t, 0, transport.Called)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), nil))
+	assert.Equal(t, map[string]float64{
+		"counter.foo": 2,
+		"counter.bar": 3,
+	}, flushedValues(t, transport), "counters of batches that weren't sent should be carried over too")
+}
+
+func TestCarryOverCountersRejected(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.CarryOverCounters = true
+	gmSink.RetryBaseDelay = time.Millisecond
+	transport.Failures = 1
+	transport.FailureCode = http.StatusBadRequest
+
+	assert.Error(t, gmSink.Flush(context.TODO(), carryOverMetrics()))
+	require.NoError(t, gmSink.Flush(context.TODO(), carryOverMetrics()[2:]))
+	assert.Equal(t, map[string]float64{"gauge.baz": 4}, flushedValues(t, transport), "counters the endpoint rejected shouldn't be carried over")
+}
+
+func TestCarryOverCountersRefused(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.CarryOverCounters = true
+	transport.Failures = 1
+
+	assert.Error(t, gmSink.Flush(context.TODO(), carryOverMetrics()))
+	// the two counters carried over push the flush past the limit
+	gmSink.MaxMetricsPerFlush = 2
+	assert.Equal(t, ErrTooManyMetrics, gmSink.Flush(context.TODO(), carryOverMetrics()[2:]))
+
+	gmSink.MaxMetricsPerFlush = 0
+	require.NoError(t, gmSink.Flush(context.TODO(), carryOverMetrics()[2:]))
+	assert.Equal(t, map[string]float64{
+		"counter.foo": 2,
+		"counter.bar": 3,
+		"gauge.baz":   4,
+	}, flushedValues(t, transport), "counters carried over into a refused flush should be carried over again")
+}
+
+func TestCarryOverCountersDisabled(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	transport.Failures = 1
+
+	assert.Error(t, gmSink.Flush(context.TODO(), carryOverMetrics()))
+	require.NoError(t, gmSink.Flush(context.TODO(), carryOverMetrics()[2:]))
+	assert.Equal(t, map[string]float64{"gauge.baz": 4}, flushedValues(t, transport))
+}
+
+func TestCarryOverCountersSplitBatch(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.CarryOverCounters = true
+	counters := []samplers.InterMetric{
+		{Name: "c.a", Value: 2, Type: samplers.CounterMetric},
+		{Name: "c.b", Value: 3, Type: samplers.CounterMetric},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gmSink.encode(&buf, gmSink.convertInterToGeneric(counters[:1])))
+	gmSink.MaxPayloadBytes = buf.Len()
+	// only the first half of the split batch fails
+	transport.Failures = 1
+
+	assert.Error(t, gmSink.Flush(context.TODO(), counters))
+	require.Equal(t, 2, transport.Called)
+	require.NoError(t, gmSink.Flush(context.TODO(), nil))
+	assert.Equal(t, map[string]float64{
+		"c.a": 2,
+		"c.b": 3,
+	}, flushedValues(t, transport), "only the counters of the half that failed should be carried over")
+}
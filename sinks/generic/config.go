@@ -0,0 +1,426 @@
+package generic
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/backoff"
+	"github.com/stripe/veneur/sinks"
+)
+
+// GenericSinkConfig configures a GenericMetricSink, and the GenericSpanSink
+// sending its spans. veneur's Config embeds it, so its fields are set by
+// the `generic_` options of veneur's config file, as documented in
+// example.yaml. Durations are strings parsed by time.ParseDuration.
+type GenericSinkConfig struct {
+	GenericEndpoint          string             `yaml:"generic_endpoint"`
+	GenericBatchSize         int                `yaml:"generic_batch_size"`
+	GenericSource            string             `yaml:"generic_source"`
+	GenericEnvironment       string             `yaml:"generic_environment"`
+	GenericNamespace         string             `yaml:"generic_namespace"`
+	GenericMaxRetries        int                `yaml:"generic_max_retries"`
+	GenericRetryBaseDelay    string             `yaml:"generic_retry_base_delay"`
+	GenericRetryMaxDelay     string             `yaml:"generic_retry_max_delay"`
+	GenericRetryJitter       float64            `yaml:"generic_retry_jitter"`
+	GenericRetryStrategy     string             `yaml:"generic_retry_strategy"`
+	GenericCompressionType   string             `yaml:"generic_compression_type"`
+	GenericBearerToken       string             `yaml:"generic_bearer_token"`
+	GenericBasicAuthUsername string             `yaml:"generic_basic_auth_username"`
+	GenericBasicAuthPassword string             `yaml:"generic_basic_auth_password"`
+	GenericMaxConcurrency    int                `yaml:"generic_max_concurrency"`
+	GenericTypeMapping       map[string]string  `yaml:"generic_type_mapping"`
+	GenericTimestampFormat   string             `yaml:"generic_timestamp_format"`
+	GenericAllowedTags       []string           `yaml:"generic_allowed_tags"`
+	GenericExcludedTags      []string           `yaml:"generic_excluded_tags"`
+	GenericRoutes            []RouteConfig      `yaml:"generic_routes"`
+	GenericDryRun            bool               `yaml:"generic_dry_run"`
+	GenericFlushTimeout      string             `yaml:"generic_flush_timeout"`
+	GenericHeaders           map[string]string  `yaml:"generic_headers"`
+	GenericFormat            string             `yaml:"generic_format"`
+	GenericName              string             `yaml:"generic_name"`
+	GenericValueMultipliers  map[string]float64 `yaml:"generic_value_multipliers"`
+	GenericTagNormalization  struct {
+		Drop      []string `yaml:"drop"`
+		Lowercase bool     `yaml:"lowercase"`
+		Rewrites  []struct {
+			Pattern     string `yaml:"pattern"`
+			Replacement string `yaml:"replacement"`
+		} `yaml:"rewrites"`
+	} `yaml:"generic_tag_normalization"`
+	GenericSpansEndpoint           string              `yaml:"generic_spans_endpoint"`
+	GenericSpanBufferSize          int                 `yaml:"generic_span_buffer_size"`
+	GenericMaxInFlight             int                 `yaml:"generic_max_in_flight"`
+	GenericExtraEnvelopeFields     map[string]string   `yaml:"generic_extra_envelope_fields"`
+	GenericNamePrefix              string              `yaml:"generic_name_prefix"`
+	GenericNameSuffix              string              `yaml:"generic_name_suffix"`
+	GenericLowercaseNames          bool                `yaml:"generic_lowercase_names"`
+	GenericEventsEndpoint          string              `yaml:"generic_events_endpoint"`
+	GenericIdleConnTimeout         string              `yaml:"generic_idle_conn_timeout"`
+	GenericMaxIdleConns            int                 `yaml:"generic_max_idle_conns"`
+	GenericMaxIdleConnsPerHost     int                 `yaml:"generic_max_idle_conns_per_host"`
+	GenericCircuitBreakerThreshold int                 `yaml:"generic_circuit_breaker_threshold"`
+	GenericCircuitBreakerCooldown  string              `yaml:"generic_circuit_breaker_cooldown"`
+	GenericGroupByType             bool                `yaml:"generic_group_by_type"`
+	GenericHostnameTag             string              `yaml:"generic_hostname_tag"`
+	GenericStreamBatches           bool                `yaml:"generic_stream_batches"`
+	GenericCarryOverCounters       bool                `yaml:"generic_carry_over_counters"`
+	GenericInvalidCharacters       string              `yaml:"generic_invalid_characters"`
+	GenericInvalidPolicy           string              `yaml:"generic_invalid_policy"`
+	GenericNonFinitePolicy         string              `yaml:"generic_non_finite_policy"`
+	GenericNonFiniteSentinel       float64             `yaml:"generic_non_finite_sentinel"`
+	GenericFlushJitter             string              `yaml:"generic_flush_jitter"`
+	GenericHTTPProtocol            string              `yaml:"generic_http_protocol"`
+	GenericTypeBatchSizes          map[string]int      `yaml:"generic_type_batch_sizes"`
+	GenericPingOnStart             bool                `yaml:"generic_ping_on_start"`
+	GenericHealthPath              string              `yaml:"generic_health_path"`
+	GenericRedactedTags            map[string]string   `yaml:"generic_redacted_tags"`
+	GenericRedactionSalt           string              `yaml:"generic_redaction_salt"`
+	GenericRedactionPlaceholder    string              `yaml:"generic_redaction_placeholder"`
+	GenericMaxPayloadBytes         int                 `yaml:"generic_max_payload_bytes"`
+	GenericMaxNameLength           int                 `yaml:"generic_max_name_length"`
+	GenericMaxTags                 int                 `yaml:"generic_max_tags"`
+	GenericLimitPolicy             string              `yaml:"generic_limit_policy"`
+	GenericFieldNames              FieldNames          `yaml:"generic_field_names"`
+	GenericDedupeMetrics           bool                `yaml:"generic_dedupe_metrics"`
+	GenericWarnMetricsPerFlush     int                 `yaml:"generic_warn_metrics_per_flush"`
+	GenericMaxMetricsPerFlush      int                 `yaml:"generic_max_metrics_per_flush"`
+	GenericTags                    []string            `yaml:"generic_tags"`
+	GenericTagPrecedence           []string            `yaml:"generic_tag_precedence"`
+	GenericCounterMode             string              `yaml:"generic_counter_mode"`
+	GenericResponseSchema          ResponseSchema      `yaml:"generic_response_schema"`
+	GenericLogRejectedMetrics      bool                `yaml:"generic_log_rejected_metrics"`
+	GenericSourceTag               string              `yaml:"generic_source_tag"`
+	GenericDropZeroCounters        bool                `yaml:"generic_drop_zero_counters"`
+	GenericNameTagRules            []NameTagRuleConfig `yaml:"generic_name_tag_rules"`
+	GenericSigningSecret           string              `yaml:"generic_signing_secret"`
+	GenericSignatureHeader         string              `yaml:"generic_signature_header"`
+	GenericRequiredTags            []string            `yaml:"generic_required_tags"`
+	GenericOverlapPolicy           string              `yaml:"generic_overlap_policy"`
+	GenericFlushSummary            bool                `yaml:"generic_flush_summary"`
+	GenericPayloadSchema           string              `yaml:"generic_payload_schema"`
+	GenericPayloadSchemaPolicy     string              `yaml:"generic_payload_schema_policy"`
+	GenericInterval                string              `yaml:"generic_interval"`
+	GenericMaxPendingBytes         int                 `yaml:"generic_max_pending_bytes"`
+	GenericSamplesEndpoint         string              `yaml:"generic_samples_endpoint"`
+}
+
+// RouteConfig configures a Route, with its MetricPattern as a regular
+// expression.
+type RouteConfig struct {
+	MetricPrefix  string `yaml:"metric_prefix"`
+	MetricPattern string `yaml:"metric_pattern"`
+	MetricType    string `yaml:"metric_type"`
+	Endpoint      string `yaml:"endpoint"`
+}
+
+// NewGenericMetricSinkFromConfig returns a new generic metrics sink,
+// configured by conf, whose metrics and events are tagged with tags and
+// (if GenericHostnameTag is set) hostname. The sink uses a client of its
+// own if conf tunes connection pooling or asks for HTTP/2, and otherwise
+// httpClient, or a client built by NewHTTPClient with the default
+// settings if httpClient is nil.
+func NewGenericMetricSinkFromConfig(log *logrus.Logger, httpClient *http.Client, tags []string, hostname string, conf GenericSinkConfig) (*GenericMetricSink, error) {
+	switch conf.GenericCompressionType {
+	case "", CompressionNone, CompressionGzip, CompressionDeflate:
+	default:
+		return nil, fmt.Errorf("unknown compression type %q", conf.GenericCompressionType)
+	}
+	switch conf.GenericTimestampFormat {
+	case "", TimestampSeconds, TimestampMilliseconds, TimestampRFC3339:
+	default:
+		return nil, fmt.Errorf("unknown timestamp format %q", conf.GenericTimestampFormat)
+	}
+	switch conf.GenericFormat {
+	case "", FormatJSON, FormatNDJSON:
+	case FormatDatadogV2:
+		if conf.GenericTimestampFormat != "" && conf.GenericTimestampFormat != TimestampSeconds {
+			return nil, fmt.Errorf("format %q needs timestamps in seconds", FormatDatadogV2)
+		}
+		if conf.GenericFieldNames != (FieldNames{}) || len(conf.GenericExtraEnvelopeFields) > 0 {
+			return nil, fmt.Errorf("format %q has fixed fields, which can't be renamed or added to", FormatDatadogV2)
+		}
+		if len(conf.GenericTypeMapping) > 0 {
+			return nil, fmt.Errorf("format %q has fixed metric types, which can't be mapped", FormatDatadogV2)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", conf.GenericFormat)
+	}
+	switch conf.GenericHTTPProtocol {
+	case "", ProtocolHTTP1, ProtocolHTTP2:
+	default:
+		return nil, fmt.Errorf("unknown HTTP protocol %q", conf.GenericHTTPProtocol)
+	}
+	switch conf.GenericPayloadSchemaPolicy {
+	case "", SchemaFail, SchemaWarn:
+	default:
+		return nil, fmt.Errorf("unknown payload schema policy %q", conf.GenericPayloadSchemaPolicy)
+	}
+	var payloadSchema *PayloadSchema
+	if conf.GenericPayloadSchema != "" {
+		schema, err := LoadPayloadSchema(conf.GenericPayloadSchema)
+		if err != nil {
+			return nil, err
+		}
+		payloadSchema = schema
+	}
+	switch conf.GenericOverlapPolicy {
+	case "", OverlapAllow, OverlapWait, OverlapSkip:
+	default:
+		return nil, fmt.Errorf("unknown overlapping flush policy %q", conf.GenericOverlapPolicy)
+	}
+	switch conf.GenericInvalidPolicy {
+	case "", InvalidDrop, InvalidSanitize:
+	default:
+		return nil, fmt.Errorf("unknown invalid metric policy %q", conf.GenericInvalidPolicy)
+	}
+	switch conf.GenericNonFinitePolicy {
+	case "", NonFiniteDrop, NonFiniteClamp, NonFiniteSentinel:
+	default:
+		return nil, fmt.Errorf("unknown non-finite value policy %q", conf.GenericNonFinitePolicy)
+	}
+	if !finite(conf.GenericNonFiniteSentinel) {
+		return nil, fmt.Errorf("generic_non_finite_sentinel must be finite")
+	}
+	switch conf.GenericLimitPolicy {
+	case "", LimitTruncate, LimitDrop:
+	default:
+		return nil, fmt.Errorf("unknown limit policy %q", conf.GenericLimitPolicy)
+	}
+	if err := sinks.CheckCounterMode(conf.GenericCounterMode); err != nil {
+		return nil, err
+	}
+	if conf.GenericCounterMode == sinks.CounterModeCumulative && conf.GenericCarryOverCounters {
+		return nil, fmt.Errorf("cumulative counters can't be carried over, since their totals already are")
+	}
+	var responseSchema *ResponseSchema
+	if conf.GenericResponseSchema != (ResponseSchema{}) {
+		if err := checkResponseSchema(conf.GenericResponseSchema); err != nil {
+			return nil, err
+		}
+		responseSchema = &conf.GenericResponseSchema
+	}
+	if err := checkTagPrecedence(conf.GenericTagPrecedence); err != nil {
+		return nil, err
+	}
+	if err := checkRedactedTags(conf.GenericRedactedTags); err != nil {
+		return nil, err
+	}
+	invalidRegexp, err := compileInvalidCharacters(conf.GenericInvalidCharacters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern of invalid characters %q: %v", conf.GenericInvalidCharacters, err)
+	}
+	nameTagRules, err := compileNameTagRules(conf.GenericNameTagRules)
+	if err != nil {
+		return nil, err
+	}
+	for pattern := range conf.GenericValueMultipliers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid value multiplier pattern %q: %v", pattern, err)
+		}
+	}
+	if err := checkFieldNames(conf.GenericFieldNames); err != nil {
+		return nil, err
+	}
+	metricField, valueField, atField := conf.GenericFieldNames.resolve()
+	for field := range conf.GenericExtraEnvelopeFields {
+		_, reserved := reservedEnvelopeFields[field]
+		if reserved || field == metricField || field == valueField || field == atField {
+			return nil, fmt.Errorf("extra envelope field %q collides with a field the sink sets", field)
+		}
+	}
+	if conf.GenericBearerToken != "" && (conf.GenericBasicAuthUsername != "" || conf.GenericBasicAuthPassword != "") {
+		return nil, fmt.Errorf("only one of a bearer token or basic auth credentials can be set")
+	}
+	if conf.GenericSigningSecret != "" && conf.GenericStreamBatches {
+		return nil, fmt.Errorf("signed requests can't be streamed, since they're signed before they're sent")
+	}
+
+	var retryBaseDelay, retryMaxDelay, flushTimeout, idleConnTimeout, circuitBreakerCooldown, flushJitter time.Duration
+	for _, d := range []struct {
+		option string
+		value  string
+		into   *time.Duration
+	}{
+		{"generic_retry_base_delay", conf.GenericRetryBaseDelay, &retryBaseDelay},
+		{"generic_retry_max_delay", conf.GenericRetryMaxDelay, &retryMaxDelay},
+		{"generic_flush_timeout", conf.GenericFlushTimeout, &flushTimeout},
+		{"generic_idle_conn_timeout", conf.GenericIdleConnTimeout, &idleConnTimeout},
+		{"generic_circuit_breaker_cooldown", conf.GenericCircuitBreakerCooldown, &circuitBreakerCooldown},
+		{"generic_flush_jitter", conf.GenericFlushJitter, &flushJitter},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if *d.into, err = time.ParseDuration(d.value); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", d.option, d.value, err)
+		}
+	}
+
+	// the server fills in the flush interval under IntervalFlush
+	var interval time.Duration
+	if conf.GenericInterval != "" && conf.GenericInterval != IntervalFlush {
+		if interval, err = time.ParseDuration(conf.GenericInterval); err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid generic_interval %q, want a positive duration or %q", conf.GenericInterval, IntervalFlush)
+		}
+	}
+
+	var newBackoff func() backoff.Backoff
+	if strategy, jitter := conf.GenericRetryStrategy, conf.GenericRetryJitter; strategy != "" {
+		if _, err := backoff.New(strategy, retryBaseDelay, retryMaxDelay, jitter); err != nil {
+			return nil, err
+		}
+		newBackoff = func() backoff.Backoff {
+			b, _ := backoff.New(strategy, retryBaseDelay, retryMaxDelay, jitter)
+			return b
+		}
+	}
+
+	endpoints := []string{conf.GenericEndpoint, conf.GenericEventsEndpoint, conf.GenericSamplesEndpoint}
+	for _, r := range conf.GenericRoutes {
+		endpoints = append(endpoints, r.Endpoint)
+	}
+	unixSocket, err := unixSocketOf(endpoints...)
+	if err != nil {
+		return nil, err
+	}
+	if unixSocket != "" && conf.GenericHTTPProtocol == ProtocolHTTP2 {
+		return nil, fmt.Errorf("endpoints on Unix domain sockets are only supported over HTTP/1.1")
+	}
+
+	routes := make([]Route, 0, len(conf.GenericRoutes))
+	for _, r := range conf.GenericRoutes {
+		var pattern *regexp.Regexp
+		if r.MetricPattern != "" {
+			if pattern, err = regexp.Compile(r.MetricPattern); err != nil {
+				return nil, fmt.Errorf("invalid route pattern %q: %v", r.MetricPattern, err)
+			}
+		}
+		routes = append(routes, Route{
+			MetricPrefix:  r.MetricPrefix,
+			MetricPattern: pattern,
+			MetricType:    r.MetricType,
+			Endpoint:      resolveUnixEndpoint(r.Endpoint),
+		})
+	}
+
+	normalization := sinks.TagNormalization{
+		Drop:      conf.GenericTagNormalization.Drop,
+		Lowercase: conf.GenericTagNormalization.Lowercase,
+	}
+	for _, r := range conf.GenericTagNormalization.Rewrites {
+		normalization.Rewrites = append(normalization.Rewrites, sinks.TagKeyRewrite{
+			Pattern:     r.Pattern,
+			Replacement: r.Replacement,
+		})
+	}
+	tagNormalizer, err := sinks.NewTagNormalizer(normalization)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case unixSocket != "":
+		httpClient = NewUnixSocketClient(unixSocket, conf.GenericMaxIdleConns, conf.GenericMaxIdleConnsPerHost, idleConnTimeout)
+	case conf.GenericHTTPProtocol == ProtocolHTTP2:
+		// HTTP/2 multiplexes requests, so it needs no pooling
+		httpClient = NewHTTP2Client()
+	case conf.GenericMaxIdleConns != 0 || conf.GenericMaxIdleConnsPerHost != 0 || conf.GenericIdleConnTimeout != "":
+		httpClient = NewHTTPClient(conf.GenericMaxIdleConns, conf.GenericMaxIdleConnsPerHost, idleConnTimeout)
+	case httpClient == nil:
+		httpClient = NewHTTPClient(0, 0, 0)
+	}
+
+	name := conf.GenericName
+	if name == "" {
+		name = "generic"
+	}
+
+	return &GenericMetricSink{
+		name:                name,
+		log:                 log,
+		httpClient:          httpClient,
+		Tags:                tags,
+		Endpoint:            resolveUnixEndpoint(conf.GenericEndpoint),
+		BatchSize:           conf.GenericBatchSize,
+		Source:              conf.GenericSource,
+		Environment:         conf.GenericEnvironment,
+		Namespace:           conf.GenericNamespace,
+		MaxRetries:          conf.GenericMaxRetries,
+		RetryBaseDelay:      retryBaseDelay,
+		RetryMaxDelay:       retryMaxDelay,
+		RetryJitter:         conf.GenericRetryJitter,
+		NewBackoff:          newBackoff,
+		CompressionType:     conf.GenericCompressionType,
+		unixSocket:          unixSocket,
+		bearerToken:         conf.GenericBearerToken,
+		basicAuthUsername:   conf.GenericBasicAuthUsername,
+		basicAuthPassword:   conf.GenericBasicAuthPassword,
+		signingSecret:       []byte(conf.GenericSigningSecret),
+		MaxConcurrency:      conf.GenericMaxConcurrency,
+		TypeMapping:         conf.GenericTypeMapping,
+		TimestampFormat:     conf.GenericTimestampFormat,
+		AllowedTags:         conf.GenericAllowedTags,
+		ExcludedTags:        conf.GenericExcludedTags,
+		Routes:              routes,
+		DryRun:              conf.GenericDryRun,
+		FlushTimeout:        flushTimeout,
+		Headers:             conf.GenericHeaders,
+		Format:              conf.GenericFormat,
+		ValueMultipliers:    conf.GenericValueMultipliers,
+		TagNormalizer:       tagNormalizer,
+		MaxInFlight:         conf.GenericMaxInFlight,
+		ExtraEnvelopeFields: conf.GenericExtraEnvelopeFields,
+		NamePrefix:          conf.GenericNamePrefix,
+		NameSuffix:          conf.GenericNameSuffix,
+		LowercaseNames:      conf.GenericLowercaseNames,
+		EventsEndpoint:      resolveUnixEndpoint(conf.GenericEventsEndpoint),
+
+		CircuitBreakerThreshold: conf.GenericCircuitBreakerThreshold,
+		CircuitBreakerCooldown:  circuitBreakerCooldown,
+		GroupByType:             conf.GenericGroupByType,
+		HostnameTag:             conf.GenericHostnameTag,
+		SourceTag:               conf.GenericSourceTag,
+		DropZeroCounters:        conf.GenericDropZeroCounters,
+		NameTagRules:            nameTagRules,
+		SignatureHeader:         conf.GenericSignatureHeader,
+		RequiredTags:            conf.GenericRequiredTags,
+		OverlapPolicy:           conf.GenericOverlapPolicy,
+		FlushSummary:            conf.GenericFlushSummary,
+		PayloadSchema:           payloadSchema,
+		PayloadSchemaPolicy:     conf.GenericPayloadSchemaPolicy,
+		Hostname:                hostname,
+		StreamBatches:           conf.GenericStreamBatches,
+		CarryOverCounters:       conf.GenericCarryOverCounters,
+		InvalidCharacters:       invalidRegexp,
+		InvalidPolicy:           conf.GenericInvalidPolicy,
+		NonFinitePolicy:         conf.GenericNonFinitePolicy,
+		NonFiniteSentinelValue:  conf.GenericNonFiniteSentinel,
+		FlushJitter:             flushJitter,
+		Interval:                interval,
+		MaxPendingBytes:         conf.GenericMaxPendingBytes,
+		SamplesEndpoint:         resolveUnixEndpoint(conf.GenericSamplesEndpoint),
+		TypeBatchSizes:          conf.GenericTypeBatchSizes,
+		PingOnStart:             conf.GenericPingOnStart,
+		HealthPath:              conf.GenericHealthPath,
+		RedactedTags:            conf.GenericRedactedTags,
+		RedactionSalt:           conf.GenericRedactionSalt,
+		RedactionPlaceholder:    conf.GenericRedactionPlaceholder,
+		MaxPayloadBytes:         conf.GenericMaxPayloadBytes,
+		MaxNameLength:           conf.GenericMaxNameLength,
+		MaxTags:                 conf.GenericMaxTags,
+		LimitPolicy:             conf.GenericLimitPolicy,
+		FieldNames:              conf.GenericFieldNames,
+		DedupeMetrics:           conf.GenericDedupeMetrics,
+		WarnMetricsPerFlush:     conf.GenericWarnMetricsPerFlush,
+		MaxMetricsPerFlush:      conf.GenericMaxMetricsPerFlush,
+		SinkTags:                conf.GenericTags,
+		TagPrecedence:           conf.GenericTagPrecedence,
+		CounterMode:             conf.GenericCounterMode,
+		ResponseSchema:          responseSchema,
+		LogRejectedMetrics:      conf.GenericLogRejectedMetrics,
+	}, nil
+}
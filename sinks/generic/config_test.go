@@ -0,0 +1,127 @@
+package generic
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/backoff"
+)
+
+func TestNewGenericMetricSinkFromConfig(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint:       "http://localhost:8080/metrics",
+		GenericBatchSize:      100,
+		GenericRetryBaseDelay: "50ms",
+		GenericFlushTimeout:   "5s",
+		GenericRoutes:         []RouteConfig{{MetricPrefix: "api.", Endpoint: "http://localhost:8080/api"}},
+	}
+
+	gmSink, err := NewGenericMetricSinkFromConfig(logrus.New(), http.DefaultClient, []string{"a:b"}, "host", conf)
+	require.NoError(t, err)
+	assert.Equal(t, "generic", gmSink.Name())
+	assert.Equal(t, http.DefaultClient, gmSink.httpClient, "the given client should be used unless pooling is tuned")
+	assert.Equal(t, 50*time.Millisecond, gmSink.RetryBaseDelay)
+	assert.Equal(t, 5*time.Second, gmSink.FlushTimeout)
+	assert.Equal(t, []Route{{MetricPrefix: "api.", Endpoint: "http://localhost:8080/api"}}, gmSink.Routes)
+
+	conf.GenericMaxIdleConns = 10
+	gmSink, err = NewGenericMetricSinkFromConfig(logrus.New(), http.DefaultClient, nil, "", conf)
+	require.NoError(t, err)
+	assert.NotEqual(t, http.DefaultClient, gmSink.httpClient, "tuned pooling needs a client of its own")
+}
+
+func TestNewGenericMetricSinkFromConfigRoutePattern(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint: "http://localhost:8080/metrics",
+		GenericRoutes:   []RouteConfig{{MetricPattern: `^api\.(get|put)\.`, Endpoint: "http://localhost:8080/api"}},
+	}
+	gmSink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	require.Len(t, gmSink.Routes, 1)
+	assert.Equal(t, `^api\.(get|put)\.`, gmSink.Routes[0].MetricPattern.String())
+
+	conf.GenericRoutes[0].MetricPattern = "api.("
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err)
+}
+
+func TestNewGenericMetricSinkFromConfigInvalidDuration(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericEndpoint:     "http://localhost:8080/metrics",
+		GenericBatchSize:    100,
+		GenericFlushTimeout: "soon",
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "generic_flush_timeout")
+	}
+}
+
+func TestNewGenericMetricSinkFromConfigInterval(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint:  "http://localhost:8080/metrics",
+		GenericBatchSize: 100,
+		GenericInterval:  "1m",
+	}
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, sink.Interval)
+
+	conf.GenericInterval = IntervalFlush
+	sink, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.Zero(t, sink.Interval, "the server should fill in the flush interval")
+
+	for _, interval := range []string{"often", "-10s"} {
+		conf.GenericInterval = interval
+		_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+		assert.Error(t, err, "interval %q", interval)
+	}
+}
+
+func TestNewGenericMetricSinkFromConfigRetryStrategy(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint:       "http://localhost:8080/metrics",
+		GenericBatchSize:      100,
+		GenericRetryBaseDelay: "10ms",
+		GenericRetryMaxDelay:  "1s",
+	}
+	gmSink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.Nil(t, gmSink.NewBackoff, "no strategy should keep the default exponential backoff")
+
+	conf.GenericRetryStrategy = backoff.StrategyConstant
+	gmSink, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.Equal(t, backoff.Constant{Delay: 10 * time.Millisecond}, gmSink.NewBackoff())
+
+	conf.GenericRetryStrategy = "eventually"
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err)
+}
+
+func TestNewGenericMetricSinkFromConfigNonFinite(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint:          "http://localhost:8080/metrics",
+		GenericBatchSize:         100,
+		GenericNonFinitePolicy:   NonFiniteSentinel,
+		GenericNonFiniteSentinel: -1,
+	}
+	gmSink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.Equal(t, NonFiniteSentinel, gmSink.NonFinitePolicy)
+	assert.Equal(t, float64(-1), gmSink.NonFiniteSentinelValue)
+
+	conf.GenericNonFiniteSentinel = math.NaN()
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err, "the sentinel can't be NaN itself")
+
+	conf.GenericNonFiniteSentinel = 0
+	conf.GenericNonFinitePolicy = "ignore"
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err)
+}
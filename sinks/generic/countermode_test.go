@@ -0,0 +1,58 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+)
+
+func TestCounterModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode     string
+		expected []float64
+	}{
+		{"", []float64{42, 42, 42}},
+		{sinks.CounterModeDelta, []float64{42, 42, 42}},
+		{sinks.CounterModeCumulative, []float64{42, 84, 126}},
+	} {
+		gmSink := defaultTestSink()
+		gmSink.CounterMode = tc.mode
+		for i, expected := range tc.expected {
+			genMetrics := gmSink.convertInterToGeneric(basicInterMetrics()).Metrics
+			require.Len(t, genMetrics, 2)
+			assert.Equal(t, expected, genMetrics[0].Value, "counter after flush %d in mode %q", i, tc.mode)
+			assert.Equal(t, float64(42), genMetrics[1].Value, "gauges shouldn't accumulate in mode %q", tc.mode)
+		}
+	}
+}
+
+func TestCounterModeCumulativeSeries(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.CounterMode = sinks.CounterModeCumulative
+	metric := samplers.InterMetric{Name: "a.b.c", Value: 1, Tags: []string{"a:1", "b:2"}, Type: samplers.CounterMetric}
+	reordered := metric
+	reordered.Tags = []string{"b:2", "a:1"}
+	other := metric
+	other.Tags = []string{"a:2"}
+
+	genMetrics := gmSink.convertInterToGeneric([]samplers.InterMetric{metric, reordered, other}).Metrics
+	assert.Equal(t, float64(1), genMetrics[0].Value)
+	assert.Equal(t, float64(2), genMetrics[1].Value, "the same series should accumulate, whatever its tags' order")
+	assert.Equal(t, float64(1), genMetrics[2].Value, "series with other tags should have totals of their own")
+}
+
+func TestNewGenericMetricSinkFromConfigCounterMode(t *testing.T) {
+	for _, conf := range []GenericSinkConfig{
+		{GenericCounterMode: "monotonic"},
+		{GenericCounterMode: sinks.CounterModeCumulative, GenericCarryOverCounters: true},
+	} {
+		conf.GenericEndpoint = "http://localhost:8080/metrics"
+		conf.GenericBatchSize = 100
+		_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+		assert.Error(t, err)
+	}
+}
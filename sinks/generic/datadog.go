@@ -0,0 +1,98 @@
+package generic
+
+import (
+	"sort"
+
+	"github.com/stripe/veneur/samplers"
+)
+
+// The types of series in Datadog's v2 series API.
+const (
+	datadogTypeUnspecified = 0
+	datadogTypeCount       = 1
+	datadogTypeGauge       = 3
+)
+
+// datadogSeries is a batch in FormatDatadogV2: the body of a request to
+// Datadog's v2 series API.
+type datadogSeries struct {
+	Series []datadogMetric `json:"series"`
+}
+
+type datadogMetric struct {
+	Metric    string            `json:"metric"`
+	Type      int               `json:"type"`
+	Points    []datadogPoint    `json:"points"`
+	Unit      string            `json:"unit,omitempty"`
+	Interval  int64             `json:"interval,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Resources []datadogResource `json:"resources,omitempty"`
+}
+
+type datadogPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type datadogResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// datadogSeries reshapes a batch into a FormatDatadogV2 batch. Every
+// metric is a series of a single point, and is attributed to Hostname,
+// if it's set. The batch's environment and namespace have no place in
+// it, so they're left out: tag metrics with them to keep them.
+func (gm *GenericMetricSink) datadogSeries(genMetrics GenericMetrics) datadogSeries {
+	var resources []datadogResource
+	if gm.Hostname != "" {
+		resources = []datadogResource{{Name: gm.Hostname, Type: "host"}}
+	}
+	series := make([]datadogMetric, 0, len(genMetrics.Metrics))
+	for _, metric := range genMetrics.Metrics {
+		// TimestampFormat is always TimestampSeconds in FormatDatadogV2
+		ts, _ := metric.At.(float64)
+		series = append(series, datadogMetric{
+			Metric:    metric.Metric,
+			Type:      datadogType(metric.Type),
+			Points:    []datadogPoint{{Timestamp: int64(ts), Value: metric.Value}},
+			Unit:      metric.Unit,
+			Interval:  int64(metric.Interval),
+			Tags:      datadogTags(metric.Tags),
+			Resources: resources,
+		})
+	}
+	return datadogSeries{Series: series}
+}
+
+// datadogType returns the type of the series a metric emitted as type t
+// is sent as. TypeMapping can't be set in FormatDatadogV2, so t is one of
+// metricTypeNames. Counters flush the count of their samples over the
+// interval, and status checks are sent as gauges of their status.
+func datadogType(t string) int {
+	switch t {
+	case metricTypeNames[samplers.CounterMetric]:
+		return datadogTypeCount
+	case metricTypeNames[samplers.GaugeMetric], metricTypeNames[samplers.StatusMetric]:
+		return datadogTypeGauge
+	default:
+		return datadogTypeUnspecified
+	}
+}
+
+// datadogTags returns tags as Datadog's `key:value` strings, sorted.
+func datadogTags(tags map[string]string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	encoded := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v == "" {
+			encoded = append(encoded, k)
+			continue
+		}
+		encoded = append(encoded, k+":"+v)
+	}
+	sort.Strings(encoded)
+	return encoded
+}
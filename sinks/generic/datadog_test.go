@@ -0,0 +1,86 @@
+package generic
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestFlushDatadogV2(t *testing.T) {
+	expected, err := ioutil.ReadFile(filepath.Join("testdata", "datadog_v2.json"))
+	require.NoError(t, err)
+
+	gmSink, transport := getRoundTripTestSink("/api/v2/series", 10)
+	gmSink.Format = FormatDatadogV2
+	gmSink.Hostname = "web-1"
+	interMetrics := []samplers.InterMetric{{
+		Name:      "api.requests",
+		Timestamp: 1636629071,
+		Value:     42,
+		Tags:      []string{"route:/users", "env:prod"},
+		Type:      samplers.CounterMetric,
+	}, {
+		Name:      "api.queue_depth",
+		Timestamp: 1636629072,
+		Value:     0.5,
+		Type:      samplers.GaugeMetric,
+	}, {
+		Name:      "api.healthy",
+		Timestamp: 1636629073,
+		Value:     1,
+		Tags:      []string{"check"},
+		Type:      samplers.StatusMetric,
+	}}
+
+	require.NoError(t, gmSink.Flush(context.TODO(), interMetrics))
+	require.Equal(t, 1, transport.Called)
+	assert.JSONEq(t, string(expected), transport.Contents[0])
+	assert.Equal(t, "application/json", transport.Headers[0].Get("Content-Type"))
+
+	gmSink.StreamBatches = true
+	require.NoError(t, gmSink.Flush(context.TODO(), interMetrics))
+	assert.JSONEq(t, string(expected), transport.Contents[1], "streamed batches should be the same")
+}
+
+func TestDatadogV2Interval(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.Format = FormatDatadogV2
+	gmSink.Interval = 10 * time.Second
+	series := gmSink.datadogSeries(gmSink.convertInterToGeneric(basicInterMetrics()))
+	require.Len(t, series.Series, 2)
+	assert.Equal(t, int64(10), series.Series[0].Interval)
+}
+
+func TestDatadogV2NoSketches(t *testing.T) {
+	gmSink := defaultTestSink()
+	assert.True(t, gmSink.FlushesSketches())
+	gmSink.Format = FormatDatadogV2
+	assert.False(t, gmSink.FlushesSketches())
+}
+
+func TestNewGenericMetricSinkFromConfigDatadogV2(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		conf GenericSinkConfig
+	}{
+		{"timestamps in milliseconds", GenericSinkConfig{GenericTimestampFormat: TimestampMilliseconds}},
+		{"renamed fields", GenericSinkConfig{GenericFieldNames: FieldNames{Value: "v"}}},
+		{"extra envelope fields", GenericSinkConfig{GenericExtraEnvelopeFields: map[string]string{"a": "b"}}},
+		{"type mapping", GenericSinkConfig{GenericTypeMapping: map[string]string{"counter": "count"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.conf.GenericEndpoint = "http://localhost:8080/api/v2/series"
+			tc.conf.GenericBatchSize = 100
+			tc.conf.GenericFormat = FormatDatadogV2
+			_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", tc.conf)
+			assert.Error(t, err)
+		})
+	}
+}
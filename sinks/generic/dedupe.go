@@ -0,0 +1,56 @@
+package generic
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyDuplicateMetricsDropped is emitted as a counter of the metrics
+// that DedupeMetrics drops, tagged with `sink:sink.Name()`.
+const MetricKeyDuplicateMetricsDropped = "sink.generic.duplicate_metrics_dropped_total"
+
+// dedupeKey identifies the metrics DedupeMetrics considers duplicates.
+type dedupeKey struct {
+	name      string
+	typ       samplers.MetricType
+	tags      string
+	timestamp int64
+	value     float64
+	sketch    string
+}
+
+// dedupe drops every metric that has the same name, type, tags (in any
+// order), timestamp and value (or sketch) as one before it. The first of
+// the duplicates is kept.
+func (gm *GenericMetricSink) dedupe(interMetrics []samplers.InterMetric) []samplers.InterMetric {
+	seen := make(map[dedupeKey]struct{}, len(interMetrics))
+	deduped := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		tags := make([]string, len(metric.Tags))
+		copy(tags, metric.Tags)
+		sort.Strings(tags)
+		key := dedupeKey{
+			name:      metric.Name,
+			typ:       metric.Type,
+			tags:      strings.Join(tags, ","),
+			timestamp: metric.Timestamp,
+			value:     metric.Value,
+			sketch:    string(metric.Sketch),
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		deduped = append(deduped, metric)
+	}
+
+	if dropped := len(interMetrics) - len(deduped); dropped > 0 {
+		metrics.ReportOne(gm.traceClient, ssf.Count(MetricKeyDuplicateMetricsDropped, float32(dropped), map[string]string{"sink": gm.Name()}))
+		gm.log.WithField("metrics", dropped).Debug("Dropped duplicate generic metrics")
+	}
+	return deduped
+}
@@ -0,0 +1,55 @@
+package generic
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func duplicateMetrics() []samplers.InterMetric {
+	counter := samplers.InterMetric{
+		Name:      "requests",
+		Timestamp: 1476119058,
+		Value:     1,
+		Tags:      []string{"a:1", "b:2"},
+		Type:      samplers.CounterMetric,
+	}
+	reordered := counter
+	reordered.Tags = []string{"b:2", "a:1"}
+	otherValue := counter
+	otherValue.Value = 2
+	gauge := counter
+	gauge.Type = samplers.GaugeMetric
+	return []samplers.InterMetric{counter, counter, reordered, otherValue, gauge}
+}
+
+func TestFlushDedupeMetrics(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.DedupeMetrics = true
+	ch := startTraceClient(t, gmSink)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), duplicateMetrics()))
+	require.Equal(t, 1, transport.Called)
+	var batch GenericMetrics
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents[0]), &batch))
+	if assert.Len(t, batch.Metrics, 3, "exact duplicates should be dropped, whatever their tags' order") {
+		assert.Equal(t, float64(1), batch.Metrics[0].Value)
+		assert.Equal(t, float64(2), batch.Metrics[1].Value)
+		assert.Equal(t, "gauge", batch.Metrics[2].Type)
+	}
+	samples := reportedSamples(ch)
+	assert.Equal(t, float32(2), sampleTotal(samples[MetricKeyDuplicateMetricsDropped]))
+}
+
+func TestFlushDedupeMetricsOff(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), duplicateMetrics()))
+	var batch GenericMetrics
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents[0]), &batch))
+	assert.Len(t, batch.Metrics, 5, "metrics shouldn't be deduped unless DedupeMetrics is set")
+}
@@ -0,0 +1,172 @@
+package generic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/protocol/dogstatsd"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyEventFlushDuration is emitted as a timer for every request
+// sending events, tagged with `sink:sink.Name()`.
+const MetricKeyEventFlushDuration = "sink.generic.event_flush_duration_ns"
+
+// MetricKeyEventsFlushed is emitted as a counter of the events that were
+// sent successfully, tagged with `sink:sink.Name()`.
+const MetricKeyEventsFlushed = "sink.generic.events_flushed_total"
+
+// GenericEvent represents a single event, such as a deploy marker. At is
+// formatted like GenericMetric.At.
+type GenericEvent struct {
+	Title          string            `json:"title"`
+	Text           string            `json:"text"`
+	Source         string            `json:"source"`
+	At             interface{}       `json:"timestamp"`
+	Tags           map[string]string `json:"tags"`
+	Hostname       string            `json:"hostname,omitempty"`
+	Priority       string            `json:"priority,omitempty"`
+	AlertType      string            `json:"alert_type,omitempty"`
+	AggregationKey string            `json:"aggregation_key,omitempty"`
+	SourceType     string            `json:"source_type,omitempty"`
+}
+
+// GenericEvents encapsulates the events of a flush, with their common
+// environment and namespace.
+type GenericEvents struct {
+	Events      []GenericEvent `json:"events"`
+	Environment string         `json:"environment"`
+	Namespace   string         `json:"namespace"`
+}
+
+// FlushOtherSamples sends the samples that are events to EventsEndpoint,
+// and the other samples to SamplesEndpoint, each in a single request.
+// Either kind of sample is dropped if its endpoint isn't set.
+func (gm *GenericMetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+	gm.flushEvents(ctx, samples)
+	gm.flushSamples(ctx, samples)
+}
+
+// flushEvents sends the samples that are events to EventsEndpoint.
+func (gm *GenericMetricSink) flushEvents(ctx context.Context, samples []ssf.SSFSample) {
+	if gm.EventsEndpoint == "" {
+		return
+	}
+	genEvents := gm.convertEvents(samples)
+	if len(genEvents.Events) == 0 {
+		return
+	}
+	if gm.DryRun {
+		gm.dryRunJSON("events", len(genEvents.Events), gm.EventsEndpoint, genEvents)
+		return
+	}
+
+	err := gm.postJSON(ctx, gm.EventsEndpoint, genEvents, MetricKeyEventFlushDuration, MetricKeyEventsFlushed, len(genEvents.Events))
+	if err != nil {
+		gm.log.WithFields(errorFields(err, logrus.Fields{
+			"events":   len(genEvents.Events),
+			"endpoint": gm.EventsEndpoint,
+		})).Warn("Error flushing generic events")
+		return
+	}
+	gm.log.WithField("events", len(genEvents.Events)).Info("Completed flushing generic events")
+}
+
+// postJSON sends v to endpoint as a single JSON document, whatever the
+// sink's Format, timing the request as durationKey. On success, count is
+// reported as a counter of flushedKey.
+func (gm *GenericMetricSink) postJSON(ctx context.Context, endpoint string, v interface{}, durationKey, flushedKey string, count int) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	err := gm.compress(buf, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(v)
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode payload: %v", err)
+	}
+
+	reported := &ssf.Samples{}
+	defer metrics.Report(gm.traceClient, reported)
+	tags := map[string]string{"sink": gm.Name()}
+	headers := gm.headers()
+	headers["Content-Type"] = "application/json"
+	gm.sign(headers, buf.Bytes())
+	_, err = gm.send(ctx, endpoint, bufferedBody(buf.Bytes()), headers, durationKey, reported, tags)
+	if err != nil {
+		return err
+	}
+	reported.Add(ssf.Count(flushedKey, float32(count), tags))
+	return nil
+}
+
+// dryRunJSON writes a payload that would have been sent by postJSON to
+// DryRunWriter, or logs it if there is no DryRunWriter. what is the kind
+// of the count things in the payload, e.g. "events".
+func (gm *GenericMetricSink) dryRunJSON(what string, count int, endpoint string, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		gm.log.WithError(err).WithField(what, count).Errorf("Could not encode generic %s", what)
+		return
+	}
+	if gm.DryRunWriter != nil {
+		gm.DryRunWriter.Write(buf.Bytes())
+		return
+	}
+	gm.log.WithFields(logrus.Fields{
+		what:       count,
+		"endpoint": endpoint,
+		"body":     strings.TrimSuffix(buf.String(), "\n"),
+	}).Infof("Dry run: not flushing generic %s", what)
+}
+
+// eventFields are the tags the DogStatsD parser encodes an event's fields
+// in, which are taken out of the event's tags.
+var eventFields = []string{
+	dogstatsd.EventIdentifierKey,
+	dogstatsd.EventHostnameTagKey,
+	dogstatsd.EventPriorityTagKey,
+	dogstatsd.EventAlertTypeTagKey,
+	dogstatsd.EventAggregationKeyTagKey,
+	dogstatsd.EventSourceTypeTagKey,
+}
+
+// convertEvents converts the samples that are events. Their tags get the
+// sink's tags (and hostname) added, and are filtered and normalized like
+// metric tags.
+func (gm *GenericMetricSink) convertEvents(samples []ssf.SSFSample) GenericEvents {
+	var genEvents []GenericEvent
+	for _, sample := range samples {
+		if _, ok := sample.Tags[dogstatsd.EventIdentifierKey]; !ok {
+			continue
+		}
+		tags := gm.mergeTags(sample.Tags)
+		for _, k := range eventFields {
+			delete(tags, k)
+		}
+		gm.addHostname(tags)
+		genEvents = append(genEvents, GenericEvent{
+			Title:          sample.Name,
+			Text:           sample.Message,
+			Source:         gm.Source,
+			At:             gm.timestamp(sample.Timestamp),
+			Tags:           gm.TagNormalizer.Normalize(gm.filterTags(tags)),
+			Hostname:       sample.Tags[dogstatsd.EventHostnameTagKey],
+			Priority:       sample.Tags[dogstatsd.EventPriorityTagKey],
+			AlertType:      sample.Tags[dogstatsd.EventAlertTypeTagKey],
+			AggregationKey: sample.Tags[dogstatsd.EventAggregationKeyTagKey],
+			SourceType:     sample.Tags[dogstatsd.EventSourceTypeTagKey],
+		})
+	}
+	return GenericEvents{
+		Events:      genEvents,
+		Environment: gm.Environment,
+		Namespace:   gm.Namespace,
+	}
+}
@@ -0,0 +1,63 @@
+package generic
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/protocol/dogstatsd"
+	"github.com/stripe/veneur/ssf"
+)
+
+func testEventSamples() []ssf.SSFSample {
+	return []ssf.SSFSample{{
+		Name:      "Deployed veneur",
+		Message:   "version 14.0.0 is out",
+		Timestamp: 1476119058,
+		Tags: map[string]string{
+			dogstatsd.EventIdentifierKey:   "",
+			dogstatsd.EventAlertTypeTagKey: "success",
+			"service":                      "veneur",
+		},
+	}, {
+		Name:      "not.an.event",
+		Timestamp: 1476119058,
+		Tags:      map[string]string{"service": "veneur"},
+	}}
+}
+
+func TestFlushOtherSamplesEvents(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.Tags = []string{"host:fnord"}
+	gmSink.EventsEndpoint = "/endpoint/events"
+
+	gmSink.FlushOtherSamples(context.Background(), testEventSamples())
+	require.Equal(t, 1, transport.Called)
+	assert.Equal(t, "/endpoint/events", transport.Paths[0])
+	assert.Equal(t, "application/json", transport.Headers[0].Get("Content-Type"))
+
+	var events GenericEvents
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents[0]), &events))
+	assert.Equal(t, defaultEnvironment, events.Environment)
+	assert.Equal(t, []GenericEvent{{
+		Title:     "Deployed veneur",
+		Text:      "version 14.0.0 is out",
+		Source:    defaultSource,
+		At:        float64(1476119058),
+		Tags:      map[string]string{"host": "fnord", "service": "veneur"},
+		AlertType: "success",
+	}}, events.Events, "only events should be sent, without the tags encoding their fields")
+}
+
+func TestFlushOtherSamplesNoEventsEndpoint(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+
+	gmSink.FlushOtherSamples(context.Background(), testEventSamples())
+	assert.Equal(t, 0, transport.Called, "events shouldn't be sent without an events endpoint")
+
+	gmSink.EventsEndpoint = "/endpoint/events"
+	gmSink.FlushOtherSamples(context.Background(), testEventSamples()[1:])
+	assert.Equal(t, 0, transport.Called, "nothing should be sent without any events")
+}
@@ -0,0 +1,102 @@
+package generic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// FieldNames renames the fields of every metric in the JSON the sink
+// sends, to match a schema owned by someone else. Fields left empty keep
+// their default names, "metric", "value" and "at".
+type FieldNames struct {
+	Metric string `yaml:"metric"`
+	Value  string `yaml:"value"`
+	At     string `yaml:"at"`
+}
+
+// resolve returns the names of the metric, value and timestamp fields.
+// A nil FieldNames resolves to the default names.
+func (fn *FieldNames) resolve() (metric, value, at string) {
+	metric, value, at = "metric", "value", "at"
+	if fn == nil {
+		return
+	}
+	if fn.Metric != "" {
+		metric = fn.Metric
+	}
+	if fn.Value != "" {
+		value = fn.Value
+	}
+	if fn.At != "" {
+		at = fn.At
+	}
+	return
+}
+
+// checkFieldNames checks that renaming fields doesn't make two fields of
+// a metric, or of its line in FormatNDJSON, share a name.
+func checkFieldNames(fn FieldNames) error {
+	metric, value, at := fn.resolve()
+	seen := map[string]struct{}{}
+	for _, name := range []string{metric, "type", value, "source", at, "tags", "sketch", "exemplar", "unit", "interval", "environment", "namespace"} {
+		if _, ok := seen[name]; ok {
+			return fmt.Errorf("renamed field %q collides with another field", name)
+		}
+		seen[name] = struct{}{}
+	}
+	return nil
+}
+
+// jsonField is a field of a JSON object, and its value.
+type jsonField struct {
+	name  string
+	value interface{}
+}
+
+// MarshalJSON encodes the metric's fields in order, under the names its
+// sink's FieldNames gives them.
+func (m GenericMetric) MarshalJSON() ([]byte, error) {
+	metric, value, at := m.fieldNames.resolve()
+	fields := []jsonField{
+		{metric, m.Metric},
+		{"type", m.Type},
+		{value, m.Value},
+		{"source", m.Source},
+		{at, m.At},
+		{"tags", m.Tags},
+	}
+	if len(m.Sketch) > 0 {
+		fields = append(fields, jsonField{"sketch", m.Sketch})
+	}
+	if m.Exemplar != 0 {
+		fields = append(fields, jsonField{"exemplar", m.Exemplar})
+	}
+	if m.Unit != "" {
+		fields = append(fields, jsonField{"unit", m.Unit})
+	}
+	if m.Interval != 0 {
+		fields = append(fields, jsonField{"interval", m.Interval})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range fields {
+		encodedName, err := json.Marshal(field.name)
+		if err != nil {
+			return nil, err
+		}
+		encodedValue, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(encodedName)
+		buf.WriteByte(':')
+		buf.Write(encodedValue)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
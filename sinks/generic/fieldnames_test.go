@@ -0,0 +1,75 @@
+package generic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func fieldNamesMetrics() []samplers.InterMetric {
+	return []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     100,
+		Tags:      []string{"foo:bar"},
+		Type:      samplers.GaugeMetric,
+	}}
+}
+
+func TestFieldNames(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.FieldNames = FieldNames{Value: "v", At: "ts"}
+
+	var buf bytes.Buffer
+	require.NoError(t, gmSink.serialize(&buf, gmSink.convertInterToGeneric(fieldNamesMetrics())))
+	assert.JSONEq(t, `{
+		"metrics": [{"metric": "a.b.c", "type": "gauge", "v": 100, "source": "`+defaultSource+`", "ts": 1476119058, "tags": {"foo": "bar"}}],
+		"environment": "`+defaultEnvironment+`",
+		"namespace": "`+defaultNamespace+`"
+	}`, buf.String())
+
+	gmSink.Format = FormatNDJSON
+	buf.Reset()
+	require.NoError(t, gmSink.serialize(&buf, gmSink.convertInterToGeneric(fieldNamesMetrics())))
+	assert.JSONEq(t, `{
+		"metric": "a.b.c", "type": "gauge", "v": 100, "source": "`+defaultSource+`", "ts": 1476119058, "tags": {"foo": "bar"},
+		"environment": "`+defaultEnvironment+`",
+		"namespace": "`+defaultNamespace+`"
+	}`, buf.String())
+}
+
+func TestFieldNamesDefault(t *testing.T) {
+	gmSink := defaultTestSink()
+	genMetrics := gmSink.convertInterToGeneric(fieldNamesMetrics())
+
+	encoded, err := genMetrics.Metrics[0].MarshalJSON()
+	require.NoError(t, err)
+	assert.Equal(t, `{"metric":"a.b.c","type":"gauge","value":100,"source":"`+defaultSource+`","at":1476119058,"tags":{"foo":"bar"}}`, string(encoded))
+}
+
+func TestNewGenericMetricSinkFromConfigFieldNames(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		fieldNames FieldNames
+		extra      map[string]string
+	}{
+		{"colliding fields", FieldNames{Value: "type"}, nil},
+		{"colliding extra field", FieldNames{Value: "v"}, map[string]string{"v": "1"}},
+		{"colliding interval field", FieldNames{}, map[string]string{"interval": "10"}},
+		{"colliding renamed interval field", FieldNames{At: "interval"}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+				GenericEndpoint:            "http://localhost:8080/metrics",
+				GenericBatchSize:           100,
+				GenericFieldNames:          tc.fieldNames,
+				GenericExtraEnvelopeFields: tc.extra,
+			})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -1,37 +1,544 @@
 package generic
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/backoff"
 	vhttp "github.com/stripe/veneur/http"
 	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/sinks"
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
 )
 
+// MetricKeyRetriesTotal is emitted as a counter every time a batch is
+// re-sent after a failed attempt, tagged with `sink:sink.Name()`.
+const MetricKeyRetriesTotal = "sink.generic.retries_total"
+
+// MetricKeyBatchesTotal is emitted as a counter for every batch that was
+// flushed successfully, tagged with `sink:sink.Name()`.
+const MetricKeyBatchesTotal = "sink.generic.batches_total"
+
+// MetricKeyFlushErrorsTotal is emitted as a counter every time a request
+// to the endpoint fails, tagged with `sink:sink.Name()`.
+const MetricKeyFlushErrorsTotal = "sink.generic.flush_errors_total"
+
+// MetricKeyBatchSize is emitted as a histogram of the number of metrics
+// in each batch, tagged with `sink:sink.Name()`.
+const MetricKeyBatchSize = "sink.generic.batch_size"
+
+// The connection pooling settings of the clients NewHTTPClient builds,
+// unless they're overridden. They keep enough connections to the endpoint
+// open between flushes for concurrent batches to reuse them, rather than
+// making new connections (and TLS handshakes) every time.
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 32
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// maxLoggedResponse is the number of bytes of an endpoint's response body
+// that are logged when a batch is rejected.
+const maxLoggedResponse = 512
+
+// The compression types supported by GenericMetricSink.
+const (
+	CompressionNone    = "none"
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+)
+
+// The formats GenericMetric.At can be serialized in.
+const (
+	TimestampSeconds      = "seconds"
+	TimestampMilliseconds = "milliseconds"
+	TimestampRFC3339      = "rfc3339"
+)
+
+// IntervalFlush is the value of the generic_interval option that sends
+// the flush interval as every metric's Interval.
+const IntervalFlush = "flush"
+
+// The formats batches can be serialized in.
+const (
+	// FormatJSON serializes each batch as a single GenericMetrics object.
+	FormatJSON = "json"
+	// FormatNDJSON serializes each metric in a batch as a JSON object on
+	// its own line, with the batch's environment and namespace added to
+	// every metric.
+	FormatNDJSON = "ndjson"
+	// FormatDatadogV2 serializes each batch as the body of a request to
+	// Datadog's v2 series API, so that the sink can send metrics to it.
+	FormatDatadogV2 = "datadog-v2"
+)
+
+// metricTypeNames are the names veneur's metric types are emitted as,
+// unless a sink's TypeMapping says otherwise.
+var metricTypeNames = map[samplers.MetricType]string{
+	samplers.CounterMetric: "counter",
+	samplers.GaugeMetric:   "gauge",
+	samplers.StatusMetric:  "status",
+	samplers.SketchMetric:  "sketch",
+}
+
+// Route sends metrics whose name starts with MetricPrefix to Endpoint,
+// rather than the sink's default endpoint. If MetricPattern is set, only
+// metrics whose name it matches match, and if MetricType is set, only
+// metrics emitted with that type (after TypeMapping) do.
+type Route struct {
+	MetricPrefix  string
+	MetricPattern *regexp.Regexp
+	MetricType    string
+	Endpoint      string
+}
+
 // GenericMetricSink flushes batches of metrics in JSON to a configured endpoint.
 type GenericMetricSink struct {
+	name        string
 	log         *logrus.Logger
 	traceClient *trace.Client
 	httpClient  *http.Client
 	Tags        []string
 	Endpoint    string
+
+	// SinkTags are added to every metric and event, like Tags, but are
+	// the sink's own rather than the whole server's. TagPrecedence orders
+	// TagSourceMetric, TagSourceSink and TagSourceServer, from the source
+	// whose value wins when several have a tag with the same key to the
+	// one that loses. The empty precedence means DefaultTagPrecedence.
+	SinkTags      []string
+	TagPrecedence []string
+
 	BatchSize   int
 	Source      string
 	Environment string
 	Namespace   string
+
+	// SourceTag, if set, is the key of the tag a metric's origin (e.g.
+	// the host that emitted it) is read from: a metric tagged with it is
+	// sent with the tag's value as its source rather than Source. The
+	// tag is still sent too.
+	SourceTag string
+
+	// MaxRetries is the number of times a failed batch is re-sent before
+	// giving up on it. Zero disables retries.
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry; each
+	// subsequent retry doubles it, up to RetryMaxDelay.
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps the delay between two retries. Zero means
+	// no cap.
+	RetryMaxDelay time.Duration
+	// RetryJitter is the fraction (between 0 and 1) of each retry
+	// delay that is randomized, so that many veneurs don't retry in
+	// lockstep.
+	RetryJitter float64
+	// NewBackoff, if set, returns what decides how long to wait before
+	// each retry of a batch, in place of RetryBaseDelay, RetryMaxDelay
+	// and RetryJitter. It's called for every batch, so that batches sent
+	// at the same time don't share the delays of strategies that
+	// remember them.
+	NewBackoff func() backoff.Backoff
+
+	// FlushTimeout bounds how long a single request to the endpoint may
+	// take. Requests that time out count as failed attempts and are
+	// retried like any other. Zero means no timeout beyond the flush's
+	// own context.
+	FlushTimeout time.Duration
+
+	// Headers are set on every request. Headers with an empty value are
+	// skipped, and the Content-Type, Content-Encoding and Authorization
+	// headers the sink sets itself take precedence.
+	Headers map[string]string
+
+	// ValueMultipliers scale the value of metrics whose name matches a
+	// pattern, e.g. to convert seconds to milliseconds. Patterns
+	// containing any of `*?[` are globs, as understood by path.Match;
+	// other patterns match name prefixes. If several patterns match a
+	// name, the longest one wins. Unmatched metrics are left alone.
+	ValueMultipliers map[string]float64
+
+	// NamePrefix and NameSuffix are added to the name of every metric,
+	// as they are: a prefix of "team." turns "foo.bar" into
+	// "team.foo.bar". Routes and ValueMultipliers match the original
+	// names.
+	NamePrefix string
+	NameSuffix string
+
+	// NameTagRules extract tags from the names of metrics, and rewrite
+	// the names, in order, before NamePrefix, NameSuffix and
+	// LowercaseNames apply. Routes and ValueMultipliers still match the
+	// original names.
+	NameTagRules []NameTagRule
+
+	// LowercaseNames lowercases the name of every metric, so that clients
+	// casing the same name differently don't split its series. It
+	// doesn't apply to NamePrefix and NameSuffix, and Routes and
+	// ValueMultipliers still match the original names. Tag keys are
+	// lowercased by TagNormalizer.
+	LowercaseNames bool
+
+	// InvalidCharacters, if set, matches the characters that metric names
+	// and tag keys may not contain. InvalidPolicy (one of InvalidDrop or
+	// InvalidSanitize, the empty string meaning InvalidDrop) decides
+	// what happens to the metrics that contain them.
+	InvalidCharacters *regexp.Regexp
+	InvalidPolicy     string
+
+	// NonFinitePolicy (one of NonFiniteDrop, NonFiniteClamp or
+	// NonFiniteSentinel, the empty string meaning NonFiniteDrop) decides
+	// what happens to the metrics whose value is NaN or infinite.
+	// NonFiniteSentinelValue replaces their values under
+	// NonFiniteSentinel.
+	NonFinitePolicy        string
+	NonFiniteSentinelValue float64
+
+	// MaxNameLength and MaxTags, if set, cap the length (in bytes) of
+	// metric names and the number of tags of a metric, as sent.
+	// LimitPolicy (one of LimitTruncate or LimitDrop, the empty string
+	// meaning LimitTruncate) decides what happens to the metrics over
+	// them.
+	MaxNameLength int
+	MaxTags       int
+	LimitPolicy   string
+
+	// Transformer, if set, is called with the metrics at the start of
+	// every flush, and the metrics it returns are flushed instead. It
+	// lets programs embedding veneur transform metrics in ways config
+	// can't express. The metrics it's given are shared with other sinks,
+	// so it must return modified copies rather than modify them.
+	Transformer func([]samplers.InterMetric) []samplers.InterMetric
+
+	// TagNormalizer, if set, rewrites tag keys to the endpoint's
+	// conventions, after AllowedTags and the excluded tags have been
+	// applied.
+	TagNormalizer *sinks.TagNormalizer
+
+	// Format is one of FormatJSON, FormatNDJSON or FormatDatadogV2. The
+	// empty string means FormatJSON.
+	Format string
+
+	// FieldNames renames the fields of the metrics the sink sends.
+	FieldNames FieldNames
+
+	// Encoder, if set, writes every batch to w in place of the sink's own
+	// encoding, e.g. to swap in a faster JSON encoder. It's given batches
+	// as converted, whatever Format, and what it writes is compressed,
+	// signed and sent like the sink's own encoding would be.
+	Encoder func(w io.Writer, genMetrics GenericMetrics) error
+
+	// ExtraEnvelopeFields are added to the top-level object of every
+	// batch, next to the environment and namespace. In FormatNDJSON,
+	// they're added to every line. Fields named like the ones the sink
+	// sets itself are ignored.
+	ExtraEnvelopeFields map[string]string
+
+	// CompressionType is one of CompressionNone, CompressionGzip or
+	// CompressionDeflate. The empty string means no compression.
+	CompressionType string
+
+	// MaxConcurrency is the number of batches that may be sent at the
+	// same time. Values below 2 mean batches are sent one after another.
+	MaxConcurrency int
+
+	// CarryOverCounters, if set, makes the sink hold onto the counters
+	// of batches that fail to flush (other than those the endpoint
+	// rejects), and add them to the same counters in the next flush,
+	// rather than dropping them. Counters of batches that the endpoint
+	// did receive but failed to acknowledge are counted twice, so this
+	// trades at-most-once delivery for at-least-once.
+	CarryOverCounters bool
+
+	// ResponseSchema, if set, describes where the endpoint's response to
+	// a batch says which of its metrics were accepted, for endpoints that
+	// accept part of a batch. The accepted and rejected metrics are
+	// counted in MetricKeyAcceptedMetrics and MetricKeyRejectedMetrics.
+	// LogRejectedMetrics, if set, also logs the names of the rejected
+	// metrics. Rejected metrics aren't sent again.
+	ResponseSchema     *ResponseSchema
+	LogRejectedMetrics bool
+
+	// WarnMetricsPerFlush, if set, is the number of metrics in a flush
+	// over which the sink warns that there may be a cardinality explosion
+	// upstream. MaxMetricsPerFlush, if set, is the number over which the
+	// flush is refused entirely, to protect the endpoint, and fails with
+	// ErrTooManyMetrics. Both are counted in
+	// MetricKeyFlushGuardrailExceeded, and GuardrailHook, if set, is
+	// called with the number of metrics and whether the flush is refused,
+	// e.g. to page someone.
+	WarnMetricsPerFlush int
+	MaxMetricsPerFlush  int
+	GuardrailHook       func(metrics int, refused bool)
+
+	// CounterMode is one of sinks.CounterModeDelta or
+	// sinks.CounterModeCumulative. The empty string means
+	// sinks.CounterModeDelta. In sinks.CounterModeCumulative, counters
+	// are sent as the running total of their series (the same name and
+	// tags) since the sink started, so a batch that fails to flush loses
+	// nothing once a later one succeeds.
+	CounterMode   string
+	counterTotals sinks.CounterTotals
+
+	// DropZeroCounters, if set, makes counters that counted nothing in
+	// the interval (whose value is exactly zero) be dropped rather than
+	// sent. Counters in sinks.CounterModeCumulative are dropped if they
+	// didn't change, rather than sent with the same total again. Other
+	// types of metrics are sent whatever their value.
+	DropZeroCounters bool
+
+	// DedupeMetrics, if set, makes every flush drop the metrics that are
+	// exact duplicates of another metric being flushed: same name, type,
+	// tags, timestamp and value. They're counted in
+	// MetricKeyDuplicateMetricsDropped.
+	DedupeMetrics bool
+
+	// RequiredTags, if set, makes every flush drop the metrics that don't
+	// have all of these tags, so that clients opt series in by tagging
+	// them. A bare key, like "forward", requires a tag with that key
+	// whatever its value; "forward:true" requires exactly that tag.
+	// Dropped metrics are counted in MetricKeyUntaggedMetricsDropped.
+	RequiredTags []string
+
+	// OverlapPolicy (one of OverlapAllow, OverlapWait or OverlapSkip, the
+	// empty string meaning OverlapAllow) decides what happens to a flush
+	// that starts while an earlier one is still in progress.
+	OverlapPolicy string
+
+	// FlushSummary, if set, makes every flush emit a summary of how it
+	// went, from the number of metrics it was given to the number that
+	// each filter dropped and the batches and bytes it sent: see
+	// MetricKeySummaryMetricsIn and the metrics after it.
+	FlushSummary bool
+
+	// MaxPendingBytes, if set, caps the memory taken up by the batches
+	// waiting behind other flushes: for one of MaxInFlight requests, or
+	// for an earlier flush under OverlapWait (which counts the whole
+	// flush as one batch). Once a batch starting to wait goes over it,
+	// the oldest batches waiting are dropped until it doesn't, and
+	// counted in MetricKeyPendingMetricsDropped. The memory a batch takes
+	// up is estimated from its metrics' names, tags and sketches.
+	MaxPendingBytes int
+
+	// PayloadSchema, if set, is checked against every batch before it's
+	// sent. PayloadSchemaPolicy (one of SchemaFail or SchemaWarn, the
+	// empty string meaning SchemaFail) decides what happens to the
+	// batches that don't match it.
+	PayloadSchema       *PayloadSchema
+	PayloadSchemaPolicy string
+
+	// StreamBatches, if set, makes batches be encoded while they're sent,
+	// rather than before. Very large batches then don't have to be held
+	// in memory in their encoded form, at the cost of encoding them again
+	// for every retry. What is sent is the same either way. Signed
+	// requests are never streamed, since they're signed before they're
+	// sent.
+	StreamBatches bool
+
+	// SignatureHeader is the header requests are signed in, if the sink
+	// has a signing secret. The empty string means
+	// DefaultSignatureHeader.
+	SignatureHeader string
+
+	// MaxPayloadBytes, if set, caps the size of the body of a request
+	// (encoded and compressed): batches that are larger are split up
+	// until they aren't, and metrics too large to be sent on their own
+	// are dropped. Measuring batches that are streamed means encoding
+	// them twice.
+	MaxPayloadBytes int
+
+	// GroupByType, if set, makes every batch hold metrics of a single
+	// type (as emitted, after TypeMapping), rather than a mix of them.
+	GroupByType bool
+
+	// TypeBatchSizes override BatchSize for metrics of a type (as
+	// emitted, after TypeMapping), e.g. to send counters in smaller
+	// batches than gauges. Setting it makes every batch hold metrics of
+	// a single type, as GroupByType does.
+	TypeBatchSizes map[string]int
+
+	// MaxInFlight caps the number of requests to the endpoint that may
+	// be in flight at the same time, across all flushes and batches.
+	// Sending a batch blocks until a request finishes if the cap is
+	// reached. Zero means no cap.
+	MaxInFlight int
+
+	// CircuitBreakerThreshold is the number of consecutive batches that
+	// may fail to reach the endpoint before the sink stops sending
+	// batches for CircuitBreakerCooldown (DefaultCircuitBreakerCooldown
+	// if it's zero), then probes the endpoint with a single batch. Zero
+	// disables the circuit breaker.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+
+	// FlushJitter, if set, makes every flush wait for a random duration
+	// of up to FlushJitter before sending its batches, so that a fleet
+	// flushing at the same time doesn't hit the endpoint all at once.
+	// The wait never takes more than half of the time left before the
+	// flush's deadline, so that the batches still have time to be sent.
+	FlushJitter time.Duration
+
+	// TypeMapping renames metric types ("counter", "gauge" and "status")
+	// to what the endpoint expects. Metrics whose type maps to the empty
+	// string are not flushed at all.
+	TypeMapping map[string]string
+
+	// TimestampFormat is one of TimestampSeconds, TimestampMilliseconds
+	// or TimestampRFC3339. The empty string means epoch seconds.
+	TimestampFormat string
+
+	// Interval, if set, is sent with every metric, in seconds, as the
+	// interval its value was aggregated over, for endpoints that need it
+	// to interpret counters as rates. It's normally the flush interval.
+	Interval time.Duration
+
+	// AllowedTags, if non-empty, lists the only tag keys that are kept
+	// on flushed metrics. ExcludedTags lists tag keys that are always
+	// stripped. Both apply to server tags as well as metric tags.
+	AllowedTags  []string
+	ExcludedTags []string
+
+	// RedactedTags maps the keys of tags whose values must not leave the
+	// host to how they're redacted: RedactHash or RedactPlaceholder.
+	// RedactionSalt is hashed with the values, and RedactionPlaceholder
+	// (DefaultRedactionPlaceholder if it's empty) replaces them. Tags are
+	// redacted on metrics, events and spans alike, by their original key.
+	RedactedTags         map[string]string
+	RedactionSalt        string
+	RedactionPlaceholder string
+
+	// HostnameTag, if set, is the key metrics and events are tagged with
+	// Hostname under, unless they already have a tag with that key.
+	HostnameTag string
+	Hostname    string
+
+	// PingOnStart, if set, makes Start fail if Ping does, so that a
+	// misconfigured endpoint is noticed right away. HealthPath, if set,
+	// is what Ping requests instead of Endpoint.
+	PingOnStart bool
+	HealthPath  string
+
+	// EventsEndpoint, if set, is where events are sent to. Events are
+	// dropped if it isn't.
+	EventsEndpoint string
+	// SamplesEndpoint, if set, is where the other samples, such as
+	// service-level metrics, are forwarded to as GenericSamples. They're
+	// dropped if it isn't.
+	SamplesEndpoint string
+
+	// Routes are consulted in order, and the first one matching a
+	// metric's name decides which endpoint the metric is sent to.
+	// Metrics that match no route are sent to Endpoint.
+	Routes []Route
+
+	// DryRun, if set, makes the sink serialize batches as usual but
+	// write them to DryRunWriter (or log them, if DryRunWriter is nil)
+	// instead of sending them to the endpoint.
+	DryRun       bool
+	DryRunWriter io.Writer
+
+	// excludedTags are the keys set by the server's tags_exclude rules.
+	excludedTags []string
+
+	// inFlight holds a token for every request in flight, if MaxInFlight
+	// is set.
+	inFlight     chan struct{}
+	inFlightOnce sync.Once
+
+	// pending holds the batches waiting behind other flushes, oldest
+	// first, and pendingTotal their size, if MaxPendingBytes is set.
+	pendingMtx   sync.Mutex
+	pending      []*pendingBatch
+	pendingTotal int
+
+	// overlap holds a token for the flush in progress, under OverlapWait
+	// and OverlapSkip.
+	overlap     chan struct{}
+	overlapOnce sync.Once
+
+	breaker circuitBreaker
+
+	// now, if set, replaces time.Now. Only tests set it: sinks built
+	// from a struct literal don't, so it's read through clock.
+	now func() time.Time
+
+	// carried holds the counters of failed batches, by name and tags, if
+	// CarryOverCounters is set.
+	carryMtx sync.Mutex
+	carried  map[string]samplers.InterMetric
+
+	// drainMtx guards the fields tracking flushes in progress, which
+	// Drain waits for.
+	drainMtx sync.Mutex
+	flushing int
+	idle     chan struct{}
+	draining bool
+
+	// Credentials sent in the Authorization header of every request:
+	// either a bearer token, or a username and password for basic auth.
+	bearerToken       string
+	basicAuthUsername string
+	basicAuthPassword string
+	// signingSecret, if set, is the key every request is signed with.
+	signingSecret []byte
+
+	// unixSocket is the Unix domain socket the sink's endpoints are on,
+	// if they're on one.
+	unixSocket string
 }
 
 // GenericMetric represents a single metric.
+//
+// At is either a float64 of epoch seconds or milliseconds, or an RFC3339
+// string, depending on the sink's TimestampFormat. The metric, value and
+// at fields are named as the sink's FieldNames say. Tags are serialized as
+// a JSON object with its keys sorted, like encoding/json does for every
+// map, so the same metric is always serialized to the same bytes.
 type GenericMetric struct {
 	Metric string            `json:"metric"`
+	Type   string            `json:"type"`
 	Value  float64           `json:"value"`
 	Source string            `json:"source"`
-	At     float64           `json:"at"`
+	At     interface{}       `json:"at"`
 	Tags   map[string]string `json:"tags"`
+
+	// Sketch is the serialized t-digest of a "sketch" metric, encoded in
+	// base64 in JSON. Other metrics don't have one.
+	Sketch []byte `json:"sketch,omitempty"`
+
+	// Exemplar is the ID of a trace sent with the metric's samples, if
+	// veneur keeps exemplars and the metric has one.
+	Exemplar int64 `json:"exemplar,omitempty"`
+
+	// Unit is the unit of the metric's value, e.g. "bytes", if it has
+	// one.
+	Unit string `json:"unit,omitempty"`
+
+	// Interval is the number of seconds the metric's value was
+	// aggregated over, if the sink has an Interval.
+	Interval float64 `json:"interval,omitempty"`
+
+	// fieldNames are the FieldNames of the sink that converted the
+	// metric, if it renames any.
+	fieldNames *FieldNames
 }
 
 // GenericMetrics encapsulates a batch of metrics, with their common environment and namespace.
@@ -39,11 +546,146 @@ type GenericMetrics struct {
 	Metrics     []GenericMetric `json:"metrics"`
 	Environment string          `json:"environment"`
 	Namespace   string          `json:"namespace"`
+
+	// Extra holds additional top-level fields.
+	Extra map[string]string `json:"-"`
+
+	// indexes holds the index of every metric in Metrics in the batch of
+	// InterMetrics it was converted from, so that only the metrics that
+	// failed to send are carried over, or reported in BatchErrors.
+	indexes []int
+}
+
+// MarshalJSON adds the Extra fields to the batch's JSON object.
+func (gm GenericMetrics) MarshalJSON() ([]byte, error) {
+	type envelope GenericMetrics
+	encoded, err := json.Marshal(envelope(gm))
+	if err != nil || len(gm.Extra) == 0 {
+		return encoded, err
+	}
+	return appendFields(encoded, gm.Extra)
+}
+
+// NDJSONMetric is a single line of a batch in FormatNDJSON.
+type NDJSONMetric struct {
+	GenericMetric
+	Environment string `json:"environment"`
+	Namespace   string `json:"namespace"`
+
+	// Extra holds additional top-level fields.
+	Extra map[string]string `json:"-"`
+}
+
+// MarshalJSON adds the environment, the namespace and the Extra fields to
+// the metric's JSON object.
+func (m NDJSONMetric) MarshalJSON() ([]byte, error) {
+	encoded, err := m.GenericMetric.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(encoded[:len(encoded)-1])
+	if err := writeField(buf, "environment", m.Environment); err != nil {
+		return nil, err
+	}
+	if err := writeField(buf, "namespace", m.Namespace); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	if len(m.Extra) == 0 {
+		return buf.Bytes(), nil
+	}
+	return appendFields(buf.Bytes(), m.Extra)
+}
+
+// reservedEnvelopeFields are the names of the fields the sink sets
+// itself, which ExtraEnvelopeFields can't override.
+var reservedEnvelopeFields = map[string]struct{}{
+	"metrics":     {},
+	"environment": {},
+	"namespace":   {},
+	"metric":      {},
+	"type":        {},
+	"value":       {},
+	"source":      {},
+	"at":          {},
+	"tags":        {},
+	"sketch":      {},
+	"exemplar":    {},
+	"unit":        {},
+	"interval":    {},
+}
+
+// appendFields adds fields, sorted by name, to the end of an encoded JSON
+// object. Reserved fields are skipped.
+func appendFields(object []byte, fields map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if _, ok := reservedEnvelopeFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	buf := bytes.NewBuffer(object[:len(object)-1])
+	for _, name := range names {
+		if err := writeField(buf, name, fields[name]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeField writes a field, preceded by a comma, to a JSON object whose
+// closing brace hasn't been written yet.
+func writeField(buf *bytes.Buffer, name, value string) error {
+	encodedName, err := json.Marshal(name)
+	if err != nil {
+		return err
+	}
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buf.WriteByte(',')
+	buf.Write(encodedName)
+	buf.WriteByte(':')
+	buf.Write(encodedValue)
+	return nil
 }
 
 var _ sinks.MetricSink = &GenericMetricSink{}
 
-// NewGenericMetricSink returns a new generic metrics sink.
+// NewHTTPClient returns an HTTP client with a transport tuned for
+// flushing to a single endpoint. Zero values mean the defaults above.
+func NewHTTPClient(maxIdleConns, maxIdleConnsPerHost int, idleConnTimeout time.Duration) *http.Client {
+	if maxIdleConns == 0 {
+		maxIdleConns = DefaultMaxIdleConns
+	}
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if idleConnTimeout == 0 {
+		idleConnTimeout = DefaultIdleConnTimeout
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          maxIdleConns,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			IdleConnTimeout:       idleConnTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+// NewGenericMetricSink returns a new generic metrics sink. It only sets
+// the sink's basic options; NewGenericMetricSinkFromConfig sets the others.
 func NewGenericMetricSink(
 	log *logrus.Logger,
 	httpClient *http.Client,
@@ -54,92 +696,1024 @@ func NewGenericMetricSink(
 	environment string,
 	namespace string,
 ) (*GenericMetricSink, error) {
-	ret := &GenericMetricSink{
-		log:         log,
-		httpClient:  httpClient,
-		Tags:        tags,
-		Endpoint:    endpoint,
-		BatchSize:   batchSize,
-		Source:      source,
-		Environment: environment,
-		Namespace:   namespace,
-	}
-	return ret, nil
+	return NewGenericMetricSinkFromConfig(log, httpClient, tags, "", GenericSinkConfig{
+		GenericEndpoint:    endpoint,
+		GenericBatchSize:   batchSize,
+		GenericSource:      source,
+		GenericEnvironment: environment,
+		GenericNamespace:   namespace,
+	})
 }
 
-// Name returns the sink's name.
+// Name returns the sink's name, which is "generic" unless the sink was
+// given another one, e.g. to tell several generic sinks apart.
 func (gm *GenericMetricSink) Name() string {
-	return "generic"
+	if gm.name == "" {
+		return "generic"
+	}
+	return gm.name
+}
+
+// FlushesSketches reports that the sink flushes sketches, as metrics of
+// type "sketch", unless its Format is FormatDatadogV2, which has no place
+// for them.
+func (gm *GenericMetricSink) FlushesSketches() bool {
+	return gm.Format != FormatDatadogV2
 }
 
-// Start sets the trace client for the sink.
+// SetExcludedTags sets the excluded tag names, in addition to the sink's
+// own ExcludedTags. Any tags with the provided key (name) will be excluded.
+func (gm *GenericMetricSink) SetExcludedTags(excludes []string) {
+	gm.excludedTags = excludes
+}
+
+// Start sets the trace client for the sink, and pings the endpoint if
+// PingOnStart is set.
 func (gm *GenericMetricSink) Start(client *trace.Client) error {
 	gm.traceClient = client
+	return gm.pingOnStart()
+}
+
+// BatchErrors is returned from Flush when one or more batches could not
+// be sent. It holds the error of each failed batch.
+type BatchErrors struct {
+	Errors  []error
+	Batches int
+
+	// failed are the metrics of the failed batches that weren't sent.
+	failed []samplers.InterMetric
+}
+
+// FailedMetrics returns the metrics that weren't sent, as the sink
+// flushed them, so that they can be flushed again without resending the
+// batches that went through.
+func (be *BatchErrors) FailedMetrics() []samplers.InterMetric {
+	return be.failed
+}
+
+func (be *BatchErrors) Error() string {
+	msgs := make([]string, len(be.Errors))
+	for i, err := range be.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of %d batches failed to flush: %s", len(be.Errors), be.Batches, strings.Join(msgs, "; "))
+}
+
+// The categories of the errors batches fail to flush with. Errors
+// returned for a batch that was attempted are *FlushError, and match
+// their category with errors.Is: ErrSerialize if the batch couldn't be
+// encoded, ErrTransport if the endpoint couldn't be reached (including
+// requests running out of time), ErrBadStatus if the endpoint answered
+// with an unexpected status, and ErrSchemaViolation if the batch didn't
+// match PayloadSchema. Batches that weren't attempted because the
+// flush's context was done fail with ErrFlushCancelled (wrapping the
+// context's error) instead.
+var (
+	ErrSerialize       = fmt.Errorf("could not serialize the batch")
+	ErrTransport       = fmt.Errorf("could not reach the endpoint")
+	ErrBadStatus       = fmt.Errorf("the endpoint rejected the batch")
+	ErrSchemaViolation = fmt.Errorf("the batch doesn't match the payload schema")
+	ErrFlushCancelled  = fmt.Errorf("the flush was cancelled before the batch was sent")
+)
+
+// FlushError is a batch failing to flush with Err, which falls into
+// Category. Err can be unwrapped, e.g. to get at a *vhttp.StatusError.
+type FlushError struct {
+	Category error
+	Err      error
+}
+
+func (fe *FlushError) Error() string {
+	return fmt.Sprintf("%v: %v", fe.Category, fe.Err)
+}
+
+// Is reports whether target is the error's category.
+func (fe *FlushError) Is(target error) bool {
+	return target == fe.Category
+}
+
+// Unwrap returns the underlying error.
+func (fe *FlushError) Unwrap() error {
+	return fe.Err
+}
+
+// ErrDraining is returned by Flush once the sink has been drained.
+var ErrDraining = fmt.Errorf("the generic sink is draining")
+
+// Drain waits for the flushes in progress to finish sending their batches,
+// and makes any later flush fail with ErrDraining. It returns ctx's error
+// if ctx is done before then. The server drains sinks when it shuts down,
+// so that the last flush isn't cut short.
+func (gm *GenericMetricSink) Drain(ctx context.Context) error {
+	gm.drainMtx.Lock()
+	gm.draining = true
+	if gm.flushing == 0 {
+		gm.drainMtx.Unlock()
+		return nil
+	}
+	if gm.idle == nil {
+		gm.idle = make(chan struct{})
+	}
+	idle := gm.idle
+	gm.drainMtx.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startFlush records that a flush is in progress, unless the sink is
+// draining.
+func (gm *GenericMetricSink) startFlush() error {
+	gm.drainMtx.Lock()
+	defer gm.drainMtx.Unlock()
+	if gm.draining {
+		return ErrDraining
+	}
+	gm.flushing++
 	return nil
 }
 
-// Flush flushes accumulated metrics.
+// finishFlush records that a flush is done, waking up Drain if it was the
+// last one.
+func (gm *GenericMetricSink) finishFlush() {
+	gm.drainMtx.Lock()
+	defer gm.drainMtx.Unlock()
+	gm.flushing--
+	if gm.flushing == 0 && gm.idle != nil {
+		close(gm.idle)
+		gm.idle = nil
+	}
+}
+
+// Flush flushes accumulated metrics. Every batch is attempted, even if an
+// earlier one failed; the failures are returned together as *BatchErrors.
+// Up to MaxConcurrency batches are sent at the same time. Once ctx is
+// done, the flush stops sending batches: those that haven't been started
+// yet fail with ErrFlushCancelled, and Flush returns as soon as the ones
+// in flight give up.
 func (gm *GenericMetricSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
-	var batchSize int
-	for len(metrics) > 0 {
-		if len(metrics) > gm.BatchSize {
-			batchSize = gm.BatchSize
-		} else {
-			batchSize = len(metrics)
+	if err := gm.startFlush(); err != nil {
+		return err
+	}
+	defer gm.finishFlush()
+	release, err := gm.acquireOverlap(ctx, metrics)
+	if err == errPendingDropped {
+		// dropped to make room for newer flushes
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, summary := gm.startSummary(ctx)
+	defer gm.reportSummary(summary)
+	summary.received(len(metrics))
+	if gm.Transformer != nil {
+		metrics = gm.Transformer(metrics)
+	}
+	filtered := gm.filterMetrics(metrics)
+	summary.drop(SummaryFilterType, len(metrics), len(filtered))
+	metrics = filtered
+	if len(gm.RequiredTags) > 0 {
+		tagged := gm.requireTags(metrics)
+		summary.drop(SummaryFilterRequiredTags, len(metrics), len(tagged))
+		metrics = tagged
+	}
+	if gm.DedupeMetrics {
+		deduped := gm.dedupe(metrics)
+		summary.drop(SummaryFilterDedupe, len(metrics), len(deduped))
+		metrics = deduped
+	}
+	var carried []samplers.InterMetric
+	if gm.CarryOverCounters {
+		metrics, carried = gm.mergeCarriedOver(metrics)
+	}
+	if err := gm.checkGuardrail(len(metrics)); err != nil {
+		// the counters carried over weren't sent either
+		gm.carryOver(carried, err)
+		return err
+	}
+	if len(metrics) == 0 {
+		gm.log.Debug("No generic metrics to flush, skipping")
+		return nil
+	}
+
+	concurrency := gm.MaxConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg     sync.WaitGroup
+		errMtx sync.Mutex
+	)
+	batches := gm.batches(metrics)
+	// batches that the wait leaves no time for fail like any other
+	// batch the flush runs out of time for, below
+	gm.waitForJitter(ctx)
+	flushErr := &BatchErrors{Batches: len(batches)}
+	addErr := func(failed []samplers.InterMetric, err error) {
+		gm.carryOver(failed, err)
+		errMtx.Lock()
+		defer errMtx.Unlock()
+		flushErr.Errors = append(flushErr.Errors, err)
+		flushErr.failed = append(flushErr.failed, failed...)
+	}
+	slots := make(chan struct{}, concurrency)
+	for i, b := range batches {
+		if ctx.Err() == nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+			}
 		}
-		batch := metrics[:batchSize]
-		metrics = metrics[batchSize:]
-		gm.flushBatch(batch)
+		if err := ctx.Err(); err != nil {
+			for _, unsent := range batches[i:] {
+				addErr(unsent.metrics, &FlushError{Category: ErrFlushCancelled, Err: err})
+			}
+			break
+		}
+		wg.Add(1)
+		go func(b batch) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if failed, err := gm.flushBatch(gm.withPendingSize(ctx, b.metrics), b.endpoint, b.metrics); err != nil {
+				addErr(failed, err)
+			}
+		}(b)
+	}
+	wg.Wait()
+
+	if len(flushErr.Errors) > 0 {
+		return flushErr
 	}
 	return nil
 }
 
-func (gm *GenericMetricSink) flushBatch(metrics []samplers.InterMetric) {
-	genMetrics := gm.convertInterToGeneric(metrics)
-	err := vhttp.PostHelper(
-		context.TODO(),
+// batch is a group of metrics that are sent to an endpoint in a single
+// request.
+type batch struct {
+	endpoint string
+	metrics  []samplers.InterMetric
+}
+
+// batches routes metrics to their endpoints and splits them up into
+// batches of at most BatchSize metrics, of a single type if GroupByType is
+// set. A BatchSize below 1 puts all the metrics for an endpoint (and type)
+// in a single batch. Batches are never empty.
+func (gm *GenericMetricSink) batches(metrics []samplers.InterMetric) []batch {
+	var batches []batch
+	for _, route := range gm.route(metrics) {
+		groups := [][]samplers.InterMetric{route.metrics}
+		if gm.GroupByType || len(gm.TypeBatchSizes) > 0 {
+			groups = gm.groupByType(route.metrics)
+		}
+		for _, grouped := range groups {
+			maxSize := gm.BatchSize
+			if len(gm.TypeBatchSizes) > 0 {
+				metricType, _ := gm.metricType(grouped[0].Type)
+				if size, ok := gm.TypeBatchSizes[metricType]; ok {
+					maxSize = size
+				}
+			}
+			for len(grouped) > 0 {
+				batchSize := maxSize
+				if batchSize < 1 || len(grouped) < batchSize {
+					batchSize = len(grouped)
+				}
+				batches = append(batches, batch{endpoint: route.endpoint, metrics: grouped[:batchSize]})
+				grouped = grouped[batchSize:]
+			}
+		}
+	}
+	return batches
+}
+
+// groupByType groups metrics by the type they're emitted as, so metrics
+// whose types TypeMapping maps to the same name are grouped together.
+// Groups are returned in the order their first metric appears in.
+func (gm *GenericMetricSink) groupByType(metrics []samplers.InterMetric) [][]samplers.InterMetric {
+	var groups [][]samplers.InterMetric
+	indexes := map[string]int{}
+	for _, metric := range metrics {
+		metricType, _ := gm.metricType(metric.Type)
+		i, ok := indexes[metricType]
+		if !ok {
+			i = len(groups)
+			indexes[metricType] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], metric)
+	}
+	return groups
+}
+
+// route groups metrics by the endpoint they should be sent to, according
+// to Routes. Endpoints are returned in the order their first metric
+// appears in.
+func (gm *GenericMetricSink) route(metrics []samplers.InterMetric) []batch {
+	if len(gm.Routes) == 0 {
+		return []batch{{endpoint: gm.Endpoint, metrics: metrics}}
+	}
+	var routed []batch
+	indexes := map[string]int{}
+	for _, metric := range metrics {
+		endpoint := gm.Endpoint
+		for _, route := range gm.Routes {
+			if !strings.HasPrefix(metric.Name, route.MetricPrefix) {
+				continue
+			}
+			if route.MetricPattern != nil && !route.MetricPattern.MatchString(metric.Name) {
+				continue
+			}
+			if route.MetricType != "" {
+				if metricType, _ := gm.metricType(metric.Type); metricType != route.MetricType {
+					continue
+				}
+			}
+			endpoint = route.Endpoint
+			break
+		}
+		i, ok := indexes[endpoint]
+		if !ok {
+			i = len(routed)
+			indexes[endpoint] = i
+			routed = append(routed, batch{endpoint: endpoint})
+		}
+		routed[i].metrics = append(routed[i].metrics, metric)
+	}
+	return routed
+}
+
+// filterMetrics returns the metrics that should be flushed. The input
+// slice is left untouched, since it is shared with other sinks.
+func (gm *GenericMetricSink) filterMetrics(metrics []samplers.InterMetric) []samplers.InterMetric {
+	if len(gm.TypeMapping) == 0 {
+		return metrics
+	}
+	filtered := make([]samplers.InterMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		if _, ok := gm.metricType(metric.Type); ok {
+			filtered = append(filtered, metric)
+		}
+	}
+	return filtered
+}
+
+// metricType returns the type name a metric is emitted with, and whether
+// it should be emitted at all.
+func (gm *GenericMetricSink) metricType(t samplers.MetricType) (string, bool) {
+	name := metricTypeNames[t]
+	if mapped, ok := gm.TypeMapping[name]; ok {
+		return mapped, mapped != ""
+	}
+	return name, true
+}
+
+// flushBatch POSTs a single batch of metrics, retrying with exponential
+// backoff up to MaxRetries times. It gives up early if ctx is cancelled,
+// and doesn't send the batch at all if the circuit breaker is open. Along
+// with the error, it returns the metrics of the batch that weren't sent,
+// which are only some of them if the batch was split up. Those are only
+// tracked if CarryOverCounters is set.
+func (gm *GenericMetricSink) flushBatch(ctx context.Context, endpoint string, batch []samplers.InterMetric) ([]samplers.InterMetric, error) {
+	if len(batch) == 0 {
+		return nil, nil
+	}
+	genMetrics := gm.convertInterToGeneric(batch)
+	summaryOf(ctx).drop(SummaryFilterConversion, len(batch), len(genMetrics.Metrics))
+	if len(genMetrics.Metrics) == 0 {
+		// every metric was invalid
+		return nil, nil
+	}
+	if gm.PayloadSchema != nil {
+		if err := gm.checkPayloadSchema(endpoint, genMetrics); err != nil {
+			return batch, err
+		}
+	}
+	if gm.DryRun {
+		if err := gm.dryRunBatch(endpoint, genMetrics); err != nil {
+			return batch, err
+		}
+		return nil, nil
+	}
+	failed, err := gm.sendBatch(ctx, endpoint, genMetrics)
+	if err != nil {
+		unsent := make([]samplers.InterMetric, 0, len(failed))
+		for _, i := range failed {
+			unsent = append(unsent, batch[i])
+		}
+		return unsent, err
+	}
+	return nil, nil
+}
+
+// sendBatch encodes and sends a batch of converted metrics, splitting it
+// up first if it encodes to more than MaxPayloadBytes. Along with the
+// error, it returns the indexes of the metrics that weren't sent.
+func (gm *GenericMetricSink) sendBatch(ctx context.Context, endpoint string, genMetrics GenericMetrics) ([]int, error) {
+	batch := genMetrics.Metrics
+	var (
+		body    requestBody
+		encoded []byte
+		size    int
+		err     error
+	)
+	if gm.StreamBatches && !gm.signs() {
+		if gm.MaxPayloadBytes > 0 {
+			size, err = gm.encodedSize(genMetrics)
+		}
+		body = gm.streamedBody(genMetrics)
+	} else {
+		buf := bufferPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer bufferPool.Put(buf)
+		err = gm.encode(buf, genMetrics)
+		encoded = buf.Bytes()
+		size = len(encoded)
+		body = bufferedBody(encoded)
+	}
+	if err != nil {
+		gm.log.WithFields(logrus.Fields{
+			"metrics":       len(batch),
+			logrus.ErrorKey: err,
+		}).Error("Could not encode generic metrics")
+		return genMetrics.indexes, &FlushError{Category: ErrSerialize, Err: err}
+	}
+	if gm.oversized(size) {
+		return gm.splitBatch(ctx, endpoint, genMetrics, size)
+	}
+	headers := gm.headers()
+	gm.sign(headers, encoded)
+	summary := summaryOf(ctx)
+	var sent int64
+	body = summary.countedBody(body, &sent)
+
+	samples := &ssf.Samples{}
+	defer metrics.Report(gm.traceClient, samples)
+	tags := map[string]string{"sink": gm.Name()}
+	allowed, state := gm.allowBatch()
+	gm.reportBreaker(state, samples, tags)
+	if !allowed {
+		samples.Add(ssf.Count(MetricKeyBatchesSkipped, 1, tags))
+		gm.log.WithFields(logrus.Fields{
+			"metrics":  len(batch),
+			"endpoint": endpoint,
+		}).Debug("Circuit breaker is open, skipping generic metrics")
+		return genMetrics.indexes, ErrCircuitOpen
+	}
+
+	samples.Add(ssf.Histogram(MetricKeyBatchSize, float32(len(batch)), tags))
+
+	response, err := gm.send(ctx, endpoint, body, headers, sinks.MetricKeyMetricFlushDuration, samples, tags)
+	gm.reportBreaker(gm.recordBatch(ctx, err), samples, tags)
+	if err == errPendingDropped {
+		// dropped to make room for newer batches
+		summary.drop(SummaryFilterPending, len(batch), 0)
+		return nil, nil
+	}
+	if err == nil {
+		samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(batch)), tags))
+		summary.sent(&sent)
+		gm.acknowledge(response, len(batch), endpoint, samples, tags)
+		gm.log.WithFields(logrus.Fields{
+			"metrics":  len(batch),
+			"endpoint": endpoint,
+		}).Info("Completed flushing generic metrics")
+		return nil, nil
+	}
+	gm.log.WithFields(errorFields(err, logrus.Fields{
+		"metrics":  len(batch),
+		"endpoint": endpoint,
+	})).Warn("Error flushing generic metrics")
+	return genMetrics.indexes, err
+}
+
+// requestBody returns the body of a request. It's called again for every
+// attempt at sending it.
+type requestBody func() io.Reader
+
+// bufferedBody is the body of a request that was encoded up front.
+func bufferedBody(body []byte) requestBody {
+	return func() io.Reader {
+		return bytes.NewBuffer(body)
+	}
+}
+
+// streamedBody is the body of a request that encodes genMetrics while the
+// request is sent, so that the whole encoded batch never has to be held
+// in memory. Failing to encode the batch fails the request with a
+// *FlushError of ErrSerialize.
+func (gm *GenericMetricSink) streamedBody(genMetrics GenericMetrics) requestBody {
+	return func() io.Reader {
+		pr, pw := io.Pipe()
+		go func() {
+			err := gm.compress(pw, func(w io.Writer) error {
+				return gm.serializeStreaming(w, genMetrics)
+			})
+			if err != nil {
+				err = &FlushError{Category: ErrSerialize, Err: err}
+			}
+			// the request closes pr once it's done, which makes any
+			// pending write fail rather than block forever
+			pw.CloseWithError(err)
+		}()
+		return pr
+	}
+}
+
+// send POSTs an encoded batch, retrying with exponential backoff up to
+// MaxRetries times. The duration of every attempt is recorded as a timer
+// named durationKey. It returns the body of the endpoint's response.
+func (gm *GenericMetricSink) send(ctx context.Context, endpoint string, body requestBody, headers map[string]string, durationKey string, samples *ssf.Samples, tags map[string]string) ([]byte, error) {
+	retryBackoff := gm.backoff()
+	for attempt := 0; ; attempt++ {
+		postStart := time.Now()
+		response, err := gm.post(ctx, endpoint, body, headers)
+		if err == errPendingDropped {
+			return nil, err
+		}
+		samples.Add(ssf.Timing(durationKey, time.Since(postStart), time.Nanosecond, tags))
+		if err == nil {
+			samples.Add(ssf.Count(MetricKeyBatchesTotal, 1, tags))
+			return response, nil
+		}
+		samples.Add(ssf.Count(MetricKeyFlushErrorsTotal, 1, tags))
+		if attempt >= gm.MaxRetries || !retryable(err) {
+			return nil, err
+		}
+
+		samples.Add(ssf.Count(MetricKeyRetriesTotal, 1, tags))
+		if err = gm.waitForRetry(ctx, retryBackoff, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// errorFields adds err, and the start of the endpoint's response if it
+// rejected a batch, to fields.
+func errorFields(err error, fields logrus.Fields) logrus.Fields {
+	fields[logrus.ErrorKey] = err
+	var statusErr *vhttp.StatusError
+	if errors.As(err, &statusErr) {
+		response := statusErr.Body
+		if len(response) > maxLoggedResponse {
+			response = response[:maxLoggedResponse]
+		}
+		fields["response"] = string(response)
+	}
+	return fields
+}
+
+// retryable reports whether a batch that failed to flush with err might
+// go through if it is sent again. The endpoint rejecting a batch with a
+// 4xx status (other than 429) means the batch itself is at fault, so
+// there is no point in re-sending it.
+func retryable(err error) bool {
+	if errors.Is(err, ErrSerialize) {
+		return false
+	}
+	var statusErr *vhttp.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	return true
+}
+
+// post sends a single request to endpoint, giving up after FlushTimeout.
+// Waiting for a request to finish, if MaxInFlight are already in flight,
+// doesn't count towards the timeout. Failed requests are returned as
+// *FlushError. It returns the body of the endpoint's response.
+func (gm *GenericMetricSink) post(ctx context.Context, endpoint string, body requestBody, headers map[string]string) ([]byte, error) {
+	release, err := gm.acquireInFlight(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if gm.FlushTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gm.FlushTimeout)
+		defer cancel()
+	}
+	response, err := vhttp.PostStreamResponseHelper(
+		ctx,
 		gm.httpClient,
 		gm.traceClient,
 		http.MethodPost,
-		gm.Endpoint,
-		genMetrics,
+		endpoint,
+		body(),
+		headers,
 		"flush_metrics",
-		false,
 		nil,
 		gm.log,
 	)
 	if err == nil {
-		gm.log.WithField(
-			"metrics", len(metrics),
-		).Info("Completed flushing generic metrics")
-	} else {
+		return response, nil
+	}
+	var flushErr *FlushError
+	if errors.As(err, &flushErr) {
+		// a streamed body failed to encode
+		return nil, flushErr
+	}
+	if _, ok := err.(*vhttp.StatusError); ok {
+		return nil, &FlushError{Category: ErrBadStatus, Err: err}
+	}
+	return nil, &FlushError{Category: ErrTransport, Err: err}
+}
+
+// acquireInFlight blocks until there are fewer than MaxInFlight requests
+// in flight, or ctx is done. The batch sent with ctx counts as pending
+// while it waits. The returned function must be called once the request
+// is done.
+func (gm *GenericMetricSink) acquireInFlight(ctx context.Context) (func(), error) {
+	if gm.MaxInFlight < 1 {
+		return func() {}, nil
+	}
+	gm.inFlightOnce.Do(func() {
+		gm.inFlight = make(chan struct{}, gm.MaxInFlight)
+	})
+	if err := gm.waitPending(ctx, gm.inFlight, pendingSizeOf(ctx)); err != nil {
+		return nil, err
+	}
+	return func() { <-gm.inFlight }, nil
+}
+
+// dryRunBatch writes a batch to DryRunWriter, uncompressed, or logs it if
+// there is no DryRunWriter.
+func (gm *GenericMetricSink) dryRunBatch(endpoint string, genMetrics GenericMetrics) error {
+	var buf bytes.Buffer
+	if err := gm.serialize(&buf, genMetrics); err != nil {
 		gm.log.WithFields(logrus.Fields{
-			"metrics":       len(metrics),
+			"metrics":       len(genMetrics.Metrics),
 			logrus.ErrorKey: err,
-		}).Warn("Error flushing generic metrics")
+		}).Error("Could not encode generic metrics")
+		return &FlushError{Category: ErrSerialize, Err: err}
 	}
+	if gm.DryRunWriter != nil {
+		_, err := gm.DryRunWriter.Write(buf.Bytes())
+		return err
+	}
+	gm.log.WithFields(logrus.Fields{
+		"metrics":  len(genMetrics.Metrics),
+		"endpoint": endpoint,
+		"body":     strings.TrimSuffix(buf.String(), "\n"),
+	}).Info("Dry run: not flushing generic metrics")
+	return nil
+}
+
+// bufferPool holds the buffers batches are encoded into, so that flushing
+// a large number of metrics doesn't allocate a new buffer for each batch.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// encode serializes a batch into buf according to Format, compressing it
+// according to CompressionType.
+func (gm *GenericMetricSink) encode(buf *bytes.Buffer, genMetrics GenericMetrics) error {
+	return gm.compress(buf, func(w io.Writer) error {
+		return gm.serialize(w, genMetrics)
+	})
+}
+
+// compress writes whatever write writes into out, compressing it
+// according to CompressionType.
+func (gm *GenericMetricSink) compress(out io.Writer, write func(io.Writer) error) error {
+	w := out
+	var compressor io.WriteCloser
+	switch gm.CompressionType {
+	case CompressionGzip:
+		compressor = gzip.NewWriter(out)
+		w = compressor
+	case CompressionDeflate:
+		compressor = zlib.NewWriter(out)
+		w = compressor
+	}
+	if err := write(w); err != nil {
+		return err
+	}
+	if compressor != nil {
+		// flush leftover compressed bytes to the buffer
+		if err := compressor.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMetrics converts metrics and writes them to w in Format, exactly
+// like the sink would send them in a single batch. Other sinks that store
+// metrics in the generic sink's format use it.
+func (gm *GenericMetricSink) WriteMetrics(w io.Writer, metrics []samplers.InterMetric) error {
+	return gm.serialize(w, gm.convertInterToGeneric(gm.filterMetrics(metrics)))
+}
+
+// serialize writes a batch to w according to Format, or with Encoder if
+// it's set.
+func (gm *GenericMetricSink) serialize(w io.Writer, genMetrics GenericMetrics) error {
+	if gm.Encoder != nil {
+		return gm.Encoder(w, genMetrics)
+	}
+	encoder := json.NewEncoder(w)
+	if gm.Format == FormatDatadogV2 {
+		return encoder.Encode(gm.datadogSeries(genMetrics))
+	}
+	if gm.Format != FormatNDJSON {
+		return encoder.Encode(genMetrics)
+	}
+	for _, metric := range genMetrics.Metrics {
+		err := encoder.Encode(NDJSONMetric{
+			GenericMetric: metric,
+			Environment:   genMetrics.Environment,
+			Namespace:     genMetrics.Namespace,
+			Extra:         genMetrics.Extra,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serializeStreaming serializes a batch exactly like serialize, but a
+// metric at a time: encoding/json would encode a whole FormatJSON batch
+// in memory before writing any of it.
+func (gm *GenericMetricSink) serializeStreaming(w io.Writer, genMetrics GenericMetrics) error {
+	if gm.Encoder != nil || (gm.Format != "" && gm.Format != FormatJSON) || len(genMetrics.Metrics) == 0 {
+		return gm.serialize(w, genMetrics)
+	}
+	// the metrics are the envelope's first field, so the other fields can
+	// be encoded by encoding the envelope without them
+	envelope, err := json.Marshal(GenericMetrics{
+		Environment: genMetrics.Environment,
+		Namespace:   genMetrics.Namespace,
+		Extra:       genMetrics.Extra,
+	})
+	if err != nil {
+		return err
+	}
+	const noMetrics = `{"metrics":null`
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"metrics":[`)
+	for i, metric := range genMetrics.Metrics {
+		encoded, err := json.Marshal(metric)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.Write(encoded)
+	}
+	bw.WriteByte(']')
+	bw.Write(envelope[len(noMetrics):])
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
+
+// headers returns the headers to set on every request.
+func (gm *GenericMetricSink) headers() map[string]string {
+	headers := make(map[string]string, len(gm.Headers)+3)
+	for k, v := range gm.Headers {
+		if v != "" {
+			headers[k] = v
+		}
+	}
+	headers["Content-Type"] = "application/json"
+	if gm.Format == FormatNDJSON {
+		headers["Content-Type"] = "application/x-ndjson"
+	}
+	switch gm.CompressionType {
+	case CompressionGzip, CompressionDeflate:
+		headers["Content-Encoding"] = gm.CompressionType
+	}
+	if gm.bearerToken != "" {
+		headers["Authorization"] = "Bearer " + gm.bearerToken
+	} else if gm.basicAuthUsername != "" || gm.basicAuthPassword != "" {
+		credentials := gm.basicAuthUsername + ":" + gm.basicAuthPassword
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	return headers
+}
+
+// waitForRetry blocks until it's time for the retry following the given
+// attempt, according to b, returning early with the context's error if
+// ctx is cancelled.
+func (gm *GenericMetricSink) waitForRetry(ctx context.Context, b backoff.Backoff, attempt int) error {
+	return backoff.Wait(ctx, b, attempt)
 }
 
+// clock returns the current time, according to now if it's set and
+// time.Now otherwise.
+func (gm *GenericMetricSink) clock() time.Time {
+	if gm.now == nil {
+		return time.Now()
+	}
+	return gm.now()
+}
+
+// waitForJitter waits for a random duration of up to FlushJitter, or
+// until ctx is done.
+func (gm *GenericMetricSink) waitForJitter(ctx context.Context) {
+	delay := gm.flushJitterDelay(ctx)
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// flushJitterDelay returns how long a flush should wait before sending
+// its batches: a random duration of up to FlushJitter, capped at half of
+// the time left before ctx's deadline.
+func (gm *GenericMetricSink) flushJitterDelay(ctx context.Context) time.Duration {
+	if gm.FlushJitter <= 0 {
+		return 0
+	}
+	max := gm.FlushJitter
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := deadline.Sub(gm.clock()) / 2; left < max {
+			max = left
+		}
+	}
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// backoff returns a new backoff for a batch: NewBackoff's, or if it's
+// unset, the exponential backoff RetryBaseDelay, RetryMaxDelay and
+// RetryJitter describe.
+func (gm *GenericMetricSink) backoff() backoff.Backoff {
+	if gm.NewBackoff != nil {
+		return gm.NewBackoff()
+	}
+	return backoff.Exponential{Base: gm.RetryBaseDelay, Max: gm.RetryMaxDelay, Jitter: gm.RetryJitter}
+}
+
+// valueMultiplier returns what the value of the named metric should be
+// multiplied by, according to ValueMultipliers.
+func (gm *GenericMetricSink) valueMultiplier(name string) float64 {
+	if multiplier, ok := sinks.MatchNamePattern(gm.ValueMultipliers, name); ok {
+		return multiplier
+	}
+	return 1
+}
+
+// normalizeName returns a metric's name, lowercased if LowercaseNames is
+// set.
+func (gm *GenericMetricSink) normalizeName(name string) string {
+	if gm.LowercaseNames {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// convertInterToGeneric converts metrics to their JSON representation.
+// Their values are used as they are, apart from the value multipliers:
+// samplers correct for sample rates when they're sampled (a counter
+// incremented by 1 at a rate of 0.1 counts 10), so an InterMetric's value
+// is already corrected.
 func (gm *GenericMetricSink) convertInterToGeneric(metrics []samplers.InterMetric) GenericMetrics {
-	var genMetrics []GenericMetric
-	for _, metric := range metrics {
-		inTags := append(metric.Tags, gm.Tags...)
-		outTags := samplers.ParseTagSliceToMap(inTags)
+	var (
+		genMetrics []GenericMetric
+		indexes    []int
+	)
+	invalid, nonFinite := 0, 0
+	limited := limitCounts{}
+	defer func() {
+		gm.reportInvalid(invalid)
+		gm.reportNonFinite(nonFinite)
+		gm.reportLimited(limited)
+	}()
+	for i, metric := range metrics {
+		metricTags := samplers.ParseTagSliceToMap(metric.Tags)
+		name := gm.extractNameTags(metric.Name, metricTags)
+		source := gm.source(metricTags)
+		tags := gm.mergeTags(metricTags)
+		gm.addHostname(tags)
+		outTags := gm.TagNormalizer.Normalize(gm.filterTags(tags))
+		metricType, _ := gm.metricType(metric.Type)
 		genMetric := GenericMetric{
-			Metric: metric.Name,
+			Metric: gm.NamePrefix + gm.normalizeName(name) + gm.NameSuffix,
+			Type:   metricType,
 			Value:  metric.Value,
-			Source: gm.Source,
-			At:     float64(metric.Timestamp),
+			Source: source,
+			At:     gm.timestamp(metric.Timestamp),
 			Tags:   outTags,
+			Sketch: metric.Sketch,
+
+			Exemplar: metric.Exemplar,
+			Unit:     metric.Unit,
+			Interval: gm.Interval.Seconds(),
+		}
+		if gm.FieldNames != (FieldNames{}) {
+			genMetric.fieldNames = &gm.FieldNames
+		}
+		// a sketch's value is its weight, which isn't in the sketch's unit
+		if metric.Type != samplers.SketchMetric {
+			genMetric.Value *= gm.valueMultiplier(metric.Name)
+		}
+		if gm.DropZeroCounters && metric.Type == samplers.CounterMetric && genMetric.Value == 0 {
+			continue
+		}
+		if !finite(genMetric.Value) {
+			nonFinite++
+			if !gm.replaceNonFinite(&genMetric) {
+				continue
+			}
+		}
+		if !gm.validate(&genMetric) {
+			invalid++
+			if gm.InvalidPolicy != InvalidSanitize {
+				continue
+			}
+		}
+		if !gm.applyLimits(&genMetric, limited) && gm.LimitPolicy == LimitDrop {
+			continue
+		}
+		if metric.Type == samplers.CounterMetric && gm.CounterMode == sinks.CounterModeCumulative {
+			genMetric.Value = gm.counterTotals.Add(counterKey(metric), genMetric.Value)
 		}
 		genMetrics = append(genMetrics, genMetric)
+		indexes = append(indexes, i)
 	}
 	return GenericMetrics{
 		Environment: gm.Environment,
 		Namespace:   gm.Namespace,
 		Metrics:     genMetrics,
+		Extra:       gm.ExtraEnvelopeFields,
+		indexes:     indexes,
 	}
 }
 
-// FlushOtherSamples does nothing; currently this sink only supports metrics.
-func (gm *GenericMetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {}
+// source returns the source of a metric with the given tags: the value
+// of its SourceTag tag if it has one, Source otherwise.
+func (gm *GenericMetricSink) source(tags map[string]string) string {
+	if gm.SourceTag == "" {
+		return gm.Source
+	}
+	if source := tags[gm.SourceTag]; source != "" {
+		return source
+	}
+	return gm.Source
+}
+
+// addHostname tags a metric or event with Hostname, if HostnameTag is
+// set and it isn't tagged with that key already.
+func (gm *GenericMetricSink) addHostname(tags map[string]string) {
+	if gm.HostnameTag == "" || gm.Hostname == "" {
+		return
+	}
+	if _, ok := tags[gm.HostnameTag]; !ok {
+		tags[gm.HostnameTag] = gm.Hostname
+	}
+}
+
+// filterTags applies AllowedTags, the excluded tags and RedactedTags to
+// a metric's tags.
+func (gm *GenericMetricSink) filterTags(tags map[string]string) map[string]string {
+	if len(gm.AllowedTags) > 0 {
+		allowed := make(map[string]string, len(gm.AllowedTags))
+		for _, k := range gm.AllowedTags {
+			if v, ok := tags[k]; ok {
+				allowed[k] = v
+			}
+		}
+		tags = allowed
+	}
+	for _, k := range gm.ExcludedTags {
+		delete(tags, k)
+	}
+	for _, k := range gm.excludedTags {
+		delete(tags, k)
+	}
+	gm.redactTags(tags)
+	return tags
+}
+
+// timestamp converts a metric's timestamp (in epoch seconds) to
+// TimestampFormat.
+func (gm *GenericMetricSink) timestamp(ts int64) interface{} {
+	switch gm.TimestampFormat {
+	case TimestampMilliseconds:
+		return float64(ts * 1000)
+	case TimestampRFC3339:
+		return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+	default:
+		return float64(ts)
+	}
+}
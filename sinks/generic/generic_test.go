@@ -1,18 +1,31 @@
 package generic
 
 import (
+	"bytes"
+	"compress/gzip"
 	"compress/zlib"
 	"context"
+	"errors"
+	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/backoff"
+	vhttp "github.com/stripe/veneur/http"
 	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
 	"k8s.io/apimachinery/pkg/util/json"
 )
 
@@ -20,20 +33,74 @@ type GenericRoundTripper struct {
 	Endpoint string
 	Called   int
 	Contents []string
+	Headers  []http.Header
+	Paths    []string
+	// Failures is the number of requests that will be answered with
+	// FailureCode (500 by default) before the round tripper starts
+	// accepting them.
+	Failures    int
+	FailureCode int
+	// Delay is how long each request takes to be answered.
+	Delay time.Duration
+	// Response is the body of the answers to accepted requests.
+	Response string
+	// MaxInFlight is the largest number of requests that were being
+	// answered at the same time.
+	MaxInFlight int
+
+	inFlight int
+	mtx      sync.Mutex
 }
 
 func (rt *GenericRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mtx.Lock()
+	rt.inFlight++
+	if rt.inFlight > rt.MaxInFlight {
+		rt.MaxInFlight = rt.inFlight
+	}
+	rt.mtx.Unlock()
+	var err error
+	select {
+	case <-time.After(rt.Delay):
+	case <-req.Context().Done():
+		err = req.Context().Err()
+	}
+
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+	rt.inFlight--
+	if err != nil {
+		rt.Called++
+		return nil, err
+	}
+
 	rec := httptest.NewRecorder()
 	if strings.HasPrefix(req.URL.Path, rt.Endpoint) {
 		bstream := req.Body
-		if req.Header.Get("Content-Encoding") == "deflate" {
+		switch req.Header.Get("Content-Encoding") {
+		case "deflate":
 			bstream, _ = zlib.NewReader(req.Body)
+		case "gzip":
+			bstream, _ = gzip.NewReader(req.Body)
 		}
 		body, _ := ioutil.ReadAll(bstream)
 		defer bstream.Close()
 		rt.Called++
+		rt.Headers = append(rt.Headers, req.Header)
+		rt.Paths = append(rt.Paths, req.URL.Path)
+		if rt.Failures > 0 {
+			rt.Failures--
+			if rt.FailureCode != 0 {
+				rec.WriteHeader(rt.FailureCode)
+			} else {
+				rec.WriteHeader(http.StatusInternalServerError)
+			}
+			rec.WriteString("nope")
+			return rec.Result(), nil
+		}
 		rt.Contents = append(rt.Contents, string(body))
 		rec.Code = http.StatusOK
+		rec.WriteString(rt.Response)
 	}
 
 	return rec.Result(), nil
@@ -85,6 +152,7 @@ func getExpectedGenericMetrics(
 		tags := append(metric.Tags, serverTags...)
 		genMetric := GenericMetric{
 			Metric: metric.Name,
+			Type:   metricTypeNames[metric.Type],
 			Value:  metric.Value,
 			Source: source,
 			At:     float64(metric.Timestamp),
@@ -150,6 +218,41 @@ func getRoundTripTestSink(endpoint string, batchSize int) (*GenericMetricSink, *
 	return sink, transport
 }
 
+// startTraceClient starts the sink with a trace client whose spans can
+// be inspected with reportedSamples.
+func startTraceClient(t *testing.T, sink *GenericMetricSink) chan *ssf.SSFSpan {
+	ch := make(chan *ssf.SSFSpan, 1000)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(cl))
+	return ch
+}
+
+// reportedSamples drains the metric samples that were reported on spans
+// so far, keyed by their name.
+func reportedSamples(ch chan *ssf.SSFSpan) map[string][]*ssf.SSFSample {
+	samples := map[string][]*ssf.SSFSample{}
+	for {
+		select {
+		case span := <-ch:
+			for _, sample := range span.Metrics {
+				samples[sample.Name] = append(samples[sample.Name], sample)
+			}
+		case <-time.After(50 * time.Millisecond):
+			return samples
+		}
+	}
+}
+
+// sampleTotal sums the values of samples.
+func sampleTotal(samples []*ssf.SSFSample) float32 {
+	var total float32
+	for _, sample := range samples {
+		total += sample.Value
+	}
+	return total
+}
+
 func TestConvertInterToGeneric(t *testing.T) {
 	gmSink := defaultTestSink()
 	interMetrics := []samplers.InterMetric{
@@ -163,9 +266,92 @@ func TestConvertInterToGeneric(t *testing.T) {
 	}
 	expected := getExpectedGenericMetrics(defaultSource, defaultEnvironment, defaultNamespace, []string{}, interMetrics)
 	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, []int{0}, genericMetrics.indexes)
+	genericMetrics.indexes = nil
 	assert.Equal(t, expected, genericMetrics)
 }
 
+func TestConvertInterToGenericSampleRate(t *testing.T) {
+	gmSink := defaultTestSink()
+	udpMetric, err := samplers.ParseMetric([]byte("foo.bar.baz:1|c|@0.1"))
+	require.NoError(t, err)
+	require.Equal(t, float32(0.1), udpMetric.SampleRate)
+
+	counter := samplers.NewCounter(udpMetric.Name, udpMetric.Tags)
+	for i := 0; i < 3; i++ {
+		counter.Sample(udpMetric.Value.(float64), udpMetric.SampleRate)
+	}
+	genericMetrics := gmSink.convertInterToGeneric(counter.Flush(10 * time.Second))
+	require.Len(t, genericMetrics.Metrics, 1)
+	assert.Equal(t, float64(30), genericMetrics.Metrics[0].Value,
+		"counters should be corrected for their sample rate before they reach the sink, and not again")
+}
+
+func TestConvertInterToGenericSketch(t *testing.T) {
+	gmSink := defaultTestSink()
+	interMetrics := []samplers.InterMetric{{
+		Name:      "foo.bar.baz",
+		Timestamp: time.Now().Unix(),
+		Value:     float64(3),
+		Type:      samplers.SketchMetric,
+		Sketch:    []byte{1, 2, 3},
+	}}
+	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	require.Len(t, genericMetrics.Metrics, 1)
+	assert.Equal(t, "sketch", genericMetrics.Metrics[0].Type)
+	assert.Equal(t, float64(3), genericMetrics.Metrics[0].Value)
+
+	encoded, err := json.Marshal(genericMetrics.Metrics[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"sketch":"AQID"`)
+}
+
+func TestConvertInterToGenericExemplar(t *testing.T) {
+	gmSink := defaultTestSink()
+	interMetrics := basicInterMetrics()
+	interMetrics[0].Exemplar = 1234
+	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	require.Len(t, genericMetrics.Metrics, 2)
+
+	encoded, err := json.Marshal(genericMetrics.Metrics[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"exemplar":1234`)
+	encoded, err = json.Marshal(genericMetrics.Metrics[1])
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "exemplar", "metrics without an exemplar shouldn't have the field")
+}
+
+func TestConvertInterToGenericUnit(t *testing.T) {
+	gmSink := defaultTestSink()
+	interMetrics := basicInterMetrics()
+	interMetrics[0].Unit = "requests"
+	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	require.Len(t, genericMetrics.Metrics, 2)
+
+	encoded, err := json.Marshal(genericMetrics.Metrics[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"unit":"requests"`)
+	encoded, err = json.Marshal(genericMetrics.Metrics[1])
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "unit", "metrics without a unit shouldn't have the field")
+}
+
+func TestConvertInterToGenericInterval(t *testing.T) {
+	gmSink := defaultTestSink()
+	genericMetrics := gmSink.convertInterToGeneric(basicInterMetrics())
+	encoded, err := json.Marshal(genericMetrics.Metrics[0])
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "interval", "the interval shouldn't be sent unless the sink has one")
+
+	gmSink.Interval = 10 * time.Second
+	genericMetrics = gmSink.convertInterToGeneric(basicInterMetrics())
+	for _, metric := range genericMetrics.Metrics {
+		encoded, err := json.Marshal(metric)
+		require.NoError(t, err)
+		assert.Contains(t, string(encoded), `"interval":10`)
+	}
+}
+
 func TestAddServerTags(t *testing.T) {
 	serverTags := []string{"snowy:plover", "plugh:bletch"}
 	gmSink := getTestSink(
@@ -180,6 +366,7 @@ func TestAddServerTags(t *testing.T) {
 	interMetrics := basicInterMetrics()
 	expected := getExpectedGenericMetrics(defaultSource, defaultEnvironment, defaultNamespace, serverTags, interMetrics)
 	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	genericMetrics.indexes = nil
 	assert.Equal(t, expected, genericMetrics)
 }
 
@@ -217,3 +404,1150 @@ func TestFlushBatch(t *testing.T) {
 		assert.Equal(t, expected[i], gotMetrics)
 	}
 }
+
+func TestFlushRetry(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.MaxRetries = 2
+	gmSink.RetryBaseDelay = time.Millisecond
+	transport.Failures = 2
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, transport.Called)
+	assert.Len(t, transport.Contents, 1)
+}
+
+func TestFlushRetryExhausted(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.MaxRetries = 1
+	gmSink.RetryBaseDelay = time.Millisecond
+	transport.Failures = 5
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	assert.Error(t, err)
+	assert.Equal(t, 2, transport.Called)
+	assert.Empty(t, transport.Contents)
+}
+
+func TestFlushRetryClientError(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.MaxRetries = 2
+	gmSink.RetryBaseDelay = time.Millisecond
+	transport.Failures = 5
+	transport.FailureCode = http.StatusBadRequest
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	if assert.IsType(t, &BatchErrors{}, err) {
+		var statusErr *vhttp.StatusError
+		if assert.True(t, errors.As(err.(*BatchErrors).Errors[0], &statusErr)) {
+			assert.Equal(t, http.StatusBadRequest, statusErr.StatusCode)
+			assert.Equal(t, "nope", string(statusErr.Body))
+		}
+	}
+	assert.Equal(t, 1, transport.Called, "a 4xx response shouldn't be retried")
+}
+
+func TestFlushRetryTooManyRequests(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.MaxRetries = 2
+	gmSink.RetryBaseDelay = time.Millisecond
+	transport.Failures = 1
+	transport.FailureCode = http.StatusTooManyRequests
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, transport.Called)
+}
+
+func TestFlushTimeout(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.FlushTimeout = 10 * time.Millisecond
+	gmSink.MaxRetries = 1
+	gmSink.RetryBaseDelay = time.Millisecond
+	transport.Delay = 50 * time.Millisecond
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	if assert.IsType(t, &BatchErrors{}, err) && assert.Len(t, err.(*BatchErrors).Errors, 1) {
+		batchErr := err.(*BatchErrors).Errors[0]
+		assert.True(t, errors.Is(batchErr, ErrTransport), "timing out is a transport failure")
+		assert.True(t, errors.Is(batchErr, context.DeadlineExceeded))
+	}
+	assert.Equal(t, 2, transport.Called, "timed out requests should be retried")
+}
+
+func TestFlushRetryCancelled(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.MaxRetries = 5
+	gmSink.RetryBaseDelay = time.Hour
+	transport.Failures = 5
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := gmSink.Flush(ctx, basicInterMetrics())
+	if assert.IsType(t, &BatchErrors{}, err) {
+		assert.Equal(t, []error{context.DeadlineExceeded}, err.(*BatchErrors).Errors)
+	}
+	assert.Equal(t, 1, transport.Called)
+}
+
+// failingRoundTripper fails every request without reaching an endpoint.
+type failingRoundTripper struct{}
+
+func (failingRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestFlushErrorCategories(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	transport.Failures = 1
+	transport.FailureCode = http.StatusBadRequest
+	_, err := gmSink.flushBatch(context.TODO(), "http://example.com/endpoint", basicInterMetrics())
+	assert.True(t, errors.Is(err, ErrBadStatus), "got %v", err)
+	assert.IsType(t, &FlushError{}, err)
+
+	gmSink.httpClient = &http.Client{Transport: failingRoundTripper{}}
+	_, err = gmSink.flushBatch(context.TODO(), "http://example.com/endpoint", basicInterMetrics())
+	assert.True(t, errors.Is(err, ErrTransport), "got %v", err)
+	assert.False(t, errors.Is(err, ErrBadStatus))
+
+	// NaN never makes it past conversion, but can't be encoded
+	_, err = gmSink.sendBatch(context.TODO(), "http://example.com/endpoint", GenericMetrics{
+		Metrics: []GenericMetric{{Metric: "a.b.c", Value: math.NaN()}},
+	})
+	assert.True(t, errors.Is(err, ErrSerialize), "got %v", err)
+}
+
+func TestDefaultBackoff(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.RetryBaseDelay = 10 * time.Millisecond
+	gmSink.RetryMaxDelay = 50 * time.Millisecond
+	gmSink.RetryJitter = 0.5
+
+	assert.Equal(t, backoff.Exponential{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond, Jitter: 0.5}, gmSink.backoff())
+}
+
+// recordingBackoff waits no time, and records the attempts it was asked
+// about.
+type recordingBackoff struct {
+	attempts []int
+}
+
+func (b *recordingBackoff) NextDelay(attempt int) time.Duration {
+	b.attempts = append(b.attempts, attempt)
+	return 0
+}
+
+func (b *recordingBackoff) Reset() {}
+
+func TestFlushRetryBackoff(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.MaxRetries = 3
+	gmSink.RetryBaseDelay = time.Hour
+	var backoffs []*recordingBackoff
+	gmSink.NewBackoff = func() backoff.Backoff {
+		b := &recordingBackoff{}
+		backoffs = append(backoffs, b)
+		return b
+	}
+	transport.Failures = 2
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, transport.Called)
+	require.Len(t, backoffs, 1, "every batch should get a backoff of its own")
+	assert.Equal(t, []int{0, 1}, backoffs[0].attempts, "NewBackoff's backoffs should replace the exponential delays")
+
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	require.Len(t, backoffs, 2, "every batch should get a backoff of its own")
+	assert.Empty(t, backoffs[1].attempts)
+}
+
+func TestFlushJitterDelay(t *testing.T) {
+	gmSink := defaultTestSink()
+	assert.Equal(t, time.Duration(0), gmSink.flushJitterDelay(context.TODO()))
+
+	gmSink.FlushJitter = 10 * time.Second
+	for i := 0; i < 100; i++ {
+		delay := gmSink.flushJitterDelay(context.TODO())
+		assert.True(t, delay >= 0 && delay < 10*time.Second, "delay %v out of bounds", delay)
+	}
+
+	now := time.Now()
+	gmSink.now = func() time.Time { return now }
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(100*time.Millisecond))
+	defer cancel()
+	for i := 0; i < 100; i++ {
+		delay := gmSink.flushJitterDelay(ctx)
+		assert.True(t, delay >= 0 && delay < 50*time.Millisecond, "delay %v should leave time before the deadline", delay)
+	}
+}
+
+func TestFlushJitterCancelled(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.FlushJitter = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := gmSink.Flush(ctx, basicInterMetrics())
+	if assert.IsType(t, &BatchErrors{}, err) {
+		assert.True(t, errors.Is(err.(*BatchErrors).Errors[0], ErrFlushCancelled))
+		assert.True(t, errors.Is(err.(*BatchErrors).Errors[0], context.Canceled))
+	}
+	assert.Equal(t, 0, transport.Called, "a flush cancelled while waiting shouldn't send anything")
+}
+
+func TestFlushTransformer(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.Transformer = func(metrics []samplers.InterMetric) []samplers.InterMetric {
+		transformed := make([]samplers.InterMetric, 0, len(metrics))
+		for _, metric := range metrics {
+			if metric.Type == samplers.GaugeMetric {
+				continue
+			}
+			metric.Name = "renamed." + metric.Name
+			transformed = append(transformed, metric)
+		}
+		return transformed
+	}
+
+	metrics := basicInterMetrics()
+	require.NoError(t, gmSink.Flush(context.TODO(), metrics))
+	require.Len(t, transport.Contents, 1)
+	var batch GenericMetrics
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents[0]), &batch))
+	require.Len(t, batch.Metrics, 1)
+	assert.Equal(t, "renamed.counter.foo", batch.Metrics[0].Metric)
+	assert.Equal(t, "counter.foo", metrics[0].Name)
+}
+
+func TestFlushBatchErrors(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 2)
+	transport.Failures = 2
+
+	err := gmSink.Flush(context.TODO(), getInterMetricsMany(6))
+	assert.Equal(t, 3, transport.Called)
+	assert.Len(t, transport.Contents, 1, "batches after a failed one should still be flushed")
+	if assert.IsType(t, &BatchErrors{}, err) {
+		batchErr := err.(*BatchErrors)
+		assert.Len(t, batchErr.Errors, 2)
+		assert.Equal(t, 3, batchErr.Batches)
+		assert.Contains(t, batchErr.Error(), "2 of 3 batches failed")
+	}
+}
+
+func TestFlushCompressed(t *testing.T) {
+	for _, compression := range []string{CompressionGzip, CompressionDeflate} {
+		t.Run(compression, func(t *testing.T) {
+			gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+			gmSink.CompressionType = compression
+
+			var gotMetrics GenericMetrics
+			interMetrics := basicInterMetrics()
+			expected := getExpectedGenericMetrics(defaultSource, defaultEnvironment, defaultNamespace, []string{}, interMetrics)
+
+			err := gmSink.Flush(context.TODO(), interMetrics)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, transport.Called)
+			assert.Equal(t, compression, transport.Headers[0].Get("Content-Encoding"))
+			err = json.Unmarshal([]byte(transport.Contents[0]), &gotMetrics)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, gotMetrics)
+		})
+	}
+}
+
+func TestFlushUncompressedByDefault(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	assert.NoError(t, err)
+	assert.Equal(t, "", transport.Headers[0].Get("Content-Encoding"))
+	assert.Equal(t, "application/json", transport.Headers[0].Get("Content-Type"))
+}
+
+func TestNewGenericMetricSinkCompression(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:       10,
+		GenericCompressionType: "brotli",
+	})
+	assert.Error(t, err)
+
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:       10,
+		GenericCompressionType: CompressionGzip,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, CompressionGzip, sink.CompressionType)
+}
+
+func TestFlushAuthorization(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.bearerToken = "hunter2"
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer hunter2", transport.Headers[0].Get("Authorization"))
+
+	gmSink, transport = getRoundTripTestSink("/endpoint", 10)
+	gmSink.basicAuthUsername = "admin"
+	gmSink.basicAuthPassword = "hunter2"
+
+	err = gmSink.Flush(context.TODO(), basicInterMetrics())
+	assert.NoError(t, err)
+	req := &http.Request{Header: transport.Headers[0]}
+	username, password, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "admin", username)
+	assert.Equal(t, "hunter2", password)
+}
+
+func TestFlushNDJSON(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.Format = FormatNDJSON
+
+	interMetrics := basicInterMetrics()
+	err := gmSink.Flush(context.TODO(), interMetrics)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, transport.Called)
+	assert.Equal(t, "application/x-ndjson", transport.Headers[0].Get("Content-Type"))
+
+	expected := getExpectedGenericMetrics(defaultSource, defaultEnvironment, defaultNamespace, []string{}, interMetrics)
+	lines := strings.Split(strings.TrimSuffix(transport.Contents[0], "\n"), "\n")
+	if assert.Len(t, lines, len(interMetrics)) {
+		for i, line := range lines {
+			var got NDJSONMetric
+			assert.NoError(t, json.Unmarshal([]byte(line), &got))
+			assert.Equal(t, NDJSONMetric{
+				GenericMetric: expected.Metrics[i],
+				Environment:   defaultEnvironment,
+				Namespace:     defaultNamespace,
+			}, got)
+		}
+	}
+}
+
+func TestNewGenericMetricSinkFormat(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize: 10,
+		GenericFormat:    "xml",
+	})
+	assert.Error(t, err)
+}
+
+func TestName(t *testing.T) {
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize: 10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "generic", sink.Name())
+
+	sink, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize: 10,
+		GenericName:      "generic-tenant",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "generic-tenant", sink.Name())
+
+	ch := startTraceClient(t, sink)
+	sink.httpClient = &http.Client{Transport: &GenericRoundTripper{Endpoint: "/"}}
+	sink.BatchSize = 10
+	assert.NoError(t, sink.Flush(context.TODO(), basicInterMetrics()))
+	samples := reportedSamples(ch)
+	if assert.Len(t, samples[MetricKeyBatchesTotal], 1) {
+		assert.Equal(t, "generic-tenant", samples[MetricKeyBatchesTotal][0].Tags["sink"])
+	}
+}
+
+func TestConvertInterToGenericLeavesTagsAlone(t *testing.T) {
+	interMetrics := basicInterMetrics()
+	for i := range interMetrics {
+		// give the tags spare capacity that an append could write into
+		tags := make([]string, len(interMetrics[i].Tags), len(interMetrics[i].Tags)+4)
+		copy(tags, interMetrics[i].Tags)
+		interMetrics[i].Tags = tags
+	}
+
+	sinkA := defaultTestSink()
+	sinkA.Tags = []string{"sink:a"}
+	sinkA.SetExcludedTags([]string{"qux"})
+	sinkB := defaultTestSink()
+	sinkB.Tags = []string{"sink:b"}
+
+	metricsA := sinkA.convertInterToGeneric(interMetrics)
+	metricsB := sinkB.convertInterToGeneric(interMetrics)
+	assert.Equal(t, map[string]string{"fnord": "xyzzy", "sink": "a"}, metricsA.Metrics[0].Tags)
+	assert.Equal(t, map[string]string{"fnord": "xyzzy", "qux": "quux", "sink": "b"}, metricsB.Metrics[0].Tags)
+	for _, metric := range interMetrics {
+		assert.Len(t, metric.Tags, 2)
+		for _, tag := range metric.Tags[:cap(metric.Tags)] {
+			assert.NotContains(t, tag, "sink:", "server tags shouldn't be written into the metric's tags")
+		}
+	}
+}
+
+func TestValueMultiplier(t *testing.T) {
+	gmSink := defaultTestSink()
+	assert.Equal(t, 1.0, gmSink.valueMultiplier("request.duration"))
+
+	gmSink.ValueMultipliers = map[string]float64{
+		"*.duration":       1000,
+		"request.":         2,
+		"request.duration": 3,
+		"request.dur":      4,
+	}
+	assert.Equal(t, 3.0, gmSink.valueMultiplier("request.duration"))
+	assert.Equal(t, 1000.0, gmSink.valueMultiplier("db.duration"))
+	assert.Equal(t, 2.0, gmSink.valueMultiplier("request.count"))
+	assert.Equal(t, 1.0, gmSink.valueMultiplier("db.count"))
+}
+
+func TestConvertInterToGenericValueMultipliers(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.ValueMultipliers = map[string]float64{"counter.*": 1000}
+
+	genericMetrics := gmSink.convertInterToGeneric(basicInterMetrics())
+	assert.Equal(t, float64(42000), genericMetrics.Metrics[0].Value)
+	assert.Equal(t, float64(42), genericMetrics.Metrics[1].Value)
+}
+
+func TestNewGenericMetricSinkValueMultipliers(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:        10,
+		GenericValueMultipliers: map[string]float64{"[": 2},
+	})
+	assert.Error(t, err)
+}
+
+func TestFlushHeaders(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.Headers = map[string]string{
+		"X-Tenant-ID":  "fnord",
+		"X-Empty":      "",
+		"Content-Type": "text/plain",
+	}
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	assert.NoError(t, err)
+	assert.Equal(t, "fnord", transport.Headers[0].Get("X-Tenant-ID"))
+	_, ok := transport.Headers[0]["X-Empty"]
+	assert.False(t, ok, "headers with empty values should be skipped")
+	assert.Equal(t, "application/json", transport.Headers[0].Get("Content-Type"))
+}
+
+func TestNewGenericMetricSinkConflictingAuthorization(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:         10,
+		GenericBearerToken:       "token",
+		GenericBasicAuthUsername: "admin",
+		GenericBasicAuthPassword: "hunter2",
+	})
+	assert.Error(t, err)
+}
+
+func TestFlushEmpty(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+
+	assert.NoError(t, gmSink.Flush(context.Background(), []samplers.InterMetric{}))
+	assert.NoError(t, gmSink.Flush(context.Background(), nil))
+	gmSink.TypeMapping = map[string]string{"counter": "", "gauge": "", "status": ""}
+	assert.NoError(t, gmSink.Flush(context.Background(), basicInterMetrics()))
+	assert.Equal(t, 0, transport.Called, "empty batches shouldn't be sent")
+}
+
+func TestFlushUnbatched(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 0)
+
+	assert.NoError(t, gmSink.Flush(context.Background(), getInterMetricsMany(25)))
+	assert.Equal(t, 1, transport.Called, "a batch size of 0 should send all the metrics at once")
+}
+
+func TestFlushConcurrent(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 1)
+	gmSink.MaxConcurrency = 3
+	transport.Delay = 10 * time.Millisecond
+
+	interMetrics := getInterMetricsMany(10)
+	err := gmSink.Flush(context.TODO(), interMetrics)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, transport.Called)
+	assert.True(t, transport.MaxInFlight > 1, "batches should have been sent concurrently")
+	assert.True(t, transport.MaxInFlight <= 3, "at most MaxConcurrency batches may be in flight, saw %d", transport.MaxInFlight)
+
+	var flushed []GenericMetric
+	for _, content := range transport.Contents {
+		var gotMetrics GenericMetrics
+		assert.NoError(t, json.Unmarshal([]byte(content), &gotMetrics))
+		flushed = append(flushed, gotMetrics.Metrics...)
+	}
+	expected := getExpectedGenericMetrics(defaultSource, defaultEnvironment, defaultNamespace, []string{}, interMetrics)
+	assert.ElementsMatch(t, expected.Metrics, flushed)
+}
+
+func TestFlushSerialByDefault(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 1)
+	transport.Delay = time.Millisecond
+
+	err := gmSink.Flush(context.TODO(), getInterMetricsMany(5))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, transport.Called)
+	assert.Equal(t, 1, transport.MaxInFlight)
+}
+
+func TestFlushCancelledContext(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := gmSink.Flush(ctx, getInterMetricsMany(3))
+	assert.Equal(t, 0, transport.Called)
+	if assert.IsType(t, &BatchErrors{}, err) {
+		assert.Len(t, err.(*BatchErrors).Errors, 3)
+	}
+}
+
+// cancellingRoundTripper cancels a context once it has passed on After
+// requests to Transport.
+type cancellingRoundTripper struct {
+	Transport http.RoundTripper
+	After     int
+	Cancel    context.CancelFunc
+
+	called int
+}
+
+func (rt *cancellingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.Transport.RoundTrip(req)
+	rt.called++
+	if rt.called == rt.After {
+		rt.Cancel()
+	}
+	return resp, err
+}
+
+func TestFlushCancelledMidFlush(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gmSink.httpClient = &http.Client{Transport: &cancellingRoundTripper{Transport: transport, After: 2, Cancel: cancel}}
+
+	err := gmSink.Flush(ctx, getInterMetricsMany(5))
+	assert.Equal(t, 2, transport.Called, "batches after the cancellation shouldn't be sent")
+	if assert.IsType(t, &BatchErrors{}, err) {
+		batchErrs := err.(*BatchErrors)
+		assert.Equal(t, 5, batchErrs.Batches)
+		if assert.Len(t, batchErrs.Errors, 3) {
+			for _, batchErr := range batchErrs.Errors {
+				assert.True(t, errors.Is(batchErr, ErrFlushCancelled), "got %v", batchErr)
+				assert.True(t, errors.Is(batchErr, context.Canceled), "got %v", batchErr)
+			}
+		}
+	}
+}
+
+func TestFlushReportsMetrics(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 2)
+	transport.Failures = 1
+	ch := startTraceClient(t, gmSink)
+
+	err := gmSink.Flush(context.TODO(), getInterMetricsMany(3))
+	assert.Error(t, err)
+
+	samples := reportedSamples(ch)
+	assert.Len(t, samples[sinks.MetricKeyMetricFlushDuration], 2)
+	assert.Equal(t, float32(1), sampleTotal(samples[MetricKeyBatchesTotal]))
+	assert.Equal(t, float32(1), sampleTotal(samples[sinks.MetricKeyTotalMetricsFlushed]))
+	assert.Equal(t, float32(1), sampleTotal(samples[MetricKeyFlushErrorsTotal]))
+	if assert.Len(t, samples[MetricKeyBatchSize], 2) {
+		assert.Equal(t, ssf.SSFSample_HISTOGRAM, samples[MetricKeyBatchSize][0].Metric)
+		assert.Equal(t, float32(3), sampleTotal(samples[MetricKeyBatchSize]))
+	}
+	for _, sample := range samples[sinks.MetricKeyMetricFlushDuration] {
+		assert.Equal(t, "generic", sample.Tags["sink"])
+	}
+}
+
+func TestConvertInterToGenericTypes(t *testing.T) {
+	gmSink := defaultTestSink()
+	genericMetrics := gmSink.convertInterToGeneric(basicInterMetrics())
+	assert.Equal(t, "counter", genericMetrics.Metrics[0].Type)
+	assert.Equal(t, "gauge", genericMetrics.Metrics[1].Type)
+
+	gmSink.TypeMapping = map[string]string{"counter": "count"}
+	genericMetrics = gmSink.convertInterToGeneric(basicInterMetrics())
+	assert.Equal(t, "count", genericMetrics.Metrics[0].Type)
+	assert.Equal(t, "gauge", genericMetrics.Metrics[1].Type)
+}
+
+func TestFlushDropsUnmappedTypes(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.TypeMapping = map[string]string{"gauge": ""}
+
+	var gotMetrics GenericMetrics
+	interMetrics := basicInterMetrics()
+	err := gmSink.Flush(context.TODO(), interMetrics)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, transport.Called)
+	assert.NoError(t, json.Unmarshal([]byte(transport.Contents[0]), &gotMetrics))
+	if assert.Len(t, gotMetrics.Metrics, 1) {
+		assert.Equal(t, "counter.foo", gotMetrics.Metrics[0].Metric)
+	}
+	assert.Len(t, interMetrics, 2, "the input metrics must not be modified")
+}
+
+func TestConvertInterToGenericTimestampFormat(t *testing.T) {
+	gmSink := defaultTestSink()
+	interMetrics := basicInterMetrics()
+	ts := interMetrics[0].Timestamp
+
+	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, float64(ts), genericMetrics.Metrics[0].At)
+
+	gmSink.TimestampFormat = TimestampMilliseconds
+	genericMetrics = gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, float64(ts*1000), genericMetrics.Metrics[0].At)
+
+	gmSink.TimestampFormat = TimestampRFC3339
+	genericMetrics = gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, "1955-11-05T06:00:00Z", genericMetrics.Metrics[0].At)
+}
+
+func TestNewGenericMetricSinkTimestampFormat(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:       10,
+		GenericTimestampFormat: "nanoseconds",
+	})
+	assert.Error(t, err)
+}
+
+func TestConvertInterToGenericExcludedTags(t *testing.T) {
+	gmSink := getTestSink(nil, []string{"snowy:plover"}, "", 10, defaultSource, defaultEnvironment, defaultNamespace)
+	gmSink.ExcludedTags = []string{"fnord", "snowy"}
+	gmSink.SetExcludedTags([]string{"bletch"})
+
+	genericMetrics := gmSink.convertInterToGeneric(basicInterMetrics())
+	assert.Equal(t, map[string]string{"qux": "quux"}, genericMetrics.Metrics[0].Tags)
+	assert.Equal(t, map[string]string{"fax": "fox"}, genericMetrics.Metrics[1].Tags)
+}
+
+func TestConvertInterToGenericAllowedTags(t *testing.T) {
+	gmSink := getTestSink(nil, []string{"snowy:plover"}, "", 10, defaultSource, defaultEnvironment, defaultNamespace)
+	gmSink.AllowedTags = []string{"fnord", "fax", "snowy"}
+	gmSink.ExcludedTags = []string{"snowy"}
+
+	genericMetrics := gmSink.convertInterToGeneric(basicInterMetrics())
+	assert.Equal(t, map[string]string{"fnord": "xyzzy"}, genericMetrics.Metrics[0].Tags)
+	assert.Equal(t, map[string]string{"fax": "fox"}, genericMetrics.Metrics[1].Tags)
+}
+
+func TestConvertInterToGenericHostname(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.HostnameTag = "host"
+	gmSink.Hostname = "box1"
+	interMetrics := basicInterMetrics()
+	interMetrics[1].Tags = append([]string{"host:box2"}, interMetrics[1].Tags...)
+
+	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, "box1", genericMetrics.Metrics[0].Tags["host"])
+	assert.Equal(t, "box2", genericMetrics.Metrics[1].Tags["host"], "a metric's own host tag should be kept")
+
+	gmSink.Tags = []string{"host:box3"}
+	genericMetrics = gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, "box3", genericMetrics.Metrics[0].Tags["host"], "the sink's own host tag should be kept")
+
+	gmSink.Tags = nil
+	gmSink.HostnameTag = ""
+	genericMetrics = gmSink.convertInterToGeneric(interMetrics)
+	assert.NotContains(t, genericMetrics.Metrics[0].Tags, "host", "metrics shouldn't be tagged with the hostname by default")
+}
+
+func TestConvertInterToGenericSourceTag(t *testing.T) {
+	gmSink := defaultTestSink()
+	interMetrics := basicInterMetrics()
+	interMetrics[1].Tags = append([]string{"host:box2"}, interMetrics[1].Tags...)
+
+	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, defaultSource, genericMetrics.Metrics[1].Source, "the source shouldn't be read from tags by default")
+
+	gmSink.SourceTag = "host"
+	genericMetrics = gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, defaultSource, genericMetrics.Metrics[0].Source, "metrics without the tag should keep the sink's source")
+	assert.Equal(t, "box2", genericMetrics.Metrics[1].Source)
+	assert.Equal(t, "box2", genericMetrics.Metrics[1].Tags["host"], "the tag should still be sent")
+
+	gmSink.Tags = []string{"host:box3"}
+	genericMetrics = gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, defaultSource, genericMetrics.Metrics[0].Source, "only the metric's own tags should be its source")
+}
+
+func TestConvertInterToGenericDropZeroCounters(t *testing.T) {
+	gmSink := defaultTestSink()
+	interMetrics := basicInterMetrics()
+	interMetrics = append(interMetrics, interMetrics...)
+	interMetrics[0].Value, interMetrics[1].Value = 0, 0
+
+	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	assert.Len(t, genericMetrics.Metrics, 4, "zero counters should be sent by default")
+
+	gmSink.DropZeroCounters = true
+	genericMetrics = gmSink.convertInterToGeneric(interMetrics)
+	require.Len(t, genericMetrics.Metrics, 3)
+	assert.Equal(t, "gauge.bar", genericMetrics.Metrics[0].Metric)
+	assert.Equal(t, float64(0), genericMetrics.Metrics[0].Value, "zero gauges should be kept")
+	assert.Equal(t, "counter.foo", genericMetrics.Metrics[1].Metric)
+	assert.Equal(t, float64(42), genericMetrics.Metrics[1].Value, "non-zero counters should be kept")
+}
+
+func TestConvertInterToGenericTagNormalizer(t *testing.T) {
+	gmSink := getTestSink(nil, []string{"snowy:plover"}, "", 10, defaultSource, defaultEnvironment, defaultNamespace)
+	gmSink.ExcludedTags = []string{"snowy"}
+	normalizer, err := sinks.NewTagNormalizer(sinks.TagNormalization{
+		Rewrites: []sinks.TagKeyRewrite{{Pattern: "n", Replacement: "_"}},
+		Drop:     []string{"qux", "bletch"},
+	})
+	require.NoError(t, err)
+	gmSink.TagNormalizer = normalizer
+
+	genericMetrics := gmSink.convertInterToGeneric(basicInterMetrics())
+	assert.Equal(t, map[string]string{"f_ord": "xyzzy"}, genericMetrics.Metrics[0].Tags,
+		"keys should be normalized after excluded tags are stripped")
+	assert.Equal(t, map[string]string{"fax": "fox"}, genericMetrics.Metrics[1].Tags)
+}
+
+func TestFlushRoutes(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/", 10)
+	gmSink.Endpoint = "/default"
+	gmSink.Routes = []Route{
+		{MetricPrefix: "gauge.", Endpoint: "/gauges"},
+		{MetricPrefix: "gauge.bar", Endpoint: "/unreachable"},
+	}
+
+	interMetrics := getInterMetricsMany(3)
+	err := gmSink.Flush(context.TODO(), interMetrics)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, transport.Called)
+
+	var defaultMetrics, gaugeMetrics GenericMetrics
+	assert.NoError(t, json.Unmarshal([]byte(transport.Contents[0]), &defaultMetrics))
+	assert.NoError(t, json.Unmarshal([]byte(transport.Contents[1]), &gaugeMetrics))
+	assert.Equal(t, getExpectedGenericMetrics(defaultSource, defaultEnvironment, defaultNamespace, []string{}, []samplers.InterMetric{interMetrics[0], interMetrics[2]}), defaultMetrics)
+	assert.Equal(t, getExpectedGenericMetrics(defaultSource, defaultEnvironment, defaultNamespace, []string{}, interMetrics[1:2]), gaugeMetrics)
+	assert.Equal(t, []string{"/default", "/gauges"}, transport.Paths)
+}
+
+func TestFlushRoutesByPattern(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/", 10)
+	gmSink.Endpoint = "/default"
+	gmSink.Routes = []Route{{MetricPattern: regexp.MustCompile(`^[a-z]+\.foo$`), Endpoint: "/foos"}}
+
+	require.NoError(t, gmSink.Flush(context.TODO(), getInterMetricsMany(4)))
+	assert.Equal(t, []string{"/foos", "/default"}, transport.Paths)
+	for i, content := range transport.Contents {
+		var batch GenericMetrics
+		require.NoError(t, json.Unmarshal([]byte(content), &batch))
+		require.Len(t, batch.Metrics, 2)
+		assert.Equal(t, []string{"counter.foo", "gauge.bar"}[i], batch.Metrics[0].Metric)
+	}
+}
+
+func TestBatchesPerRoute(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.BatchSize = 2
+	gmSink.Routes = []Route{{MetricPrefix: "gauge.", Endpoint: "/gauges"}}
+
+	batches := gmSink.batches(getInterMetricsMany(7))
+	var sizes []int
+	for _, b := range batches {
+		sizes = append(sizes, len(b.metrics))
+	}
+	assert.Equal(t, []int{2, 2, 2, 1}, sizes)
+	assert.Equal(t, "", batches[0].endpoint)
+	assert.Equal(t, "/gauges", batches[2].endpoint)
+}
+
+func TestFlushGroupByType(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 3)
+	gmSink.GroupByType = true
+
+	require.NoError(t, gmSink.Flush(context.TODO(), getInterMetricsMany(7)))
+	require.Len(t, transport.Contents, 3)
+	var types []string
+	var sizes []int
+	for _, content := range transport.Contents {
+		var batch GenericMetrics
+		require.NoError(t, json.Unmarshal([]byte(content), &batch))
+		for _, metric := range batch.Metrics {
+			assert.Equal(t, batch.Metrics[0].Type, metric.Type, "batches should hold a single type")
+		}
+		types = append(types, batch.Metrics[0].Type)
+		sizes = append(sizes, len(batch.Metrics))
+	}
+	assert.Equal(t, []string{"counter", "counter", "gauge"}, types)
+	assert.Equal(t, []int{3, 1, 3}, sizes)
+}
+
+func TestBatchesGroupByMappedType(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.GroupByType = true
+	gmSink.TypeMapping = map[string]string{"counter": "metric", "gauge": "metric"}
+
+	batches := gmSink.batches(getInterMetricsMany(4))
+	require.Len(t, batches, 1, "types mapped to the same name should be grouped together")
+	assert.Len(t, batches[0].metrics, 4)
+
+	gmSink.GroupByType = false
+	gmSink.TypeMapping = nil
+	assert.Len(t, gmSink.batches(getInterMetricsMany(4)), 1, "types should be mixed by default")
+}
+
+func TestBatchesTypeBatchSizes(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.BatchSize = 5
+	gmSink.TypeBatchSizes = map[string]int{"counter": 2}
+
+	batches := gmSink.batches(getInterMetricsMany(10))
+	var sizes []int
+	for _, b := range batches {
+		sizes = append(sizes, len(b.metrics))
+		for _, metric := range b.metrics {
+			assert.Equal(t, b.metrics[0].Type, metric.Type, "batches should be segmented by type")
+		}
+	}
+	assert.Equal(t, []int{2, 2, 1, 5}, sizes, "counters should be batched by their own size, and gauges by BatchSize")
+	assert.Equal(t, samplers.CounterMetric, batches[0].metrics[0].Type)
+	assert.Equal(t, samplers.GaugeMetric, batches[3].metrics[0].Type)
+
+	gmSink.TypeMapping = map[string]string{"counter": "count"}
+	assert.Len(t, gmSink.batches(getInterMetricsMany(10)), 2, "sizes should apply to the types as emitted")
+	gmSink.TypeBatchSizes = map[string]int{"count": 1}
+	assert.Len(t, gmSink.batches(getInterMetricsMany(10)), 6)
+}
+
+func TestFlushRoutesByType(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/", 10)
+	gmSink.Endpoint = "/default"
+	gmSink.Routes = []Route{{MetricType: "counter", Endpoint: "/counters"}}
+
+	require.NoError(t, gmSink.Flush(context.TODO(), getInterMetricsMany(4)))
+	assert.Equal(t, []string{"/counters", "/default"}, transport.Paths)
+	for i, content := range transport.Contents {
+		var batch GenericMetrics
+		require.NoError(t, json.Unmarshal([]byte(content), &batch))
+		require.Len(t, batch.Metrics, 2)
+		assert.Equal(t, []string{"counter", "gauge"}[i], batch.Metrics[0].Type)
+	}
+}
+
+func TestFlushDryRun(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("", 1)
+	gmSink.Tags = []string{"server:tag"}
+	gmSink.ExcludedTags = []string{"qux"}
+	gmSink.DryRun = true
+	var out bytes.Buffer
+	gmSink.DryRunWriter = &out
+
+	interMetrics := basicInterMetrics()
+	err := gmSink.Flush(context.TODO(), interMetrics)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, transport.Called, "dry run should not send anything")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		var gms GenericMetrics
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &gms))
+		assert.Equal(t, "counter.foo", gms.Metrics[0].Metric)
+		assert.Equal(t, map[string]string{"fnord": "xyzzy", "server": "tag"}, gms.Metrics[0].Tags)
+	}
+}
+
+func TestEncodeMatchesMarshal(t *testing.T) {
+	gmSink := defaultTestSink()
+	genMetrics := gmSink.convertInterToGeneric(getInterMetricsMany(100))
+
+	expected, err := json.Marshal(genMetrics)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, gmSink.encode(&buf, genMetrics))
+	assert.Equal(t, string(expected)+"\n", buf.String())
+}
+
+func TestSerializeStreamingMatches(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatNDJSON} {
+		gmSink := defaultTestSink()
+		gmSink.Format = format
+		gmSink.ExtraEnvelopeFields = map[string]string{"cluster": "east", "<team>": "o&m"}
+		genMetrics := gmSink.convertInterToGeneric(getInterMetricsMany(5))
+
+		var expected, streamed bytes.Buffer
+		require.NoError(t, gmSink.serialize(&expected, genMetrics))
+		require.NoError(t, gmSink.serializeStreaming(&streamed, genMetrics))
+		assert.Equal(t, expected.String(), streamed.String(), "format %s", format)
+	}
+}
+
+func TestFlushStreamed(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.StreamBatches = true
+	gmSink.CompressionType = CompressionGzip
+	gmSink.MaxRetries = 1
+	gmSink.RetryBaseDelay = time.Millisecond
+	transport.Failures = 1
+
+	require.NoError(t, gmSink.Flush(context.TODO(), getInterMetricsMany(5)))
+	assert.Equal(t, 2, transport.Called, "streamed batches should be encoded again when they're retried")
+
+	var expected bytes.Buffer
+	require.NoError(t, gmSink.serialize(&expected, gmSink.convertInterToGeneric(getInterMetricsMany(5))))
+	require.Len(t, transport.Contents, 1)
+	assert.Equal(t, expected.String(), transport.Contents[0])
+}
+
+func TestFlushStreamedSerializeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	gmSink := getTestSink(srv.Client(), nil, srv.URL, 10, defaultSource, defaultEnvironment, defaultNamespace)
+	gmSink.StreamBatches = true
+	gmSink.MaxRetries = 2
+
+	// infinity never makes it past conversion, but can't be encoded
+	genMetrics := gmSink.convertInterToGeneric(basicInterMetrics())
+	genMetrics.Metrics[1].Value = math.Inf(1)
+	_, err := gmSink.sendBatch(context.TODO(), srv.URL, genMetrics)
+	assert.True(t, errors.Is(err, ErrSerialize), "got %v", err)
+}
+
+func TestFlushEncoder(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.StreamBatches = true
+	var encoded []int
+	gmSink.Encoder = func(w io.Writer, genMetrics GenericMetrics) error {
+		encoded = append(encoded, len(genMetrics.Metrics))
+		_, err := io.WriteString(w, "encoded")
+		return err
+	}
+
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	assert.Equal(t, []int{len(basicInterMetrics())}, encoded)
+	assert.Equal(t, []string{"encoded"}, transport.Contents, "what Encoder writes should be sent as it is")
+}
+
+func BenchmarkEncodeMarshal(b *testing.B) {
+	gmSink := defaultTestSink()
+	genMetrics := gmSink.convertInterToGeneric(getInterMetricsMany(10000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(genMetrics); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodePooled(b *testing.B) {
+	gmSink := defaultTestSink()
+	genMetrics := gmSink.convertInterToGeneric(getInterMetricsMany(10000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := bufferPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		if err := gmSink.encode(buf, genMetrics); err != nil {
+			b.Fatal(err)
+		}
+		bufferPool.Put(buf)
+	}
+}
+
+// blockingRoundTripper holds every request until release is closed.
+type blockingRoundTripper struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (rt *blockingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.started <- struct{}{}
+	<-rt.release
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestDrain(t *testing.T) {
+	transport := &blockingRoundTripper{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	gmSink := getTestSink(&http.Client{Transport: transport}, nil, "http://localhost/", 10, defaultSource, defaultEnvironment, defaultNamespace)
+
+	flushed := make(chan error)
+	go func() {
+		flushed <- gmSink.Flush(context.Background(), basicInterMetrics())
+	}()
+	<-transport.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, gmSink.Drain(ctx), "draining should give up once its context is done")
+
+	drained := make(chan error)
+	go func() {
+		drained <- gmSink.Drain(context.Background())
+	}()
+	select {
+	case <-drained:
+		t.Fatal("draining shouldn't finish before the flush in progress")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(transport.release)
+	assert.NoError(t, <-flushed, "the flush in progress should go through")
+	assert.NoError(t, <-drained)
+	assert.Equal(t, ErrDraining, gmSink.Flush(context.Background(), basicInterMetrics()),
+		"flushes after draining should be refused")
+}
+
+func TestMaxInFlight(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 1)
+	gmSink.MaxConcurrency = 10
+	gmSink.MaxInFlight = 3
+	transport.Delay = 5 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, gmSink.Flush(context.Background(), getInterMetricsMany(10)))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 40, transport.Called)
+	assert.True(t, transport.MaxInFlight <= 3, "%d requests were in flight at the same time", transport.MaxInFlight)
+}
+
+func TestMaxInFlightCancelled(t *testing.T) {
+	gmSink := getTestSink(nil, nil, "http://localhost/", 1, defaultSource, defaultEnvironment, defaultNamespace)
+	gmSink.MaxInFlight = 1
+	release, err := gmSink.acquireInFlight(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = gmSink.post(ctx, gmSink.Endpoint, nil, nil)
+	assert.Equal(t, context.DeadlineExceeded, err, "waiting for a request slot should stop once the context is done")
+}
+
+func TestExtraEnvelopeFields(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.ExtraEnvelopeFields = map[string]string{"region": "us-west-2", "cluster": "blue", "namespace": "ignored"}
+
+	var buf bytes.Buffer
+	require.NoError(t, gmSink.WriteMetrics(&buf, basicInterMetrics()[:1]))
+	assert.Equal(t,
+		`{"metrics":[{"metric":"counter.foo","type":"counter","value":42,"source":"source","at":-446752800,"tags":{"fnord":"xyzzy","qux":"quux"}}],"environment":"environment","namespace":"namespace","cluster":"blue","region":"us-west-2"}`+"\n",
+		buf.String())
+
+	gmSink.Format = FormatNDJSON
+	buf.Reset()
+	require.NoError(t, gmSink.WriteMetrics(&buf, basicInterMetrics()[:1]))
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "blue", line["cluster"])
+	assert.Equal(t, "us-west-2", line["region"])
+	assert.Equal(t, "namespace", line["namespace"], "extra fields shouldn't override the sink's own")
+}
+
+func TestNewGenericMetricSinkExtraEnvelopeFields(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:           10,
+		GenericExtraEnvelopeFields: map[string]string{"metrics": "oops"},
+	})
+	assert.Error(t, err)
+}
+
+func TestConvertInterToGenericNamePrefixSuffix(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.NamePrefix = "team."
+	gmSink.NameSuffix = ".v2"
+
+	interMetrics := getInterMetricsMany(6)
+	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	require.Len(t, genericMetrics.Metrics, len(interMetrics))
+	for i, metric := range genericMetrics.Metrics {
+		assert.Equal(t, "team."+interMetrics[i].Name+".v2", metric.Metric)
+	}
+	assert.Equal(t, basicInterMetrics()[0].Name, interMetrics[0].Name, "the input metrics shouldn't be renamed")
+
+	gmSink.NamePrefix, gmSink.NameSuffix = "", ""
+	genericMetrics = gmSink.convertInterToGeneric(interMetrics)
+	assert.Equal(t, interMetrics[0].Name, genericMetrics.Metrics[0].Metric)
+}
+
+func TestConvertInterToGenericLowercaseNames(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.NamePrefix = "Team."
+	tagNormalizer, err := sinks.NewTagNormalizer(sinks.TagNormalization{Lowercase: true})
+	require.NoError(t, err)
+	gmSink.TagNormalizer = tagNormalizer
+
+	interMetrics := basicInterMetrics()[:1]
+	interMetrics = append(interMetrics, interMetrics[0])
+	interMetrics[0].Name, interMetrics[0].Tags = "HTTP.requests", []string{"Path:/"}
+	interMetrics[1].Name, interMetrics[1].Tags = "http.requests", []string{"path:/"}
+
+	genericMetrics := gmSink.convertInterToGeneric(interMetrics)
+	require.Len(t, genericMetrics.Metrics, 2)
+	assert.Equal(t, "Team.HTTP.requests", genericMetrics.Metrics[0].Metric, "names shouldn't be lowercased by default")
+
+	gmSink.LowercaseNames = true
+	genericMetrics = gmSink.convertInterToGeneric(interMetrics)
+	require.Len(t, genericMetrics.Metrics, 2)
+	for _, metric := range genericMetrics.Metrics {
+		assert.Equal(t, "Team.http.requests", metric.Metric, "only the metric's own name should be lowercased")
+		assert.Equal(t, map[string]string{"path": "/"}, metric.Tags)
+	}
+}
+
+func TestSerializeIsStable(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.Tags = []string{"zeta:1", "alpha:2", "mu:3", "beta:4", "omega:5"}
+
+	var first bytes.Buffer
+	require.NoError(t, gmSink.WriteMetrics(&first, basicInterMetrics()))
+	assert.Contains(t, first.String(), `"tags":{"alpha":"2","beta":"4","fnord":"xyzzy","mu":"3","omega":"5","qux":"quux","zeta":"1"}`,
+		"tags should be serialized with their keys sorted")
+	for i := 0; i < 20; i++ {
+		var again bytes.Buffer
+		require.NoError(t, gmSink.WriteMetrics(&again, basicInterMetrics()))
+		require.Equal(t, first.String(), again.String(), "serializing the same metrics should always produce the same bytes")
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	transport := NewHTTPClient(0, 0, 0).Transport.(*http.Transport)
+	assert.Equal(t, DefaultMaxIdleConns, transport.MaxIdleConns)
+	assert.Equal(t, DefaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, DefaultIdleConnTimeout, transport.IdleConnTimeout)
+
+	transport = NewHTTPClient(10, 5, time.Minute).Transport.(*http.Transport)
+	assert.Equal(t, 10, transport.MaxIdleConns)
+	assert.Equal(t, 5, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+}
+
+func TestNewGenericMetricSinkDefaultHTTPClient(t *testing.T) {
+	gmSink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize: 10,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, gmSink.httpClient)
+	transport, ok := gmSink.httpClient.Transport.(*http.Transport)
+	require.True(t, ok, "the sink should build its own transport when it isn't given a client")
+	assert.Equal(t, DefaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+}
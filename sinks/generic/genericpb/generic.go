@@ -0,0 +1,149 @@
+// Package genericpb contains the messages and the MetricIngest service
+// described in generic.proto, which the generic gRPC sink streams metrics
+// to.
+//
+// The types here are written out by hand rather than generated, and use
+// the struct-tag based encoding of github.com/golang/protobuf. Keep them
+// in sync with generic.proto.
+package genericpb
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// GenericMetric is a single metric. At is in seconds since the epoch.
+type GenericMetric struct {
+	Metric string            `protobuf:"bytes,1,opt,name=metric,proto3" json:"metric,omitempty"`
+	Type   string            `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Value  float64           `protobuf:"fixed64,3,opt,name=value,proto3" json:"value,omitempty"`
+	Source string            `protobuf:"bytes,4,opt,name=source,proto3" json:"source,omitempty"`
+	At     int64             `protobuf:"varint,5,opt,name=at,proto3" json:"at,omitempty"`
+	Tags   map[string]string `protobuf:"bytes,6,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *GenericMetric) Reset()         { *m = GenericMetric{} }
+func (m *GenericMetric) String() string { return proto.CompactTextString(m) }
+func (*GenericMetric) ProtoMessage()    {}
+
+// GenericMetricBatch is a batch of metrics with their common environment
+// and namespace.
+type GenericMetricBatch struct {
+	Metrics     []*GenericMetric `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	Environment string           `protobuf:"bytes,2,opt,name=environment,proto3" json:"environment,omitempty"`
+	Namespace   string           `protobuf:"bytes,3,opt,name=namespace,proto3" json:"namespace,omitempty"`
+}
+
+func (m *GenericMetricBatch) Reset()         { *m = GenericMetricBatch{} }
+func (m *GenericMetricBatch) String() string { return proto.CompactTextString(m) }
+func (*GenericMetricBatch) ProtoMessage()    {}
+
+// Ack is the server's answer once a stream has been closed.
+type Ack struct{}
+
+func (m *Ack) Reset()         { *m = Ack{} }
+func (m *Ack) String() string { return proto.CompactTextString(m) }
+func (*Ack) ProtoMessage()    {}
+
+// MetricIngestClient is the client API for the MetricIngest service.
+type MetricIngestClient interface {
+	StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (MetricIngest_StreamMetricsClient, error)
+}
+
+type metricIngestClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewMetricIngestClient returns a client for the MetricIngest service.
+func NewMetricIngestClient(cc *grpc.ClientConn) MetricIngestClient {
+	return &metricIngestClient{cc}
+}
+
+func (c *metricIngestClient) StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (MetricIngest_StreamMetricsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_MetricIngest_serviceDesc.Streams[0], "/genericpb.MetricIngest/StreamMetrics", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &metricIngestStreamMetricsClient{stream}, nil
+}
+
+// MetricIngest_StreamMetricsClient is the client side of a StreamMetrics
+// stream.
+type MetricIngest_StreamMetricsClient interface {
+	Send(*GenericMetricBatch) error
+	CloseAndRecv() (*Ack, error)
+	grpc.ClientStream
+}
+
+type metricIngestStreamMetricsClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricIngestStreamMetricsClient) Send(m *GenericMetricBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *metricIngestStreamMetricsClient) CloseAndRecv() (*Ack, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Ack)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MetricIngestServer is the server API for the MetricIngest service.
+type MetricIngestServer interface {
+	StreamMetrics(MetricIngest_StreamMetricsServer) error
+}
+
+// RegisterMetricIngestServer registers srv with s.
+func RegisterMetricIngestServer(s *grpc.Server, srv MetricIngestServer) {
+	s.RegisterService(&_MetricIngest_serviceDesc, srv)
+}
+
+func _MetricIngest_StreamMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricIngestServer).StreamMetrics(&metricIngestStreamMetricsServer{stream})
+}
+
+// MetricIngest_StreamMetricsServer is the server side of a StreamMetrics
+// stream.
+type MetricIngest_StreamMetricsServer interface {
+	SendAndClose(*Ack) error
+	Recv() (*GenericMetricBatch, error)
+	grpc.ServerStream
+}
+
+type metricIngestStreamMetricsServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricIngestStreamMetricsServer) SendAndClose(m *Ack) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *metricIngestStreamMetricsServer) Recv() (*GenericMetricBatch, error) {
+	m := new(GenericMetricBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _MetricIngest_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "genericpb.MetricIngest",
+	HandlerType: (*MetricIngestServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamMetrics",
+			Handler:       _MetricIngest_StreamMetrics_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "sinks/generic/genericpb/generic.proto",
+}
@@ -0,0 +1,288 @@
+package generic
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/backoff"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/sinks/generic/genericpb"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+	"google.golang.org/grpc"
+)
+
+// stopTimeout bounds how long Stop waits for the server to acknowledge
+// the metrics sent on the stream before tearing it down anyway.
+const stopTimeout = 5 * time.Second
+
+// GenericGRPCSink streams batches of metrics to a server implementing the
+// MetricIngest service in genericpb, over a single long-lived gRPC
+// stream. Metrics are converted the same way GenericMetricSink converts
+// them, except that they are encoded as protobuf messages.
+//
+// If sending on the stream fails, the stream is torn down and a new one is
+// opened for the next attempt, after a delay that doubles with every
+// attempt.
+type GenericGRPCSink struct {
+	log         *logrus.Logger
+	traceClient *trace.Client
+	Target      string
+	BatchSize   int
+
+	// MaxRetries is the number of times a failed batch is re-sent on a
+	// new stream before giving up on it. Zero disables retries.
+	MaxRetries int
+	// ReconnectBaseDelay is the delay before opening a new stream after
+	// the first failure; each subsequent failure doubles it, up to
+	// ReconnectMaxDelay.
+	ReconnectBaseDelay time.Duration
+	// ReconnectMaxDelay caps the delay before opening a new stream. Zero
+	// means no cap.
+	ReconnectMaxDelay time.Duration
+
+	// converter holds the settings metrics are converted with.
+	converter *GenericMetricSink
+	dialOpts  []grpc.DialOption
+
+	// streamMtx guards the connection and the stream, since a stream
+	// mustn't be sent on from several goroutines at once.
+	streamMtx    sync.Mutex
+	conn         *grpc.ClientConn
+	client       genericpb.MetricIngestClient
+	stream       genericpb.MetricIngest_StreamMetricsClient
+	cancelStream context.CancelFunc
+}
+
+var _ sinks.MetricSink = &GenericGRPCSink{}
+
+// NewGenericGRPCSink returns a new generic gRPC sink, which connects to
+// target once it's started. Any dial options are used when connecting.
+func NewGenericGRPCSink(
+	log *logrus.Logger,
+	target string,
+	tags []string,
+	batchSize int,
+	source string,
+	environment string,
+	namespace string,
+	maxRetries int,
+	reconnectBaseDelay time.Duration,
+	reconnectMaxDelay time.Duration,
+	opts ...grpc.DialOption,
+) (*GenericGRPCSink, error) {
+	return &GenericGRPCSink{
+		log:                log,
+		Target:             target,
+		BatchSize:          batchSize,
+		MaxRetries:         maxRetries,
+		ReconnectBaseDelay: reconnectBaseDelay,
+		ReconnectMaxDelay:  reconnectMaxDelay,
+		converter: &GenericMetricSink{
+			log:         log,
+			Tags:        tags,
+			Source:      source,
+			Environment: environment,
+			Namespace:   namespace,
+		},
+		dialOpts: opts,
+	}, nil
+}
+
+// Name returns the sink's name.
+func (g *GenericGRPCSink) Name() string {
+	return "generic_grpc"
+}
+
+// SetExcludedTags sets the excluded tag names. Any tags with the provided
+// key (name) will be excluded.
+func (g *GenericGRPCSink) SetExcludedTags(excludes []string) {
+	g.converter.SetExcludedTags(excludes)
+}
+
+// Start sets the trace client for the sink and connects to the target.
+// The stream itself is opened by the first flush.
+func (g *GenericGRPCSink) Start(client *trace.Client) error {
+	g.traceClient = client
+
+	g.streamMtx.Lock()
+	defer g.streamMtx.Unlock()
+	conn, err := grpc.Dial(g.Target, g.dialOpts...)
+	if err != nil {
+		g.log.WithError(err).WithField("target", g.Target).Error("Error connecting to generic gRPC target")
+		return err
+	}
+	g.conn = conn
+	g.client = genericpb.NewMetricIngestClient(conn)
+	return nil
+}
+
+// Stop closes the stream, waiting up to stopTimeout for the server to
+// acknowledge the metrics sent on it, and then the connection.
+func (g *GenericGRPCSink) Stop() {
+	g.streamMtx.Lock()
+	defer g.streamMtx.Unlock()
+
+	if g.stream != nil {
+		stream := g.stream
+		closed := make(chan error, 1)
+		go func() {
+			_, err := stream.CloseAndRecv()
+			closed <- err
+		}()
+		select {
+		case err := <-closed:
+			if err != nil {
+				g.log.WithError(err).Warn("Error closing generic gRPC stream")
+			}
+		case <-time.After(stopTimeout):
+			g.log.Warn("Timed out waiting for the generic gRPC target to close the stream")
+		}
+		g.resetStream()
+	}
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+		g.client = nil
+	}
+}
+
+// Flush sends metrics on the stream in batches of at most BatchSize
+// metrics. Every batch is attempted, even if an earlier one failed; the
+// last error is returned.
+func (g *GenericGRPCSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	converted := g.convert(interMetrics)
+
+	var flushErr error
+	for len(converted) > 0 {
+		batchSize := g.BatchSize
+		if batchSize < 1 || len(converted) < batchSize {
+			batchSize = len(converted)
+		}
+		batch := &genericpb.GenericMetricBatch{
+			Metrics:     converted[:batchSize],
+			Environment: g.converter.Environment,
+			Namespace:   g.converter.Namespace,
+		}
+		if err := g.flushBatch(ctx, batch); err != nil {
+			flushErr = err
+		}
+		converted = converted[batchSize:]
+	}
+	return flushErr
+}
+
+// flushBatch sends a single batch on the stream. If that fails, it
+// re-sends it on a new stream, with exponential backoff, up to MaxRetries
+// times. It gives up early if ctx is cancelled.
+func (g *GenericGRPCSink) flushBatch(ctx context.Context, batch *genericpb.GenericMetricBatch) error {
+	g.streamMtx.Lock()
+	defer g.streamMtx.Unlock()
+
+	samples := &ssf.Samples{}
+	defer metrics.Report(g.traceClient, samples)
+	tags := map[string]string{"sink": g.Name()}
+	samples.Add(ssf.Histogram(MetricKeyBatchSize, float32(len(batch.Metrics)), tags))
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		sendStart := time.Now()
+		err = g.send(batch)
+		samples.Add(ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(sendStart), time.Nanosecond, tags))
+		if err == nil {
+			samples.Add(
+				ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(batch.Metrics)), tags),
+				ssf.Count(MetricKeyBatchesTotal, 1, tags),
+			)
+			return nil
+		}
+		samples.Add(ssf.Count(MetricKeyFlushErrorsTotal, 1, tags))
+		g.log.WithError(err).WithField("attempt", attempt).Warn("Error sending metrics on generic gRPC stream")
+		if attempt >= g.MaxRetries {
+			break
+		}
+		if err = g.waitForReconnect(ctx, attempt); err != nil {
+			break
+		}
+		samples.Add(ssf.Count(MetricKeyRetriesTotal, 1, tags))
+	}
+	g.log.WithFields(logrus.Fields{
+		"metrics":       len(batch.Metrics),
+		logrus.ErrorKey: err,
+	}).Error("Giving up on generic gRPC batch")
+	return err
+}
+
+// send sends a batch on the current stream, opening one if there is none.
+// If sending fails, the stream is torn down. streamMtx must be held.
+func (g *GenericGRPCSink) send(batch *genericpb.GenericMetricBatch) error {
+	if g.client == nil {
+		return grpc.ErrClientConnClosing
+	}
+	if g.stream == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := g.client.StreamMetrics(ctx)
+		if err != nil {
+			cancel()
+			return err
+		}
+		g.stream = stream
+		g.cancelStream = cancel
+	}
+
+	err := g.stream.Send(batch)
+	if err == io.EOF {
+		// The server ended the stream; the reason is only available
+		// from receiving on it.
+		if _, recvErr := g.stream.CloseAndRecv(); recvErr != nil {
+			err = recvErr
+		}
+	}
+	if err != nil {
+		g.resetStream()
+	}
+	return err
+}
+
+// resetStream cancels the current stream, so that the next send opens a
+// new one. streamMtx must be held.
+func (g *GenericGRPCSink) resetStream() {
+	if g.cancelStream != nil {
+		g.cancelStream()
+	}
+	g.stream = nil
+	g.cancelStream = nil
+}
+
+// waitForReconnect blocks until it's time to open a new stream after the
+// given attempt, returning early with the context's error if ctx is
+// cancelled.
+func (g *GenericGRPCSink) waitForReconnect(ctx context.Context, attempt int) error {
+	return backoff.Wait(ctx, backoff.Exponential{Base: g.ReconnectBaseDelay, Max: g.ReconnectMaxDelay}, attempt)
+}
+
+// convert turns metrics into protobuf messages, the same way
+// GenericMetricSink turns them into JSON.
+func (g *GenericGRPCSink) convert(interMetrics []samplers.InterMetric) []*genericpb.GenericMetric {
+	genMetrics := g.converter.convertInterToGeneric(interMetrics)
+	converted := make([]*genericpb.GenericMetric, len(genMetrics.Metrics))
+	for i, metric := range genMetrics.Metrics {
+		converted[i] = &genericpb.GenericMetric{
+			Metric: metric.Metric,
+			Type:   metric.Type,
+			Value:  metric.Value,
+			Source: metric.Source,
+			At:     interMetrics[i].Timestamp,
+			Tags:   metric.Tags,
+		}
+	}
+	return converted
+}
+
+// FlushOtherSamples does nothing; currently this sink only supports metrics.
+func (g *GenericGRPCSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {}
@@ -0,0 +1,146 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks/generic/genericpb"
+	"google.golang.org/grpc"
+)
+
+// testIngestServer records the batches it receives. Every stream is ended
+// with an error after failAfter batches, if failAfter is set.
+type testIngestServer struct {
+	batches   chan *genericpb.GenericMetricBatch
+	streams   chan struct{}
+	failAfter int
+}
+
+func (s *testIngestServer) StreamMetrics(stream genericpb.MetricIngest_StreamMetricsServer) error {
+	s.streams <- struct{}{}
+	for received := 0; ; received++ {
+		if s.failAfter > 0 && received >= s.failAfter {
+			return fmt.Errorf("going away")
+		}
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&genericpb.Ack{})
+		}
+		if err != nil {
+			return err
+		}
+		s.batches <- batch
+	}
+}
+
+func startTestIngestServer(t *testing.T, failAfter int) (*testIngestServer, string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &testIngestServer{
+		batches:   make(chan *genericpb.GenericMetricBatch, 100),
+		streams:   make(chan struct{}, 100),
+		failAfter: failAfter,
+	}
+	server := grpc.NewServer()
+	genericpb.RegisterMetricIngestServer(server, srv)
+	go server.Serve(lis)
+	return srv, lis.Addr().String(), server.Stop
+}
+
+func receiveBatch(t *testing.T, srv *testIngestServer) *genericpb.GenericMetricBatch {
+	select {
+	case batch := <-srv.batches:
+		return batch
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a batch")
+		return nil
+	}
+}
+
+func TestGRPCFlush(t *testing.T) {
+	srv, addr, stop := startTestIngestServer(t, 0)
+	defer stop()
+
+	sink, err := NewGenericGRPCSink(logrus.New(), addr, []string{"host:fnord"}, 1, "veneur", "prod", "ns", 0, 0, 0, grpc.WithInsecure())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     2,
+		Tags:      []string{"foo:bar"},
+		Type:      samplers.CounterMetric,
+	}, {
+		Name:      "a.b.d",
+		Timestamp: 1476119058,
+		Value:     0,
+		Type:      samplers.GaugeMetric,
+	}})
+	require.NoError(t, err)
+
+	first := receiveBatch(t, srv)
+	assert.Equal(t, "prod", first.Environment)
+	assert.Equal(t, "ns", first.Namespace)
+	require.Len(t, first.Metrics, 1)
+	assert.Equal(t, &genericpb.GenericMetric{
+		Metric: "a.b.c",
+		Type:   "counter",
+		Value:  2,
+		Source: "veneur",
+		At:     1476119058,
+		Tags:   map[string]string{"foo": "bar", "host": "fnord"},
+	}, first.Metrics[0])
+
+	second := receiveBatch(t, srv)
+	require.Len(t, second.Metrics, 1)
+	assert.Equal(t, "gauge", second.Metrics[0].Type)
+	assert.Len(t, srv.streams, 1, "both batches should be sent on the same stream")
+}
+
+func TestGRPCReconnect(t *testing.T) {
+	srv, addr, stop := startTestIngestServer(t, 1)
+	defer stop()
+
+	sink, err := NewGenericGRPCSink(logrus.New(), addr, nil, 0, "", "", "", 3, time.Millisecond, 10*time.Millisecond, grpc.WithInsecure())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	metrics := []samplers.InterMetric{{Name: "a.b.c", Type: samplers.GaugeMetric}}
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	receiveBatch(t, srv)
+
+	// The server ends the stream after the first batch; whether the
+	// client notices on the next send or the one after depends on
+	// timing, so keep flushing until a second stream is opened.
+	deadline := time.After(5 * time.Second)
+	for len(srv.streams) < 2 {
+		sink.Flush(context.Background(), metrics)
+		select {
+		case <-deadline:
+			t.Fatal("the sink never opened a new stream")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	receiveBatch(t, srv)
+}
+
+func TestGRPCGivesUp(t *testing.T) {
+	sink, err := NewGenericGRPCSink(logrus.New(), "127.0.0.1:1", nil, 0, "", "", "", 2, time.Millisecond, 0, grpc.WithInsecure())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{{Name: "a.b.c", Type: samplers.GaugeMetric}})
+	assert.Error(t, err, "flushing to a target that isn't listening should fail")
+}
@@ -0,0 +1,59 @@
+package generic
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyFlushGuardrailExceeded is emitted as a counter of the flushes
+// with more metrics than WarnMetricsPerFlush or MaxMetricsPerFlush,
+// tagged with `sink:sink.Name()` and `threshold` (`warn` or `max`).
+const MetricKeyFlushGuardrailExceeded = "sink.generic.flush_guardrail_exceeded_total"
+
+// ErrTooManyMetrics is returned by flushes that are refused because they
+// have more metrics than MaxMetricsPerFlush.
+var ErrTooManyMetrics = fmt.Errorf("the flush has more generic metrics than allowed")
+
+// The thresholds of the guardrail, as reported in
+// MetricKeyFlushGuardrailExceeded.
+const (
+	guardrailWarn = "warn"
+	guardrailMax  = "max"
+)
+
+// checkGuardrail checks the number of metrics in a flush against
+// WarnMetricsPerFlush and MaxMetricsPerFlush, and returns
+// ErrTooManyMetrics if the flush must be refused. Going over either calls
+// GuardrailHook, if it's set.
+func (gm *GenericMetricSink) checkGuardrail(count int) error {
+	threshold, limit := "", 0
+	switch {
+	case gm.MaxMetricsPerFlush > 0 && count > gm.MaxMetricsPerFlush:
+		threshold, limit = guardrailMax, gm.MaxMetricsPerFlush
+	case gm.WarnMetricsPerFlush > 0 && count > gm.WarnMetricsPerFlush:
+		threshold, limit = guardrailWarn, gm.WarnMetricsPerFlush
+	default:
+		return nil
+	}
+
+	metrics.ReportOne(gm.traceClient, ssf.Count(MetricKeyFlushGuardrailExceeded, 1, map[string]string{
+		"sink":      gm.Name(),
+		"threshold": threshold,
+	}))
+	if gm.GuardrailHook != nil {
+		gm.GuardrailHook(count, threshold == guardrailMax)
+	}
+	log := gm.log.WithFields(logrus.Fields{
+		"metrics": count,
+		"limit":   limit,
+	})
+	if threshold == guardrailWarn {
+		log.Warn("Flushing an unusual number of generic metrics, which may be a cardinality explosion upstream")
+		return nil
+	}
+	log.Error("Refusing to flush more generic metrics than allowed, which may be a cardinality explosion upstream")
+	return ErrTooManyMetrics
+}
@@ -0,0 +1,53 @@
+package generic
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushGuardrail(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		metrics   int
+		threshold string
+		refused   bool
+	}{
+		{"under", 4, "", false},
+		{"warn", 6, guardrailWarn, false},
+		{"max", 10, guardrailMax, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gmSink, transport := getRoundTripTestSink("/endpoint", 100)
+			gmSink.WarnMetricsPerFlush = 4
+			gmSink.MaxMetricsPerFlush = 8
+			var hooked []bool
+			gmSink.GuardrailHook = func(metrics int, refused bool) {
+				assert.Equal(t, tc.metrics, metrics)
+				hooked = append(hooked, refused)
+			}
+			ch := startTraceClient(t, gmSink)
+
+			err := gmSink.Flush(context.TODO(), getInterMetricsMany(tc.metrics))
+			samples := reportedSamples(ch)[MetricKeyFlushGuardrailExceeded]
+			if tc.threshold == "" {
+				assert.NoError(t, err)
+				assert.Empty(t, samples)
+				assert.Empty(t, hooked)
+				return
+			}
+			assert.Equal(t, []bool{tc.refused}, hooked)
+			if assert.Len(t, samples, 1) {
+				assert.Equal(t, tc.threshold, samples[0].Tags["threshold"])
+			}
+			if tc.refused {
+				assert.Equal(t, ErrTooManyMetrics, err)
+				assert.Equal(t, 0, transport.Called, "refused flushes shouldn't reach the endpoint")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, transport.Called)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package generic
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// The HTTP protocols the sink's own client can speak.
+const (
+	// ProtocolHTTP1 is HTTP/1.1, what the sink speaks by default.
+	ProtocolHTTP1 = "http1"
+	// ProtocolHTTP2 is HTTP/2 only, multiplexing every request to an
+	// endpoint over a single connection.
+	ProtocolHTTP2 = "http2"
+)
+
+// NewHTTP2Client returns an HTTP client that only speaks HTTP/2: over TLS
+// to https endpoints, and with prior knowledge (h2c) to http endpoints.
+// Requests fail, rather than falling back to HTTP/1.1, if an https
+// endpoint doesn't negotiate HTTP/2, or if an http endpoint doesn't speak
+// it.
+func NewHTTP2Client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Transport: &http2OnlyTransport{
+			tls: &http2.Transport{},
+			cleartext: &http2.Transport{
+				AllowHTTP: true,
+				DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+					return dialer.Dial(network, addr)
+				},
+			},
+		},
+	}
+}
+
+// http2OnlyTransport sends requests to https endpoints over TLS, and to
+// http endpoints in cleartext, both with HTTP/2.
+type http2OnlyTransport struct {
+	tls       *http2.Transport
+	cleartext *http2.Transport
+}
+
+func (t *http2OnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.cleartext.RoundTrip(req)
+	}
+	return t.tls.RoundTrip(req)
+}
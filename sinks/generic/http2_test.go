@@ -0,0 +1,92 @@
+package generic
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// http2TestSink returns a sink sending to endpoint with NewHTTP2Client,
+// trusting cert if it's set.
+func http2TestSink(endpoint string, cert *x509.Certificate) *GenericMetricSink {
+	client := NewHTTP2Client()
+	if cert != nil {
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		client.Transport.(*http2OnlyTransport).tls.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return getTestSink(client, []string{}, endpoint, 10, defaultSource, defaultEnvironment, defaultNamespace)
+}
+
+// protoRecorder records the major HTTP version of the requests it gets.
+func protoRecorder(protos chan<- int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos <- r.ProtoMajor
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func TestHTTP2OverTLS(t *testing.T) {
+	protos := make(chan int, 10)
+	srv := httptest.NewUnstartedServer(protoRecorder(protos))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	require.NoError(t, http2TestSink(srv.URL, srv.Certificate()).Flush(context.TODO(), basicInterMetrics()))
+	assert.Equal(t, 2, <-protos)
+}
+
+func TestHTTP2Cleartext(t *testing.T) {
+	protos := make(chan int, 10)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: protoRecorder(protos)})
+		}
+	}()
+
+	require.NoError(t, http2TestSink("http://"+listener.Addr().String(), nil).Flush(context.TODO(), basicInterMetrics()))
+	assert.Equal(t, 2, <-protos)
+}
+
+func TestHTTP2NotNegotiated(t *testing.T) {
+	protos := make(chan int, 10)
+	srv := httptest.NewTLSServer(protoRecorder(protos))
+	defer srv.Close()
+
+	_, err := http2TestSink(srv.URL, srv.Certificate()).flushBatch(context.TODO(), srv.URL, basicInterMetrics())
+	assert.True(t, errors.Is(err, ErrTransport), "the sink shouldn't fall back to HTTP/1.1, got %v", err)
+	assert.Empty(t, protos)
+}
+
+func TestNewGenericMetricSinkHTTPProtocol(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:    10,
+		GenericHTTPProtocol: "spdy",
+	})
+	assert.Error(t, err)
+
+	gmSink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:    10,
+		GenericHTTPProtocol: ProtocolHTTP2,
+	})
+	require.NoError(t, err)
+	assert.IsType(t, &http2OnlyTransport{}, gmSink.httpClient.Transport)
+}
@@ -0,0 +1,99 @@
+package generic
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyLimitedMetrics is emitted as a counter of the metrics whose
+// name is longer than MaxNameLength or that have more than MaxTags tags,
+// tagged with `sink:sink.Name()`, `limit` (`name` or `tags`) and
+// `policy` (the LimitPolicy applied).
+const MetricKeyLimitedMetrics = "sink.generic.limited_metrics_total"
+
+// The policies for metrics over MaxNameLength or MaxTags.
+const (
+	// LimitTruncate cuts their names down to MaxNameLength bytes, and
+	// keeps the first MaxTags of their tags, ordered by key.
+	LimitTruncate = "truncate"
+	// LimitDrop drops them.
+	LimitDrop = "drop"
+)
+
+// The limits a metric can go over, as reported in MetricKeyLimitedMetrics.
+const (
+	limitName = "name"
+	limitTags = "tags"
+)
+
+// limitCounts counts the metrics that went over each limit in a flush.
+type limitCounts map[string]int
+
+// applyLimits reports whether a metric is within MaxNameLength and
+// MaxTags, and truncates it if it isn't and LimitPolicy says so. Every
+// limit the metric goes over is counted in counts.
+func (gm *GenericMetricSink) applyLimits(metric *GenericMetric, counts limitCounts) bool {
+	within := true
+	if gm.MaxNameLength > 0 && len(metric.Metric) > gm.MaxNameLength {
+		counts[limitName]++
+		within = false
+		if gm.LimitPolicy != LimitDrop {
+			metric.Metric = truncateName(metric.Metric, gm.MaxNameLength)
+		}
+	}
+	if gm.MaxTags > 0 && len(metric.Tags) > gm.MaxTags {
+		counts[limitTags]++
+		within = false
+		if gm.LimitPolicy != LimitDrop {
+			metric.Tags = truncateTags(metric.Tags, gm.MaxTags)
+		}
+	}
+	return within
+}
+
+// truncateName cuts name down to at most max bytes, without splitting a
+// UTF-8 character.
+func truncateName(name string, max int) string {
+	for max > 0 && !utf8.RuneStart(name[max]) {
+		max--
+	}
+	return name[:max]
+}
+
+// truncateTags returns the first max of tags, ordered by key, so that the
+// same tags are kept from one flush to the next.
+func truncateTags(tags map[string]string, max int) map[string]string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kept := make(map[string]string, max)
+	for _, k := range keys[:max] {
+		kept[k] = tags[k]
+	}
+	return kept
+}
+
+// reportLimited records the number of metrics that went over each limit.
+func (gm *GenericMetricSink) reportLimited(counts limitCounts) {
+	policy := gm.LimitPolicy
+	if policy == "" {
+		policy = LimitTruncate
+	}
+	for limit, n := range counts {
+		metrics.ReportOne(gm.traceClient, ssf.Count(MetricKeyLimitedMetrics, float32(n), map[string]string{
+			"sink":   gm.Name(),
+			"limit":  limit,
+			"policy": policy,
+		}))
+		gm.log.WithFields(logrus.Fields{
+			"metrics": n,
+			"limit":   limit,
+		}).Debug("Found generic metrics over the sink's limits")
+	}
+}
@@ -0,0 +1,91 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func limitedMetrics() []samplers.InterMetric {
+	return []samplers.InterMetric{{
+		Name:  "a.very.long.metric.name",
+		Value: 1,
+		Tags:  []string{"c:3", "a:1", "b:2"},
+		Type:  samplers.CounterMetric,
+	}, {
+		Name:  "short",
+		Value: 2,
+		Tags:  []string{"a:1"},
+		Type:  samplers.GaugeMetric,
+	}}
+}
+
+func TestLimitsTruncate(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.MaxNameLength = 6
+	gmSink.MaxTags = 2
+	ch := startTraceClient(t, gmSink)
+
+	genMetrics := gmSink.convertInterToGeneric(limitedMetrics()).Metrics
+	require.Len(t, genMetrics, 2)
+	assert.Equal(t, "a.very", genMetrics[0].Metric)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, genMetrics[0].Tags, "the first tags by key should be kept")
+	assert.Equal(t, "short", genMetrics[1].Metric)
+	assert.Equal(t, map[string]string{"a": "1"}, genMetrics[1].Tags)
+
+	samples := reportedSamples(ch)
+	if assert.Len(t, samples[MetricKeyLimitedMetrics], 2) {
+		for _, sample := range samples[MetricKeyLimitedMetrics] {
+			assert.Equal(t, float32(1), sample.Value)
+			assert.Equal(t, LimitTruncate, sample.Tags["policy"])
+		}
+	}
+}
+
+func TestLimitsDrop(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		maxNameLength int
+		maxTags       int
+		limit         string
+	}{
+		{"name", 6, 0, "name"},
+		{"tags", 0, 2, "tags"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gmSink := defaultTestSink()
+			gmSink.MaxNameLength = tc.maxNameLength
+			gmSink.MaxTags = tc.maxTags
+			gmSink.LimitPolicy = LimitDrop
+			ch := startTraceClient(t, gmSink)
+
+			genMetrics := gmSink.convertInterToGeneric(limitedMetrics()).Metrics
+			if assert.Len(t, genMetrics, 1) {
+				assert.Equal(t, "short", genMetrics[0].Metric)
+			}
+			samples := reportedSamples(ch)
+			if assert.Len(t, samples[MetricKeyLimitedMetrics], 1) {
+				sample := samples[MetricKeyLimitedMetrics][0]
+				assert.Equal(t, tc.limit, sample.Tags["limit"])
+				assert.Equal(t, LimitDrop, sample.Tags["policy"])
+			}
+		})
+	}
+}
+
+func TestTruncateNameUTF8(t *testing.T) {
+	assert.Equal(t, "caf", truncateName("café.latency", 4), "characters shouldn't be split")
+	assert.Equal(t, "café", truncateName("café.latency", 5))
+}
+
+func TestNewGenericMetricSinkFromConfigLimitPolicy(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericEndpoint:    "http://localhost:8080/metrics",
+		GenericBatchSize:   100,
+		GenericLimitPolicy: "shorten",
+	})
+	assert.Error(t, err)
+}
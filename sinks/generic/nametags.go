@@ -0,0 +1,76 @@
+package generic
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// NameTagRule extracts tags from the names of the metrics Pattern
+// matches, for clients that encode dimensions in names, e.g.
+// "api.latency.endpoint_checkout". Every named capture group of Pattern
+// becomes a tag whose key is the group's name, unless the metric already
+// has a tag with that key. If Rewrite is set, the part of the name
+// Pattern matched is replaced with it, expanding $1 or ${name} to the
+// text of the capture groups, so that
+//
+//	Pattern: `^(.+)\.endpoint_(?P<endpoint>\w+)$`, Rewrite: "$1"
+//
+// turns "api.latency.endpoint_checkout" into "api.latency", tagged with
+// endpoint:checkout.
+type NameTagRule struct {
+	Pattern *regexp.Regexp
+	Rewrite string
+}
+
+// NameTagRuleConfig configures a NameTagRule, with its Pattern as a
+// regular expression.
+type NameTagRuleConfig struct {
+	Pattern string `yaml:"pattern"`
+	Rewrite string `yaml:"rewrite"`
+}
+
+// compileNameTagRules compiles the configured rules, checking that each
+// of them has a tag to extract.
+func compileNameTagRules(configs []NameTagRuleConfig) ([]NameTagRule, error) {
+	var rules []NameTagRule
+	for _, conf := range configs {
+		pattern, err := regexp.Compile(conf.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name tag pattern %q: %v", conf.Pattern, err)
+		}
+		named := false
+		for _, name := range pattern.SubexpNames() {
+			named = named || name != ""
+		}
+		if !named {
+			return nil, fmt.Errorf("name tag pattern %q has no named capture group to extract", conf.Pattern)
+		}
+		rules = append(rules, NameTagRule{Pattern: pattern, Rewrite: conf.Rewrite})
+	}
+	return rules, nil
+}
+
+// extractNameTags applies NameTagRules to a metric's name, in order, each
+// to the name the previous ones left, adding the tags they extract to
+// tags. It returns the rewritten name.
+func (gm *GenericMetricSink) extractNameTags(name string, tags map[string]string) string {
+	for _, rule := range gm.NameTagRules {
+		match := rule.Pattern.FindStringSubmatchIndex(name)
+		if match == nil {
+			continue
+		}
+		for i, key := range rule.Pattern.SubexpNames() {
+			if key == "" || match[2*i] < 0 {
+				continue
+			}
+			if _, ok := tags[key]; !ok {
+				tags[key] = name[match[2*i]:match[2*i+1]]
+			}
+		}
+		if rule.Rewrite != "" {
+			rewritten := rule.Pattern.ExpandString(nil, rule.Rewrite, name, match)
+			name = name[:match[0]] + string(rewritten) + name[match[1]:]
+		}
+	}
+	return name
+}
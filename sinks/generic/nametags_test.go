@@ -0,0 +1,59 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestConvertInterToGenericNameTagRules(t *testing.T) {
+	rules, err := compileNameTagRules([]NameTagRuleConfig{
+		{Pattern: `^(.+)\.endpoint_(?P<endpoint>[a-z]+)$`, Rewrite: "$1"},
+		{Pattern: `^(?P<service>[a-z]+)\.`},
+	})
+	require.NoError(t, err)
+	gmSink := defaultTestSink()
+	gmSink.NameTagRules = rules
+	gmSink.NamePrefix = "team."
+	gmSink.ValueMultipliers = map[string]float64{"api.latency.endpoint_*": 1000}
+
+	metrics := []samplers.InterMetric{
+		{Name: "api.latency.endpoint_checkout", Value: 1, Type: samplers.GaugeMetric},
+		{Name: "api.latency.endpoint_cart", Value: 2, Tags: []string{"endpoint:override"}, Type: samplers.GaugeMetric},
+		{Name: "Other", Value: 3, Type: samplers.GaugeMetric},
+	}
+	genericMetrics := gmSink.convertInterToGeneric(metrics)
+	require.Len(t, genericMetrics.Metrics, 3)
+
+	checkout := genericMetrics.Metrics[0]
+	assert.Equal(t, "team.api.latency", checkout.Metric, "the name should be rewritten before the prefix is added")
+	assert.Equal(t, map[string]string{"endpoint": "checkout", "service": "api"}, checkout.Tags, "the rules should apply in order, each to the name the previous left")
+	assert.Equal(t, float64(1000), checkout.Value, "value multipliers should match the original name")
+
+	cart := genericMetrics.Metrics[1]
+	assert.Equal(t, "team.api.latency", cart.Metric)
+	assert.Equal(t, "override", cart.Tags["endpoint"], "a metric's own tags shouldn't be overridden")
+
+	assert.Equal(t, "team.Other", genericMetrics.Metrics[2].Metric)
+	assert.Empty(t, genericMetrics.Metrics[2].Tags)
+}
+
+func TestNewGenericMetricSinkFromConfigNameTagRules(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint:     "http://localhost:8080/metrics",
+		GenericBatchSize:    100,
+		GenericNameTagRules: []NameTagRuleConfig{{Pattern: `\.host_(?P<host>\w+)$`}},
+	}
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.Len(t, sink.NameTagRules, 1)
+
+	for _, pattern := range []string{`(`, `\.host_(\w+)$`} {
+		conf.GenericNameTagRules = []NameTagRuleConfig{{Pattern: pattern}}
+		_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+		assert.Error(t, err, "pattern %q", pattern)
+	}
+}
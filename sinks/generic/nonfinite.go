@@ -0,0 +1,65 @@
+package generic
+
+import (
+	"math"
+
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyNonFiniteMetrics is emitted as a counter of the metrics whose
+// value is NaN or infinite, tagged with `sink:sink.Name()` and `policy`
+// (the NonFinitePolicy applied).
+const MetricKeyNonFiniteMetrics = "sink.generic.non_finite_metrics_total"
+
+// The policies for metrics whose value is NaN or infinite, which JSON
+// can't encode: sent as they are, they'd fail their whole batch.
+const (
+	// NonFiniteDrop drops them.
+	NonFiniteDrop = "drop"
+	// NonFiniteClamp replaces infinite values with the largest finite
+	// value of the same sign. NaN can't be clamped, so it is dropped.
+	NonFiniteClamp = "clamp"
+	// NonFiniteSentinel replaces the values with NonFiniteSentinelValue.
+	NonFiniteSentinel = "sentinel"
+)
+
+// finite reports whether v is neither NaN nor infinite.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// replaceNonFinite makes a metric's non-finite value finite, as
+// NonFinitePolicy says, reporting whether it could.
+func (gm *GenericMetricSink) replaceNonFinite(metric *GenericMetric) bool {
+	switch {
+	case gm.NonFinitePolicy == NonFiniteSentinel:
+		metric.Value = gm.NonFiniteSentinelValue
+		return true
+	case gm.NonFinitePolicy == NonFiniteClamp && math.IsInf(metric.Value, 1):
+		metric.Value = math.MaxFloat64
+		return true
+	case gm.NonFinitePolicy == NonFiniteClamp && math.IsInf(metric.Value, -1):
+		metric.Value = -math.MaxFloat64
+		return true
+	default:
+		return false
+	}
+}
+
+// reportNonFinite records the number of metrics whose value wasn't
+// finite.
+func (gm *GenericMetricSink) reportNonFinite(nonFinite int) {
+	if nonFinite == 0 {
+		return
+	}
+	policy := gm.NonFinitePolicy
+	if policy == "" {
+		policy = NonFiniteDrop
+	}
+	metrics.ReportOne(gm.traceClient, ssf.Count(MetricKeyNonFiniteMetrics, float32(nonFinite), map[string]string{
+		"sink":   gm.Name(),
+		"policy": policy,
+	}))
+	gm.log.WithField("metrics", nonFinite).Warn("Found generic metrics whose value is NaN or infinite")
+}
@@ -0,0 +1,82 @@
+package generic
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func nonFiniteMetrics() []samplers.InterMetric {
+	metrics := getInterMetricsMany(4)
+	metrics[0].Value = math.NaN()
+	metrics[1].Value = math.Inf(1)
+	metrics[2].Value = math.Inf(-1)
+	return metrics
+}
+
+func TestConvertInterToGenericNonFiniteDrop(t *testing.T) {
+	gmSink := defaultTestSink()
+	ch := startTraceClient(t, gmSink)
+
+	metrics := nonFiniteMetrics()
+	genericMetrics := gmSink.convertInterToGeneric(metrics)
+	require.Len(t, genericMetrics.Metrics, 1, "metrics whose value isn't finite should be dropped by default")
+	assert.Equal(t, metrics[3].Name, genericMetrics.Metrics[0].Metric)
+
+	samples := reportedSamples(ch)[MetricKeyNonFiniteMetrics]
+	if assert.Len(t, samples, 1) {
+		assert.Equal(t, float32(3), samples[0].Value)
+		assert.Equal(t, NonFiniteDrop, samples[0].Tags["policy"])
+	}
+}
+
+func TestConvertInterToGenericNonFiniteClamp(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.NonFinitePolicy = NonFiniteClamp
+
+	genericMetrics := gmSink.convertInterToGeneric(nonFiniteMetrics())
+	require.Len(t, genericMetrics.Metrics, 3, "NaN can't be clamped")
+	assert.Equal(t, math.MaxFloat64, genericMetrics.Metrics[0].Value)
+	assert.Equal(t, -math.MaxFloat64, genericMetrics.Metrics[1].Value)
+}
+
+func TestConvertInterToGenericNonFiniteSentinel(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.NonFinitePolicy = NonFiniteSentinel
+	gmSink.NonFiniteSentinelValue = -1
+
+	genericMetrics := gmSink.convertInterToGeneric(nonFiniteMetrics())
+	require.Len(t, genericMetrics.Metrics, 4)
+	for _, metric := range genericMetrics.Metrics[:3] {
+		assert.Equal(t, float64(-1), metric.Value)
+	}
+}
+
+func TestConvertInterToGenericNonFiniteMultiplied(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.ValueMultipliers = map[string]float64{"": math.MaxFloat64}
+
+	metrics := basicInterMetrics()
+	genericMetrics := gmSink.convertInterToGeneric(metrics)
+	assert.Empty(t, genericMetrics.Metrics, "metrics multiplied out of range should be dropped")
+}
+
+func TestFlushNaN(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+
+	metrics := basicInterMetrics()
+	metrics[0].Value = math.NaN()
+	require.NoError(t, gmSink.Flush(context.TODO(), metrics), "a NaN shouldn't fail its batch")
+	require.Len(t, transport.Contents, 1)
+
+	var gotMetrics GenericMetrics
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents[0]), &gotMetrics))
+	if assert.Len(t, gotMetrics.Metrics, 1) {
+		assert.Equal(t, metrics[1].Name, gotMetrics.Metrics[0].Metric)
+	}
+}
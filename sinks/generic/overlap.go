@@ -0,0 +1,65 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyOverlappingFlushesSkipped is emitted as a counter of the
+// flushes that are skipped under OverlapSkip, tagged with
+// `sink:sink.Name()`.
+const MetricKeyOverlappingFlushesSkipped = "sink.generic.overlapping_flushes_skipped_total"
+
+// The policies for a flush that starts while an earlier one is still in
+// progress, e.g. because sending the earlier one took longer than the
+// flush interval.
+const (
+	// OverlapAllow runs it alongside the earlier one.
+	OverlapAllow = "allow"
+	// OverlapWait holds it back until the earlier one is done, so that
+	// flushes never pile up on the endpoint.
+	OverlapWait = "wait"
+	// OverlapSkip drops it, counting it in
+	// MetricKeyOverlappingFlushesSkipped.
+	OverlapSkip = "skip"
+)
+
+// ErrFlushInProgress is returned by Flush when it's skipped under
+// OverlapSkip.
+var ErrFlushInProgress = fmt.Errorf("a generic sink flush is already in progress")
+
+// acquireOverlap applies OverlapPolicy to a flush of metrics that's
+// starting. It blocks until the flush can go ahead, or ctx is done; the
+// flush counts as pending while it waits. The returned function must be
+// called once the flush is done.
+func (gm *GenericMetricSink) acquireOverlap(ctx context.Context, flushed []samplers.InterMetric) (func(), error) {
+	if gm.OverlapPolicy != OverlapWait && gm.OverlapPolicy != OverlapSkip {
+		return func() {}, nil
+	}
+	gm.overlapOnce.Do(func() {
+		gm.overlap = make(chan struct{}, 1)
+	})
+	release := func() { <-gm.overlap }
+	if gm.OverlapPolicy == OverlapSkip {
+		select {
+		case gm.overlap <- struct{}{}:
+			return release, nil
+		default:
+			metrics.ReportOne(gm.traceClient, ssf.Count(MetricKeyOverlappingFlushesSkipped, 1, map[string]string{"sink": gm.Name()}))
+			gm.log.Warn("Skipping generic flush, the previous one is still in progress")
+			return nil, ErrFlushInProgress
+		}
+	}
+	var size pendingSize
+	if gm.MaxPendingBytes > 0 {
+		size = pendingSize{bytes: pendingBytes(flushed), metrics: len(flushed)}
+	}
+	if err := gm.waitPending(ctx, gm.overlap, size); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
@@ -0,0 +1,102 @@
+package generic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitInFlight waits for a request to be in flight on transport.
+func waitInFlight(t *testing.T, transport *GenericRoundTripper) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		transport.mtx.Lock()
+		inFlight := transport.inFlight
+		transport.mtx.Unlock()
+		if inFlight > 0 {
+			return
+		}
+	}
+	t.Fatal("no request was sent")
+}
+
+// flushOverlapping starts a flush, and another once the first one's
+// request is in flight, returning what both returned.
+func flushOverlapping(t *testing.T, gmSink *GenericMetricSink, transport *GenericRoundTripper) (first, second error) {
+	firstErr := make(chan error, 1)
+	go func() {
+		firstErr <- gmSink.Flush(context.TODO(), basicInterMetrics())
+	}()
+	waitInFlight(t, transport)
+	second = gmSink.Flush(context.TODO(), basicInterMetrics())
+	return <-firstErr, second
+}
+
+func TestFlushOverlapAllow(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	transport.Delay = 50 * time.Millisecond
+
+	first, second := flushOverlapping(t, gmSink, transport)
+	assert.NoError(t, first)
+	assert.NoError(t, second)
+	assert.Equal(t, 2, transport.MaxInFlight, "flushes should overlap by default")
+}
+
+func TestFlushOverlapWait(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.OverlapPolicy = OverlapWait
+	transport.Delay = 50 * time.Millisecond
+
+	first, second := flushOverlapping(t, gmSink, transport)
+	assert.NoError(t, first)
+	assert.NoError(t, second)
+	assert.Equal(t, 2, transport.Called, "both flushes should be sent")
+	assert.Equal(t, 1, transport.MaxInFlight, "the second flush should wait for the first")
+}
+
+func TestFlushOverlapWaitCancelled(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.OverlapPolicy = OverlapWait
+	transport.Delay = time.Second
+
+	go gmSink.Flush(context.TODO(), basicInterMetrics())
+	waitInFlight(t, transport)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, gmSink.Flush(ctx, basicInterMetrics()))
+}
+
+func TestFlushOverlapSkip(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.OverlapPolicy = OverlapSkip
+	transport.Delay = 50 * time.Millisecond
+	ch := startTraceClient(t, gmSink)
+
+	first, second := flushOverlapping(t, gmSink, transport)
+	assert.NoError(t, first)
+	assert.Equal(t, ErrFlushInProgress, second)
+	assert.Equal(t, 1, transport.Called, "the second flush should be skipped")
+	samples := reportedSamples(ch)
+	assert.Equal(t, float32(1), sampleTotal(samples[MetricKeyOverlappingFlushesSkipped]))
+
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()), "flushes after the first one is done should go ahead")
+	assert.Equal(t, 2, transport.Called)
+}
+
+func TestNewGenericMetricSinkFromConfigOverlapPolicy(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint:      "http://localhost:8080/metrics",
+		GenericBatchSize:     100,
+		GenericOverlapPolicy: OverlapSkip,
+	}
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.Equal(t, OverlapSkip, sink.OverlapPolicy)
+
+	conf.GenericOverlapPolicy = "queue"
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err)
+}
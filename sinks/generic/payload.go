@@ -0,0 +1,83 @@
+package generic
+
+import (
+	"context"
+	"io"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyOversizedMetricsDropped is emitted as a counter of the metrics
+// that are dropped because they encode to more than MaxPayloadBytes on
+// their own, tagged with `sink:sink.Name()`.
+const MetricKeyOversizedMetricsDropped = "sink.generic.oversized_metrics_dropped_total"
+
+// oversized reports whether a batch encoded to size bytes is over
+// MaxPayloadBytes.
+func (gm *GenericMetricSink) oversized(size int) bool {
+	return gm.MaxPayloadBytes > 0 && size > gm.MaxPayloadBytes
+}
+
+// encodedSize returns the number of bytes genMetrics encodes to, without
+// holding onto them, for batches that are streamed.
+func (gm *GenericMetricSink) encodedSize(genMetrics GenericMetrics) (int, error) {
+	var cw countingWriter
+	err := gm.compress(&cw, func(w io.Writer) error {
+		return gm.serializeStreaming(w, genMetrics)
+	})
+	return int(cw), err
+}
+
+// countingWriter counts the bytes written to it, and discards them.
+type countingWriter int
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	*cw += countingWriter(len(p))
+	return len(p), nil
+}
+
+// splitBatch sends a batch that encoded to more than MaxPayloadBytes as
+// two batches of half its metrics, which are split further if they're
+// still too large. A single metric that is too large is dropped, since
+// the endpoint would reject it anyway. The first error of the halves is
+// returned, along with the indexes of the metrics of the halves that
+// failed, like sendBatch.
+func (gm *GenericMetricSink) splitBatch(ctx context.Context, endpoint string, genMetrics GenericMetrics, size int) ([]int, error) {
+	if len(genMetrics.Metrics) == 1 {
+		metrics.ReportOne(gm.traceClient, ssf.Count(MetricKeyOversizedMetricsDropped, 1, map[string]string{"sink": gm.Name()}))
+		summaryOf(ctx).drop(SummaryFilterOversized, 1, 0)
+		gm.log.WithFields(logrus.Fields{
+			"metric":   genMetrics.Metrics[0].Metric,
+			"bytes":    size,
+			"max":      gm.MaxPayloadBytes,
+			"endpoint": endpoint,
+		}).Error("Generic metric is too large to send on its own, dropping it")
+		return nil, nil
+	}
+	gm.log.WithFields(logrus.Fields{
+		"metrics": len(genMetrics.Metrics),
+		"bytes":   size,
+		"max":     gm.MaxPayloadBytes,
+	}).Debug("Generic metrics batch is too large, splitting it")
+
+	half := len(genMetrics.Metrics) / 2
+	first, second := genMetrics, genMetrics
+	first.Metrics = genMetrics.Metrics[:half]
+	second.Metrics = genMetrics.Metrics[half:]
+	if len(genMetrics.indexes) == len(genMetrics.Metrics) {
+		first.indexes = genMetrics.indexes[:half]
+		second.indexes = genMetrics.indexes[half:]
+	}
+	total := len(genMetrics.Metrics)
+	failed, firstErr := gm.sendBatch(splitPendingSize(ctx, half, total), endpoint, first)
+	secondFailed, err := gm.sendBatch(splitPendingSize(ctx, total-half, total), endpoint, second)
+	if err != nil {
+		failed = append(failed[:len(failed):len(failed)], secondFailed...)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return failed, firstErr
+}
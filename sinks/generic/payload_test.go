@@ -0,0 +1,57 @@
+package generic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodedBatchSize returns how many bytes a batch of n basic metrics
+// encodes to.
+func encodedBatchSize(t *testing.T, gmSink *GenericMetricSink, n int) int {
+	var buf bytes.Buffer
+	require.NoError(t, gmSink.encode(&buf, gmSink.convertInterToGeneric(getInterMetricsMany(n))))
+	return buf.Len()
+}
+
+func TestFlushMaxPayloadBytes(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+		gmSink.StreamBatches = stream
+		gmSink.MaxPayloadBytes = encodedBatchSize(t, gmSink, 2)
+
+		require.NoError(t, gmSink.Flush(context.TODO(), getInterMetricsMany(8)))
+		assert.Equal(t, 4, transport.Called, "the batch should be split until every part fits (streamed: %v)", stream)
+		flushed := 0
+		for _, content := range transport.Contents {
+			assert.True(t, len(content) <= gmSink.MaxPayloadBytes, "a %d byte payload is over the cap", len(content))
+			var batch GenericMetrics
+			require.NoError(t, json.Unmarshal([]byte(content), &batch))
+			flushed += len(batch.Metrics)
+		}
+		assert.Equal(t, 8, flushed, "no metric should be lost splitting batches (streamed: %v)", stream)
+	}
+}
+
+func TestFlushMaxPayloadBytesUnsplit(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.MaxPayloadBytes = encodedBatchSize(t, gmSink, 8)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), getInterMetricsMany(8)))
+	assert.Equal(t, 1, transport.Called, "batches under the cap shouldn't be split")
+}
+
+func TestFlushOversizedMetric(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.MaxPayloadBytes = 10
+	ch := startTraceClient(t, gmSink)
+
+	assert.NoError(t, gmSink.Flush(context.TODO(), getInterMetricsMany(3)))
+	assert.Equal(t, 0, transport.Called, "metrics too large on their own should be dropped")
+	samples := reportedSamples(ch)
+	assert.Equal(t, float32(3), sampleTotal(samples[MetricKeyOversizedMetricsDropped]))
+}
@@ -0,0 +1,175 @@
+package generic
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyPendingBufferFull is emitted as a counter every time a batch
+// starting to wait goes over MaxPendingBytes, and
+// MetricKeyPendingMetricsDropped as a counter of the pending metrics
+// dropped to make room, both tagged with `sink:sink.Name()`.
+const (
+	MetricKeyPendingBufferFull     = "sink.generic.pending_buffer_full_total"
+	MetricKeyPendingMetricsDropped = "sink.generic.pending_metrics_dropped_total"
+)
+
+// interMetricOverhead is roughly the number of bytes a metric takes up on
+// top of its name, tags and sketch.
+const interMetricOverhead = 128
+
+// errPendingDropped is returned by waitPending when the batch waiting was
+// dropped to make room for newer ones.
+var errPendingDropped = errors.New("dropped while waiting to be sent")
+
+// pendingSize is the size of a batch, or of a whole flush, that might
+// have to wait behind other flushes.
+type pendingSize struct {
+	bytes   int
+	metrics int
+}
+
+// pendingBatch is a batch that's waiting behind other flushes, if
+// MaxPendingBytes is set.
+type pendingBatch struct {
+	pendingSize
+	// dropped is closed once the batch was dropped to make room for
+	// newer ones. It's guarded by the sink's pendingMtx.
+	dropped chan struct{}
+}
+
+// pendingSizeKey is the key of the size of the batch being sent in its
+// context.
+type pendingSizeKey struct{}
+
+// withPendingSize returns ctx with the size of the batch sent with it,
+// if MaxPendingBytes is set.
+func (gm *GenericMetricSink) withPendingSize(ctx context.Context, batch []samplers.InterMetric) context.Context {
+	if gm.MaxPendingBytes <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, pendingSizeKey{}, pendingSize{bytes: pendingBytes(batch), metrics: len(batch)})
+}
+
+// pendingSizeOf returns the size of the batch sent with ctx, which is
+// zero if there is none.
+func pendingSizeOf(ctx context.Context) pendingSize {
+	size, _ := ctx.Value(pendingSizeKey{}).(pendingSize)
+	return size
+}
+
+// splitPendingSize returns ctx with the size of n of the batch's total
+// metrics, once the batch was split up.
+func splitPendingSize(ctx context.Context, n, total int) context.Context {
+	size := pendingSizeOf(ctx)
+	if size.metrics == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, pendingSizeKey{}, pendingSize{bytes: size.bytes * n / total, metrics: n})
+}
+
+// pendingBytes estimates the memory a batch of metrics takes up.
+func pendingBytes(batch []samplers.InterMetric) int {
+	n := 0
+	for _, metric := range batch {
+		n += interMetricOverhead + len(metric.Name) + len(metric.Sketch)
+		for _, tag := range metric.Tags {
+			n += len(tag)
+		}
+	}
+	return n
+}
+
+// waitPending takes a token from sem, blocking until there's room for it
+// or ctx is done. A batch (or flush) that has to wait is held up by other
+// flushes, so it counts as pending while it does if MaxPendingBytes is
+// set, and gives up with errPendingDropped if it's dropped to make room
+// for newer ones.
+func (gm *GenericMetricSink) waitPending(ctx context.Context, sem chan struct{}, size pendingSize) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	default:
+	}
+	var dropped chan struct{}
+	if gm.MaxPendingBytes > 0 && size.metrics > 0 {
+		p := &pendingBatch{pendingSize: size, dropped: make(chan struct{})}
+		gm.queuePending(p)
+		defer gm.takePending(p)
+		dropped = p.dropped
+	}
+	select {
+	case sem <- struct{}{}:
+		select {
+		case <-dropped:
+			// it was counted as dropped already
+			<-sem
+			return errPendingDropped
+		default:
+			return nil
+		}
+	case <-dropped:
+		return errPendingDropped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queuePending adds a batch to the ones waiting behind other flushes. If
+// they take up more than MaxPendingBytes together, the oldest are dropped
+// until they don't, whichever flush they're from.
+func (gm *GenericMetricSink) queuePending(p *pendingBatch) {
+	droppedBatches, droppedMetrics := gm.addPending(p)
+	if droppedBatches == 0 {
+		return
+	}
+	tags := map[string]string{"sink": gm.Name()}
+	samples := &ssf.Samples{}
+	samples.Add(
+		ssf.Count(MetricKeyPendingBufferFull, 1, tags),
+		ssf.Count(MetricKeyPendingMetricsDropped, float32(droppedMetrics), tags),
+	)
+	metrics.Report(gm.traceClient, samples)
+	gm.log.WithFields(logrus.Fields{
+		"batches": droppedBatches,
+		"metrics": droppedMetrics,
+		"max":     gm.MaxPendingBytes,
+	}).Warn("Too many generic metrics are waiting to be sent, dropping the oldest")
+}
+
+// addPending does the bookkeeping of queuePending, returning the number
+// of batches and metrics it dropped.
+func (gm *GenericMetricSink) addPending(p *pendingBatch) (droppedBatches, droppedMetrics int) {
+	gm.pendingMtx.Lock()
+	defer gm.pendingMtx.Unlock()
+	gm.pending = append(gm.pending, p)
+	gm.pendingTotal += p.bytes
+	for gm.pendingTotal > gm.MaxPendingBytes && len(gm.pending) > 0 {
+		oldest := gm.pending[0]
+		gm.pending = gm.pending[1:]
+		gm.pendingTotal -= oldest.bytes
+		close(oldest.dropped)
+		droppedBatches++
+		droppedMetrics += oldest.metrics
+	}
+	return droppedBatches, droppedMetrics
+}
+
+// takePending takes a batch off the ones waiting, once it's done
+// waiting, unless it was dropped in the meantime.
+func (gm *GenericMetricSink) takePending(p *pendingBatch) {
+	gm.pendingMtx.Lock()
+	defer gm.pendingMtx.Unlock()
+	for i, queued := range gm.pending {
+		if queued == p {
+			gm.pending = append(gm.pending[:i], gm.pending[i+1:]...)
+			gm.pendingTotal -= p.bytes
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package generic
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func pendingTestMetrics(prefix string) []samplers.InterMetric {
+	var metrics []samplers.InterMetric
+	for _, name := range []string{"a", "b", "c"} {
+		metrics = append(metrics, samplers.InterMetric{Name: prefix + name, Value: 1, Type: samplers.GaugeMetric})
+	}
+	return metrics
+}
+
+// waitPendingBatches waits for n batches to be waiting behind other
+// flushes.
+func waitPendingBatches(t *testing.T, gmSink *GenericMetricSink, n int) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		gmSink.pendingMtx.Lock()
+		pending := len(gmSink.pending)
+		gmSink.pendingMtx.Unlock()
+		if pending == n {
+			return
+		}
+	}
+	t.Fatalf("%d batches never started waiting", n)
+}
+
+// sentMetrics returns the names of the metrics a round tripper received.
+func sentMetrics(t *testing.T, transport *GenericRoundTripper) []string {
+	var sent []string
+	for _, contents := range transport.Contents {
+		var batch GenericMetrics
+		require.NoError(t, json.Unmarshal([]byte(contents), &batch))
+		for _, metric := range batch.Metrics {
+			sent = append(sent, metric.Metric)
+		}
+	}
+	return sent
+}
+
+// flushPendingBehind starts a flush of older, and once it's in flight,
+// one of middle and one of newer that both wait behind it.
+func flushPendingBehind(t *testing.T, gmSink *GenericMetricSink, transport *GenericRoundTripper, older, middle, newer []samplers.InterMetric) {
+	olderErr, middleErr := make(chan error, 1), make(chan error, 1)
+	go func() {
+		olderErr <- gmSink.Flush(context.TODO(), older)
+	}()
+	waitInFlight(t, transport)
+	go func() {
+		middleErr <- gmSink.Flush(context.TODO(), middle)
+	}()
+	waitPendingBatches(t, gmSink, 1)
+	require.NoError(t, gmSink.Flush(context.TODO(), newer))
+	require.NoError(t, <-olderErr)
+	require.NoError(t, <-middleErr, "a batch dropped while it waits isn't an error")
+}
+
+func TestFlushMaxPendingBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*GenericMetricSink)
+	}{
+		{"in flight", func(gmSink *GenericMetricSink) { gmSink.MaxInFlight = 1 }},
+		{"overlap", func(gmSink *GenericMetricSink) { gmSink.OverlapPolicy = OverlapWait }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+			transport.Delay = 50 * time.Millisecond
+			test.setup(gmSink)
+			older, middle, newer := pendingTestMetrics("old."), pendingTestMetrics("mid."), pendingTestMetrics("new.")
+			// room for a single flush waiting
+			gmSink.MaxPendingBytes = pendingBytes(newer)
+			ch := startTraceClient(t, gmSink)
+
+			flushPendingBehind(t, gmSink, transport, older, middle, newer)
+			assert.ElementsMatch(t, []string{"old.a", "old.b", "old.c", "new.a", "new.b", "new.c"}, sentMetrics(t, transport),
+				"the older of the flushes waiting should be dropped")
+
+			samples := reportedSamples(ch)
+			assert.Equal(t, float32(1), sampleTotal(samples[MetricKeyPendingBufferFull]))
+			assert.Equal(t, float32(3), sampleTotal(samples[MetricKeyPendingMetricsDropped]))
+
+			gmSink.pendingMtx.Lock()
+			defer gmSink.pendingMtx.Unlock()
+			assert.Empty(t, gmSink.pending, "no batch should be left waiting")
+			assert.Zero(t, gmSink.pendingTotal)
+		})
+	}
+}
+
+func TestFlushMaxPendingBytesUncongested(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 1)
+	gmSink.MaxInFlight = 1
+	metrics := pendingTestMetrics("m.")
+	gmSink.MaxPendingBytes = pendingBytes(metrics[:1])
+	ch := startTraceClient(t, gmSink)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), metrics))
+	assert.ElementsMatch(t, []string{"m.a", "m.b", "m.c"}, sentMetrics(t, transport),
+		"a flush over the limit should be sent in full if nothing holds it up")
+	samples := reportedSamples(ch)
+	assert.Zero(t, sampleTotal(samples[MetricKeyPendingMetricsDropped]))
+}
+
+func TestFlushMaxPendingBytesUnset(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	transport.Delay = 20 * time.Millisecond
+	gmSink.MaxInFlight = 1
+
+	errs := make(chan error, 3)
+	for _, prefix := range []string{"old.", "mid.", "new."} {
+		go func(prefix string) {
+			errs <- gmSink.Flush(context.TODO(), pendingTestMetrics(prefix))
+		}(prefix)
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, <-errs)
+	}
+	assert.Len(t, sentMetrics(t, transport), 9, "no batch should be dropped unless MaxPendingBytes is set")
+	assert.Empty(t, gmSink.pending, "batches shouldn't be tracked unless MaxPendingBytes is set")
+}
@@ -0,0 +1,88 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	vhttp "github.com/stripe/veneur/http"
+)
+
+// DefaultPingTimeout is how long Ping waits for the endpoint when the
+// sink is started with PingOnStart, unless FlushTimeout is set.
+const DefaultPingTimeout = 10 * time.Second
+
+// Ping checks that the endpoint can be reached, with the same headers
+// and credentials as batches. If HealthPath is set, it is resolved
+// against Endpoint and requested with a GET, which must succeed with a
+// 2xx status. Otherwise Endpoint itself is requested with a HEAD, and
+// only a 404 (suggesting a typo in the endpoint) or a server error
+// fails: endpoints that only accept POST may well reject the HEAD.
+// Errors are *FlushError, of category ErrTransport or ErrBadStatus.
+func (gm *GenericMetricSink) Ping(ctx context.Context) error {
+	method, target := http.MethodHead, gm.Endpoint
+	if gm.HealthPath != "" {
+		endpoint, err := url.Parse(gm.Endpoint)
+		if err != nil {
+			return &FlushError{Category: ErrTransport, Err: err}
+		}
+		health, err := url.Parse(gm.HealthPath)
+		if err != nil {
+			return &FlushError{Category: ErrTransport, Err: err}
+		}
+		method, target = http.MethodGet, endpoint.ResolveReference(health).String()
+	}
+
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return &FlushError{Category: ErrTransport, Err: err}
+	}
+	for k, v := range gm.headers() {
+		if k == "Content-Type" || k == "Content-Encoding" {
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	resp, err := gm.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return &FlushError{Category: ErrTransport, Err: err}
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	failed := resp.StatusCode == http.StatusNotFound || resp.StatusCode >= 500
+	if gm.HealthPath != "" {
+		failed = resp.StatusCode < 200 || resp.StatusCode >= 300
+	}
+	if failed {
+		return &FlushError{
+			Category: ErrBadStatus,
+			Err:      fmt.Errorf("%s %s: %v", method, target, &vhttp.StatusError{StatusCode: resp.StatusCode}),
+		}
+	}
+	return nil
+}
+
+// pingOnStart pings the endpoint if PingOnStart is set, so that a
+// misconfigured endpoint stops veneur from starting rather than failing
+// every flush.
+func (gm *GenericMetricSink) pingOnStart() error {
+	if !gm.PingOnStart || gm.DryRun {
+		return nil
+	}
+	timeout := gm.FlushTimeout
+	if timeout <= 0 {
+		timeout = DefaultPingTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := gm.Ping(ctx); err != nil {
+		return fmt.Errorf("pinging the generic sink's endpoint failed: %v", err)
+	}
+	gm.log.WithField("endpoint", gm.Endpoint).Info("Reached the generic sink's endpoint")
+	return nil
+}
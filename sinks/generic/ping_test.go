@@ -0,0 +1,88 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pingServer answers requests with status, recording their method, path
+// and Authorization header.
+func pingServer(status int, requests chan<- *http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		w.WriteHeader(status)
+	}))
+}
+
+func TestPing(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	srv := pingServer(http.StatusMethodNotAllowed, requests)
+	defer srv.Close()
+
+	gmSink := getTestSink(http.DefaultClient, []string{}, srv.URL+"/v1/metrics", 10, defaultSource, defaultEnvironment, defaultNamespace)
+	gmSink.bearerToken = "sekrit"
+	require.NoError(t, gmSink.Ping(context.TODO()), "an endpoint rejecting HEAD is still reachable")
+	req := <-requests
+	assert.Equal(t, http.MethodHead, req.Method)
+	assert.Equal(t, "/v1/metrics", req.URL.Path)
+	assert.Equal(t, "Bearer sekrit", req.Header.Get("Authorization"))
+}
+
+func TestPingHealthPath(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	srv := pingServer(http.StatusOK, requests)
+	defer srv.Close()
+
+	gmSink := getTestSink(http.DefaultClient, []string{}, srv.URL+"/v1/metrics", 10, defaultSource, defaultEnvironment, defaultNamespace)
+	gmSink.HealthPath = "/healthz"
+	require.NoError(t, gmSink.Ping(context.TODO()))
+	req := <-requests
+	assert.Equal(t, http.MethodGet, req.Method)
+	assert.Equal(t, "/healthz", req.URL.Path)
+}
+
+func TestPingFails(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		status     int
+		healthPath string
+	}{
+		{"not found", http.StatusNotFound, ""},
+		{"server error", http.StatusBadGateway, ""},
+		{"unhealthy", http.StatusMethodNotAllowed, "/healthz"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := pingServer(tc.status, make(chan *http.Request, 1))
+			defer srv.Close()
+
+			gmSink := getTestSink(http.DefaultClient, []string{}, srv.URL, 10, defaultSource, defaultEnvironment, defaultNamespace)
+			gmSink.HealthPath = tc.healthPath
+			err := gmSink.Ping(context.TODO())
+			assert.True(t, errors.Is(err, ErrBadStatus), "got %v", err)
+		})
+	}
+
+	srv := pingServer(http.StatusOK, make(chan *http.Request, 1))
+	srv.Close()
+	gmSink := getTestSink(http.DefaultClient, []string{}, srv.URL, 10, defaultSource, defaultEnvironment, defaultNamespace)
+	err := gmSink.Ping(context.TODO())
+	assert.True(t, errors.Is(err, ErrTransport), "got %v", err)
+}
+
+func TestPingOnStart(t *testing.T) {
+	srv := pingServer(http.StatusNotFound, make(chan *http.Request, 2))
+	defer srv.Close()
+
+	gmSink := getTestSink(http.DefaultClient, []string{}, srv.URL, 10, defaultSource, defaultEnvironment, defaultNamespace)
+	assert.NoError(t, gmSink.Start(nil), "the endpoint shouldn't be pinged unless PingOnStart is set")
+	gmSink.PingOnStart = true
+	assert.Error(t, gmSink.Start(nil))
+	gmSink.DryRun = true
+	assert.NoError(t, gmSink.Start(nil), "dry runs never reach the endpoint, so it isn't pinged")
+}
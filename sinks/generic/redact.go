@@ -0,0 +1,58 @@
+package generic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// The ways RedactedTags redacts the values of tags.
+const (
+	// RedactHash replaces values with the hex-encoded SHA-256 of
+	// RedactionSalt followed by the value, so that they can still be
+	// told apart and grouped by.
+	RedactHash = "hash"
+	// RedactPlaceholder replaces values with RedactionPlaceholder.
+	RedactPlaceholder = "placeholder"
+)
+
+// DefaultRedactionPlaceholder replaces the values of tags redacted with
+// RedactPlaceholder, unless RedactionPlaceholder says otherwise.
+const DefaultRedactionPlaceholder = "REDACTED"
+
+// redactTags redacts the values of the tags listed in RedactedTags, in
+// place.
+func (gm *GenericMetricSink) redactTags(tags map[string]string) {
+	for k, redaction := range gm.RedactedTags {
+		v, ok := tags[k]
+		if !ok {
+			continue
+		}
+		switch redaction {
+		case RedactHash:
+			sum := sha256.Sum256([]byte(gm.RedactionSalt + v))
+			tags[k] = hex.EncodeToString(sum[:])
+		default:
+			tags[k] = gm.redactionPlaceholder()
+		}
+	}
+}
+
+func (gm *GenericMetricSink) redactionPlaceholder() string {
+	if gm.RedactionPlaceholder == "" {
+		return DefaultRedactionPlaceholder
+	}
+	return gm.RedactionPlaceholder
+}
+
+// checkRedactedTags checks that every tag is redacted in a known way.
+func checkRedactedTags(redactedTags map[string]string) error {
+	for k, redaction := range redactedTags {
+		switch redaction {
+		case RedactHash, RedactPlaceholder:
+		default:
+			return fmt.Errorf("unknown redaction %q for tag %q", redaction, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package generic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/veneur/protocol/dogstatsd"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+func redactedMetrics() []samplers.InterMetric {
+	return []samplers.InterMetric{{
+		Name:  "logins",
+		Value: 1,
+		Tags:  []string{"user:jane@example.com", "email:jane@example.com", "region:us-west"},
+		Type:  samplers.CounterMetric,
+	}}
+}
+
+func TestRedactHash(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.RedactedTags = map[string]string{"user": RedactHash}
+	gmSink.RedactionSalt = "pepper"
+
+	sum := sha256.Sum256([]byte("pepperjane@example.com"))
+	tags := gmSink.convertInterToGeneric(redactedMetrics()).Metrics[0].Tags
+	assert.Equal(t, hex.EncodeToString(sum[:]), tags["user"])
+	assert.Equal(t, "jane@example.com", tags["email"], "only the listed tags should be redacted")
+	assert.Equal(t, "us-west", tags["region"])
+	assert.Equal(t, tags, gmSink.convertInterToGeneric(redactedMetrics()).Metrics[0].Tags, "hashes should be stable")
+}
+
+func TestRedactPlaceholder(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.RedactedTags = map[string]string{"user": RedactPlaceholder, "email": RedactPlaceholder}
+
+	tags := gmSink.convertInterToGeneric(redactedMetrics()).Metrics[0].Tags
+	assert.Equal(t, DefaultRedactionPlaceholder, tags["user"])
+	assert.Equal(t, DefaultRedactionPlaceholder, tags["email"])
+	assert.Equal(t, "us-west", tags["region"])
+
+	gmSink.RedactionPlaceholder = "<pii>"
+	tags = gmSink.convertInterToGeneric(redactedMetrics()).Metrics[0].Tags
+	assert.Equal(t, "<pii>", tags["user"])
+}
+
+func TestRedactEvents(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.RedactedTags = map[string]string{"user": RedactPlaceholder}
+
+	event := ssf.SSFSample{
+		Name: "password reset",
+		Tags: map[string]string{
+			dogstatsd.EventIdentifierKey: "",
+			"user":                       "jane@example.com",
+		},
+	}
+	events := gmSink.convertEvents([]ssf.SSFSample{event})
+	if assert.Len(t, events.Events, 1) {
+		assert.Equal(t, DefaultRedactionPlaceholder, events.Events[0].Tags["user"])
+	}
+	assert.Equal(t, "jane@example.com", event.Tags["user"], "the sample's tags shouldn't be modified")
+}
+
+func TestRedactSamples(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.RedactedTags = map[string]string{"user": RedactHash}
+	gmSink.ExcludedTags = []string{"email"}
+
+	sample := ssf.SSFSample{
+		Name: "logins",
+		Tags: map[string]string{
+			"user":   "jane@example.com",
+			"email":  "jane@example.com",
+			"region": "us-west",
+		},
+	}
+	samples := gmSink.convertSamples([]ssf.SSFSample{sample})
+	if assert.Len(t, samples.Samples, 1) {
+		sum := sha256.Sum256([]byte("jane@example.com"))
+		assert.Equal(t, map[string]string{
+			"user":   hex.EncodeToString(sum[:]),
+			"region": "us-west",
+		}, samples.Samples[0].Tags)
+	}
+	assert.Equal(t, "jane@example.com", sample.Tags["user"], "the sample's tags shouldn't be modified")
+	assert.Contains(t, sample.Tags, "email")
+}
+
+func TestNewGenericMetricSinkRedactedTags(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:    10,
+		GenericRedactedTags: map[string]string{"user": "encrypt"},
+	})
+	assert.Error(t, err)
+}
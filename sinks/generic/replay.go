@@ -0,0 +1,76 @@
+package generic
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/stripe/veneur/samplers"
+)
+
+// millisecondsThreshold is the smallest epoch timestamp InterMetric takes
+// to be in milliseconds: in seconds, it would be thousands of years away.
+const millisecondsThreshold = 1e11
+
+// InterMetric converts a metric back into veneur's form, e.g. to replay
+// metrics archived in the generic sink's format. Its timestamp may be in
+// any TimestampFormat. The conversion undoes what the sink does where it
+// can, but metrics keep their prefixes, multipliers and normalized tags,
+// and metrics with fields renamed by FieldNames or types renamed by
+// TypeMapping can't be converted.
+func (m GenericMetric) InterMetric() (samplers.InterMetric, error) {
+	metricType, ok := parseMetricType(m.Type)
+	if !ok {
+		return samplers.InterMetric{}, fmt.Errorf("unknown type %q of metric %q", m.Type, m.Metric)
+	}
+
+	var ts int64
+	switch at := m.At.(type) {
+	case nil:
+	case float64:
+		ts = int64(at)
+		if at >= millisecondsThreshold {
+			ts = int64(at / 1000)
+		}
+	case string:
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return samplers.InterMetric{}, fmt.Errorf("invalid timestamp %q of metric %q: %v", at, m.Metric, err)
+		}
+		ts = t.Unix()
+	default:
+		return samplers.InterMetric{}, fmt.Errorf("invalid timestamp %v of metric %q", m.At, m.Metric)
+	}
+
+	tags := make([]string, 0, len(m.Tags))
+	for k, v := range m.Tags {
+		if v == "" {
+			tags = append(tags, k)
+			continue
+		}
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+
+	return samplers.InterMetric{
+		Name:      m.Metric,
+		Timestamp: ts,
+		Value:     m.Value,
+		Tags:      tags,
+		Type:      metricType,
+		Sketch:    m.Sketch,
+		Exemplar:  m.Exemplar,
+		Unit:      m.Unit,
+	}, nil
+}
+
+// parseMetricType returns the type of the metrics emitted as name, by a
+// sink without a TypeMapping.
+func parseMetricType(name string) (samplers.MetricType, bool) {
+	for t, n := range metricTypeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,33 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestGenericMetricInterMetric(t *testing.T) {
+	expected := samplers.InterMetric{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     2,
+		Tags:      []string{"bare", "foo:bar"},
+		Type:      samplers.CounterMetric,
+	}
+	for _, format := range []string{TimestampSeconds, TimestampMilliseconds, TimestampRFC3339} {
+		gmSink := defaultTestSink()
+		gmSink.TimestampFormat = format
+		genMetric := gmSink.convertInterToGeneric([]samplers.InterMetric{expected}).Metrics[0]
+
+		converted, err := genMetric.InterMetric()
+		require.NoError(t, err)
+		assert.Equal(t, expected, converted, "timestamps in %s should be converted back", format)
+	}
+}
+
+func TestGenericMetricInterMetricUnknownType(t *testing.T) {
+	_, err := GenericMetric{Metric: "a.b.c", Type: "count"}.InterMetric()
+	assert.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package generic
+
+import (
+	"strings"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyUntaggedMetricsDropped is emitted as a counter of the metrics
+// that are dropped for lacking one of RequiredTags, tagged with
+// `sink:sink.Name()`.
+const MetricKeyUntaggedMetricsDropped = "sink.generic.untagged_metrics_dropped_total"
+
+// hasTag returns whether tags has the required tag: a tag with the same
+// key, if required is a bare key, or the same tag, if it's key:value.
+func hasTag(tags []string, required string) bool {
+	for _, tag := range tags {
+		if tag == required {
+			return true
+		}
+		if !strings.Contains(required, ":") && strings.HasPrefix(tag, required+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// requireTags drops every metric that doesn't have all of RequiredTags.
+// Only the metric's own tags count, not the sink's or the server's.
+func (gm *GenericMetricSink) requireTags(interMetrics []samplers.InterMetric) []samplers.InterMetric {
+	kept := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		tagged := true
+		for _, required := range gm.RequiredTags {
+			if !hasTag(metric.Tags, required) {
+				tagged = false
+				break
+			}
+		}
+		if tagged {
+			kept = append(kept, metric)
+		}
+	}
+
+	if dropped := len(interMetrics) - len(kept); dropped > 0 {
+		metrics.ReportOne(gm.traceClient, ssf.Count(MetricKeyUntaggedMetricsDropped, float32(dropped), map[string]string{"sink": gm.Name()}))
+		gm.log.WithField("metrics", dropped).Debug("Dropped generic metrics without the required tags")
+	}
+	return kept
+}
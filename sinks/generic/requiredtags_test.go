@@ -0,0 +1,40 @@
+package generic
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestFlushRequiredTags(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.RequiredTags = []string{"forward:true", "team"}
+	ch := startTraceClient(t, gmSink)
+
+	metrics := []samplers.InterMetric{
+		{Name: "opted.in", Value: 1, Tags: []string{"forward:true", "team:infra"}, Type: samplers.CounterMetric},
+		{Name: "untagged", Value: 2, Type: samplers.CounterMetric},
+		{Name: "not.forwarded", Value: 3, Tags: []string{"forward:false", "team:infra"}, Type: samplers.CounterMetric},
+		{Name: "no.team", Value: 4, Tags: []string{"forward:true"}, Type: samplers.GaugeMetric},
+		{Name: "bare.team", Value: 5, Tags: []string{"team", "forward:true"}, Type: samplers.GaugeMetric},
+		{Name: "team.prefix", Value: 6, Tags: []string{"forward:true", "teams:infra"}, Type: samplers.GaugeMetric},
+	}
+	require.NoError(t, gmSink.Flush(context.TODO(), metrics))
+	require.Equal(t, 1, transport.Called)
+	var batch GenericMetrics
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents[0]), &batch))
+	if assert.Len(t, batch.Metrics, 2, "only the metrics with all the required tags should be flushed") {
+		assert.Equal(t, "opted.in", batch.Metrics[0].Metric)
+		assert.Equal(t, "bare.team", batch.Metrics[1].Metric, "a bare key should match a tag without a value")
+	}
+	samples := reportedSamples(ch)
+	assert.Equal(t, float32(4), sampleTotal(samples[MetricKeyUntaggedMetricsDropped]))
+
+	transport.Called = 0
+	require.NoError(t, gmSink.Flush(context.TODO(), metrics[1:2]))
+	assert.Zero(t, transport.Called, "nothing should be sent when every metric is dropped")
+}
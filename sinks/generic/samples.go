@@ -0,0 +1,103 @@
+package generic
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/protocol/dogstatsd"
+	"github.com/stripe/veneur/ssf"
+)
+
+// MetricKeySampleFlushDuration is emitted as a timer for every request
+// forwarding samples, tagged with `sink:sink.Name()`.
+const MetricKeySampleFlushDuration = "sink.generic.sample_flush_duration_ns"
+
+// MetricKeySamplesFlushed is emitted as a counter of the samples that were
+// forwarded successfully, tagged with `sink:sink.Name()`.
+const MetricKeySamplesFlushed = "sink.generic.samples_flushed_total"
+
+// GenericSample is an SSF sample forwarded as is, with its enums spelled
+// out. Timestamp is in nanoseconds since the epoch, like the sample's.
+type GenericSample struct {
+	Metric     string            `json:"metric"`
+	Name       string            `json:"name"`
+	Value      float32           `json:"value"`
+	Timestamp  int64             `json:"timestamp"`
+	Message    string            `json:"message,omitempty"`
+	Status     string            `json:"status"`
+	SampleRate float32           `json:"sample_rate"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Unit       string            `json:"unit,omitempty"`
+	Scope      string            `json:"scope"`
+}
+
+// GenericSamples encapsulates the samples of a flush, with their common
+// environment and namespace.
+type GenericSamples struct {
+	Samples     []GenericSample `json:"samples"`
+	Environment string          `json:"environment"`
+	Namespace   string          `json:"namespace"`
+}
+
+// flushSamples forwards the samples that aren't events to SamplesEndpoint.
+func (gm *GenericMetricSink) flushSamples(ctx context.Context, samples []ssf.SSFSample) {
+	if gm.SamplesEndpoint == "" {
+		return
+	}
+	genSamples := gm.convertSamples(samples)
+	if len(genSamples.Samples) == 0 {
+		return
+	}
+	if gm.DryRun {
+		gm.dryRunJSON("samples", len(genSamples.Samples), gm.SamplesEndpoint, genSamples)
+		return
+	}
+
+	err := gm.postJSON(ctx, gm.SamplesEndpoint, genSamples, MetricKeySampleFlushDuration, MetricKeySamplesFlushed, len(genSamples.Samples))
+	if err != nil {
+		gm.log.WithFields(errorFields(err, logrus.Fields{
+			"samples":  len(genSamples.Samples),
+			"endpoint": gm.SamplesEndpoint,
+		})).Warn("Error forwarding generic samples")
+		return
+	}
+	gm.log.WithField("samples", len(genSamples.Samples)).Info("Completed forwarding generic samples")
+}
+
+// convertSamples converts the samples that aren't events, which are sent
+// to EventsEndpoint instead. Unlike metrics and events, they don't get
+// the sink's tags, but their tags are filtered and redacted all the same.
+func (gm *GenericMetricSink) convertSamples(samples []ssf.SSFSample) GenericSamples {
+	var genSamples []GenericSample
+	for _, sample := range samples {
+		if _, ok := sample.Tags[dogstatsd.EventIdentifierKey]; ok {
+			continue
+		}
+		var tags map[string]string
+		if len(sample.Tags) > 0 {
+			// filtering modifies the tags, which are the caller's
+			tags = make(map[string]string, len(sample.Tags))
+			for k, v := range sample.Tags {
+				tags[k] = v
+			}
+			tags = gm.filterTags(tags)
+		}
+		genSamples = append(genSamples, GenericSample{
+			Metric:     sample.Metric.String(),
+			Name:       sample.Name,
+			Value:      sample.Value,
+			Timestamp:  sample.Timestamp,
+			Message:    sample.Message,
+			Status:     sample.Status.String(),
+			SampleRate: sample.SampleRate,
+			Tags:       tags,
+			Unit:       sample.Unit,
+			Scope:      sample.Scope.String(),
+		})
+	}
+	return GenericSamples{
+		Samples:     genSamples,
+		Environment: gm.Environment,
+		Namespace:   gm.Namespace,
+	}
+}
@@ -0,0 +1,71 @@
+package generic
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/ssf"
+)
+
+func TestConvertSamples(t *testing.T) {
+	gmSink, _ := getRoundTripTestSink("/endpoint", 10)
+	gmSink.Tags = []string{"host:fnord"}
+	samples := append(testEventSamples(), ssf.SSFSample{
+		Metric:     ssf.SSFSample_HISTOGRAM,
+		Name:       "request.latency",
+		Value:      12.5,
+		Timestamp:  1476119058000000000,
+		SampleRate: 0.5,
+		Tags:       map[string]string{"service": "veneur"},
+		Unit:       "ms",
+		Scope:      ssf.SSFSample_GLOBAL,
+	})
+
+	genSamples := gmSink.convertSamples(samples)
+	assert.Equal(t, defaultEnvironment, genSamples.Environment)
+	assert.Equal(t, []GenericSample{{
+		Metric:    "COUNTER",
+		Name:      "not.an.event",
+		Timestamp: 1476119058,
+		Status:    "OK",
+		Tags:      map[string]string{"service": "veneur"},
+		Scope:     "DEFAULT",
+	}, {
+		Metric:     "HISTOGRAM",
+		Name:       "request.latency",
+		Value:      12.5,
+		Timestamp:  1476119058000000000,
+		Status:     "OK",
+		SampleRate: 0.5,
+		Tags:       map[string]string{"service": "veneur"},
+		Unit:       "ms",
+		Scope:      "GLOBAL",
+	}}, genSamples.Samples, "events should be left out, and the rest forwarded without the sink's tags")
+}
+
+func TestFlushOtherSamplesSamplesEndpoint(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.SamplesEndpoint = "/endpoint/samples"
+
+	gmSink.FlushOtherSamples(context.Background(), testEventSamples())
+	require.Equal(t, 1, transport.Called, "the event shouldn't be sent without an events endpoint")
+	assert.Equal(t, "/endpoint/samples", transport.Paths[0])
+	assert.Equal(t, "application/json", transport.Headers[0].Get("Content-Type"))
+
+	var genSamples GenericSamples
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents[0]), &genSamples))
+	if assert.Len(t, genSamples.Samples, 1) {
+		assert.Equal(t, "not.an.event", genSamples.Samples[0].Name)
+	}
+
+	gmSink.EventsEndpoint = "/endpoint/events"
+	gmSink.FlushOtherSamples(context.Background(), testEventSamples())
+	require.Equal(t, 3, transport.Called)
+	assert.ElementsMatch(t, []string{"/endpoint/events", "/endpoint/samples"}, transport.Paths[1:])
+
+	gmSink.FlushOtherSamples(context.Background(), testEventSamples()[:1])
+	assert.Equal(t, 4, transport.Called, "nothing should be forwarded without any samples")
+}
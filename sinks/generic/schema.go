@@ -0,0 +1,395 @@
+package generic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeySchemaViolations is emitted as a counter of the batches that
+// don't match PayloadSchema, tagged with `sink:sink.Name()`.
+const MetricKeySchemaViolations = "sink.generic.schema_violations_total"
+
+// The policies for batches that don't match PayloadSchema.
+const (
+	// SchemaFail fails them with ErrSchemaViolation, without sending
+	// them.
+	SchemaFail = "fail"
+	// SchemaWarn logs a warning, and sends them anyway.
+	SchemaWarn = "warn"
+)
+
+// PayloadSchema is a JSON Schema that the batches the sink sends are
+// checked against, e.g. in staging, to catch them drifting from what the
+// endpoint expects before it rejects them in production. Every JSON
+// document of a batch is checked: the whole batch, or every line of a
+// batch in FormatNDJSON.
+//
+// Only the keywords that describe the shape of a document are supported:
+// type, enum, const, properties, required, additionalProperties, items,
+// minItems, maxItems, minLength, maxLength, pattern, minimum and maximum.
+// Annotations, like title or description, are ignored, but a schema using
+// any other keyword (e.g. $ref or oneOf) is refused, rather than checked
+// without the parts of it the sink doesn't understand.
+type PayloadSchema struct {
+	types                []string
+	enum                 []interface{}
+	properties           map[string]*PayloadSchema
+	required             []string
+	additionalProperties *PayloadSchema
+	noAdditional         bool
+	items                *PayloadSchema
+	minItems, maxItems   *float64
+	minLength, maxLength *float64
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+}
+
+// LoadPayloadSchema reads the JSON Schema in the file at path.
+func LoadPayloadSchema(path string) (*PayloadSchema, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the payload schema: %v", err)
+	}
+	var raw interface{}
+	if err := json.Unmarshal(contents, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse the payload schema %s: %v", path, err)
+	}
+	schema, err := compileSchema(raw, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload schema %s: %v", path, err)
+	}
+	return schema, nil
+}
+
+// schemaKeywords are the keywords compileSchema supports, and the
+// annotations it ignores.
+var schemaKeywords = map[string]bool{
+	"type":                 true,
+	"enum":                 true,
+	"const":                true,
+	"properties":           true,
+	"required":             true,
+	"additionalProperties": true,
+	"items":                true,
+	"minItems":             true,
+	"maxItems":             true,
+	"minLength":            true,
+	"maxLength":            true,
+	"pattern":              true,
+	"minimum":              true,
+	"maximum":              true,
+
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+	"format":      true,
+	"deprecated":  true,
+	"readOnly":    true,
+	"writeOnly":   true,
+}
+
+// compileSchema compiles the schema at path (a JSON pointer) of a schema
+// document.
+func compileSchema(raw interface{}, path string) (*PayloadSchema, error) {
+	if accept, ok := raw.(bool); ok {
+		// true accepts anything, false nothing
+		if accept {
+			return &PayloadSchema{}, nil
+		}
+		return &PayloadSchema{enum: []interface{}{}}, nil
+	}
+	keywords, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: a schema must be an object or a boolean", pointer(path))
+	}
+	schema := &PayloadSchema{}
+	invalid := func(keyword, want string) error {
+		return fmt.Errorf("%s: %s must be %s", pointer(path+"/"+keyword), keyword, want)
+	}
+	for keyword := range keywords {
+		if !schemaKeywords[keyword] {
+			return nil, fmt.Errorf("%s: unsupported keyword %s", pointer(path+"/"+keyword), keyword)
+		}
+	}
+
+	switch t := keywords["type"].(type) {
+	case nil:
+	case string:
+		schema.types = []string{t}
+	case []interface{}:
+		for _, name := range t {
+			s, ok := name.(string)
+			if !ok {
+				return nil, invalid("type", "a string or an array of strings")
+			}
+			schema.types = append(schema.types, s)
+		}
+	default:
+		return nil, invalid("type", "a string or an array of strings")
+	}
+	if enum, ok := keywords["enum"]; ok {
+		if schema.enum, ok = enum.([]interface{}); !ok {
+			return nil, invalid("enum", "an array")
+		}
+	}
+	if c, ok := keywords["const"]; ok {
+		schema.enum = []interface{}{c}
+	}
+
+	if props, ok := keywords["properties"]; ok {
+		m, ok := props.(map[string]interface{})
+		if !ok {
+			return nil, invalid("properties", "an object")
+		}
+		schema.properties = make(map[string]*PayloadSchema, len(m))
+		for name, sub := range m {
+			compiled, err := compileSchema(sub, path+"/properties/"+name)
+			if err != nil {
+				return nil, err
+			}
+			schema.properties[name] = compiled
+		}
+	}
+	if req, ok := keywords["required"]; ok {
+		names, ok := req.([]interface{})
+		if !ok {
+			return nil, invalid("required", "an array of strings")
+		}
+		for _, name := range names {
+			s, ok := name.(string)
+			if !ok {
+				return nil, invalid("required", "an array of strings")
+			}
+			schema.required = append(schema.required, s)
+		}
+	}
+	if additional, ok := keywords["additionalProperties"]; ok {
+		if allowed, ok := additional.(bool); ok {
+			schema.noAdditional = !allowed
+		} else {
+			compiled, err := compileSchema(additional, path+"/additionalProperties")
+			if err != nil {
+				return nil, err
+			}
+			schema.additionalProperties = compiled
+		}
+	}
+	if items, ok := keywords["items"]; ok {
+		compiled, err := compileSchema(items, path+"/items")
+		if err != nil {
+			return nil, err
+		}
+		schema.items = compiled
+	}
+
+	for keyword, bound := range map[string]**float64{
+		"minItems":  &schema.minItems,
+		"maxItems":  &schema.maxItems,
+		"minLength": &schema.minLength,
+		"maxLength": &schema.maxLength,
+		"minimum":   &schema.minimum,
+		"maximum":   &schema.maximum,
+	} {
+		if v, ok := keywords[keyword]; ok {
+			n, ok := v.(float64)
+			if !ok {
+				return nil, invalid(keyword, "a number")
+			}
+			*bound = &n
+		}
+	}
+	if p, ok := keywords["pattern"]; ok {
+		s, ok := p.(string)
+		if !ok {
+			return nil, invalid("pattern", "a string")
+		}
+		pattern, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pointer(path+"/pattern"), err)
+		}
+		schema.pattern = pattern
+	}
+	return schema, nil
+}
+
+// pointer returns path as a JSON pointer: "/" for the root.
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// ValidateJSON checks every JSON document in r against the schema,
+// returning where the first of them doesn't match it.
+func (ps *PayloadSchema) ValidateJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ps.validate(doc, ""); err != nil {
+			return err
+		}
+	}
+}
+
+// validate checks a decoded JSON value, at path in its document.
+func (ps *PayloadSchema) validate(v interface{}, path string) error {
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s: %s", pointer(path), fmt.Sprintf(format, args...))
+	}
+	if len(ps.types) > 0 && !ps.hasType(v) {
+		return fail("want %s, got %s", strings.Join(ps.types, " or "), jsonType(v))
+	}
+	if ps.enum != nil {
+		found := false
+		for _, allowed := range ps.enum {
+			found = found || reflect.DeepEqual(v, allowed)
+		}
+		if !found {
+			return fail("%v isn't one of the allowed values", v)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range ps.required {
+			if _, ok := v[name]; !ok {
+				return fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, ok := ps.properties[name]
+			if !ok {
+				if ps.noAdditional {
+					return fail("unexpected property %q", name)
+				}
+				sub = ps.additionalProperties
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(v[name], path+"/"+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if ps.minItems != nil && float64(len(v)) < *ps.minItems {
+			return fail("want at least %v items, got %d", *ps.minItems, len(v))
+		}
+		if ps.maxItems != nil && float64(len(v)) > *ps.maxItems {
+			return fail("want at most %v items, got %d", *ps.maxItems, len(v))
+		}
+		if ps.items != nil {
+			for i, item := range v {
+				if err := ps.items.validate(item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if ps.minLength != nil && length < *ps.minLength {
+			return fail("want at least %v characters, got %q", *ps.minLength, v)
+		}
+		if ps.maxLength != nil && length > *ps.maxLength {
+			return fail("want at most %v characters, got %q", *ps.maxLength, v)
+		}
+		if ps.pattern != nil && !ps.pattern.MatchString(v) {
+			return fail("%q doesn't match %s", v, ps.pattern)
+		}
+	case float64:
+		if ps.minimum != nil && v < *ps.minimum {
+			return fail("want at least %v, got %v", *ps.minimum, v)
+		}
+		if ps.maximum != nil && v > *ps.maximum {
+			return fail("want at most %v, got %v", *ps.maximum, v)
+		}
+	}
+	return nil
+}
+
+// hasType returns whether v is one of the schema's types.
+func (ps *PayloadSchema) hasType(v interface{}) bool {
+	actual := jsonType(v)
+	for _, t := range ps.types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// checkPayloadSchema checks a batch against PayloadSchema, as it would be
+// serialized. Under SchemaFail, a batch that doesn't match fails with
+// ErrSchemaViolation; under SchemaWarn, it's only logged.
+func (gm *GenericMetricSink) checkPayloadSchema(endpoint string, genMetrics GenericMetrics) error {
+	var buf bytes.Buffer
+	if err := gm.serialize(&buf, genMetrics); err != nil {
+		return &FlushError{Category: ErrSerialize, Err: err}
+	}
+	err := gm.PayloadSchema.ValidateJSON(&buf)
+	if err == nil {
+		return nil
+	}
+	metrics.ReportOne(gm.traceClient, ssf.Count(MetricKeySchemaViolations, 1, map[string]string{"sink": gm.Name()}))
+	entry := gm.log.WithFields(logrus.Fields{
+		"metrics":       len(genMetrics.Metrics),
+		"endpoint":      endpoint,
+		logrus.ErrorKey: err,
+	})
+	if gm.PayloadSchemaPolicy == SchemaWarn {
+		entry.Warn("Generic metrics don't match the payload schema, sending them anyway")
+		return nil
+	}
+	entry.Error("Generic metrics don't match the payload schema, not sending them")
+	return &FlushError{Category: ErrSchemaViolation, Err: err}
+}
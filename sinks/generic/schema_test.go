@@ -0,0 +1,136 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPayloadSchema(t *testing.T) *PayloadSchema {
+	schema, err := LoadPayloadSchema(filepath.Join("testdata", "payload_schema.json"))
+	require.NoError(t, err)
+	return schema
+}
+
+func TestFlushPayloadSchema(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.PayloadSchema = testPayloadSchema(t)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	assert.Equal(t, 1, transport.Called)
+}
+
+func TestFlushPayloadSchemaMismatch(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.PayloadSchema = testPayloadSchema(t)
+	gmSink.NamePrefix = "Team."
+	ch := startTraceClient(t, gmSink)
+
+	err := gmSink.Flush(context.TODO(), basicInterMetrics())
+	if assert.IsType(t, &BatchErrors{}, err) && assert.Len(t, err.(*BatchErrors).Errors, 1) {
+		batchErr := err.(*BatchErrors).Errors[0]
+		assert.True(t, errors.Is(batchErr, ErrSchemaViolation), "got %v", batchErr)
+		assert.Contains(t, batchErr.Error(), "/metrics/0/metric", "the error should say where the batch doesn't match")
+	}
+	assert.Zero(t, transport.Called, "a batch that doesn't match the schema shouldn't be sent")
+	samples := reportedSamples(ch)
+	assert.Equal(t, float32(1), sampleTotal(samples[MetricKeySchemaViolations]))
+
+	gmSink.PayloadSchemaPolicy = SchemaWarn
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	assert.Equal(t, 1, transport.Called, "under SchemaWarn, the batch should be sent anyway")
+}
+
+func TestPayloadSchemaValidateJSON(t *testing.T) {
+	schema := testPayloadSchema(t)
+	tests := []struct {
+		name    string
+		payload string
+		err     string
+	}{
+		{"valid", `{"metrics":[{"metric":"a.b","type":"gauge","value":1.5,"at":1}]}`, ""},
+		{"ndjson", `{"metrics":[{"metric":"a","type":"gauge","value":1,"at":1}]}` + "\n" + `{"metrics":[]}`, "/metrics: want at least 1 items"},
+		{"missing", `{"environment":"prod"}`, `/: missing required property "metrics"`},
+		{"additional", `{"metrics":[{"metric":"a","type":"gauge","value":1,"at":1,"extra":true}]}`, `/metrics/0: unexpected property "extra"`},
+		{"enum", `{"metrics":[{"metric":"a","type":"distribution","value":1,"at":1}]}`, "/metrics/0/type"},
+		{"integer", `{"metrics":[{"metric":"a","type":"gauge","value":1,"at":1.5}]}`, "/metrics/0/at: want integer, got number"},
+		{"tags", `{"metrics":[{"metric":"a","type":"gauge","value":1,"at":1,"tags":{"a":1}}]}`, "/metrics/0/tags/a: want string, got integer"},
+		{"not json", `{"metrics":`, "unexpected EOF"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := schema.ValidateJSON(strings.NewReader(test.payload))
+			if test.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+}
+
+func TestNewGenericMetricSinkFromConfigPayloadSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generic-schema")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, ioutil.WriteFile(invalid, []byte(`{"properties":{"metrics":{"type":3}}}`), 0644))
+
+	conf := GenericSinkConfig{
+		GenericEndpoint:            "http://localhost:8080/metrics",
+		GenericBatchSize:           100,
+		GenericPayloadSchema:       filepath.Join("testdata", "payload_schema.json"),
+		GenericPayloadSchemaPolicy: SchemaWarn,
+	}
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.NotNil(t, sink.PayloadSchema)
+	assert.Equal(t, SchemaWarn, sink.PayloadSchemaPolicy)
+
+	for _, path := range []string{invalid, filepath.Join(dir, "missing.json")} {
+		conf.GenericPayloadSchema = path
+		_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+		assert.Error(t, err, "schema %s", path)
+	}
+
+	conf.GenericPayloadSchema = ""
+	conf.GenericPayloadSchemaPolicy = "ignore"
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err)
+}
+
+func TestLoadPayloadSchemaUnsupportedKeyword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generic-schema")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ref.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{
+		"$defs": {"metric": {"type": "object", "required": ["name"]}},
+		"type": "object",
+		"properties": {"metrics": {"type": "array", "items": {"$ref": "#/$defs/metric"}}}
+	}`), 0644))
+
+	_, err = LoadPayloadSchema(path)
+	if assert.Error(t, err, "a schema the sink can't check in full should be refused") {
+		assert.Contains(t, err.Error(), "$defs")
+	}
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{
+		"type": "object",
+		"properties": {"metrics": {"type": "array", "items": {"$ref": "#/$defs/metric"}}}
+	}`), 0644))
+	_, err = LoadPayloadSchema(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "/properties/metrics/items/$ref")
+	}
+}
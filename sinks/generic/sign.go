@@ -0,0 +1,32 @@
+package generic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// DefaultSignatureHeader is the header requests are signed in, unless
+// SignatureHeader says otherwise.
+const DefaultSignatureHeader = "X-Signature"
+
+// signs reports whether requests are signed.
+func (gm *GenericMetricSink) signs() bool {
+	return len(gm.signingSecret) > 0
+}
+
+// sign adds the signature of a request's body to its headers, if requests
+// are signed: the hex-encoded HMAC-SHA256 of the body, as sent (i.e.
+// compressed), keyed with the signing secret.
+func (gm *GenericMetricSink) sign(headers map[string]string, body []byte) {
+	if !gm.signs() {
+		return
+	}
+	mac := hmac.New(sha256.New, gm.signingSecret)
+	mac.Write(body)
+	header := gm.SignatureHeader
+	if header == "" {
+		header = DefaultSignatureHeader
+	}
+	headers[header] = hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,60 @@
+package generic
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestFlushSigned(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.CompressionType = CompressionNone
+	gmSink.signingSecret = []byte("hunter2")
+	// signed batches are buffered whatever StreamBatches says
+	gmSink.StreamBatches = true
+
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	require.Len(t, transport.Contents, 1)
+	assert.Equal(t, signature("hunter2", transport.Contents[0]), transport.Headers[0].Get(DefaultSignatureHeader))
+
+	gmSink.SignatureHeader = "X-Hub-Signature"
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	assert.Equal(t, signature("hunter2", transport.Contents[1]), transport.Headers[1].Get("X-Hub-Signature"))
+	assert.Empty(t, transport.Headers[1].Get(DefaultSignatureHeader))
+}
+
+func TestFlushUnsigned(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	require.Len(t, transport.Headers, 1)
+	assert.Empty(t, transport.Headers[0].Get(DefaultSignatureHeader), "requests shouldn't be signed by default")
+}
+
+func TestNewGenericMetricSinkFromConfigSigning(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint:      "http://localhost:8080/metrics",
+		GenericBatchSize:     100,
+		GenericSigningSecret: "hunter2",
+		GenericStreamBatches: true,
+	}
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err, "signed requests can't be streamed")
+	assert.NotContains(t, err.Error(), "hunter2")
+
+	conf.GenericStreamBatches = false
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.True(t, sink.signs())
+}
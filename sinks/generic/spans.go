@@ -0,0 +1,217 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/protocol"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// defaultSpanBufferSize is the number of spans GenericSpanSink holds
+// between two flushes, unless it's told otherwise.
+const defaultSpanBufferSize = 1 << 14
+
+// defaultSpanFlushInterval bounds how long GenericSpanSink's flushes may
+// take, unless it's told otherwise. It's veneur's default flush interval.
+const defaultSpanFlushInterval = 10 * time.Second
+
+// GenericSpan represents a single SSF span. Timestamps are in nanoseconds
+// since the epoch.
+type GenericSpan struct {
+	TraceID   int64             `json:"trace_id"`
+	ID        int64             `json:"id"`
+	ParentID  int64             `json:"parent_id"`
+	Name      string            `json:"name"`
+	Service   string            `json:"service"`
+	Start     int64             `json:"start"`
+	End       int64             `json:"end"`
+	Error     bool              `json:"error"`
+	Indicator bool              `json:"indicator"`
+	Source    string            `json:"source"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// GenericSpans encapsulates a batch of spans, with their common
+// environment and namespace.
+type GenericSpans struct {
+	Spans       []GenericSpan `json:"spans"`
+	Environment string        `json:"environment"`
+	Namespace   string        `json:"namespace"`
+}
+
+// GenericSpanSink buffers the spans it ingests and POSTs them in JSON to
+// Endpoint on every flush. It sends them the way its metric sink sends
+// metrics: in batches of the metric sink's BatchSize, with the same
+// retries, timeout, compression, credentials and headers, and with the
+// same source, environment and namespace.
+type GenericSpanSink struct {
+	metricSink *GenericMetricSink
+	Endpoint   string
+	BufferSize int
+
+	// FlushInterval is how long a flush may take to send its spans, so
+	// that an endpoint that hangs can't hold up the flushes after it.
+	// It's normally the server's flush interval; zero means a default
+	// interval. Spans not sent by then are dropped.
+	FlushInterval time.Duration
+
+	mtx     sync.Mutex
+	spans   []*ssf.SSFSpan
+	dropped int
+}
+
+var _ sinks.SpanSink = &GenericSpanSink{}
+
+// NewGenericSpanSink returns a new generic span sink, sending spans to
+// endpoint with metricSink's settings. It buffers at most bufferSize
+// spans between two flushes; a bufferSize of zero means a default size.
+func NewGenericSpanSink(metricSink *GenericMetricSink, endpoint string, bufferSize int) (*GenericSpanSink, error) {
+	if metricSink == nil {
+		return nil, fmt.Errorf("the generic span sink needs a metric sink to send spans with")
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("the generic span sink needs an endpoint to send spans to")
+	}
+	if socket, _, ok := parseUnixEndpoint(endpoint); ok && socket != metricSink.unixSocket {
+		return nil, fmt.Errorf("the generic span sink can only send spans to the Unix domain socket of its metric sink's endpoints")
+	}
+	if bufferSize <= 0 {
+		bufferSize = defaultSpanBufferSize
+	}
+	return &GenericSpanSink{
+		metricSink: metricSink,
+		Endpoint:   resolveUnixEndpoint(endpoint),
+		BufferSize: bufferSize,
+	}, nil
+}
+
+// Name returns the name of the sink's metric sink.
+func (gs *GenericSpanSink) Name() string {
+	return gs.metricSink.Name()
+}
+
+// Start sets the trace client for the sink. The metric sink's trace
+// client is used as well, if it hasn't been started yet.
+func (gs *GenericSpanSink) Start(client *trace.Client) error {
+	if gs.metricSink.traceClient == nil {
+		gs.metricSink.traceClient = client
+	}
+	return nil
+}
+
+// Ingest buffers a span until the next flush. Spans ingested while the
+// buffer is full are dropped.
+func (gs *GenericSpanSink) Ingest(span *ssf.SSFSpan) error {
+	if err := protocol.ValidateTrace(span); err != nil {
+		return err
+	}
+	gs.mtx.Lock()
+	defer gs.mtx.Unlock()
+	if len(gs.spans) >= gs.BufferSize {
+		gs.dropped++
+		return nil
+	}
+	gs.spans = append(gs.spans, span)
+	return nil
+}
+
+// Flush sends the buffered spans to Endpoint.
+func (gs *GenericSpanSink) Flush() {
+	gs.mtx.Lock()
+	spans, dropped := gs.spans, gs.dropped
+	gs.spans, gs.dropped = nil, 0
+	gs.mtx.Unlock()
+
+	gm := gs.metricSink
+	samples := &ssf.Samples{}
+	defer metrics.Report(gm.traceClient, samples)
+	tags := map[string]string{"sink": gs.Name()}
+	if dropped > 0 {
+		samples.Add(ssf.Count(sinks.MetricKeyTotalSpansDropped, float32(dropped), tags))
+	}
+	if len(spans) == 0 {
+		return
+	}
+
+	interval := gs.FlushInterval
+	if interval <= 0 {
+		interval = defaultSpanFlushInterval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), interval)
+	defer cancel()
+
+	batchSize := gm.BatchSize
+	if batchSize < 1 {
+		batchSize = len(spans)
+	}
+	for len(spans) > 0 {
+		if batchSize > len(spans) {
+			batchSize = len(spans)
+		}
+		gs.flushBatch(ctx, spans[:batchSize])
+		spans = spans[batchSize:]
+	}
+}
+
+// flushBatch POSTs a single batch of spans.
+func (gs *GenericSpanSink) flushBatch(ctx context.Context, batch []*ssf.SSFSpan) {
+	gm := gs.metricSink
+	genSpans := gs.convertSpans(batch)
+	if gm.DryRun {
+		gm.dryRunJSON("spans", len(batch), gs.Endpoint, genSpans)
+		return
+	}
+
+	err := gm.postJSON(ctx, gs.Endpoint, genSpans, sinks.MetricKeySpanFlushDuration, sinks.MetricKeyTotalSpansFlushed, len(batch))
+	if err != nil {
+		gm.log.WithFields(errorFields(err, logrus.Fields{
+			"spans":    len(batch),
+			"endpoint": gs.Endpoint,
+		})).Warn("Error flushing generic spans")
+		return
+	}
+	gm.log.WithField("spans", len(batch)).Info("Completed flushing generic spans")
+}
+
+// convertSpans converts spans to their JSON representation. Span tags are
+// filtered and normalized like metric tags; the sink's own tags are not
+// added to them.
+func (gs *GenericSpanSink) convertSpans(spans []*ssf.SSFSpan) GenericSpans {
+	gm := gs.metricSink
+	genSpans := make([]GenericSpan, 0, len(spans))
+	for _, span := range spans {
+		tags := make(map[string]string, len(span.Tags))
+		for k, v := range span.Tags {
+			tags[k] = v
+		}
+		parentID := span.ParentId
+		if parentID < 0 {
+			parentID = 0
+		}
+		genSpans = append(genSpans, GenericSpan{
+			TraceID:   span.TraceId,
+			ID:        span.Id,
+			ParentID:  parentID,
+			Name:      span.Name,
+			Service:   span.Service,
+			Start:     span.StartTimestamp,
+			End:       span.EndTimestamp,
+			Error:     span.Error,
+			Indicator: span.Indicator,
+			Source:    gm.Source,
+			Tags:      gm.TagNormalizer.Normalize(gm.filterTags(tags)),
+		})
+	}
+	return GenericSpans{
+		Spans:       genSpans,
+		Environment: gm.Environment,
+		Namespace:   gm.Namespace,
+	}
+}
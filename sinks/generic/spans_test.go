@@ -0,0 +1,133 @@
+package generic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/ssf"
+)
+
+// spanServer records the batches of spans POSTed to it.
+func spanServer(t *testing.T) (*httptest.Server, chan GenericSpans) {
+	batches := make(chan GenericSpans, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/spans", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var batch GenericSpans
+		if assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch)) {
+			batches <- batch
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return srv, batches
+}
+
+func testSpan(id int64) *ssf.SSFSpan {
+	return &ssf.SSFSpan{
+		TraceId:        1,
+		Id:             id,
+		ParentId:       -1,
+		Name:           "request",
+		Service:        "farm",
+		StartTimestamp: 1476119058000000000,
+		EndTimestamp:   1476119059000000000,
+		Tags:           map[string]string{"foo": "bar", "secret": "hunter2"},
+	}
+}
+
+func TestGenericSpanSinkFlush(t *testing.T) {
+	srv, batches := spanServer(t)
+	defer srv.Close()
+
+	gmSink := getTestSink(srv.Client(), []string{"host:fnord"}, srv.URL+"/metrics", 2, defaultSource, defaultEnvironment, defaultNamespace)
+	gmSink.Format = FormatNDJSON
+	gmSink.ExcludedTags = []string{"secret"}
+	sink, err := NewGenericSpanSink(gmSink, srv.URL+"/spans", 0)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	for id := int64(1); id <= 3; id++ {
+		require.NoError(t, sink.Ingest(testSpan(id)))
+	}
+	sink.Flush()
+
+	require.Len(t, batches, 2, "spans should be sent in batches of the metric sink's batch size")
+	first := <-batches
+	assert.Equal(t, defaultEnvironment, first.Environment)
+	assert.Equal(t, defaultNamespace, first.Namespace)
+	require.Len(t, first.Spans, 2)
+	assert.Equal(t, GenericSpan{
+		TraceID: 1,
+		ID:      1,
+		Name:    "request",
+		Service: "farm",
+		Start:   1476119058000000000,
+		End:     1476119059000000000,
+		Source:  defaultSource,
+		Tags:    map[string]string{"foo": "bar"},
+	}, first.Spans[0])
+	second := <-batches
+	require.Len(t, second.Spans, 1)
+	assert.Equal(t, int64(3), second.Spans[0].ID)
+
+	sink.Flush()
+	assert.Empty(t, batches, "spans should only be sent once")
+}
+
+func TestGenericSpanSinkIngestInvalid(t *testing.T) {
+	sink, err := NewGenericSpanSink(defaultTestSink(), "http://localhost/spans", 0)
+	require.NoError(t, err)
+
+	assert.Error(t, sink.Ingest(&ssf.SSFSpan{}), "spans without IDs should be rejected")
+	assert.Empty(t, sink.spans)
+}
+
+func TestGenericSpanSinkBufferFull(t *testing.T) {
+	srv, batches := spanServer(t)
+	defer srv.Close()
+
+	gmSink := getTestSink(srv.Client(), nil, "", 0, defaultSource, defaultEnvironment, defaultNamespace)
+	sink, err := NewGenericSpanSink(gmSink, srv.URL+"/spans", 2)
+	require.NoError(t, err)
+
+	for id := int64(1); id <= 3; id++ {
+		require.NoError(t, sink.Ingest(testSpan(id)))
+	}
+	assert.Equal(t, 1, sink.dropped)
+	sink.Flush()
+
+	require.Len(t, batches, 1)
+	assert.Len(t, (<-batches).Spans, 2, "spans beyond the buffer size should be dropped")
+	assert.Zero(t, sink.dropped)
+}
+
+func TestGenericSpanSinkFlushDeadline(t *testing.T) {
+	hung := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer srv.Close()
+	defer close(hung)
+
+	gmSink := getTestSink(srv.Client(), nil, "", 0, defaultSource, defaultEnvironment, defaultNamespace)
+	sink, err := NewGenericSpanSink(gmSink, srv.URL+"/spans", 0)
+	require.NoError(t, err)
+	sink.FlushInterval = 50 * time.Millisecond
+	require.NoError(t, sink.Ingest(testSpan(1)))
+
+	flushed := make(chan struct{})
+	go func() {
+		sink.Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a hung endpoint should not block the flush past its interval")
+	}
+}
@@ -0,0 +1,162 @@
+package generic
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// The metrics of the summary every flush emits if FlushSummary is set,
+// tagged with `sink:sink.Name()`. MetricKeySummaryMetricsDropped is
+// emitted for every filter that dropped metrics, also tagged with
+// `filter:` one of the summary filters.
+const (
+	MetricKeySummaryMetricsIn      = "sink.generic.summary.metrics_in_total"
+	MetricKeySummaryMetricsDropped = "sink.generic.summary.metrics_dropped_total"
+	MetricKeySummaryBytesSent      = "sink.generic.summary.bytes_sent_total"
+	MetricKeySummaryBatchesSent    = "sink.generic.summary.batches_sent_total"
+	MetricKeySummaryFlushDuration  = "sink.generic.summary.flush_duration_ns"
+)
+
+// The filters a flush summary counts the metrics dropped by.
+const (
+	// SummaryFilterType is TypeMapping mapping metrics' type to nothing.
+	SummaryFilterType = "type_mapping"
+	// SummaryFilterRequiredTags is RequiredTags.
+	SummaryFilterRequiredTags = "required_tags"
+	// SummaryFilterDedupe is DedupeMetrics.
+	SummaryFilterDedupe = "dedupe"
+	// SummaryFilterConversion is everything that drops metrics while
+	// they're converted: DropZeroCounters, NonFinitePolicy,
+	// InvalidPolicy and LimitPolicy.
+	SummaryFilterConversion = "conversion"
+	// SummaryFilterOversized is MaxPayloadBytes.
+	SummaryFilterOversized = "oversized"
+	// SummaryFilterPending is MaxPendingBytes.
+	SummaryFilterPending = "pending"
+)
+
+// flushSummary tallies a single flush, for FlushSummary. Batches are sent
+// concurrently, so it's safe for concurrent use. Its methods do nothing
+// on a nil summary, which is what flushes without one get.
+type flushSummary struct {
+	start time.Time
+
+	mtx     sync.Mutex
+	in      int
+	dropped map[string]int
+	bytes   int64
+	batches int
+}
+
+// flushSummaryKey is the key of the flush's summary in its context.
+type flushSummaryKey struct{}
+
+// startSummary returns ctx with a new summary for the flush, if
+// FlushSummary is set.
+func (gm *GenericMetricSink) startSummary(ctx context.Context) (context.Context, *flushSummary) {
+	if !gm.FlushSummary {
+		return ctx, nil
+	}
+	summary := &flushSummary{start: time.Now(), dropped: map[string]int{}}
+	return context.WithValue(ctx, flushSummaryKey{}, summary), summary
+}
+
+// summaryOf returns the summary of the flush ctx belongs to, if it has
+// one.
+func summaryOf(ctx context.Context) *flushSummary {
+	summary, _ := ctx.Value(flushSummaryKey{}).(*flushSummary)
+	return summary
+}
+
+// received records the number of metrics the flush was given.
+func (fs *flushSummary) received(n int) {
+	if fs == nil {
+		return
+	}
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	fs.in += n
+}
+
+// drop records that filter took the number of metrics from before to
+// after.
+func (fs *flushSummary) drop(filter string, before, after int) {
+	if fs == nil || before <= after {
+		return
+	}
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	fs.dropped[filter] += before - after
+}
+
+// sent records a batch that was sent, in the number of bytes its
+// countedBody counted.
+func (fs *flushSummary) sent(counted *int64) {
+	if fs == nil {
+		return
+	}
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	fs.bytes += atomic.LoadInt64(counted)
+	fs.batches++
+}
+
+// countedBody returns body, counting the bytes of the last attempt at
+// sending it in sent, if the flush has a summary.
+func (fs *flushSummary) countedBody(body requestBody, sent *int64) requestBody {
+	if fs == nil {
+		return body
+	}
+	return func() io.Reader {
+		atomic.StoreInt64(sent, 0)
+		return &countingReader{r: body(), n: sent}
+	}
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddInt64(cr.n, int64(n))
+	return n, err
+}
+
+// reportSummary emits a flush's summary, once it's done.
+func (gm *GenericMetricSink) reportSummary(fs *flushSummary) {
+	if fs == nil {
+		return
+	}
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	tags := map[string]string{"sink": gm.Name()}
+	samples := &ssf.Samples{}
+	samples.Add(
+		ssf.Count(MetricKeySummaryMetricsIn, float32(fs.in), tags),
+		ssf.Count(MetricKeySummaryBytesSent, float32(fs.bytes), tags),
+		ssf.Count(MetricKeySummaryBatchesSent, float32(fs.batches), tags),
+		ssf.Timing(MetricKeySummaryFlushDuration, time.Since(fs.start), time.Nanosecond, tags),
+	)
+	filters := make([]string, 0, len(fs.dropped))
+	for filter := range fs.dropped {
+		filters = append(filters, filter)
+	}
+	sort.Strings(filters)
+	for _, filter := range filters {
+		samples.Add(ssf.Count(MetricKeySummaryMetricsDropped, float32(fs.dropped[filter]), map[string]string{
+			"sink":   gm.Name(),
+			"filter": filter,
+		}))
+	}
+	metrics.Report(gm.traceClient, samples)
+}
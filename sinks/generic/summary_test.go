@@ -0,0 +1,60 @@
+package generic
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestFlushSummary(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 2)
+	gmSink.CompressionType = CompressionNone
+	gmSink.FlushSummary = true
+	gmSink.RequiredTags = []string{"forward"}
+	gmSink.DedupeMetrics = true
+	gmSink.DropZeroCounters = true
+	ch := startTraceClient(t, gmSink)
+
+	forwarded := []string{"forward:true"}
+	metrics := []samplers.InterMetric{
+		{Name: "a", Value: 1, Tags: forwarded, Type: samplers.CounterMetric},
+		{Name: "a", Value: 1, Tags: forwarded, Type: samplers.CounterMetric},
+		{Name: "b", Value: 2, Type: samplers.CounterMetric},
+		{Name: "c", Value: 0, Tags: forwarded, Type: samplers.CounterMetric},
+		{Name: "d", Value: 4, Tags: forwarded, Type: samplers.GaugeMetric},
+		{Name: "e", Value: 5, Tags: forwarded, Type: samplers.GaugeMetric},
+	}
+	require.NoError(t, gmSink.Flush(context.TODO(), metrics))
+	require.Equal(t, 2, transport.Called)
+
+	samples := reportedSamples(ch)
+	assert.Equal(t, float32(6), sampleTotal(samples[MetricKeySummaryMetricsIn]))
+	assert.Equal(t, float32(2), sampleTotal(samples[MetricKeySummaryBatchesSent]))
+	assert.Equal(t, float32(len(transport.Contents[0])+len(transport.Contents[1])), sampleTotal(samples[MetricKeySummaryBytesSent]))
+	assert.Len(t, samples[MetricKeySummaryFlushDuration], 1)
+	for _, sample := range samples[MetricKeySummaryBytesSent] {
+		assert.Equal(t, map[string]string{"sink": gmSink.Name()}, sample.Tags)
+	}
+
+	dropped := map[string]float32{}
+	for _, sample := range samples[MetricKeySummaryMetricsDropped] {
+		dropped[sample.Tags["filter"]] += sample.Value
+	}
+	assert.Equal(t, map[string]float32{
+		SummaryFilterRequiredTags: 1,
+		SummaryFilterDedupe:       1,
+		SummaryFilterConversion:   1,
+	}, dropped)
+}
+
+func TestFlushSummaryOff(t *testing.T) {
+	gmSink, _ := getRoundTripTestSink("/endpoint", 10)
+	ch := startTraceClient(t, gmSink)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), basicInterMetrics()))
+	samples := reportedSamples(ch)
+	assert.NotContains(t, samples, MetricKeySummaryMetricsIn, "flushes shouldn't be summarized unless FlushSummary is set")
+}
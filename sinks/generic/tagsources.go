@@ -0,0 +1,77 @@
+package generic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stripe/veneur/samplers"
+)
+
+// The sources of the tags of metrics and events, which TagPrecedence
+// orders.
+const (
+	// TagSourceMetric is the tags a metric or event was sent with.
+	TagSourceMetric = "metric"
+	// TagSourceSink is the sink's SinkTags.
+	TagSourceSink = "sink"
+	// TagSourceServer is the tags of the whole server, the sink's Tags.
+	TagSourceServer = "server"
+)
+
+// DefaultTagPrecedence is the precedence of tags, unless TagPrecedence
+// says otherwise: a metric's own tags win over the sink's, which win over
+// the server's.
+var DefaultTagPrecedence = []string{TagSourceMetric, TagSourceSink, TagSourceServer}
+
+// mergeTags merges a metric's or event's own tags with SinkTags and Tags.
+// When several sources have a tag with the same key, the value of the
+// source that comes first in TagPrecedence wins. Within a source, the
+// last of the tags with the same key wins.
+func (gm *GenericMetricSink) mergeTags(own map[string]string) map[string]string {
+	precedence := gm.TagPrecedence
+	if len(precedence) == 0 {
+		precedence = DefaultTagPrecedence
+	}
+	tags := make(map[string]string, len(own)+len(gm.SinkTags)+len(gm.Tags))
+	for i := len(precedence) - 1; i >= 0; i-- {
+		switch precedence[i] {
+		case TagSourceMetric:
+			for k, v := range own {
+				tags[k] = v
+			}
+		case TagSourceSink:
+			for k, v := range samplers.ParseTagSliceToMap(gm.SinkTags) {
+				tags[k] = v
+			}
+		case TagSourceServer:
+			for k, v := range samplers.ParseTagSliceToMap(gm.Tags) {
+				tags[k] = v
+			}
+		}
+	}
+	return tags
+}
+
+// checkTagPrecedence checks that a precedence orders every source of tags
+// exactly once. The empty precedence means DefaultTagPrecedence.
+func checkTagPrecedence(precedence []string) error {
+	if len(precedence) == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, source := range precedence {
+		switch source {
+		case TagSourceMetric, TagSourceSink, TagSourceServer:
+		default:
+			return fmt.Errorf("unknown source of tags %q", source)
+		}
+		if seen[source] {
+			return fmt.Errorf("source of tags %q is listed twice", source)
+		}
+		seen[source] = true
+	}
+	if len(seen) != len(DefaultTagPrecedence) {
+		return fmt.Errorf("tag precedence %q must list every one of %q", strings.Join(precedence, ","), strings.Join(DefaultTagPrecedence, ","))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/veneur/protocol/dogstatsd"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+// conflictingTagsSink returns a sink whose sink and server tags conflict
+// with each other and with conflictingTagsMetric's tags on "env".
+func conflictingTagsSink() *GenericMetricSink {
+	gmSink := getTestSink(nil, []string{"env:server", "region:us-west"}, "", 10, defaultSource, defaultEnvironment, defaultNamespace)
+	gmSink.SinkTags = []string{"env:sink", "team:metrics"}
+	return gmSink
+}
+
+func conflictingTagsMetric() samplers.InterMetric {
+	return samplers.InterMetric{
+		Name:  "a.b.c",
+		Value: 1,
+		Tags:  []string{"env:metric"},
+		Type:  samplers.CounterMetric,
+	}
+}
+
+func TestTagPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		precedence []string
+		expected   string
+	}{
+		{"default", nil, "metric"},
+		{"sink first", []string{TagSourceSink, TagSourceMetric, TagSourceServer}, "sink"},
+		{"server first", []string{TagSourceServer, TagSourceSink, TagSourceMetric}, "server"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gmSink := conflictingTagsSink()
+			gmSink.TagPrecedence = tc.precedence
+
+			tags := gmSink.convertInterToGeneric([]samplers.InterMetric{conflictingTagsMetric()}).Metrics[0].Tags
+			assert.Equal(t, map[string]string{
+				"env":    tc.expected,
+				"region": "us-west",
+				"team":   "metrics",
+			}, tags)
+
+			events := gmSink.convertEvents([]ssf.SSFSample{{
+				Name: "deploy",
+				Tags: map[string]string{dogstatsd.EventIdentifierKey: "", "env": "metric"},
+			}})
+			if assert.Len(t, events.Events, 1) {
+				assert.Equal(t, tc.expected, events.Events[0].Tags["env"], "events should follow the same precedence")
+			}
+		})
+	}
+}
+
+func TestTagPrecedenceWithinSource(t *testing.T) {
+	gmSink := conflictingTagsSink()
+	metric := conflictingTagsMetric()
+	metric.Tags = []string{"env:first", "env:last"}
+	tags := gmSink.convertInterToGeneric([]samplers.InterMetric{metric}).Metrics[0].Tags
+	assert.Equal(t, "last", tags["env"], "the last of a source's tags with the same key should win")
+}
+
+func TestNewGenericMetricSinkFromConfigTagPrecedence(t *testing.T) {
+	for _, precedence := range [][]string{
+		{TagSourceMetric, TagSourceSink},
+		{TagSourceMetric, TagSourceSink, TagSourceSink},
+		{TagSourceMetric, TagSourceSink, "host"},
+	} {
+		_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+			GenericEndpoint:      "http://localhost:8080/metrics",
+			GenericBatchSize:     100,
+			GenericTagPrecedence: precedence,
+		})
+		assert.Error(t, err, "precedence %v should be invalid", precedence)
+	}
+}
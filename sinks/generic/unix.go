@@ -0,0 +1,89 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// unixScheme starts the endpoints that are Unix domain sockets, e.g. of a
+// sidecar: unix://<socket>, or unix://<socket>:<path> to send requests to
+// a path other than "/", as in unix:///var/run/sidecar.sock:/v1/metrics.
+const unixScheme = "unix://"
+
+// unixHost is the host requests to endpoints on a Unix domain socket are
+// sent to. The sink's client dials the socket instead of it.
+const unixHost = "unix.socket"
+
+// parseUnixEndpoint splits an endpoint on a Unix domain socket into the
+// socket's path and the path requests are sent to. ok is false if the
+// endpoint isn't on a socket.
+func parseUnixEndpoint(endpoint string) (socket, path string, ok bool) {
+	if !strings.HasPrefix(endpoint, unixScheme) {
+		return "", "", false
+	}
+	socket = strings.TrimPrefix(endpoint, unixScheme)
+	path = "/"
+	if i := strings.Index(socket, ":"); i >= 0 {
+		socket, path = socket[:i], socket[i+1:]
+	}
+	return socket, path, true
+}
+
+// unixSocketOf returns the socket of the endpoints that are on a Unix
+// domain socket, or the empty string if none of them is. The sink has a
+// single client, so they must all be on the same socket.
+func unixSocketOf(endpoints ...string) (string, error) {
+	var socket string
+	for _, endpoint := range endpoints {
+		s, path, ok := parseUnixEndpoint(endpoint)
+		if !ok {
+			continue
+		}
+		if s == "" || !strings.HasPrefix(path, "/") {
+			return "", fmt.Errorf("invalid Unix domain socket endpoint %q, want unix://<socket>[:<path>]", endpoint)
+		}
+		if socket != "" && s != socket {
+			return "", fmt.Errorf("endpoints on different Unix domain sockets %q and %q", socket, s)
+		}
+		socket = s
+	}
+	return socket, nil
+}
+
+// resolveUnixEndpoint returns the URL requests to an endpoint are sent
+// to: the endpoint itself, unless it's on a Unix domain socket.
+func resolveUnixEndpoint(endpoint string) string {
+	if _, path, ok := parseUnixEndpoint(endpoint); ok {
+		return "http://" + unixHost + path
+	}
+	return endpoint
+}
+
+// NewUnixSocketClient returns an HTTP client like NewHTTPClient's, whose
+// requests to unixHost go to the Unix domain socket at path socket.
+func NewUnixSocketClient(socket string, maxIdleConns, maxIdleConnsPerHost int, idleConnTimeout time.Duration) *http.Client {
+	client := NewHTTPClient(maxIdleConns, maxIdleConnsPerHost, idleConnTimeout)
+	transport := client.Transport.(*http.Transport)
+	dialTCP := transport.DialContext
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && host == unixHost {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		return dialTCP(ctx, network, addr)
+	}
+	proxy := transport.Proxy
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		// the socket is local, there's no proxy to go through
+		if req.URL.Hostname() == unixHost {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+	return client
+}
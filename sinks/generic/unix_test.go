@@ -0,0 +1,105 @@
+package generic
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unixRequest is a request a test server on a Unix domain socket got.
+type unixRequest struct {
+	path string
+	body string
+}
+
+// startUnixServer serves HTTP on a socket in dir, recording the requests
+// it gets, until the returned listener is closed.
+func startUnixServer(t *testing.T, dir string) (net.Listener, chan unixRequest) {
+	socket := filepath.Join(dir, "sidecar.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	requests := make(chan unixRequest, 10)
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- unixRequest{path: r.URL.Path, body: string(body)}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return listener, requests
+}
+
+func TestFlushUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generic-unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	listener, requests := startUnixServer(t, dir)
+	defer listener.Close()
+
+	conf := GenericSinkConfig{
+		GenericEndpoint:        "unix://" + listener.Addr().String() + ":/v1/metrics",
+		GenericBatchSize:       100,
+		GenericCompressionType: CompressionNone,
+	}
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	require.NoError(t, sink.Flush(context.TODO(), basicInterMetrics()))
+
+	req := <-requests
+	assert.Equal(t, "/v1/metrics", req.path)
+	assert.Contains(t, req.body, `"metric":"counter.foo"`)
+	assert.Contains(t, req.body, `"metric":"gauge.bar"`)
+}
+
+func TestParseUnixEndpoint(t *testing.T) {
+	socket, path, ok := parseUnixEndpoint("unix:///var/run/sidecar.sock")
+	assert.True(t, ok)
+	assert.Equal(t, "/var/run/sidecar.sock", socket)
+	assert.Equal(t, "/", path)
+
+	socket, path, ok = parseUnixEndpoint("unix:///var/run/sidecar.sock:/v1/metrics")
+	assert.True(t, ok)
+	assert.Equal(t, "/var/run/sidecar.sock", socket)
+	assert.Equal(t, "/v1/metrics", path)
+
+	_, _, ok = parseUnixEndpoint("http://localhost:8080/metrics")
+	assert.False(t, ok)
+}
+
+func TestNewGenericMetricSinkFromConfigUnixSocket(t *testing.T) {
+	conf := GenericSinkConfig{
+		GenericEndpoint:       "unix:///var/run/sidecar.sock:/metrics",
+		GenericEventsEndpoint: "unix:///var/run/sidecar.sock:/events",
+		GenericBatchSize:      100,
+	}
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	require.NoError(t, err)
+	assert.Equal(t, "http://unix.socket/metrics", sink.Endpoint)
+	assert.Equal(t, "http://unix.socket/events", sink.EventsEndpoint)
+
+	_, err = NewGenericSpanSink(sink, "unix:///var/run/sidecar.sock:/spans", 0)
+	assert.NoError(t, err)
+	_, err = NewGenericSpanSink(sink, "unix:///var/run/other.sock:/spans", 0)
+	assert.Error(t, err, "spans are sent with the metric sink's client")
+
+	for _, endpoint := range []string{"unix://", "unix:///var/run/sidecar.sock:metrics"} {
+		conf.GenericEndpoint = endpoint
+		_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+		assert.Error(t, err, "endpoint %q", endpoint)
+	}
+
+	conf.GenericEndpoint = "unix:///var/run/other.sock:/metrics"
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err, "the sink's endpoints should be on a single socket")
+
+	conf.GenericEndpoint = "unix:///var/run/sidecar.sock:/metrics"
+	conf.GenericHTTPProtocol = ProtocolHTTP2
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", conf)
+	assert.Error(t, err)
+}
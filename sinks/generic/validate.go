@@ -0,0 +1,75 @@
+package generic
+
+import (
+	"regexp"
+
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyInvalidMetrics is emitted as a counter of the metrics whose
+// name or tag keys have characters matching InvalidCharacters, tagged
+// with `sink:sink.Name()` and `policy` (the InvalidPolicy applied).
+const MetricKeyInvalidMetrics = "sink.generic.invalid_metrics_total"
+
+// The policies for metrics whose name or tag keys are invalid.
+const (
+	// InvalidDrop drops them, so that they don't make the endpoint reject
+	// the rest of their batch.
+	InvalidDrop = "drop"
+	// InvalidSanitize replaces the invalid characters with underscores.
+	InvalidSanitize = "sanitize"
+)
+
+// sanitizeReplacement replaces invalid characters under InvalidSanitize.
+const sanitizeReplacement = "_"
+
+// validate reports whether a metric's name and tag keys are valid, and
+// sanitizes them if they aren't and InvalidPolicy says so. Tag keys that
+// are sanitized into the same key are merged, with one of their values
+// winning.
+func (gm *GenericMetricSink) validate(metric *GenericMetric) bool {
+	if gm.InvalidCharacters == nil {
+		return true
+	}
+	valid := !gm.InvalidCharacters.MatchString(metric.Metric)
+	for k := range metric.Tags {
+		valid = valid && !gm.InvalidCharacters.MatchString(k)
+	}
+	if valid || gm.InvalidPolicy != InvalidSanitize {
+		return valid
+	}
+
+	metric.Metric = gm.InvalidCharacters.ReplaceAllString(metric.Metric, sanitizeReplacement)
+	tags := make(map[string]string, len(metric.Tags))
+	for k, v := range metric.Tags {
+		tags[gm.InvalidCharacters.ReplaceAllString(k, sanitizeReplacement)] = v
+	}
+	metric.Tags = tags
+	return false
+}
+
+// reportInvalid records the number of invalid metrics.
+func (gm *GenericMetricSink) reportInvalid(invalid int) {
+	if invalid == 0 {
+		return
+	}
+	policy := gm.InvalidPolicy
+	if policy == "" {
+		policy = InvalidDrop
+	}
+	metrics.ReportOne(gm.traceClient, ssf.Count(MetricKeyInvalidMetrics, float32(invalid), map[string]string{
+		"sink":   gm.Name(),
+		"policy": policy,
+	}))
+	gm.log.WithField("metrics", invalid).Debug("Found generic metrics with invalid names or tag keys")
+}
+
+// compileInvalidCharacters compiles the pattern of invalid characters.
+// The empty pattern means every character is valid.
+func compileInvalidCharacters(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
@@ -0,0 +1,83 @@
+package generic
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func invalidMetrics() []samplers.InterMetric {
+	return []samplers.InterMetric{{
+		Name:  "good.metric",
+		Value: 1,
+		Tags:  []string{"fine:yes"},
+		Type:  samplers.CounterMetric,
+	}, {
+		Name:  "bad metric!",
+		Value: 2,
+		Type:  samplers.CounterMetric,
+	}, {
+		Name:  "bad.tag",
+		Value: 3,
+		Tags:  []string{"no good:value"},
+		Type:  samplers.GaugeMetric,
+	}}
+}
+
+func TestConvertInterToGenericInvalidDrop(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.InvalidCharacters = regexp.MustCompile(`[^a-z.]`)
+	ch := startTraceClient(t, gmSink)
+
+	genericMetrics := gmSink.convertInterToGeneric(invalidMetrics())
+	require.Len(t, genericMetrics.Metrics, 1, "metrics with invalid names or tag keys should be dropped")
+	assert.Equal(t, "good.metric", genericMetrics.Metrics[0].Metric)
+
+	samples := reportedSamples(ch)
+	assert.Equal(t, float32(2), sampleTotal(samples[MetricKeyInvalidMetrics]))
+	assert.Equal(t, InvalidDrop, samples[MetricKeyInvalidMetrics][0].Tags["policy"])
+}
+
+func TestConvertInterToGenericInvalidSanitize(t *testing.T) {
+	gmSink := defaultTestSink()
+	gmSink.InvalidCharacters = regexp.MustCompile(`[^a-z.]`)
+	gmSink.InvalidPolicy = InvalidSanitize
+
+	genericMetrics := gmSink.convertInterToGeneric(invalidMetrics())
+	require.Len(t, genericMetrics.Metrics, 3)
+	assert.Equal(t, "bad_metric_", genericMetrics.Metrics[1].Metric)
+	assert.Equal(t, map[string]string{"no_good": "value"}, genericMetrics.Metrics[2].Tags)
+}
+
+func TestFlushAllInvalid(t *testing.T) {
+	gmSink, transport := getRoundTripTestSink("/endpoint", 10)
+	gmSink.InvalidCharacters = regexp.MustCompile(`.`)
+
+	require.NoError(t, gmSink.Flush(context.TODO(), invalidMetrics()))
+	assert.Equal(t, 0, transport.Called, "batches without any valid metric shouldn't be sent")
+}
+
+func TestNewGenericMetricSinkInvalidCharacters(t *testing.T) {
+	_, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:         10,
+		GenericInvalidCharacters: "[",
+	})
+	assert.Error(t, err)
+	_, err = NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:     10,
+		GenericInvalidPolicy: "reject",
+	})
+	assert.Error(t, err)
+	sink, err := NewGenericMetricSinkFromConfig(logrus.New(), nil, nil, "", GenericSinkConfig{
+		GenericBatchSize:         10,
+		GenericInvalidCharacters: "[^a-z]",
+		GenericInvalidPolicy:     InvalidSanitize,
+	})
+	require.NoError(t, err)
+	assert.True(t, sink.InvalidCharacters.MatchString("A"))
+}
@@ -0,0 +1,260 @@
+// Package influxdb implements a metric sink that writes to InfluxDB's
+// /write endpoint in line protocol.
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/backoff"
+	vhttp "github.com/stripe/veneur/http"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// The precisions InfluxDB accepts timestamps in, mapped to the number of
+// nanoseconds in each unit.
+var precisions = map[string]int64{
+	"ns": 1,
+	"u":  int64(time.Microsecond),
+	"ms": int64(time.Millisecond),
+	"s":  int64(time.Second),
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// InfluxDBSink writes metrics to InfluxDB in line protocol. Every metric
+// becomes a point in the measurement named after it, with its tags as the
+// tag set and its value as the float field "value".
+type InfluxDBSink struct {
+	log             *logrus.Logger
+	traceClient     *trace.Client
+	httpClient      *http.Client
+	Tags            []string
+	Address         string
+	Database        string
+	RetentionPolicy string
+	Precision       string
+	BatchSize       int
+
+	// MaxRetries is the number of times a failed batch is re-sent before
+	// giving up on it. Zero disables retries.
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry; each
+	// subsequent retry doubles it, up to RetryMaxDelay.
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps the delay between two retries. Zero means
+	// no cap.
+	RetryMaxDelay time.Duration
+
+	excludedTags []string
+
+	// writeURL is the /write endpoint with the database, retention policy
+	// and precision filled in.
+	writeURL string
+}
+
+var _ sinks.MetricSink = &InfluxDBSink{}
+
+// NewInfluxDBSink returns a new InfluxDB sink writing to the server at
+// address. precision is one of "ns", "u", "ms" or "s"; the empty string
+// means "ns". The retention policy may be empty to use the database's
+// default one.
+func NewInfluxDBSink(
+	log *logrus.Logger,
+	httpClient *http.Client,
+	tags []string,
+	address string,
+	database string,
+	retentionPolicy string,
+	precision string,
+	batchSize int,
+	maxRetries int,
+	retryBaseDelay time.Duration,
+	retryMaxDelay time.Duration,
+) (*InfluxDBSink, error) {
+	if precision == "" {
+		precision = "ns"
+	}
+	if _, ok := precisions[precision]; !ok {
+		return nil, fmt.Errorf("unknown precision %q", precision)
+	}
+	if database == "" {
+		return nil, fmt.Errorf("the InfluxDB sink needs a database to write to")
+	}
+
+	query := url.Values{}
+	query.Set("db", database)
+	if retentionPolicy != "" {
+		query.Set("rp", retentionPolicy)
+	}
+	query.Set("precision", precision)
+
+	return &InfluxDBSink{
+		log:             log,
+		httpClient:      httpClient,
+		Tags:            tags,
+		Address:         address,
+		Database:        database,
+		RetentionPolicy: retentionPolicy,
+		Precision:       precision,
+		BatchSize:       batchSize,
+		MaxRetries:      maxRetries,
+		RetryBaseDelay:  retryBaseDelay,
+		RetryMaxDelay:   retryMaxDelay,
+		writeURL:        strings.TrimRight(address, "/") + "/write?" + query.Encode(),
+	}, nil
+}
+
+// Name returns the sink's name.
+func (i *InfluxDBSink) Name() string {
+	return "influxdb"
+}
+
+// SetExcludedTags sets the excluded tag names. Any tags with the provided
+// key (name) will be excluded.
+func (i *InfluxDBSink) SetExcludedTags(excludes []string) {
+	i.excludedTags = excludes
+}
+
+// Start sets the trace client for the sink.
+func (i *InfluxDBSink) Start(client *trace.Client) error {
+	i.traceClient = client
+	return nil
+}
+
+// Flush writes metrics in batches of at most BatchSize points. Every batch
+// is attempted, even if an earlier one failed; the last error is returned.
+func (i *InfluxDBSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	lines := i.convert(interMetrics)
+
+	var flushErr error
+	for len(lines) > 0 {
+		batchSize := i.BatchSize
+		if batchSize < 1 || len(lines) < batchSize {
+			batchSize = len(lines)
+		}
+		if err := i.flushBatch(ctx, lines[:batchSize]); err != nil {
+			flushErr = err
+		}
+		lines = lines[batchSize:]
+	}
+	return flushErr
+}
+
+// flushBatch POSTs a single batch of points, retrying with exponential
+// backoff up to MaxRetries times. It gives up early if ctx is cancelled.
+func (i *InfluxDBSink) flushBatch(ctx context.Context, batch []string) error {
+	body := []byte(strings.Join(batch, "\n"))
+	headers := map[string]string{"Content-Type": "text/plain; charset=utf-8"}
+
+	samples := &ssf.Samples{}
+	defer metrics.Report(i.traceClient, samples)
+	tags := map[string]string{"sink": i.Name()}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		postStart := time.Now()
+		err = vhttp.PostRawHelper(ctx, i.httpClient, i.traceClient, http.MethodPost, i.writeURL, body, headers, "flush_metrics", nil, i.log)
+		samples.Add(ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(postStart), time.Nanosecond, tags))
+		if err == nil {
+			samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(batch)), tags))
+			i.log.WithField("points", len(batch)).Info("Completed flushing InfluxDB batch")
+			return nil
+		}
+		if attempt >= i.MaxRetries {
+			break
+		}
+		if statusErr, ok := err.(*vhttp.StatusError); ok && !statusErr.Temporary() {
+			break
+		}
+		if err = i.waitForRetry(ctx, attempt); err != nil {
+			break
+		}
+	}
+	i.log.WithFields(logrus.Fields{
+		"points":        len(batch),
+		logrus.ErrorKey: err,
+	}).Warn("Error flushing InfluxDB batch")
+	return err
+}
+
+// waitForRetry blocks until it's time for the retry following the given
+// attempt, returning early with the context's error if ctx is cancelled.
+func (i *InfluxDBSink) waitForRetry(ctx context.Context, attempt int) error {
+	return backoff.Wait(ctx, backoff.Exponential{Base: i.RetryBaseDelay, Max: i.RetryMaxDelay}, attempt)
+}
+
+// convert turns metrics into lines of line protocol. Metrics whose value
+// isn't finite are dropped, since InfluxDB rejects them.
+func (i *InfluxDBSink) convert(interMetrics []samplers.InterMetric) []string {
+	lines := make([]string, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if !sinks.IsAcceptableMetric(metric, i) {
+			continue
+		}
+		if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+			continue
+		}
+		lines = append(lines, i.line(metric))
+	}
+	return lines
+}
+
+// line formats a single point:
+// measurement,tag=value,... value=<float> <timestamp>
+func (i *InfluxDBSink) line(metric samplers.InterMetric) string {
+	// metric.Tags is shared with the other sinks, so we mustn't append
+	// to it in place
+	tags := make([]string, 0, len(metric.Tags)+len(i.Tags))
+	tags = append(tags, metric.Tags...)
+	tags = append(tags, i.Tags...)
+	tagMap := samplers.ParseTagSliceToMap(tags)
+	for _, excluded := range i.excludedTags {
+		delete(tagMap, excluded)
+	}
+	keys := make([]string, 0, len(tagMap))
+	for k, v := range tagMap {
+		// InfluxDB doesn't allow empty tag keys or values
+		if k == "" || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	// InfluxDB ingests points fastest with their tags sorted by key
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.WriteString(measurementEscaper.Replace(metric.Name))
+	for _, k := range keys {
+		b.WriteByte(',')
+		b.WriteString(tagEscaper.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(tagEscaper.Replace(tagMap[k]))
+	}
+	b.WriteString(" value=")
+	b.WriteString(strconv.FormatFloat(metric.Value, 'g', -1, 64))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(metric.Timestamp*int64(time.Second)/precisions[i.Precision], 10))
+	return b.String()
+}
+
+// FlushOtherSamples does nothing, since InfluxDB has no notion of events
+// or service checks.
+func (i *InfluxDBSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
@@ -0,0 +1,78 @@
+package influxdb
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestLine(t *testing.T) {
+	sink, err := NewInfluxDBSink(logrus.New(), http.DefaultClient, []string{"host:fnord"}, "http://localhost:8086", "veneur", "", "s", 0, 0, 0, 0)
+	require.NoError(t, err)
+	sink.SetExcludedTags([]string{"secret"})
+
+	line := sink.line(samplers.InterMetric{
+		Name:      "a.b c,d",
+		Timestamp: 1476119058,
+		Value:     2.5,
+		Tags:      []string{"z:last", "a:with space", "secret:x", "e=q:1,2", "empty:"},
+		Type:      samplers.GaugeMetric,
+	})
+	assert.Equal(t, `a.b\ c\,d,a=with\ space,e\=q=1\,2,host=fnord,z=last value=2.5 1476119058`, line)
+}
+
+func TestPrecision(t *testing.T) {
+	for precision, expected := range map[string]string{
+		"":   "1476119058000000000",
+		"ns": "1476119058000000000",
+		"u":  "1476119058000000",
+		"ms": "1476119058000",
+		"s":  "1476119058",
+	} {
+		sink, err := NewInfluxDBSink(logrus.New(), http.DefaultClient, nil, "", "veneur", "", precision, 0, 0, 0, 0)
+		require.NoError(t, err)
+		line := sink.line(samplers.InterMetric{Name: "a", Timestamp: 1476119058, Value: 1})
+		assert.Equal(t, "a value=1 "+expected, line, "precision %q", precision)
+	}
+
+	_, err := NewInfluxDBSink(logrus.New(), http.DefaultClient, nil, "", "veneur", "", "h", 0, 0, 0, 0)
+	assert.Error(t, err)
+}
+
+func TestFlush(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/write", r.URL.Path)
+		assert.Equal(t, "veneur", r.URL.Query().Get("db"))
+		assert.Equal(t, "weekly", r.URL.Query().Get("rp"))
+		assert.Equal(t, "ms", r.URL.Query().Get("precision"))
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewInfluxDBSink(logrus.New(), http.DefaultClient, nil, server.URL+"/", "veneur", "weekly", "ms", 2, 0, 0, 0)
+	require.NoError(t, err)
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{Name: "a", Timestamp: 1, Value: 1, Type: samplers.CounterMetric},
+		{Name: "b", Timestamp: 1, Value: math.NaN(), Type: samplers.GaugeMetric},
+		{Name: "c", Timestamp: 1, Value: 3, Type: samplers.GaugeMetric},
+		{Name: "d", Timestamp: 1, Value: 4, Type: samplers.GaugeMetric},
+	})
+	require.NoError(t, err)
+	require.Len(t, bodies, 2)
+	assert.Equal(t, []string{"a value=1 1000", "c value=3 1000"}, strings.Split(bodies[0], "\n"))
+	assert.Equal(t, "d value=4 1000", bodies[1])
+}
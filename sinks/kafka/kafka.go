@@ -10,6 +10,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/gogo/protobuf/proto"
 	gometrics "github.com/rcrowley/go-metrics"
 	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/protocol/dogstatsd"
 	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/sinks"
 	"github.com/stripe/veneur/ssf"
@@ -44,6 +46,12 @@ type KafkaMetricSink struct {
 	brokers     string
 	config      *sarama.Config
 	traceClient *trace.Client
+
+	// errMtx guards the errors the producer reported since the last
+	// flush, which are collected in the background.
+	errMtx         sync.Mutex
+	producerErrors int
+	lastErr        error
 }
 
 type KafkaSpanSink struct {
@@ -81,6 +89,9 @@ func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string,
 	}
 
 	config, _ := newProducerConfig(ll, ackRequirement, partitioner, retries, bufferBytes, bufferMessages, finalBufferDuration)
+	// Delivery errors are collected in the background and reported by
+	// the next flush.
+	config.Producer.Return.Errors = true
 
 	ll.WithFields(logrus.Fields{
 		"brokers":         brokers,
@@ -165,6 +176,7 @@ func newConfiguredProducer(logger *logrus.Entry, brokerString string, config *sa
 
 	if err != nil {
 		logger.Error("Error Connecting to Kafka. client error: ", err)
+		return nil, err
 	}
 
 	return producer, nil
@@ -181,18 +193,53 @@ func (k *KafkaMetricSink) Start(cl *trace.Client) error {
 	if err != nil {
 		return err
 	}
-	k.producer = producer
+	k.setProducer(producer)
 	return nil
 }
 
-// Flush sends a slice of metrics to Kafka
+// setProducer makes the sink produce with producer, collecting the errors
+// it reports until the producer is closed.
+func (k *KafkaMetricSink) setProducer(producer sarama.AsyncProducer) {
+	k.producer = producer
+	go func() {
+		for perr := range producer.Errors() {
+			k.errMtx.Lock()
+			k.producerErrors++
+			k.lastErr = perr.Err
+			k.errMtx.Unlock()
+		}
+	}()
+}
+
+// producerError returns an error summarizing the messages the producer
+// failed to deliver since it was last called, if there were any.
+func (k *KafkaMetricSink) producerError() error {
+	k.errMtx.Lock()
+	defer k.errMtx.Unlock()
+	if k.producerErrors == 0 {
+		return nil
+	}
+	err := fmt.Errorf("failed to produce %d messages, last error: %v", k.producerErrors, k.lastErr)
+	k.producerErrors = 0
+	k.lastErr = nil
+	return err
+}
+
+// Flush sends a slice of metrics to Kafka. Each metric is sent as its own
+// message, keyed by the metric's name so that every series always lands on
+// the same partition. Status checks go to the check topic, and all other
+// metrics to the metric topic; metrics whose topic isn't configured are
+// dropped.
+//
+// The producer delivers messages asynchronously, so Flush returns an
+// error if any messages failed to be delivered since the previous flush.
 func (k *KafkaMetricSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
 	samples := &ssf.Samples{}
 	defer metrics.Report(k.traceClient, samples)
 
 	if len(interMetrics) == 0 {
 		k.logger.Info("Nothing to flush, skipping.")
-		return nil
+		return k.producerError()
 	}
 
 	successes := int64(0)
@@ -200,6 +247,13 @@ func (k *KafkaMetricSink) Flush(ctx context.Context, interMetrics []samplers.Int
 		if !sinks.IsAcceptableMetric(metric, k) {
 			continue
 		}
+		topic := k.metricTopic
+		if metric.Type == samplers.StatusMetric {
+			topic = k.checkTopic
+		}
+		if topic == "" {
+			continue
+		}
 
 		k.logger.Debug("Emitting Metric: ", metric.Name)
 		j, err := json.Marshal(metric)
@@ -210,18 +264,44 @@ func (k *KafkaMetricSink) Flush(ctx context.Context, interMetrics []samplers.Int
 		}
 
 		k.producer.Input() <- &sarama.ProducerMessage{
-			Topic: k.metricTopic,
+			Topic: topic,
+			Key:   sarama.StringEncoder(metric.Name),
 			Value: sarama.StringEncoder(j),
 		}
 		successes++
 	}
 	samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(successes), map[string]string{"sink": k.Name()}))
+
+	if err := k.producerError(); err != nil {
+		k.logger.WithError(err).Warn("Error producing metrics to Kafka")
+		samples.Add(ssf.Count("kafka.produce.error_total", 1, nil))
+		return err
+	}
 	return nil
 }
 
-// FlushOtherSamples flushes non-metric, non-span samples
+// FlushOtherSamples sends events to the event topic, as JSON-encoded SSF
+// samples keyed by their name. Nothing is sent if no event topic is
+// configured.
 func (k *KafkaMetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
-	// TODO
+	if k.eventTopic == "" {
+		return
+	}
+	for _, sample := range samples {
+		if _, ok := sample.Tags[dogstatsd.EventIdentifierKey]; !ok {
+			continue
+		}
+		j, err := json.Marshal(sample)
+		if err != nil {
+			k.logger.WithError(err).Error("Error marshalling event: ", sample.Name)
+			continue
+		}
+		k.producer.Input() <- &sarama.ProducerMessage{
+			Topic: k.eventTopic,
+			Key:   sarama.StringEncoder(sample.Name),
+			Value: sarama.StringEncoder(j),
+		}
+	}
 }
 
 // NewKafkaSpanSink creates a new Kafka Plugin.
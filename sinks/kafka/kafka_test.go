@@ -11,6 +11,7 @@ import (
 	"github.com/gogo/protobuf/proto"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stripe/veneur/protocol/dogstatsd"
 	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
@@ -147,6 +148,87 @@ func TestMetricFlushRouting(t *testing.T) {
 	}
 }
 
+func TestMetricFlushKeysAndTopics(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	producerMock := mocks.NewAsyncProducer(t, config)
+	producerMock.ExpectInputAndSucceed()
+	producerMock.ExpectInputAndSucceed()
+
+	sink, err := NewKafkaMetricSink(logrus.StandardLogger(), nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", 0, 0, 0, "")
+	assert.NoError(t, err)
+	sink.setProducer(producerMock)
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{Name: "a.b.c", Type: samplers.GaugeMetric},
+		{Name: "a.b.check", Type: samplers.StatusMetric},
+	})
+	assert.NoError(t, err)
+
+	metricMsg := <-producerMock.Successes()
+	assert.Equal(t, "testMetricTopic", metricMsg.Topic)
+	key, err := metricMsg.Key.Encode()
+	assert.NoError(t, err)
+	assert.Equal(t, "a.b.c", string(key), "messages should be keyed by metric name")
+
+	checkMsg := <-producerMock.Successes()
+	assert.Equal(t, "testCheckTopic", checkMsg.Topic)
+}
+
+func TestMetricFlushProducerErrors(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Errors = true
+	producerMock := mocks.NewAsyncProducer(t, config)
+	producerMock.ExpectInputAndFail(sarama.ErrNotLeaderForPartition)
+
+	sink, err := NewKafkaMetricSink(logrus.StandardLogger(), nil, "testing", "", "", "testMetricTopic", "all", "hash", 0, 0, 0, "")
+	assert.NoError(t, err)
+	sink.setProducer(producerMock)
+
+	sink.Flush(context.Background(), []samplers.InterMetric{{Name: "a.b.c", Type: samplers.GaugeMetric}})
+	// Errors are reported asynchronously; wait until the sink has
+	// collected this one.
+	producerMock.Close()
+	for i := 0; i < 100; i++ {
+		sink.errMtx.Lock()
+		collected := sink.producerErrors
+		sink.errMtx.Unlock()
+		if collected > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = sink.Flush(context.Background(), nil)
+	if assert.Error(t, err, "the next flush should report the failed message") {
+		assert.Contains(t, err.Error(), sarama.ErrNotLeaderForPartition.Error())
+	}
+	assert.NoError(t, sink.Flush(context.Background(), nil), "errors should only be reported once")
+}
+
+func TestFlushOtherSamplesEvents(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	producerMock := mocks.NewAsyncProducer(t, config)
+	producerMock.ExpectInputAndSucceed()
+
+	sink, err := NewKafkaMetricSink(logrus.StandardLogger(), nil, "testing", "", "testEventTopic", "testMetricTopic", "all", "hash", 0, 0, 0, "")
+	assert.NoError(t, err)
+	sink.setProducer(producerMock)
+
+	sink.FlushOtherSamples(context.Background(), []ssf.SSFSample{
+		{Name: "an.event", Message: "hi", Tags: map[string]string{dogstatsd.EventIdentifierKey: ""}},
+		{Name: "not.an.event"},
+	})
+	producerMock.Close()
+
+	msg := <-producerMock.Successes()
+	assert.Equal(t, "testEventTopic", msg.Topic)
+	contents, err := msg.Value.Encode()
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "an.event")
+}
+
 func TestMetricConstructor(t *testing.T) {
 	logger := logrus.StandardLogger()
 
@@ -0,0 +1,169 @@
+// Package multi implements a metric sink that duplicates every flush to
+// several other metric sinks, e.g. to compare two backends during a
+// migration.
+package multi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// SinkError is the error a single sink returned from a flush.
+type SinkError struct {
+	Sink string
+	Err  error
+}
+
+// Errors holds the errors of all the sinks a flush failed on.
+type Errors []SinkError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Sink + ": " + err.Err.Error()
+	}
+	return fmt.Sprintf("%d sinks failed to flush: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// MultiSink passes every flush on to each of its sinks. Each sink still
+// decides for itself which metrics it accepts, by its own name.
+type MultiSink struct {
+	sinks []sinks.MetricSink
+
+	// Parallel makes the sinks flush at the same time, rather than one
+	// after another.
+	Parallel bool
+}
+
+var _ sinks.MetricSink = &MultiSink{}
+
+// NewMultiSink returns a sink duplicating flushes to all of the given
+// sinks.
+func NewMultiSink(parallel bool, metricSinks ...sinks.MetricSink) *MultiSink {
+	return &MultiSink{sinks: metricSinks, Parallel: parallel}
+}
+
+// Name returns "multi", followed by the names of the sinks in brackets,
+// e.g. "multi[datadog,generic]".
+func (m *MultiSink) Name() string {
+	names := make([]string, len(m.sinks))
+	for i, sink := range m.sinks {
+		names[i] = sink.Name()
+	}
+	return "multi[" + strings.Join(names, ",") + "]"
+}
+
+// SetExcludedTags passes the excluded tag names on to every sink that
+// supports them.
+func (m *MultiSink) SetExcludedTags(excludes []string) {
+	for _, sink := range m.sinks {
+		if excludable, ok := sink.(interface{ SetExcludedTags([]string) }); ok {
+			excludable.SetExcludedTags(excludes)
+		}
+	}
+}
+
+// Start starts every sink, stopping at the first one that fails.
+func (m *MultiSink) Start(client *trace.Client) error {
+	for _, sink := range m.sinks {
+		if err := sink.Start(client); err != nil {
+			return fmt.Errorf("starting %s: %v", sink.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Stop stops every sink that needs stopping.
+func (m *MultiSink) Stop() {
+	for _, sink := range m.sinks {
+		if stopper, ok := sink.(interface{ Stop() }); ok {
+			stopper.Stop()
+		}
+	}
+}
+
+// Drain drains every sink that can be drained, returning the first
+// error.
+func (m *MultiSink) Drain(ctx context.Context) error {
+	var firstErr error
+	for _, sink := range m.sinks {
+		if drainer, ok := sink.(interface {
+			Drain(context.Context) error
+		}); ok {
+			if err := drainer.Drain(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// FlushesSketches reports whether any of the sinks flushes sketches.
+func (m *MultiSink) FlushesSketches() bool {
+	for _, sink := range m.sinks {
+		if sinks.FlushesSketches(sink) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush flushes the metrics to every sink. If any of them fail, an Errors
+// holding all their errors is returned. Sketches are only flushed to the
+// sinks that flush sketches.
+func (m *MultiSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	withoutSketches := sinks.WithoutSketches(metrics)
+	errs := make([]error, len(m.sinks))
+	m.each(func(i int, sink sinks.MetricSink) {
+		if sinks.FlushesSketches(sink) {
+			errs[i] = sink.Flush(ctx, metrics)
+		} else {
+			errs[i] = sink.Flush(ctx, withoutSketches)
+		}
+	})
+
+	var flushErr Errors
+	for i, err := range errs {
+		if err != nil {
+			flushErr = append(flushErr, SinkError{Sink: m.sinks[i].Name(), Err: err})
+		}
+	}
+	if len(flushErr) > 0 {
+		return flushErr
+	}
+	return nil
+}
+
+// FlushOtherSamples passes the samples on to every sink.
+func (m *MultiSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+	m.each(func(i int, sink sinks.MetricSink) {
+		sink.FlushOtherSamples(ctx, samples)
+	})
+}
+
+// each calls f for every sink, in parallel if Parallel is set, and
+// returns once all calls are done.
+func (m *MultiSink) each(f func(int, sinks.MetricSink)) {
+	if !m.Parallel {
+		for i, sink := range m.sinks {
+			f(i, sink)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for i, sink := range m.sinks {
+		wg.Add(1)
+		go func(i int, sink sinks.MetricSink) {
+			defer wg.Done()
+			f(i, sink)
+		}(i, sink)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,88 @@
+package multi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// recordingSink remembers what it was flushed, and fails every flush with
+// err if it's set.
+type recordingSink struct {
+	name    string
+	err     error
+	mtx     sync.Mutex
+	metrics []samplers.InterMetric
+	samples []ssf.SSFSample
+}
+
+func (r *recordingSink) Name() string              { return r.name }
+func (r *recordingSink) Start(*trace.Client) error { return nil }
+func (r *recordingSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.metrics = append(r.metrics, metrics...)
+	return r.err
+}
+func (r *recordingSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.samples = append(r.samples, samples...)
+}
+
+func TestFanOut(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		a := &recordingSink{name: "a"}
+		b := &recordingSink{name: "b"}
+		sink := NewMultiSink(parallel, a, b)
+		assert.Equal(t, "multi[a,b]", sink.Name())
+
+		metrics := []samplers.InterMetric{{Name: "a.b.c"}}
+		samples := []ssf.SSFSample{{Name: "an.event"}}
+		require.NoError(t, sink.Flush(context.Background(), metrics))
+		sink.FlushOtherSamples(context.Background(), samples)
+
+		for _, inner := range []*recordingSink{a, b} {
+			assert.Equal(t, metrics, inner.metrics, "parallel: %v", parallel)
+			assert.Equal(t, samples, inner.samples, "parallel: %v", parallel)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	failed := errors.New("nope")
+	sink := NewMultiSink(true, &recordingSink{name: "a", err: failed}, &recordingSink{name: "b"}, &recordingSink{name: "c", err: failed})
+
+	err := sink.Flush(context.Background(), nil)
+	require.Error(t, err)
+	assert.Equal(t, Errors{{Sink: "a", Err: failed}, {Sink: "c", Err: failed}}, err)
+	assert.Equal(t, "2 sinks failed to flush: a: nope; c: nope", err.Error())
+}
+
+// sketchSink is a recordingSink that can flush sketches.
+type sketchSink struct{ recordingSink }
+
+func (s *sketchSink) FlushesSketches() bool { return true }
+
+func TestSketchesOnlyToSketchSinks(t *testing.T) {
+	a := &recordingSink{name: "a"}
+	b := &sketchSink{recordingSink{name: "b"}}
+	sink := NewMultiSink(false, a, b)
+	assert.True(t, sink.FlushesSketches())
+	assert.False(t, NewMultiSink(false, a).FlushesSketches())
+
+	metrics := []samplers.InterMetric{
+		{Name: "a.b.c", Type: samplers.SketchMetric},
+		{Name: "a.b.d", Type: samplers.GaugeMetric},
+	}
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	assert.Equal(t, metrics[1:], a.metrics)
+	assert.Equal(t, metrics, b.metrics)
+}
@@ -0,0 +1,258 @@
+package otlp
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/sirupsen/logrus"
+	vhttp "github.com/stripe/veneur/http"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/tdigest"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// scopeName is the instrumentation scope all metrics are exported under.
+const scopeName = "veneur"
+
+// MetricSink exports metrics to an OpenTelemetry collector (or anything
+// else speaking OTLP) over OTLP/HTTP, encoded as protobuf.
+//
+// Counters are exported as monotonic delta Sums, the histograms and timers
+// listed in sketch_histograms as delta Histograms, and everything else as
+// Gauges. Veneur flushes other histograms and timers as separate metrics
+// for each of their aggregates and percentiles, so those end up as Gauges
+// too.
+type MetricSink struct {
+	log         *logrus.Logger
+	traceClient *trace.Client
+	httpClient  *http.Client
+	interval    time.Duration
+	Endpoint    string
+	BatchSize   int
+
+	// resource holds the server's tags, which are exported as the
+	// attributes of the resource every metric belongs to.
+	resource *Resource
+
+	excludedTags map[string]struct{}
+}
+
+var _ sinks.MetricSink = &MetricSink{}
+
+// NewMetricSink returns a new OTLP metric sink. The server's tags become
+// resource attributes, and interval is the length of the period each
+// counter's value covers.
+func NewMetricSink(log *logrus.Logger, httpClient *http.Client, tags []string, interval time.Duration, endpoint string, batchSize int) (*MetricSink, error) {
+	return &MetricSink{
+		log:        log,
+		httpClient: httpClient,
+		interval:   interval,
+		Endpoint:   endpoint,
+		BatchSize:  batchSize,
+		resource:   &Resource{Attributes: attributes(samplers.ParseTagSliceToMap(tags), nil)},
+	}, nil
+}
+
+// Name returns the sink's name.
+func (o *MetricSink) Name() string {
+	return "otlp"
+}
+
+// SetExcludedTags sets the excluded tag names. Any tags with the provided
+// key (name) will be excluded.
+func (o *MetricSink) SetExcludedTags(excludes []string) {
+	excludedTags := make(map[string]struct{}, len(excludes))
+	for _, tag := range excludes {
+		excludedTags[tag] = struct{}{}
+	}
+	o.excludedTags = excludedTags
+}
+
+// FlushesSketches reports that the sink flushes sketches, which it exports
+// as Histograms.
+func (o *MetricSink) FlushesSketches() bool {
+	return true
+}
+
+// Start sets the trace client for the sink.
+func (o *MetricSink) Start(client *trace.Client) error {
+	o.traceClient = client
+	return nil
+}
+
+// Flush exports metrics in batches of at most BatchSize metrics. Every
+// batch is attempted, even if an earlier one failed; the last error is
+// returned.
+func (o *MetricSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	converted := o.convert(interMetrics)
+
+	var flushErr error
+	for len(converted) > 0 {
+		batchSize := o.BatchSize
+		if batchSize < 1 || len(converted) < batchSize {
+			batchSize = len(converted)
+		}
+		if err := o.flushBatch(ctx, converted[:batchSize]); err != nil {
+			flushErr = err
+		}
+		converted = converted[batchSize:]
+	}
+	return flushErr
+}
+
+func (o *MetricSink) flushBatch(ctx context.Context, batch []*Metric) error {
+	body, err := proto.Marshal(o.request(batch))
+	if err != nil {
+		o.log.WithError(err).Error("Could not encode OTLP export request")
+		return err
+	}
+
+	samples := &ssf.Samples{}
+	defer metrics.Report(o.traceClient, samples)
+	tags := map[string]string{"sink": o.Name()}
+
+	postStart := time.Now()
+	err = vhttp.PostRawHelper(
+		ctx,
+		o.httpClient,
+		o.traceClient,
+		http.MethodPost,
+		o.Endpoint,
+		body,
+		map[string]string{"Content-Type": "application/x-protobuf"},
+		"flush_metrics",
+		nil,
+		o.log,
+	)
+	samples.Add(ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(postStart), time.Nanosecond, tags))
+	if err != nil {
+		o.log.WithError(err).WithField("metrics", len(batch)).Warn("Error exporting OTLP metrics")
+		return err
+	}
+	samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(batch)), tags))
+	o.log.WithField("metrics", len(batch)).Info("Completed exporting OTLP metrics")
+	return nil
+}
+
+// request wraps a batch of metrics in an export request.
+func (o *MetricSink) request(batch []*Metric) *ExportMetricsServiceRequest {
+	return &ExportMetricsServiceRequest{
+		ResourceMetrics: []*ResourceMetrics{{
+			Resource: o.resource,
+			ScopeMetrics: []*ScopeMetrics{{
+				Scope:   &InstrumentationScope{Name: scopeName},
+				Metrics: batch,
+			}},
+		}},
+	}
+}
+
+// convert turns each InterMetric into an OTLP metric with a single data
+// point. Sketches that can't be decoded are left out.
+func (o *MetricSink) convert(interMetrics []samplers.InterMetric) []*Metric {
+	converted := make([]*Metric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if !sinks.IsAcceptableMetric(metric, o) {
+			continue
+		}
+		end := time.Unix(metric.Timestamp, 0)
+		attrs := attributes(samplers.ParseTagSliceToMap(metric.Tags), o.excludedTags)
+		m := &Metric{Name: metric.Name, Description: metric.Description, Unit: metric.Unit}
+		if metric.Type == samplers.SketchMetric {
+			point, err := histogramPoint(metric.Sketch)
+			if err != nil {
+				o.log.WithError(err).WithField("metric", metric.Name).Warn("Could not decode the sketch of an OTLP histogram, dropping it")
+				continue
+			}
+			point.StartTimeUnixNano = uint64(end.Add(-o.interval).UnixNano())
+			point.TimeUnixNano = uint64(end.UnixNano())
+			point.Attributes = attrs
+			m.Histogram = &Histogram{
+				DataPoints:             []*HistogramDataPoint{point},
+				AggregationTemporality: AggregationTemporalityDelta,
+			}
+			converted = append(converted, m)
+			continue
+		}
+
+		value := metric.Value
+		point := &NumberDataPoint{
+			TimeUnixNano: uint64(end.UnixNano()),
+			AsDouble:     &value,
+			Attributes:   attrs,
+		}
+		if metric.Type == samplers.CounterMetric {
+			point.StartTimeUnixNano = uint64(end.Add(-o.interval).UnixNano())
+			m.Sum = &Sum{
+				DataPoints:             []*NumberDataPoint{point},
+				AggregationTemporality: AggregationTemporalityDelta,
+				IsMonotonic:            true,
+			}
+		} else {
+			m.Gauge = &Gauge{DataPoints: []*NumberDataPoint{point}}
+		}
+		converted = append(converted, m)
+	}
+	return converted
+}
+
+// histogramPoint converts a serialized t-digest into a histogram data
+// point with a bucket per centroid, bounded halfway between the centroid
+// and the next one, like the t-digest assumes its samples are spread.
+func histogramPoint(sketch []byte) (*HistogramDataPoint, error) {
+	var data tdigest.MergingDigestData
+	if err := data.Unmarshal(sketch); err != nil {
+		return nil, err
+	}
+	point := &HistogramDataPoint{}
+	if len(data.MainCentroids) == 0 {
+		return point, nil
+	}
+	var sum float64
+	point.BucketCounts = []uint64{0}
+	for i, centroid := range data.MainCentroids {
+		// bounds must increase, so centroids with the same mean share a
+		// bucket
+		if i > 0 && centroid.Mean > data.MainCentroids[i-1].Mean {
+			point.ExplicitBounds = append(point.ExplicitBounds, (data.MainCentroids[i-1].Mean+centroid.Mean)/2)
+			point.BucketCounts = append(point.BucketCounts, 0)
+		}
+		count := uint64(math.Round(centroid.Weight))
+		point.BucketCounts[len(point.BucketCounts)-1] += count
+		point.Count += count
+		sum += centroid.Mean * centroid.Weight
+	}
+	point.Sum = &sum
+	point.Min = &data.Min
+	point.Max = &data.Max
+	return point, nil
+}
+
+// attributes converts tags to attributes, sorted by key, leaving out the
+// excluded ones.
+func attributes(tags map[string]string, excluded map[string]struct{}) []*KeyValue {
+	attrs := make([]*KeyValue, 0, len(tags))
+	for k, v := range tags {
+		if _, ok := excluded[k]; ok {
+			continue
+		}
+		v := v
+		attrs = append(attrs, &KeyValue{Key: k, Value: &AnyValue{StringValue: &v}})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].Key < attrs[j].Key
+	})
+	return attrs
+}
+
+// FlushOtherSamples does nothing for now; OTLP has no direct equivalent of
+// events and service checks.
+func (o *MetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
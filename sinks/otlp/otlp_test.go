@@ -0,0 +1,149 @@
+package otlp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/tdigest"
+)
+
+func testMetrics() []samplers.InterMetric {
+	ts := time.Date(1955, time.November, 5, 6, 0, 0, 0, time.UTC).Unix()
+	return []samplers.InterMetric{
+		{
+			Name:      "a.b.counter",
+			Timestamp: ts,
+			Value:     2,
+			Tags:      []string{"foo:bar", "baz:qux"},
+			Type:      samplers.CounterMetric,
+		},
+		{
+			Name:      "a.b.gauge",
+			Timestamp: ts,
+			Value:     0,
+			Tags:      []string{"foo:bar"},
+			Type:      samplers.GaugeMetric,
+		},
+	}
+}
+
+func stringValue(s string) *AnyValue {
+	return &AnyValue{StringValue: &s}
+}
+
+func TestConvert(t *testing.T) {
+	sink, err := NewMetricSink(logrus.New(), http.DefaultClient, nil, 10*time.Second, "", 10)
+	require.NoError(t, err)
+	sink.SetExcludedTags([]string{"baz"})
+
+	metrics := testMetrics()
+	metrics[0].Description = "How many things happened."
+	metrics[0].Unit = "requests"
+	converted := sink.convert(metrics)
+	require.Len(t, converted, 2)
+
+	ts := time.Unix(testMetrics()[0].Timestamp, 0)
+	counter := converted[0]
+	assert.Equal(t, "a.b.counter", counter.Name)
+	assert.Equal(t, "How many things happened.", counter.Description)
+	assert.Equal(t, "requests", counter.Unit)
+	assert.Nil(t, counter.Gauge)
+	if assert.NotNil(t, counter.Sum) {
+		assert.True(t, counter.Sum.IsMonotonic)
+		assert.Equal(t, AggregationTemporalityDelta, counter.Sum.AggregationTemporality)
+		point := counter.Sum.DataPoints[0]
+		assert.Equal(t, float64(2), *point.AsDouble)
+		assert.Equal(t, uint64(ts.UnixNano()), point.TimeUnixNano)
+		assert.Equal(t, uint64(ts.Add(-10*time.Second).UnixNano()), point.StartTimeUnixNano)
+		assert.Equal(t, []*KeyValue{{Key: "foo", Value: stringValue("bar")}}, point.Attributes)
+	}
+
+	gauge := converted[1]
+	assert.Nil(t, gauge.Sum)
+	if assert.NotNil(t, gauge.Gauge) {
+		assert.Equal(t, float64(0), *gauge.Gauge.DataPoints[0].AsDouble)
+	}
+}
+
+func TestConvertSketch(t *testing.T) {
+	sink, err := NewMetricSink(logrus.New(), http.DefaultClient, nil, 10*time.Second, "", 10)
+	require.NoError(t, err)
+	assert.True(t, sink.FlushesSketches())
+
+	digest := tdigest.NewMerging(100, false)
+	for _, value := range []float64{1, 2, 2, 5} {
+		digest.Add(value, 1)
+	}
+	sketch, err := digest.Data().Marshal()
+	require.NoError(t, err)
+	metric := testMetrics()[0]
+	metric.Name = "a.b.histogram"
+	metric.Type = samplers.SketchMetric
+	metric.Value = 4
+	metric.Sketch = sketch
+
+	converted := sink.convert([]samplers.InterMetric{metric, {Name: "broken", Type: samplers.SketchMetric, Sketch: []byte{0xff}}})
+	require.Len(t, converted, 1, "sketches that can't be decoded should be dropped")
+	histogram := converted[0].Histogram
+	require.NotNil(t, histogram)
+	assert.Nil(t, converted[0].Gauge)
+	assert.Equal(t, AggregationTemporalityDelta, histogram.AggregationTemporality)
+
+	// round trip it, to check it encodes
+	encoded, err := proto.Marshal(sink.request(converted))
+	require.NoError(t, err)
+	req := &ExportMetricsServiceRequest{}
+	require.NoError(t, proto.Unmarshal(encoded, req))
+	point := req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Histogram.DataPoints[0]
+
+	ts := time.Unix(metric.Timestamp, 0)
+	assert.Equal(t, uint64(ts.UnixNano()), point.TimeUnixNano)
+	assert.Equal(t, uint64(ts.Add(-10*time.Second).UnixNano()), point.StartTimeUnixNano)
+	assert.Equal(t, uint64(4), point.Count)
+	assert.Equal(t, float64(10), *point.Sum)
+	assert.Equal(t, float64(1), *point.Min)
+	assert.Equal(t, float64(5), *point.Max)
+	assert.Equal(t, []float64{1.5, 3.5}, point.ExplicitBounds)
+	assert.Equal(t, []uint64{1, 2, 1}, point.BucketCounts)
+	assert.Len(t, point.Attributes, 2)
+}
+
+func TestFlush(t *testing.T) {
+	var requests []*ExportMetricsServiceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		req := &ExportMetricsServiceRequest{}
+		require.NoError(t, proto.Unmarshal(body, req))
+		requests = append(requests, req)
+	}))
+	defer server.Close()
+
+	sink, err := NewMetricSink(logrus.New(), http.DefaultClient, []string{"host:fnord"}, 10*time.Second, server.URL, 1)
+	require.NoError(t, err)
+
+	err = sink.Flush(context.Background(), testMetrics())
+	assert.NoError(t, err)
+	require.Len(t, requests, 2, "every metric should be sent in its own batch")
+
+	rm := requests[0].ResourceMetrics[0]
+	assert.Equal(t, []*KeyValue{{Key: "host", Value: stringValue("fnord")}}, rm.Resource.Attributes)
+	assert.Equal(t, "veneur", rm.ScopeMetrics[0].Scope.Name)
+	assert.Equal(t, "a.b.counter", rm.ScopeMetrics[0].Metrics[0].Name)
+
+	gauge := requests[1].ResourceMetrics[0].ScopeMetrics[0].Metrics[0]
+	if assert.NotNil(t, gauge.Gauge) {
+		require.NotNil(t, gauge.Gauge.DataPoints[0].AsDouble, "zero values should still be sent")
+		assert.Equal(t, float64(0), *gauge.Gauge.DataPoints[0].AsDouble)
+	}
+}
@@ -0,0 +1,161 @@
+package otlp
+
+import "github.com/golang/protobuf/proto"
+
+// The types below are the subset of the OpenTelemetry metrics protocol
+// (opentelemetry/proto/collector/metrics/v1 and its dependencies) that
+// this sink sends. Fields that are part of a oneof in the .proto files are
+// declared as optional fields with the same numbers, which encodes
+// identically as long as only one of them is set.
+
+// AggregationTemporality values for Sum.AggregationTemporality.
+const (
+	AggregationTemporalityDelta      int32 = 1
+	AggregationTemporalityCumulative int32 = 2
+)
+
+// ExportMetricsServiceRequest is the body of an OTLP metrics export.
+type ExportMetricsServiceRequest struct {
+	ResourceMetrics []*ResourceMetrics `protobuf:"bytes,1,rep,name=resource_metrics" json:"resource_metrics,omitempty"`
+}
+
+func (m *ExportMetricsServiceRequest) Reset()         { *m = ExportMetricsServiceRequest{} }
+func (m *ExportMetricsServiceRequest) String() string { return proto.CompactTextString(m) }
+func (*ExportMetricsServiceRequest) ProtoMessage()    {}
+
+// ResourceMetrics holds the metrics produced by a single resource.
+type ResourceMetrics struct {
+	Resource     *Resource       `protobuf:"bytes,1,opt,name=resource" json:"resource,omitempty"`
+	ScopeMetrics []*ScopeMetrics `protobuf:"bytes,2,rep,name=scope_metrics" json:"scope_metrics,omitempty"`
+}
+
+func (m *ResourceMetrics) Reset()         { *m = ResourceMetrics{} }
+func (m *ResourceMetrics) String() string { return proto.CompactTextString(m) }
+func (*ResourceMetrics) ProtoMessage()    {}
+
+// Resource describes the entity producing metrics.
+type Resource struct {
+	Attributes []*KeyValue `protobuf:"bytes,1,rep,name=attributes" json:"attributes,omitempty"`
+}
+
+func (m *Resource) Reset()         { *m = Resource{} }
+func (m *Resource) String() string { return proto.CompactTextString(m) }
+func (*Resource) ProtoMessage()    {}
+
+// ScopeMetrics holds the metrics produced by a single instrumentation
+// scope.
+type ScopeMetrics struct {
+	Scope   *InstrumentationScope `protobuf:"bytes,1,opt,name=scope" json:"scope,omitempty"`
+	Metrics []*Metric             `protobuf:"bytes,2,rep,name=metrics" json:"metrics,omitempty"`
+}
+
+func (m *ScopeMetrics) Reset()         { *m = ScopeMetrics{} }
+func (m *ScopeMetrics) String() string { return proto.CompactTextString(m) }
+func (*ScopeMetrics) ProtoMessage()    {}
+
+// InstrumentationScope names what produced a set of metrics.
+type InstrumentationScope struct {
+	Name    string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version string `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+}
+
+func (m *InstrumentationScope) Reset()         { *m = InstrumentationScope{} }
+func (m *InstrumentationScope) String() string { return proto.CompactTextString(m) }
+func (*InstrumentationScope) ProtoMessage()    {}
+
+// KeyValue is an attribute of a resource or a data point.
+type KeyValue struct {
+	Key   string    `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value *AnyValue `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *KeyValue) Reset()         { *m = KeyValue{} }
+func (m *KeyValue) String() string { return proto.CompactTextString(m) }
+func (*KeyValue) ProtoMessage()    {}
+
+// AnyValue is the value of an attribute. Veneur only ever has strings.
+type AnyValue struct {
+	StringValue *string `protobuf:"bytes,1,opt,name=string_value" json:"string_value,omitempty"`
+}
+
+func (m *AnyValue) Reset()         { *m = AnyValue{} }
+func (m *AnyValue) String() string { return proto.CompactTextString(m) }
+func (*AnyValue) ProtoMessage()    {}
+
+// Metric is a named metric and its data points, of which exactly one of
+// Gauge, Sum or Histogram is set.
+type Metric struct {
+	Name        string     `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Description string     `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	Unit        string     `protobuf:"bytes,3,opt,name=unit,proto3" json:"unit,omitempty"`
+	Gauge       *Gauge     `protobuf:"bytes,5,opt,name=gauge" json:"gauge,omitempty"`
+	Sum         *Sum       `protobuf:"bytes,7,opt,name=sum" json:"sum,omitempty"`
+	Histogram   *Histogram `protobuf:"bytes,9,opt,name=histogram" json:"histogram,omitempty"`
+}
+
+func (m *Metric) Reset()         { *m = Metric{} }
+func (m *Metric) String() string { return proto.CompactTextString(m) }
+func (*Metric) ProtoMessage()    {}
+
+// Gauge holds instantaneous measurements.
+type Gauge struct {
+	DataPoints []*NumberDataPoint `protobuf:"bytes,1,rep,name=data_points" json:"data_points,omitempty"`
+}
+
+func (m *Gauge) Reset()         { *m = Gauge{} }
+func (m *Gauge) String() string { return proto.CompactTextString(m) }
+func (*Gauge) ProtoMessage()    {}
+
+// Sum holds measurements that are meant to be added up, like counters.
+type Sum struct {
+	DataPoints             []*NumberDataPoint `protobuf:"bytes,1,rep,name=data_points" json:"data_points,omitempty"`
+	AggregationTemporality int32              `protobuf:"varint,2,opt,name=aggregation_temporality,proto3" json:"aggregation_temporality,omitempty"`
+	IsMonotonic            bool               `protobuf:"varint,3,opt,name=is_monotonic,proto3" json:"is_monotonic,omitempty"`
+}
+
+func (m *Sum) Reset()         { *m = Sum{} }
+func (m *Sum) String() string { return proto.CompactTextString(m) }
+func (*Sum) ProtoMessage()    {}
+
+// NumberDataPoint is a single measurement. Times are in nanoseconds since
+// the epoch.
+type NumberDataPoint struct {
+	StartTimeUnixNano uint64      `protobuf:"fixed64,2,opt,name=start_time_unix_nano,proto3" json:"start_time_unix_nano,omitempty"`
+	TimeUnixNano      uint64      `protobuf:"fixed64,3,opt,name=time_unix_nano,proto3" json:"time_unix_nano,omitempty"`
+	AsDouble          *float64    `protobuf:"fixed64,4,opt,name=as_double" json:"as_double,omitempty"`
+	Attributes        []*KeyValue `protobuf:"bytes,7,rep,name=attributes" json:"attributes,omitempty"`
+}
+
+func (m *NumberDataPoint) Reset()         { *m = NumberDataPoint{} }
+func (m *NumberDataPoint) String() string { return proto.CompactTextString(m) }
+func (*NumberDataPoint) ProtoMessage()    {}
+
+// Histogram holds distributions of measurements, bucketed by explicit
+// bounds.
+type Histogram struct {
+	DataPoints             []*HistogramDataPoint `protobuf:"bytes,1,rep,name=data_points" json:"data_points,omitempty"`
+	AggregationTemporality int32                 `protobuf:"varint,2,opt,name=aggregation_temporality,proto3" json:"aggregation_temporality,omitempty"`
+}
+
+func (m *Histogram) Reset()         { *m = Histogram{} }
+func (m *Histogram) String() string { return proto.CompactTextString(m) }
+func (*Histogram) ProtoMessage()    {}
+
+// HistogramDataPoint is a single distribution. BucketCounts has one more
+// count than ExplicitBounds has bounds: bucket i holds the measurements
+// above bound i-1, up to and including bound i.
+type HistogramDataPoint struct {
+	StartTimeUnixNano uint64      `protobuf:"fixed64,2,opt,name=start_time_unix_nano,proto3" json:"start_time_unix_nano,omitempty"`
+	TimeUnixNano      uint64      `protobuf:"fixed64,3,opt,name=time_unix_nano,proto3" json:"time_unix_nano,omitempty"`
+	Count             uint64      `protobuf:"fixed64,4,opt,name=count,proto3" json:"count,omitempty"`
+	Sum               *float64    `protobuf:"fixed64,5,opt,name=sum" json:"sum,omitempty"`
+	BucketCounts      []uint64    `protobuf:"fixed64,6,rep,packed,name=bucket_counts,proto3" json:"bucket_counts,omitempty"`
+	ExplicitBounds    []float64   `protobuf:"fixed64,7,rep,packed,name=explicit_bounds,proto3" json:"explicit_bounds,omitempty"`
+	Attributes        []*KeyValue `protobuf:"bytes,9,rep,name=attributes" json:"attributes,omitempty"`
+	Min               *float64    `protobuf:"fixed64,11,opt,name=min" json:"min,omitempty"`
+	Max               *float64    `protobuf:"fixed64,12,opt,name=max" json:"max,omitempty"`
+}
+
+func (m *HistogramDataPoint) Reset()         { *m = HistogramDataPoint{} }
+func (m *HistogramDataPoint) String() string { return proto.CompactTextString(m) }
+func (*HistogramDataPoint) ProtoMessage()    {}
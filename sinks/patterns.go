@@ -0,0 +1,35 @@
+package sinks
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchNamePattern returns the value of the pattern in patterns that
+// matches the named metric, and whether any pattern matched it at all.
+// Patterns containing any of `*?[` are globs, as understood by
+// path.Match; other patterns match name prefixes. If several patterns
+// match a name, the longest one wins, and equally long ones are ordered
+// lexically so that the winner doesn't depend on map iteration.
+func MatchNamePattern(patterns map[string]float64, name string) (float64, bool) {
+	value := 0.0
+	matched := false
+	longest := ""
+	for pattern, v := range patterns {
+		var ok bool
+		if strings.ContainsAny(pattern, "*?[") {
+			ok, _ = path.Match(pattern, name)
+		} else {
+			ok = strings.HasPrefix(name, pattern)
+		}
+		if !ok {
+			continue
+		}
+		if !matched || len(pattern) > len(longest) || (len(pattern) == len(longest) && pattern < longest) {
+			value = v
+			matched = true
+			longest = pattern
+		}
+	}
+	return value, matched
+}
@@ -0,0 +1,30 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchNamePattern(t *testing.T) {
+	patterns := map[string]float64{
+		"request.":   1,
+		"request.d*": 2,
+		"request.du": 3,
+		"*.duration": 4,
+	}
+	for name, want := range map[string]float64{
+		"request.count": 1,
+		"request.data":  2,
+		// as long as "request.d*", but lexically first
+		"request.duration": 4,
+		"db.duration":      4,
+	} {
+		got, ok := MatchNamePattern(patterns, name)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, got, name)
+	}
+
+	_, ok := MatchNamePattern(patterns, "db.count")
+	assert.False(t, ok)
+}
@@ -0,0 +1,312 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/backoff"
+	vhttp "github.com/stripe/veneur/http"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// RemoteWriteSink flushes metrics to an endpoint implementing Prometheus'
+// remote write protocol, such as Prometheus itself or Cortex.
+type RemoteWriteSink struct {
+	log         *logrus.Logger
+	traceClient *trace.Client
+	httpClient  *http.Client
+	Tags        []string
+	Endpoint    string
+	BatchSize   int
+
+	// MaxRetries is the number of times a failed batch is re-sent before
+	// giving up on it. Zero disables retries.
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry; each
+	// subsequent retry doubles it, up to RetryMaxDelay.
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps the delay between two retries. Zero means
+	// no cap.
+	RetryMaxDelay time.Duration
+
+	excludedTags []string
+
+	// CounterMode is one of sinks.CounterModeCumulative or
+	// sinks.CounterModeDelta. The empty string means
+	// sinks.CounterModeCumulative, since Prometheus expects counters to
+	// only ever go up, while veneur flushes the increments of each
+	// interval.
+	CounterMode string
+	// counters holds the running total of every counter series, keyed by
+	// the series' labels.
+	counters sinks.CounterTotals
+}
+
+var _ sinks.MetricSink = &RemoteWriteSink{}
+
+// NewRemoteWriteSink returns a new Prometheus remote write sink.
+func NewRemoteWriteSink(
+	log *logrus.Logger,
+	httpClient *http.Client,
+	tags []string,
+	endpoint string,
+	batchSize int,
+	maxRetries int,
+	retryBaseDelay time.Duration,
+	retryMaxDelay time.Duration,
+) (*RemoteWriteSink, error) {
+	return &RemoteWriteSink{
+		log:            log,
+		httpClient:     httpClient,
+		Tags:           tags,
+		Endpoint:       endpoint,
+		BatchSize:      batchSize,
+		MaxRetries:     maxRetries,
+		RetryBaseDelay: retryBaseDelay,
+		RetryMaxDelay:  retryMaxDelay,
+	}, nil
+}
+
+// Name returns the sink's name.
+func (p *RemoteWriteSink) Name() string {
+	return "prometheus"
+}
+
+// SetExcludedTags sets the excluded tag names. Any tags with the provided
+// key (name) will be excluded.
+func (p *RemoteWriteSink) SetExcludedTags(excludes []string) {
+	p.excludedTags = excludes
+}
+
+// Start sets the trace client for the sink.
+func (p *RemoteWriteSink) Start(client *trace.Client) error {
+	p.traceClient = client
+	return nil
+}
+
+// Flush converts metrics to time series and sends them in batches of at
+// most BatchSize series. Every batch is attempted, even if an earlier one
+// failed; the last error is returned. The metadata of the metrics that
+// have a description is sent along with the batches their series are in.
+func (p *RemoteWriteSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	series := p.convert(interMetrics)
+	metadata := p.metadata(interMetrics)
+
+	var flushErr error
+	for len(series) > 0 {
+		batchSize := p.BatchSize
+		if batchSize < 1 || len(series) < batchSize {
+			batchSize = len(series)
+		}
+		if err := p.flushBatch(ctx, series[:batchSize], metadata); err != nil {
+			flushErr = err
+		}
+		series = series[batchSize:]
+	}
+	return flushErr
+}
+
+// flushBatch POSTs a single batch of series, retrying with exponential
+// backoff up to MaxRetries times. It gives up early if ctx is cancelled.
+func (p *RemoteWriteSink) flushBatch(ctx context.Context, batch []*TimeSeries, metadata map[string]*MetricMetadata) error {
+	encoded, err := proto.Marshal(&WriteRequest{
+		Timeseries: batch,
+		Metadata:   batchMetadata(batch, metadata),
+	})
+	if err != nil {
+		p.log.WithError(err).Error("Could not encode Prometheus remote write request")
+		return err
+	}
+	body := snappy.Encode(nil, encoded)
+	headers := map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	}
+
+	samples := &ssf.Samples{}
+	defer metrics.Report(p.traceClient, samples)
+	tags := map[string]string{"sink": p.Name()}
+
+	for attempt := 0; ; attempt++ {
+		postStart := time.Now()
+		err = vhttp.PostRawHelper(ctx, p.httpClient, p.traceClient, http.MethodPost, p.Endpoint, body, headers, "flush_metrics", nil, p.log)
+		samples.Add(ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(postStart), time.Nanosecond, tags))
+		if err == nil {
+			samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(batch)), tags))
+			p.log.WithField("series", len(batch)).Info("Completed flushing Prometheus remote write batch")
+			return nil
+		}
+		if attempt >= p.MaxRetries {
+			break
+		}
+		if statusErr, ok := err.(*vhttp.StatusError); ok && !statusErr.Temporary() {
+			break
+		}
+		if err = p.waitForRetry(ctx, attempt); err != nil {
+			break
+		}
+	}
+	p.log.WithFields(logrus.Fields{
+		"series":        len(batch),
+		logrus.ErrorKey: err,
+	}).Warn("Error flushing Prometheus remote write batch")
+	return err
+}
+
+// waitForRetry blocks until it's time for the retry following the given
+// attempt, returning early with the context's error if ctx is cancelled.
+func (p *RemoteWriteSink) waitForRetry(ctx context.Context, attempt int) error {
+	return backoff.Wait(ctx, backoff.Exponential{Base: p.RetryBaseDelay, Max: p.RetryMaxDelay}, attempt)
+}
+
+// convert turns metrics into time series with a single sample each.
+// Counters are converted to their running total, unless CounterMode is
+// sinks.CounterModeDelta.
+func (p *RemoteWriteSink) convert(interMetrics []samplers.InterMetric) []*TimeSeries {
+	series := make([]*TimeSeries, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if !sinks.IsAcceptableMetric(metric, p) {
+			continue
+		}
+		labels := p.labels(metric)
+		value := metric.Value
+		if metric.Type == samplers.CounterMetric && p.CounterMode != sinks.CounterModeDelta {
+			value = p.counters.Add(seriesKey(labels), value)
+		}
+		series = append(series, &TimeSeries{
+			Labels: labels,
+			Samples: []*Sample{{
+				Value:     value,
+				Timestamp: metric.Timestamp * 1000,
+			}},
+		})
+	}
+	return series
+}
+
+// metadata returns the metadata of the metrics that have a description,
+// keyed by their sanitized names.
+func (p *RemoteWriteSink) metadata(interMetrics []samplers.InterMetric) map[string]*MetricMetadata {
+	var metadata map[string]*MetricMetadata
+	for _, metric := range interMetrics {
+		if metric.Description == "" || !sinks.IsAcceptableMetric(metric, p) {
+			continue
+		}
+		if metadata == nil {
+			metadata = map[string]*MetricMetadata{}
+		}
+		name := sanitizeName(metric.Name)
+		metricType := MetricTypeGauge
+		if metric.Type == samplers.CounterMetric {
+			metricType = MetricTypeCounter
+		}
+		metadata[name] = &MetricMetadata{
+			Type:             metricType,
+			MetricFamilyName: name,
+			Help:             metric.Description,
+		}
+	}
+	return metadata
+}
+
+// batchMetadata returns the metadata of the metrics a batch has series
+// of, each only once.
+func batchMetadata(batch []*TimeSeries, metadata map[string]*MetricMetadata) []*MetricMetadata {
+	if len(metadata) == 0 {
+		return nil
+	}
+	var selected []*MetricMetadata
+	seen := map[string]bool{}
+	for _, ts := range batch {
+		for _, l := range ts.Labels {
+			if l.Name != "__name__" {
+				continue
+			}
+			if m, ok := metadata[l.Value]; ok && !seen[l.Value] {
+				seen[l.Value] = true
+				selected = append(selected, m)
+			}
+			break
+		}
+	}
+	return selected
+}
+
+// labels returns a metric's labels, sorted by name as Prometheus requires:
+// its name, its tags and the sink's tags, minus any excluded tags.
+func (p *RemoteWriteSink) labels(metric samplers.InterMetric) []*Label {
+	return seriesLabels(metric.Name, metric.Tags, p.Tags, p.excludedTags)
+}
+
+// seriesLabels returns the labels of a series named name, sorted by name:
+// its name, its tags and the sink's tags, minus any excluded tags.
+func seriesLabels(name string, metricTags, sinkTags, excludedTags []string) []*Label {
+	// the metric's tags are shared with the other sinks, so we mustn't
+	// append to them in place
+	tags := make([]string, 0, len(metricTags)+len(sinkTags))
+	tags = append(tags, metricTags...)
+	tags = append(tags, sinkTags...)
+	tagMap := samplers.ParseTagSliceToMap(tags)
+	for _, excluded := range excludedTags {
+		delete(tagMap, excluded)
+	}
+
+	labels := make([]*Label, 0, len(tagMap)+1)
+	labels = append(labels, &Label{Name: "__name__", Value: sanitizeName(name)})
+	for k, v := range tagMap {
+		name := sanitizeName(k)
+		if name == "__name__" {
+			continue
+		}
+		labels = append(labels, &Label{Name: name, Value: v})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels
+}
+
+// seriesKey identifies a series by its sorted labels.
+func seriesKey(labels []*Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		b.WriteString(l.Value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// sanitizeName replaces the characters that aren't allowed in Prometheus
+// metric and label names with underscores.
+func sanitizeName(name string) string {
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			return r
+		case r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// FlushOtherSamples does nothing, since Prometheus has no notion of
+// events or service checks.
+func (p *RemoteWriteSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
@@ -0,0 +1,158 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+)
+
+// remoteWriteServer records the write requests it receives, answering
+// the first `failures` of them with a 500.
+type remoteWriteServer struct {
+	*httptest.Server
+	requests []*WriteRequest
+	headers  []http.Header
+	failures int
+}
+
+func newRemoteWriteServer(t *testing.T, failures int) *remoteWriteServer {
+	rw := &remoteWriteServer{failures: failures}
+	rw.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rw.failures > 0 {
+			rw.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		req := &WriteRequest{}
+		require.NoError(t, proto.Unmarshal(body, req))
+		rw.requests = append(rw.requests, req)
+		rw.headers = append(rw.headers, r.Header)
+	}))
+	return rw
+}
+
+func testMetrics() []samplers.InterMetric {
+	ts := time.Date(1955, time.November, 5, 6, 0, 0, 0, time.UTC).Unix()
+	return []samplers.InterMetric{
+		{
+			Name:      "a.b.counter",
+			Timestamp: ts,
+			Value:     2,
+			Tags:      []string{"foo:bar", "weird-tag:baz"},
+			Type:      samplers.CounterMetric,
+		},
+		{
+			Name:      "a.b.gauge",
+			Timestamp: ts,
+			Value:     5,
+			Tags:      []string{"foo:bar"},
+			Type:      samplers.GaugeMetric,
+		},
+	}
+}
+
+func TestConvert(t *testing.T) {
+	sink, err := NewRemoteWriteSink(logrus.New(), http.DefaultClient, []string{"host:fnord"}, "", 10, 0, 0, 0)
+	require.NoError(t, err)
+	sink.SetExcludedTags([]string{"foo"})
+
+	series := sink.convert(testMetrics())
+	require.Len(t, series, 2)
+	assert.Equal(t, []*Label{
+		{Name: "__name__", Value: "a_b_counter"},
+		{Name: "host", Value: "fnord"},
+		{Name: "weird_tag", Value: "baz"},
+	}, series[0].Labels)
+	assert.Equal(t, []*Sample{{Value: 2, Timestamp: testMetrics()[0].Timestamp * 1000}}, series[0].Samples)
+	assert.Equal(t, float64(5), series[1].Samples[0].Value)
+}
+
+func TestConvertCountersAccumulate(t *testing.T) {
+	sink, err := NewRemoteWriteSink(logrus.New(), http.DefaultClient, nil, "", 10, 0, 0, 0)
+	require.NoError(t, err)
+
+	for i, expected := range []float64{2, 4, 6} {
+		series := sink.convert(testMetrics())
+		assert.Equal(t, expected, series[0].Samples[0].Value, "counter value after flush %d", i)
+		assert.Equal(t, float64(5), series[1].Samples[0].Value, "gauges shouldn't accumulate")
+	}
+}
+
+func TestConvertCountersDelta(t *testing.T) {
+	sink, err := NewRemoteWriteSink(logrus.New(), http.DefaultClient, nil, "", 10, 0, 0, 0)
+	require.NoError(t, err)
+	sink.CounterMode = sinks.CounterModeDelta
+
+	for i := 0; i < 3; i++ {
+		series := sink.convert(testMetrics())
+		assert.Equal(t, float64(2), series[0].Samples[0].Value, "counters shouldn't accumulate in delta mode (flush %d)", i)
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	assert.Equal(t, "a_b_c", sanitizeName("a.b-c"))
+	assert.Equal(t, "_2xx", sanitizeName("2xx"))
+	assert.Equal(t, "ns:metric_total", sanitizeName("ns:metric_total"))
+}
+
+func TestFlush(t *testing.T) {
+	server := newRemoteWriteServer(t, 1)
+	defer server.Close()
+
+	sink, err := NewRemoteWriteSink(logrus.New(), http.DefaultClient, nil, server.URL, 1, 1, time.Millisecond, 0)
+	require.NoError(t, err)
+
+	err = sink.Flush(context.Background(), testMetrics())
+	assert.NoError(t, err)
+	require.Len(t, server.requests, 2, "every metric should be sent in its own batch")
+	assert.Equal(t, "a_b_counter", server.requests[0].Timeseries[0].Labels[0].Value)
+	assert.Equal(t, "a_b_gauge", server.requests[1].Timeseries[0].Labels[0].Value)
+	assert.Equal(t, "snappy", server.headers[0].Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", server.headers[0].Get("Content-Type"))
+}
+
+func TestFlushRetryExhausted(t *testing.T) {
+	server := newRemoteWriteServer(t, 5)
+	defer server.Close()
+
+	sink, err := NewRemoteWriteSink(logrus.New(), http.DefaultClient, nil, server.URL, 10, 1, time.Millisecond, 0)
+	require.NoError(t, err)
+
+	err = sink.Flush(context.Background(), testMetrics())
+	assert.Error(t, err)
+	assert.Equal(t, 3, server.failures, "the batch should have been sent twice")
+}
+
+func TestFlushMetadata(t *testing.T) {
+	server := newRemoteWriteServer(t, 0)
+	defer server.Close()
+
+	sink, err := NewRemoteWriteSink(logrus.New(), http.DefaultClient, nil, server.URL, 1, 0, 0, 0)
+	require.NoError(t, err)
+
+	metrics := testMetrics()
+	metrics[0].Description = "How many things happened."
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	require.Len(t, server.requests, 2)
+	assert.Equal(t, []*MetricMetadata{{
+		Type:             MetricTypeCounter,
+		MetricFamilyName: "a_b_counter",
+		Help:             "How many things happened.",
+	}}, server.requests[0].Metadata)
+	assert.Empty(t, server.requests[1].Metadata, "metrics without a description shouldn't have metadata")
+}
@@ -0,0 +1,70 @@
+package prometheus
+
+import "github.com/golang/protobuf/proto"
+
+// The types below are the messages of Prometheus' remote write protocol,
+// as defined in prompb/remote.proto and prompb/types.proto in the
+// Prometheus repository. They are declared here, rather than vendored,
+// because the prompb package drags in most of Prometheus with it.
+
+// WriteRequest is the body of a remote write request.
+type WriteRequest struct {
+	Timeseries []*TimeSeries     `protobuf:"bytes,1,rep,name=timeseries" json:"timeseries,omitempty"`
+	Metadata   []*MetricMetadata `protobuf:"bytes,3,rep,name=metadata" json:"metadata,omitempty"`
+}
+
+func (m *WriteRequest) Reset()         { *m = WriteRequest{} }
+func (m *WriteRequest) String() string { return proto.CompactTextString(m) }
+func (*WriteRequest) ProtoMessage()    {}
+
+// TimeSeries is a series of samples, identified by its labels.
+type TimeSeries struct {
+	Labels  []*Label  `protobuf:"bytes,1,rep,name=labels" json:"labels,omitempty"`
+	Samples []*Sample `protobuf:"bytes,2,rep,name=samples" json:"samples,omitempty"`
+}
+
+func (m *TimeSeries) Reset()         { *m = TimeSeries{} }
+func (m *TimeSeries) String() string { return proto.CompactTextString(m) }
+func (*TimeSeries) ProtoMessage()    {}
+
+// Label is a single name/value pair identifying a series. The metric's
+// name is the value of the "__name__" label.
+type Label struct {
+	Name  string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *Label) Reset()         { *m = Label{} }
+func (m *Label) String() string { return proto.CompactTextString(m) }
+func (*Label) ProtoMessage()    {}
+
+// Sample is a single value of a series, at a timestamp in milliseconds
+// since the epoch.
+type Sample struct {
+	Value     float64 `protobuf:"fixed64,1,opt,name=value" json:"value,omitempty"`
+	Timestamp int64   `protobuf:"varint,2,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *Sample) Reset()         { *m = Sample{} }
+func (m *Sample) String() string { return proto.CompactTextString(m) }
+func (*Sample) ProtoMessage()    {}
+
+// The types of metric families in MetricMetadata.
+const (
+	MetricTypeUnknown int32 = 0
+	MetricTypeCounter int32 = 1
+	MetricTypeGauge   int32 = 2
+)
+
+// MetricMetadata describes a metric family, so that Prometheus can show
+// its help text.
+type MetricMetadata struct {
+	Type             int32  `protobuf:"varint,1,opt,name=type" json:"type,omitempty"`
+	MetricFamilyName string `protobuf:"bytes,2,opt,name=metric_family_name" json:"metric_family_name,omitempty"`
+	Help             string `protobuf:"bytes,4,opt,name=help" json:"help,omitempty"`
+	Unit             string `protobuf:"bytes,5,opt,name=unit" json:"unit,omitempty"`
+}
+
+func (m *MetricMetadata) Reset()         { *m = MetricMetadata{} }
+func (m *MetricMetadata) String() string { return proto.CompactTextString(m) }
+func (*MetricMetadata) ProtoMessage()    {}
@@ -0,0 +1,249 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// ScrapeContentType is the content type of the text exposition format
+// ScrapeSink serves.
+const ScrapeContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// percentileName matches the names of the percentiles histograms and
+// timers are flushed as, e.g. "request.latency.99percentile".
+var percentileName = regexp.MustCompile(`^(.+)\.(\d+)percentile$`)
+
+// ScrapeSink serves the metrics of the most recent flush for Prometheus
+// to scrape, in the text exposition format, rather than pushing them
+// anywhere. Counters are served as their running total since the sink
+// started, as Prometheus expects, gauges and status checks as gauges,
+// and the percentiles of a histogram or timer as a summary named after
+// it. Its other aggregates (e.g. "request.latency.max") are served as
+// the counters or gauges they are flushed as.
+type ScrapeSink struct {
+	log          *logrus.Logger
+	Tags         []string
+	Address      string
+	Path         string
+	excludedTags []string
+
+	server   *http.Server
+	listener net.Listener
+	// snapshot holds the encoded metrics of the most recent flush, as a
+	// []byte. Flush replaces it whole, so scrapes never see part of a
+	// flush.
+	snapshot atomic.Value
+	counters sinks.CounterTotals
+}
+
+var _ sinks.MetricSink = &ScrapeSink{}
+
+// NewScrapeSink returns a sink serving metrics on path, over HTTP on
+// address.
+func NewScrapeSink(log *logrus.Logger, tags []string, address string, path string) (*ScrapeSink, error) {
+	if path == "" {
+		path = "/metrics"
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("the Prometheus scrape path %q must start with a slash", path)
+	}
+	sink := &ScrapeSink{
+		log:     log,
+		Tags:    tags,
+		Address: address,
+		Path:    path,
+	}
+	sink.snapshot.Store([]byte{})
+	return sink, nil
+}
+
+// Name returns the sink's name.
+func (p *ScrapeSink) Name() string {
+	return "prometheus_scrape"
+}
+
+// SetExcludedTags sets the excluded tag names. Any tags with the provided
+// key (name) will be excluded.
+func (p *ScrapeSink) SetExcludedTags(excludes []string) {
+	p.excludedTags = excludes
+}
+
+// Start starts serving metrics on Address.
+func (p *ScrapeSink) Start(*trace.Client) error {
+	listener, err := net.Listen("tcp", p.Address)
+	if err != nil {
+		return err
+	}
+	p.listener = listener
+	mux := http.NewServeMux()
+	mux.HandleFunc(p.Path, p.serve)
+	p.server = &http.Server{Handler: mux}
+	go func() {
+		if err := p.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			p.log.WithError(err).Error("Prometheus scrape endpoint stopped")
+		}
+	}()
+	p.log.WithField("address", listener.Addr().String()).Info("Serving metrics for Prometheus to scrape")
+	return nil
+}
+
+// Addr returns the address metrics are served on, once the sink has
+// started.
+func (p *ScrapeSink) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+// Stop stops serving metrics.
+func (p *ScrapeSink) Stop() {
+	if p.server != nil {
+		p.server.Close()
+	}
+}
+
+// serve answers a scrape with the metrics of the most recent flush.
+func (p *ScrapeSink) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ScrapeContentType)
+	w.Write(p.snapshot.Load().([]byte))
+}
+
+// Flush replaces the metrics served with metrics.
+func (p *ScrapeSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	p.snapshot.Store(p.encode(interMetrics))
+	return nil
+}
+
+// FlushOtherSamples does nothing, since Prometheus has no notion of
+// events or service checks.
+func (p *ScrapeSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
+
+// family is a metric family of the exposition format: the series of a
+// name, all of a single type.
+type family struct {
+	metricType string
+	help       string
+	series     []string
+}
+
+// encode encodes metrics in the text exposition format, the families
+// sorted by name and their series by labels, so that scrapes are stable.
+// A series whose family was already given another type is dropped.
+func (p *ScrapeSink) encode(interMetrics []samplers.InterMetric) []byte {
+	families := map[string]*family{}
+	for _, metric := range interMetrics {
+		if metric.Type == samplers.SketchMetric || !sinks.IsAcceptableMetric(metric, p) {
+			continue
+		}
+		name, metricType, value := metric.Name, "gauge", metric.Value
+		var quantile string
+		if match := percentileName.FindStringSubmatch(metric.Name); match != nil && metric.Type == samplers.GaugeMetric {
+			name, metricType, quantile = match[1], "summary", "0."+match[2]
+		}
+		labels := seriesLabels(name, metric.Tags, p.Tags, p.excludedTags)
+		if metric.Type == samplers.CounterMetric {
+			metricType = "counter"
+			value = p.counters.Add(seriesKey(labels), value)
+		}
+		familyName := sanitizeName(name)
+		labels = seriesOnlyLabels(labels, quantile != "")
+		if quantile != "" {
+			labels = append(labels, &Label{Name: "quantile", Value: quantile})
+		}
+
+		f, ok := families[familyName]
+		if !ok {
+			f = &family{metricType: metricType}
+			families[familyName] = f
+		} else if f.metricType != metricType {
+			continue
+		}
+		if f.help == "" {
+			f.help = metric.Description
+		}
+		f.series = append(f.series, encodeSeries(familyName, labels, value))
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		f := families[name]
+		if f.help != "" {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", name, escapeHelp(f.help))
+		}
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, f.metricType)
+		sort.Strings(f.series)
+		for _, series := range f.series {
+			buf.WriteString(series)
+		}
+	}
+	return buf.Bytes()
+}
+
+// seriesOnlyLabels returns labels without the series' name, and without
+// any "quantile" label if the series is a quantile, which would clash
+// with its own.
+func seriesOnlyLabels(labels []*Label, isQuantile bool) []*Label {
+	filtered := make([]*Label, 0, len(labels))
+	for _, l := range labels {
+		if l.Name == "__name__" || (isQuantile && l.Name == "quantile") {
+			continue
+		}
+		filtered = append(filtered, l)
+	}
+	return filtered
+}
+
+// encodeSeries encodes a single series as a line of the text exposition
+// format. labels exclude the name.
+func encodeSeries(name string, labels []*Label, value float64) string {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", l.Name, escapeLabelValue(l.Value))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('\n')
+	return b.String()
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// escapeHelp escapes the backslashes and line feeds of a help text.
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+// escapeLabelValue escapes the backslashes, line feeds and double quotes
+// of a label value.
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
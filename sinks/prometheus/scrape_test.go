@@ -0,0 +1,97 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestScrapeSinkEncode(t *testing.T) {
+	sink, err := NewScrapeSink(logrus.New(), []string{"host:fnord"}, "", "")
+	require.NoError(t, err)
+	sink.SetExcludedTags([]string{"foo"})
+
+	metrics := append(testMetrics(),
+		samplers.InterMetric{
+			Name:  "a.b.timer.99percentile",
+			Value: 12,
+			Tags:  []string{"path:/\"quoted\""},
+			Type:  samplers.GaugeMetric,
+		},
+		samplers.InterMetric{
+			Name:  "a.b.timer.50percentile",
+			Value: 3,
+			Tags:  []string{"path:/\"quoted\""},
+			Type:  samplers.GaugeMetric,
+		},
+		samplers.InterMetric{
+			Name:        "a.b.timer.max",
+			Value:       20,
+			Description: "Slowest request\nof the interval",
+			Type:        samplers.GaugeMetric,
+		},
+		samplers.InterMetric{
+			Name:  "a.b.gauge",
+			Value: 1,
+			Type:  samplers.CounterMetric,
+		},
+		samplers.InterMetric{
+			Name:  "a.b.elsewhere",
+			Value: 1,
+			Type:  samplers.GaugeMetric,
+			Sinks: samplers.RouteInformation{"other": struct{}{}},
+		},
+	)
+	expected := `# TYPE a_b_counter counter
+a_b_counter{host="fnord",weird_tag="baz"} 2
+# TYPE a_b_gauge gauge
+a_b_gauge{host="fnord"} 5
+# TYPE a_b_timer summary
+a_b_timer{host="fnord",path="/\"quoted\"",quantile="0.50"} 3
+a_b_timer{host="fnord",path="/\"quoted\"",quantile="0.99"} 12
+# HELP a_b_timer_max Slowest request\nof the interval
+# TYPE a_b_timer_max gauge
+a_b_timer_max{host="fnord"} 20
+`
+	assert.Equal(t, expected, string(sink.encode(metrics)))
+
+	encoded := string(sink.encode(testMetrics()))
+	assert.Contains(t, encoded, `a_b_counter{host="fnord",weird_tag="baz"} 4`, "counters should be served as their running total")
+}
+
+func TestScrapeSinkServe(t *testing.T) {
+	sink, err := NewScrapeSink(logrus.New(), nil, "127.0.0.1:0", "/scrape")
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+	url := "http://" + sink.Addr().String() + "/scrape"
+
+	scrape := func() string {
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, ScrapeContentType, resp.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	assert.Empty(t, scrape(), "nothing should be served before the first flush")
+
+	require.NoError(t, sink.Flush(context.Background(), testMetrics()))
+	assert.Contains(t, scrape(), `a_b_gauge{foo="bar"} 5`)
+
+	require.NoError(t, sink.Flush(context.Background(), testMetrics()[:1]))
+	assert.NotContains(t, scrape(), "a_b_gauge", "every flush should replace the metrics served")
+}
+
+func TestNewScrapeSinkInvalidPath(t *testing.T) {
+	_, err := NewScrapeSink(logrus.New(), nil, ":0", "metrics")
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+// Package ratelimit implements a metric sink that caps the rate of
+// metrics forwarded to another sink.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeyDropped is emitted as a counter of the metrics a rate limiting
+// sink dropped, tagged with `sink:sink.Name()`.
+const MetricKeyDropped = "sink.ratelimit.dropped_total"
+
+// RateLimitSink wraps another metric sink, and forwards at most Limit
+// metrics per second to it, using a token bucket holding up to Burst
+// tokens. Each forwarded metric takes a token; metrics that find the
+// bucket empty are dropped.
+//
+// Since metrics arrive once per flush interval, Burst should be at least
+// Limit times the interval, or the inner sink will never receive more than
+// Burst metrics per flush. That's what it defaults to.
+//
+// The sink takes on the name of the sink it wraps, so that routing, tag
+// exclusion and everything else keyed by sink name keep applying to it.
+type RateLimitSink struct {
+	inner       sinks.MetricSink
+	traceClient *trace.Client
+	Limit       float64
+	Burst       int
+
+	mtx    sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// now returns the current time; it's replaced in tests.
+	now func() time.Time
+}
+
+var _ sinks.MetricSink = &RateLimitSink{}
+
+// NewRateLimitSink returns a sink forwarding at most limit metrics per
+// second to inner, in bursts of up to burst metrics. A burst below 1 means
+// a burst of the metrics allowed over a flush interval (or a second, if
+// interval is shorter), so that a flush can use up the whole rate.
+func NewRateLimitSink(inner sinks.MetricSink, limit float64, burst int, interval time.Duration) (*RateLimitSink, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("rate limit %v must be positive", limit)
+	}
+	if burst < 1 {
+		if interval < time.Second {
+			interval = time.Second
+		}
+		burst = int(math.Ceil(limit * interval.Seconds()))
+	}
+	return &RateLimitSink{
+		inner:  inner,
+		Limit:  limit,
+		Burst:  burst,
+		tokens: float64(burst),
+		now:    time.Now,
+	}, nil
+}
+
+// Name returns the name of the wrapped sink.
+func (r *RateLimitSink) Name() string {
+	return r.inner.Name()
+}
+
+// SetExcludedTags passes the excluded tag names on to the wrapped sink,
+// if it supports them.
+func (r *RateLimitSink) SetExcludedTags(excludes []string) {
+	if excludable, ok := r.inner.(interface{ SetExcludedTags([]string) }); ok {
+		excludable.SetExcludedTags(excludes)
+	}
+}
+
+// Start starts the wrapped sink, with a full bucket.
+func (r *RateLimitSink) Start(client *trace.Client) error {
+	r.traceClient = client
+	r.mtx.Lock()
+	r.last = r.now()
+	r.mtx.Unlock()
+	return r.inner.Start(client)
+}
+
+// Stop stops the wrapped sink, if it needs stopping.
+func (r *RateLimitSink) Stop() {
+	if stopper, ok := r.inner.(interface{ Stop() }); ok {
+		stopper.Stop()
+	}
+}
+
+// FlushesSketches reports whether the wrapped sink flushes sketches.
+func (r *RateLimitSink) FlushesSketches() bool {
+	return sinks.FlushesSketches(r.inner)
+}
+
+// Drain drains the wrapped sink, if it can be drained.
+func (r *RateLimitSink) Drain(ctx context.Context) error {
+	if drainer, ok := r.inner.(interface {
+		Drain(context.Context) error
+	}); ok {
+		return drainer.Drain(ctx)
+	}
+	return nil
+}
+
+// Flush forwards as many of the metrics meant for the wrapped sink as
+// there are tokens in the bucket, in order, and drops the rest.
+func (r *RateLimitSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	accepted := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if sinks.IsAcceptableMetric(metric, r) {
+			accepted = append(accepted, metric)
+		}
+	}
+
+	allowed := r.take(len(accepted))
+	if dropped := len(accepted) - allowed; dropped > 0 {
+		metrics.ReportOne(r.traceClient, ssf.Count(MetricKeyDropped, float32(dropped), map[string]string{"sink": r.Name()}))
+	}
+	return r.inner.Flush(ctx, accepted[:allowed])
+}
+
+// FlushOtherSamples passes all samples on to the wrapped sink.
+func (r *RateLimitSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+	r.inner.FlushOtherSamples(ctx, samples)
+}
+
+// take refills the bucket for the time since it was last refilled, and
+// takes up to n tokens from it. It returns the number of tokens taken.
+func (r *RateLimitSink) take(n int) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := r.now()
+	if !r.last.IsZero() {
+		r.tokens += now.Sub(r.last).Seconds() * r.Limit
+	}
+	r.tokens = math.Min(r.tokens, float64(r.Burst))
+	r.last = now
+
+	taken := int(math.Min(float64(n), math.Floor(r.tokens)))
+	r.tokens -= float64(taken)
+	return taken
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// recordingSink remembers the metrics of its last flush.
+type recordingSink struct {
+	flushed []samplers.InterMetric
+}
+
+func (r *recordingSink) Name() string              { return "recording" }
+func (r *recordingSink) Start(*trace.Client) error { return nil }
+func (r *recordingSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	r.flushed = metrics
+	return nil
+}
+func (r *recordingSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {}
+
+func TestRateLimit(t *testing.T) {
+	inner := &recordingSink{}
+	sink, err := NewRateLimitSink(inner, 10, 50, 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "recording", sink.Name())
+
+	now := time.Unix(1476119058, 0)
+	sink.now = func() time.Time { return now }
+	require.NoError(t, sink.Start(nil))
+
+	metrics := make([]samplers.InterMetric, 100)
+	for i := range metrics {
+		metrics[i].Name = "a.b.c"
+	}
+	metrics = append(metrics, samplers.InterMetric{
+		Name:  "elsewhere",
+		Sinks: samplers.RouteInformation{"other": struct{}{}},
+	})
+
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	assert.Len(t, inner.flushed, 50, "the first flush should get a full bucket")
+
+	now = now.Add(2 * time.Second)
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	assert.Len(t, inner.flushed, 20, "the bucket should refill at the limit")
+
+	now = now.Add(time.Hour)
+	require.NoError(t, sink.Flush(context.Background(), metrics[:30]))
+	assert.Len(t, inner.flushed, 30)
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	assert.Len(t, inner.flushed, 20, "the bucket should never hold more than the burst")
+}
+
+func TestDefaultBurst(t *testing.T) {
+	sink, err := NewRateLimitSink(&recordingSink{}, 2.5, 0, 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 25, sink.Burst, "the default burst should let a flush use up the rate of a whole interval")
+
+	sink, err = NewRateLimitSink(&recordingSink{}, 2.5, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, sink.Burst)
+
+	_, err = NewRateLimitSink(&recordingSink{}, 0, 10, 10*time.Second)
+	assert.Error(t, err)
+}
@@ -0,0 +1,188 @@
+// Package s3 implements a metric sink that archives flushed metrics in S3.
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/sinks/generic"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// S3Sink uploads the metrics of every flush to S3 as a single object, in
+// the same format the generic sink sends them in: either one JSON
+// document per object (generic.FormatJSON), or gzipped JSON lines
+// (generic.FormatNDJSON).
+//
+// Objects are keyed by the time of the flush, as
+// <Prefix>/YYYY/MM/DD/HH/<hostname>-<unix timestamp>.<extension>.
+type S3Sink struct {
+	log         *logrus.Logger
+	traceClient *trace.Client
+	svc         s3iface.S3API
+	Bucket      string
+	Prefix      string
+	Hostname    string
+
+	// converter holds the settings metrics are converted and serialized
+	// with.
+	converter *generic.GenericMetricSink
+
+	// now returns the time objects are keyed by; it's replaced in tests.
+	now func() time.Time
+}
+
+var _ sinks.MetricSink = &S3Sink{}
+
+// NewS3Sink returns a new S3 sink uploading to bucket with svc. format is
+// one of generic.FormatJSON or generic.FormatNDJSON, and timestampFormat
+// one of the generic sink's timestamp formats.
+func NewS3Sink(
+	log *logrus.Logger,
+	svc s3iface.S3API,
+	bucket string,
+	prefix string,
+	hostname string,
+	tags []string,
+	source string,
+	environment string,
+	namespace string,
+	format string,
+	timestampFormat string,
+) (*S3Sink, error) {
+	switch format {
+	case "", generic.FormatJSON, generic.FormatNDJSON:
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	switch timestampFormat {
+	case "", generic.TimestampSeconds, generic.TimestampMilliseconds, generic.TimestampRFC3339:
+	default:
+		return nil, fmt.Errorf("unknown timestamp format %q", timestampFormat)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("the S3 sink needs a bucket to upload to")
+	}
+
+	return &S3Sink{
+		log:      log,
+		svc:      svc,
+		Bucket:   bucket,
+		Prefix:   prefix,
+		Hostname: hostname,
+		converter: &generic.GenericMetricSink{
+			Tags:            tags,
+			Source:          source,
+			Environment:     environment,
+			Namespace:       namespace,
+			Format:          format,
+			TimestampFormat: timestampFormat,
+		},
+		now: time.Now,
+	}, nil
+}
+
+// Name returns the sink's name.
+func (s *S3Sink) Name() string {
+	return "s3"
+}
+
+// SetExcludedTags sets the excluded tag names. Any tags with the provided
+// key (name) will be excluded.
+func (s *S3Sink) SetExcludedTags(excludes []string) {
+	s.converter.SetExcludedTags(excludes)
+}
+
+// Start sets the trace client for the sink.
+func (s *S3Sink) Start(client *trace.Client) error {
+	s.traceClient = client
+	return nil
+}
+
+// Flush uploads all the metrics as a single object. Nothing is uploaded
+// if there are no metrics.
+func (s *S3Sink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	accepted := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if sinks.IsAcceptableMetric(metric, s) {
+			accepted = append(accepted, metric)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+
+	body, err := s.encode(accepted)
+	if err != nil {
+		s.log.WithError(err).Error("Could not encode metrics for S3")
+		return err
+	}
+
+	samples := &ssf.Samples{}
+	defer metrics.Report(s.traceClient, samples)
+	tags := map[string]string{"sink": s.Name()}
+
+	key := s.key()
+	putStart := time.Now()
+	_, err = s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	samples.Add(ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(putStart), time.Nanosecond, tags))
+	if err != nil {
+		s.log.WithError(err).WithFields(logrus.Fields{
+			"bucket":  s.Bucket,
+			"key":     key,
+			"metrics": len(accepted),
+		}).Error("Error uploading metrics to S3")
+		return err
+	}
+	samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(accepted)), tags))
+	s.log.WithField("metrics", len(accepted)).Info("Completed uploading metrics to S3")
+	return nil
+}
+
+// encode serializes metrics in the sink's format, gzipping JSON lines.
+func (s *S3Sink) encode(metrics []samplers.InterMetric) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if s.converter.Format != generic.FormatNDJSON {
+		err := s.converter.WriteMetrics(buf, metrics)
+		return buf.Bytes(), err
+	}
+	gzw := gzip.NewWriter(buf)
+	if err := s.converter.WriteMetrics(gzw, metrics); err != nil {
+		return nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// key returns the key of the object for the current flush.
+func (s *S3Sink) key() string {
+	extension := "json"
+	if s.converter.Format == generic.FormatNDJSON {
+		extension = "ndjson.gz"
+	}
+	t := s.now().UTC()
+	filename := s.Hostname + "-" + strconv.FormatInt(t.Unix(), 10) + "." + extension
+	return path.Join(s.Prefix, t.Format("2006/01/02/15"), filename)
+}
+
+// FlushOtherSamples does nothing; currently this sink only supports metrics.
+func (s *S3Sink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {}
@@ -0,0 +1,106 @@
+package s3
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks/generic"
+)
+
+// fakeS3 records the objects that are uploaded to it.
+type fakeS3 struct {
+	s3iface.S3API
+	puts []*s3.PutObjectInput
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	f.puts = append(f.puts, input)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func testMetrics() []samplers.InterMetric {
+	return []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     2,
+		Tags:      []string{"foo:bar"},
+		Type:      samplers.CounterMetric,
+	}, {
+		Name:      "a.b.d",
+		Timestamp: 1476119058,
+		Value:     1,
+		Type:      samplers.GaugeMetric,
+	}}
+}
+
+func testNow() time.Time {
+	return time.Date(2016, time.October, 10, 17, 4, 18, 0, time.UTC)
+}
+
+func TestFlushJSON(t *testing.T) {
+	svc := &fakeS3{}
+	sink, err := NewS3Sink(logrus.New(), svc, "bucket", "archive", "myhost", []string{"host:fnord"}, "veneur", "prod", "ns", "", generic.TimestampMilliseconds)
+	require.NoError(t, err)
+	sink.now = testNow
+
+	require.NoError(t, sink.Flush(context.Background(), testMetrics()))
+	require.Len(t, svc.puts, 1, "every flush should be uploaded as a single object")
+	assert.Equal(t, "bucket", *svc.puts[0].Bucket)
+	assert.Equal(t, "archive/2016/10/10/17/myhost-1476119058.json", *svc.puts[0].Key)
+
+	body, err := ioutil.ReadAll(svc.puts[0].Body)
+	require.NoError(t, err)
+	var uploaded generic.GenericMetrics
+	require.NoError(t, json.Unmarshal(body, &uploaded))
+	assert.Equal(t, "prod", uploaded.Environment)
+	require.Len(t, uploaded.Metrics, 2)
+	assert.Equal(t, "a.b.c", uploaded.Metrics[0].Metric)
+	assert.Equal(t, float64(1476119058000), uploaded.Metrics[0].At)
+	assert.Equal(t, map[string]string{"foo": "bar", "host": "fnord"}, uploaded.Metrics[0].Tags)
+}
+
+func TestFlushNDJSON(t *testing.T) {
+	svc := &fakeS3{}
+	sink, err := NewS3Sink(logrus.New(), svc, "bucket", "", "myhost", nil, "", "prod", "", generic.FormatNDJSON, "")
+	require.NoError(t, err)
+	sink.now = testNow
+
+	require.NoError(t, sink.Flush(context.Background(), testMetrics()))
+	require.Len(t, svc.puts, 1)
+	assert.Equal(t, "2016/10/10/17/myhost-1476119058.ndjson.gz", *svc.puts[0].Key)
+
+	gzr, err := gzip.NewReader(svc.puts[0].Body)
+	require.NoError(t, err)
+	scanner := bufio.NewScanner(gzr)
+	var lines []generic.NDJSONMetric
+	for scanner.Scan() {
+		var line generic.NDJSONMetric
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 2)
+	assert.Equal(t, "a.b.d", lines[1].Metric)
+	assert.Equal(t, "prod", lines[1].Environment)
+}
+
+func TestFlushNothing(t *testing.T) {
+	svc := &fakeS3{}
+	sink, err := NewS3Sink(logrus.New(), svc, "bucket", "", "myhost", nil, "", "", "", "", "")
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Flush(context.Background(), nil))
+	assert.Empty(t, svc.puts, "empty flushes shouldn't be uploaded")
+}
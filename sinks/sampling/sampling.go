@@ -0,0 +1,143 @@
+// Package sampling implements a metric sink that forwards only a fraction
+// of the series of noisy metrics to another sink.
+package sampling
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/segmentio/fasthash/fnv1a"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// MetricKeySampledOut is emitted as a counter of the metrics a sampling
+// sink didn't forward, tagged with `sink:sink.Name()`.
+const MetricKeySampledOut = "sink.sampling.sampled_out_total"
+
+// SamplingSink wraps another metric sink, and forwards only a fraction of
+// the series of the metrics matching its rates to it. Which series are
+// forwarded is decided by a hash of their name and tags, so the same
+// series are forwarded on every flush. Metrics that match no rate are
+// always forwarded.
+//
+// The sink takes on the name of the sink it wraps, so that routing, tag
+// exclusion and everything else keyed by sink name keep applying to it.
+type SamplingSink struct {
+	inner       sinks.MetricSink
+	traceClient *trace.Client
+
+	// rates maps metric name patterns to the fraction (between 0 and 1)
+	// of matching series that are forwarded. Patterns containing any of
+	// `*?[` are globs, as understood by path.Match; other patterns match
+	// name prefixes. If several patterns match a name, the longest one
+	// wins.
+	rates map[string]float64
+}
+
+var _ sinks.MetricSink = &SamplingSink{}
+
+// NewSamplingSink returns a sink forwarding the given fraction of the
+// series matching each pattern in rates to inner.
+func NewSamplingSink(inner sinks.MetricSink, rates map[string]float64) (*SamplingSink, error) {
+	for pattern, rate := range rates {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid sampling pattern %q: %v", pattern, err)
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("sampling rate %v for %q must be between 0 and 1", rate, pattern)
+		}
+	}
+	return &SamplingSink{inner: inner, rates: rates}, nil
+}
+
+// Name returns the name of the wrapped sink.
+func (s *SamplingSink) Name() string {
+	return s.inner.Name()
+}
+
+// SetExcludedTags passes the excluded tag names on to the wrapped sink,
+// if it supports them.
+func (s *SamplingSink) SetExcludedTags(excludes []string) {
+	if excludable, ok := s.inner.(interface{ SetExcludedTags([]string) }); ok {
+		excludable.SetExcludedTags(excludes)
+	}
+}
+
+// Start starts the wrapped sink.
+func (s *SamplingSink) Start(client *trace.Client) error {
+	s.traceClient = client
+	return s.inner.Start(client)
+}
+
+// Stop stops the wrapped sink, if it needs stopping.
+func (s *SamplingSink) Stop() {
+	if stopper, ok := s.inner.(interface{ Stop() }); ok {
+		stopper.Stop()
+	}
+}
+
+// FlushesSketches reports whether the wrapped sink flushes sketches.
+func (s *SamplingSink) FlushesSketches() bool {
+	return sinks.FlushesSketches(s.inner)
+}
+
+// Drain drains the wrapped sink, if it can be drained.
+func (s *SamplingSink) Drain(ctx context.Context) error {
+	if drainer, ok := s.inner.(interface {
+		Drain(context.Context) error
+	}); ok {
+		return drainer.Drain(ctx)
+	}
+	return nil
+}
+
+// Flush forwards the sampled metrics to the wrapped sink.
+func (s *SamplingSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	sampled := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if s.keep(metric) {
+			sampled = append(sampled, metric)
+		}
+	}
+	if dropped := len(interMetrics) - len(sampled); dropped > 0 {
+		metrics.ReportOne(s.traceClient, ssf.Count(MetricKeySampledOut, float32(dropped), map[string]string{"sink": s.Name()}))
+	}
+	return s.inner.Flush(ctx, sampled)
+}
+
+// FlushOtherSamples passes all samples on to the wrapped sink.
+func (s *SamplingSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+	s.inner.FlushOtherSamples(ctx, samples)
+}
+
+// keep returns whether a metric's series is forwarded.
+func (s *SamplingSink) keep(metric samplers.InterMetric) bool {
+	rate, ok := sinks.MatchNamePattern(s.rates, metric.Name)
+	if !ok || rate >= 1 {
+		return true
+	}
+	return float64(seriesDigest(metric)) < rate*math.MaxUint32
+}
+
+// seriesDigest hashes a metric's name and tags, the same way the parser
+// computes a metric's digest, except that the type is left out since the
+// type a metric is flushed as isn't always the one it was parsed as.
+func seriesDigest(metric samplers.InterMetric) uint32 {
+	// metric.Tags is shared with the other sinks, so we mustn't sort
+	// it in place
+	tags := make([]string, len(metric.Tags))
+	copy(tags, metric.Tags)
+	sort.Strings(tags)
+
+	h := fnv1a.Init32
+	h = fnv1a.AddString32(h, metric.Name)
+	return fnv1a.AddString32(h, strings.Join(tags, ","))
+}
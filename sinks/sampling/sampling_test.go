@@ -0,0 +1,84 @@
+package sampling
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks/blackhole"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// recordingSink remembers the metrics of its last flush.
+type recordingSink struct {
+	flushed []samplers.InterMetric
+}
+
+func (r *recordingSink) Name() string              { return "recording" }
+func (r *recordingSink) Start(*trace.Client) error { return nil }
+func (r *recordingSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	r.flushed = metrics
+	return nil
+}
+func (r *recordingSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {}
+
+func series(name string, n int) []samplers.InterMetric {
+	metrics := make([]samplers.InterMetric, n)
+	for i := range metrics {
+		metrics[i] = samplers.InterMetric{
+			Name: name,
+			Tags: []string{fmt.Sprintf("host:%d", i)},
+			Type: samplers.CounterMetric,
+		}
+	}
+	return metrics
+}
+
+func TestSampling(t *testing.T) {
+	inner := &recordingSink{}
+	sink, err := NewSamplingSink(inner, map[string]float64{
+		"noisy.":     0.25,
+		"noisy.off.": 0,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "recording", sink.Name())
+
+	var metrics []samplers.InterMetric
+	metrics = append(metrics, series("noisy.metric", 1000)...)
+	metrics = append(metrics, series("noisy.off.metric", 10)...)
+	metrics = append(metrics, series("quiet.metric", 10)...)
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+
+	counts := map[string]int{}
+	for _, m := range inner.flushed {
+		counts[m.Name]++
+	}
+	assert.InDelta(t, 250, counts["noisy.metric"], 50, "about a quarter of the noisy series should be kept")
+	assert.Equal(t, 0, counts["noisy.off.metric"], "the longest matching pattern should win")
+	assert.Equal(t, 10, counts["quiet.metric"], "unmatched metrics should all be kept")
+
+	// the same series should be selected on every flush
+	kept := inner.flushed
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	assert.Equal(t, kept, inner.flushed)
+}
+
+func TestSamplingIgnoresTagOrder(t *testing.T) {
+	a := samplers.InterMetric{Name: "a", Tags: []string{"x:1", "y:2"}}
+	b := samplers.InterMetric{Name: "a", Tags: []string{"y:2", "x:1"}}
+	assert.Equal(t, seriesDigest(a), seriesDigest(b))
+	assert.Equal(t, []string{"y:2", "x:1"}, b.Tags, "the metric's tags shouldn't be reordered")
+}
+
+func TestInvalidRates(t *testing.T) {
+	inner, err := blackhole.NewBlackholeMetricSink()
+	require.NoError(t, err)
+	_, err = NewSamplingSink(inner, map[string]float64{"a.": 1.5})
+	assert.Error(t, err)
+	_, err = NewSamplingSink(inner, map[string]float64{"[": 0.5})
+	assert.Error(t, err)
+}
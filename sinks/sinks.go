@@ -2,6 +2,7 @@ package sinks
 
 import (
 	"context"
+	"errors"
 
 	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/ssf"
@@ -55,6 +56,47 @@ func IsAcceptableMetric(metric samplers.InterMetric, sink MetricSink) bool {
 	return metric.Sinks.RouteTo(sink.Name())
 }
 
+// FlushesSketches reports whether a sink can flush
+// samplers.SketchMetric metrics, i.e. whether it has a FlushesSketches
+// method returning true. Other sinks never receive sketches.
+func FlushesSketches(sink MetricSink) bool {
+	sketchSink, ok := sink.(interface{ FlushesSketches() bool })
+	return ok && sketchSink.FlushesSketches()
+}
+
+// WithoutSketches returns metrics without any samplers.SketchMetric, for
+// the sinks that can't flush them. metrics is returned as it is if it
+// holds no sketches, and left untouched otherwise.
+func WithoutSketches(metrics []samplers.InterMetric) []samplers.InterMetric {
+	for i, metric := range metrics {
+		if metric.Type != samplers.SketchMetric {
+			continue
+		}
+		without := make([]samplers.InterMetric, i, len(metrics))
+		copy(without, metrics[:i])
+		for _, metric := range metrics[i+1:] {
+			if metric.Type != samplers.SketchMetric {
+				without = append(without, metric)
+			}
+		}
+		return without
+	}
+	return metrics
+}
+
+// FailedMetrics returns the metrics out of metrics that a sink's Flush
+// failed to flush with err. Sinks that send metrics in batches can return
+// an error with a FailedMetrics method when only some batches failed, so
+// that the metrics that did go through aren't flushed again; otherwise,
+// all of metrics are taken to have failed.
+func FailedMetrics(err error, metrics []samplers.InterMetric) []samplers.InterMetric {
+	var partial interface{ FailedMetrics() []samplers.InterMetric }
+	if errors.As(err, &partial) {
+		return partial.FailedMetrics()
+	}
+	return metrics
+}
+
 // MetricKeySpanFlushDuration should be emitted as a timer by a SpanSink
 // if possible. Tagged with `sink:sink.Name()`. The `Flush` function is a great
 // place to do this. If your sync does async sends, this might not be necessary.
@@ -0,0 +1,99 @@
+package sinks
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TagKeyRewrite replaces every match of Pattern, a regular expression, in
+// a tag key with Replacement, which may refer to submatches like
+// regexp.Regexp.ReplaceAllString does.
+type TagKeyRewrite struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// TagNormalization configures a TagNormalizer.
+type TagNormalization struct {
+	// Lowercase lowercases every tag key.
+	Lowercase bool `yaml:"lowercase"`
+	// Rewrites are applied to every tag key, in order, after it's been
+	// lowercased.
+	Rewrites []TagKeyRewrite `yaml:"rewrites"`
+	// Drop lists reserved tag keys that are removed, after all the
+	// other rules have been applied.
+	Drop []string `yaml:"drop"`
+}
+
+// TagNormalizer rewrites tag keys to the conventions of a backend. Sinks
+// call Normalize in their conversion step, on the output of
+// samplers.ParseTagSliceToMap.
+type TagNormalizer struct {
+	lowercase bool
+	rewrites  []*regexp.Regexp
+	replaces  []string
+	drop      map[string]struct{}
+}
+
+// NewTagNormalizer compiles the rules of a TagNormalization.
+func NewTagNormalizer(rules TagNormalization) (*TagNormalizer, error) {
+	n := &TagNormalizer{
+		lowercase: rules.Lowercase,
+		drop:      make(map[string]struct{}, len(rules.Drop)),
+	}
+	for _, rewrite := range rules.Rewrites {
+		re, err := regexp.Compile(rewrite.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag key rewrite %q: %v", rewrite.Pattern, err)
+		}
+		n.rewrites = append(n.rewrites, re)
+		n.replaces = append(n.replaces, rewrite.Replacement)
+	}
+	for _, key := range rules.Drop {
+		n.drop[key] = struct{}{}
+	}
+	return n, nil
+}
+
+// Key returns the normalized form of a tag key, and whether the tag should
+// be kept at all.
+func (n *TagNormalizer) Key(key string) (string, bool) {
+	if n.lowercase {
+		key = strings.ToLower(key)
+	}
+	for i, re := range n.rewrites {
+		key = re.ReplaceAllString(key, n.replaces[i])
+	}
+	if _, ok := n.drop[key]; ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+// Normalize returns a copy of tags with normalized keys. If several keys
+// normalize to the same one, the value of the key that sorts first wins.
+// A nil TagNormalizer returns tags unchanged.
+func (n *TagNormalizer) Normalize(tags map[string]string) map[string]string {
+	if n == nil {
+		return tags
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	normalized := make(map[string]string, len(tags))
+	for _, k := range keys {
+		key, ok := n.Key(k)
+		if !ok {
+			continue
+		}
+		if _, exists := normalized[key]; !exists {
+			normalized[key] = tags[k]
+		}
+	}
+	return normalized
+}
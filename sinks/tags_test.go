@@ -0,0 +1,85 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestDatadogStyleNormalization(t *testing.T) {
+	// Datadog lowercases tag keys and only allows alphanumerics,
+	// underscores, minuses, colons, periods and slashes in them
+	n, err := NewTagNormalizer(TagNormalization{
+		Lowercase: true,
+		Rewrites:  []TagKeyRewrite{{Pattern: `[^a-z0-9_\-:./]`, Replacement: "_"}},
+		Drop:      []string{"host"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"availability-zone": "us-west-2a",
+		"service_name":      "api",
+		"k8s.pod":           "api-1",
+	}, n.Normalize(map[string]string{
+		"Availability-Zone": "us-west-2a",
+		"service name":      "api",
+		"k8s.pod":           "api-1",
+		"HOST":              "dropped",
+	}))
+}
+
+func TestPrometheusStyleNormalization(t *testing.T) {
+	// Prometheus label names must match [a-zA-Z_][a-zA-Z0-9_]*, and
+	// names starting with __ are reserved
+	n, err := NewTagNormalizer(TagNormalization{
+		Rewrites: []TagKeyRewrite{
+			{Pattern: `[^a-zA-Z0-9_]`, Replacement: "_"},
+			{Pattern: `^([0-9])`, Replacement: "_$1"},
+			{Pattern: `^__+`, Replacement: ""},
+		},
+		Drop: []string{"job", "instance"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"k8s_pod": "api-1",
+		"_2fa":    "on",
+		"name__":  "reserved",
+		"Method":  "GET",
+	}, n.Normalize(map[string]string{
+		"k8s.pod":  "api-1",
+		"2fa":      "on",
+		"__name__": "reserved",
+		"Method":   "GET",
+		"job":      "dropped",
+	}))
+}
+
+func TestNormalizationCollisions(t *testing.T) {
+	n, err := NewTagNormalizer(TagNormalization{Rewrites: []TagKeyRewrite{{Pattern: `\.`, Replacement: "_"}}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a_b": "dots"}, n.Normalize(map[string]string{"a_b": "underscores", "a.b": "dots"}),
+		"the key sorting first should win")
+}
+
+func TestNilNormalizer(t *testing.T) {
+	var n *TagNormalizer
+	tags := map[string]string{"A.b": "c"}
+	assert.Equal(t, tags, n.Normalize(tags))
+
+	_, err := NewTagNormalizer(TagNormalization{Rewrites: []TagKeyRewrite{{Pattern: "("}}})
+	assert.Error(t, err)
+}
+
+func TestWithoutSketches(t *testing.T) {
+	metrics := []samplers.InterMetric{
+		{Name: "a", Type: samplers.GaugeMetric},
+		{Name: "b", Type: samplers.SketchMetric},
+		{Name: "c", Type: samplers.CounterMetric},
+	}
+	assert.Equal(t, []samplers.InterMetric{metrics[0], metrics[2]}, WithoutSketches(metrics))
+	assert.Equal(t, "b", metrics[1].Name, "the metrics shouldn't be modified")
+	assert.Equal(t, metrics[:1], WithoutSketches(metrics[:1]))
+}
@@ -45,6 +45,28 @@ type Worker struct {
 	logger                *logrus.Logger
 	wm                    WorkerMetrics
 	stats                 scopedstatsd.Client
+	// pool interns the names and tags of every interval's metrics.
+	pool *stringPool
+
+	// LocalOnlyHistograms names the histograms and timers that are
+	// aggregated on this veneur, as if they had been sent with the
+	// veneurlocalonly tag, instead of being forwarded.
+	LocalOnlyHistograms map[string]struct{}
+
+	// MaxSampleAge, if set, is how old a sample's timestamp may be before
+	// the sample is dropped rather than aggregated. Samples without a
+	// timestamp are never dropped.
+	MaxSampleAge time.Duration
+	stale        int64
+
+	// Exemplars, if set, makes the worker keep the trace of the largest
+	// sample of every metric that was sent with a span that is part of
+	// a trace, so that e.g. a timer can be linked to its slowest span.
+	Exemplars bool
+
+	// now returns the current time. NewWorker sets it to time.Now, and
+	// only tests replace it.
+	now func() time.Time
 }
 
 // IngestUDP on a Worker feeds the metric into the worker's PacketChan.
@@ -81,6 +103,91 @@ type WorkerMetrics struct {
 	localSets         map[samplers.MetricKey]*samplers.Set
 	localTimers       map[samplers.MetricKey]*samplers.Histo
 	localStatusChecks map[samplers.MetricKey]*samplers.StatusCheck
+
+	// exemplars holds the trace of the largest sample of each metric
+	// that was sent with a span, if the worker keeps exemplars
+	exemplars map[samplers.MetricKey]exemplar
+
+	// units holds the unit each metric's samples were last sent with, if
+	// they were sent with one
+	units map[samplers.MetricKey]string
+
+	// pool interns the names and tags of the metrics, so that metrics
+	// sharing them share their storage too, rather than each holding the
+	// copy parsed from its first packet. It's the worker's, which keeps
+	// it across flushes, and nil for a WorkerMetrics of its own.
+	pool *stringPool
+}
+
+// maxInternedStrings caps the number of strings a stringPool holds per
+// interval, so that a burst of unique names or tags can't grow it without
+// bound. Strings past it aren't interned.
+const maxInternedStrings = 1 << 20
+
+// stringPool interns strings: it maps every string to the first equal
+// string it was given. It's kept across flushes, so that the metrics of
+// a series sent every interval share their name and tags with the
+// previous intervals' too. Strings that go a whole interval without
+// being interned are evicted.
+type stringPool struct {
+	current  map[string]string
+	previous map[string]string
+}
+
+func newStringPool() *stringPool {
+	return &stringPool{current: map[string]string{}}
+}
+
+// intern returns the string in the pool equal to s, adding s if there is
+// none.
+func (p *stringPool) intern(s string) string {
+	if p == nil {
+		return s
+	}
+	if interned, ok := p.current[s]; ok {
+		return interned
+	}
+	if interned, ok := p.previous[s]; ok {
+		p.current[s] = interned
+		return interned
+	}
+	if len(p.current) >= maxInternedStrings {
+		return s
+	}
+	p.current[s] = s
+	return s
+}
+
+// rotate starts a new interval, evicting the strings that weren't
+// interned during the last one.
+func (p *stringPool) rotate() {
+	if p == nil {
+		return
+	}
+	p.previous = p.current
+	p.current = map[string]string{}
+}
+
+// intern returns a metric's key and tags, with their name and tags
+// interned. Only the metrics being created are interned, so that the
+// samples of existing ones don't pay for it.
+func (wm WorkerMetrics) intern(mk samplers.MetricKey, tags []string) (samplers.MetricKey, []string) {
+	if wm.pool == nil {
+		// nothing to intern them with
+		return mk, tags
+	}
+	mk.Name = wm.pool.intern(mk.Name)
+	interned := make([]string, len(tags))
+	for i, tag := range tags {
+		interned[i] = wm.pool.intern(tag)
+	}
+	return mk, interned
+}
+
+// exemplar is the trace of a metric's sample, and the sample's value.
+type exemplar struct {
+	traceID int64
+	value   float64
 }
 
 // NewWorkerMetrics initializes a WorkerMetrics struct
@@ -98,6 +205,8 @@ func NewWorkerMetrics() WorkerMetrics {
 		localHistograms:   map[samplers.MetricKey]*samplers.Histo{},
 		localSets:         map[samplers.MetricKey]*samplers.Set{},
 		localTimers:       map[samplers.MetricKey]*samplers.Histo{},
+		exemplars:         map[samplers.MetricKey]exemplar{},
+		units:             map[samplers.MetricKey]string{},
 		localStatusChecks: map[samplers.MetricKey]*samplers.StatusCheck{},
 	}
 }
@@ -111,63 +220,76 @@ func (wm WorkerMetrics) Upsert(mk samplers.MetricKey, Scope samplers.MetricScope
 	case counterTypeName:
 		if Scope == samplers.GlobalOnly {
 			if _, present = wm.globalCounters[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.globalCounters[mk] = samplers.NewCounter(mk.Name, tags)
 			}
 		} else {
 			if _, present = wm.counters[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.counters[mk] = samplers.NewCounter(mk.Name, tags)
 			}
 		}
 	case gaugeTypeName:
 		if Scope == samplers.GlobalOnly {
 			if _, present = wm.globalGauges[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.globalGauges[mk] = samplers.NewGauge(mk.Name, tags)
 			}
 		} else {
 			if _, present = wm.gauges[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.gauges[mk] = samplers.NewGauge(mk.Name, tags)
 			}
 		}
 	case histogramTypeName:
 		if Scope == samplers.LocalOnly {
 			if _, present = wm.localHistograms[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.localHistograms[mk] = samplers.NewHist(mk.Name, tags)
 			}
 		} else if Scope == samplers.GlobalOnly {
 			if _, present = wm.globalHistograms[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.globalHistograms[mk] = samplers.NewHist(mk.Name, tags)
 			}
 		} else {
 			if _, present = wm.histograms[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.histograms[mk] = samplers.NewHist(mk.Name, tags)
 			}
 		}
 	case setTypeName:
 		if Scope == samplers.LocalOnly {
 			if _, present = wm.localSets[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.localSets[mk] = samplers.NewSet(mk.Name, tags)
 			}
 		} else {
 			if _, present = wm.sets[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.sets[mk] = samplers.NewSet(mk.Name, tags)
 			}
 		}
 	case timerTypeName:
 		if Scope == samplers.LocalOnly {
 			if _, present = wm.localTimers[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.localTimers[mk] = samplers.NewHist(mk.Name, tags)
 			}
 		} else if Scope == samplers.GlobalOnly {
 			if _, present = wm.globalTimers[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.globalTimers[mk] = samplers.NewHist(mk.Name, tags)
 			}
 		} else {
 			if _, present = wm.timers[mk]; !present {
+				mk, tags = wm.intern(mk, tags)
 				wm.timers[mk] = samplers.NewHist(mk.Name, tags)
 			}
 		}
 	case statusTypeName:
 		if _, present = wm.localStatusChecks[mk]; !present {
+			mk, tags = wm.intern(mk, tags)
 			wm.localStatusChecks[mk] = samplers.NewStatusCheck(mk.Name, tags)
 		}
 		// no need to raise errors on unknown types
@@ -240,6 +362,9 @@ func (wm WorkerMetrics) appendExportedMetric(res []*metricpb.Metric, exp metricE
 
 // NewWorker creates, and returns a new Worker object.
 func NewWorker(id int, isLocal bool, countUniqueTimeseries bool, cl *trace.Client, logger *logrus.Logger, stats scopedstatsd.Client) *Worker {
+	pool := newStringPool()
+	wm := NewWorkerMetrics()
+	wm.pool = pool
 	return &Worker{
 		id:                    id,
 		isLocal:               isLocal,
@@ -255,8 +380,10 @@ func NewWorker(id int, isLocal bool, countUniqueTimeseries bool, cl *trace.Clien
 		mutex:                 &sync.Mutex{},
 		traceClient:           cl,
 		logger:                logger,
-		wm:                    NewWorkerMetrics(),
+		wm:                    wm,
 		stats:                 scopedstatsd.Ensure(stats),
+		pool:                  pool,
+		now:                   time.Now,
 	}
 }
 
@@ -295,6 +422,41 @@ func (w *Worker) MetricsProcessedCount() int64 {
 	return w.processed
 }
 
+// WorkerStats is a snapshot of how busy a Worker is.
+type WorkerStats struct {
+	// QueueLength is the number of metrics waiting in the Worker's
+	// PacketChan.
+	QueueLength int
+	// ImportQueueLength is the number of batches of imported metrics
+	// waiting in the Worker's ImportChan and ImportMetricChan.
+	ImportQueueLength int
+	// Processed and Imported are the number of metrics the Worker has
+	// processed and imported since it was last flushed.
+	Processed int64
+	Imported  int64
+	// Stale is the number of samples that were dropped for being older
+	// than MaxSampleAge since the Worker was last flushed.
+	Stale int64
+}
+
+// Stats returns a snapshot of the Worker's queues and counters.
+func (w *Worker) Stats() WorkerStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.snapshotStats()
+}
+
+// snapshotStats is Stats, for callers that already hold the mutex.
+func (w *Worker) snapshotStats() WorkerStats {
+	return WorkerStats{
+		QueueLength:       len(w.PacketChan),
+		ImportQueueLength: len(w.ImportChan) + len(w.ImportMetricChan),
+		Processed:         w.processed,
+		Imported:          w.imported,
+		Stale:             w.stale,
+	}
+}
+
 // SampleTimeseries takes a metric and counts whether the timeseries
 // has already been seen by the worker in this flush interval.
 func (w *Worker) SampleTimeseries(m *samplers.UDPMetric) {
@@ -344,40 +506,69 @@ func (w *Worker) SampleTimeseries(m *samplers.UDPMetric) {
 func (w *Worker) ProcessMetric(m *samplers.UDPMetric) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
+	w.processMetric(m)
+}
+
+// ProcessMetrics samples a batch of metrics, holding the worker's lock only
+// once for the whole batch.
+func (w *Worker) ProcessMetrics(ms []*samplers.UDPMetric) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, m := range ms {
+		w.processMetric(m)
+	}
+}
+
+// processMetric samples a metric. The caller must hold the mutex.
+func (w *Worker) processMetric(m *samplers.UDPMetric) {
+	if w.isStale(m) {
+		w.stale++
+		return
+	}
 	w.processed++
-	w.wm.Upsert(m.MetricKey, m.Scope, m.Tags)
+	scope := w.scope(m)
+	w.wm.Upsert(m.MetricKey, scope, m.Tags)
+	if m.Unit != "" {
+		w.wm.units[m.MetricKey] = m.Unit
+	}
+	if w.Exemplars && m.TraceID != 0 {
+		// sets and status checks have no value to compare
+		if v, ok := m.Value.(float64); ok {
+			w.wm.keepExemplar(m.MetricKey, m.TraceID, v)
+		}
+	}
 
 	switch m.Type {
 	case counterTypeName:
-		if m.Scope == samplers.GlobalOnly {
+		if scope == samplers.GlobalOnly {
 			w.wm.globalCounters[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		} else {
 			w.wm.counters[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		}
 	case gaugeTypeName:
-		if m.Scope == samplers.GlobalOnly {
+		if scope == samplers.GlobalOnly {
 			w.wm.globalGauges[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		} else {
 			w.wm.gauges[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		}
 	case histogramTypeName:
-		if m.Scope == samplers.LocalOnly {
+		if scope == samplers.LocalOnly {
 			w.wm.localHistograms[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
-		} else if m.Scope == samplers.GlobalOnly {
+		} else if scope == samplers.GlobalOnly {
 			w.wm.globalHistograms[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		} else {
 			w.wm.histograms[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		}
 	case setTypeName:
-		if m.Scope == samplers.LocalOnly {
+		if scope == samplers.LocalOnly {
 			w.wm.localSets[m.MetricKey].Sample(m.Value.(string))
 		} else {
 			w.wm.sets[m.MetricKey].Sample(m.Value.(string))
 		}
 	case timerTypeName:
-		if m.Scope == samplers.LocalOnly {
+		if scope == samplers.LocalOnly {
 			w.wm.localTimers[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
-		} else if m.Scope == samplers.GlobalOnly {
+		} else if scope == samplers.GlobalOnly {
 			w.wm.globalTimers[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		} else {
 			w.wm.timers[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
@@ -390,6 +581,26 @@ func (w *Worker) ProcessMetric(m *samplers.UDPMetric) {
 	}
 }
 
+// isStale reports whether a sample is older than MaxSampleAge.
+func (w *Worker) isStale(m *samplers.UDPMetric) bool {
+	if w.MaxSampleAge <= 0 || m.Timestamp == 0 {
+		return false
+	}
+	return w.now().Sub(time.Unix(m.Timestamp, 0)) > w.MaxSampleAge
+}
+
+// scope returns the scope a metric is sampled with: its own, unless it's a
+// histogram or timer listed in LocalOnlyHistograms.
+func (w *Worker) scope(m *samplers.UDPMetric) samplers.MetricScope {
+	if m.Scope != samplers.MixedScope || (m.Type != histogramTypeName && m.Type != timerTypeName) {
+		return m.Scope
+	}
+	if _, ok := w.LocalOnlyHistograms[m.Name]; ok {
+		return samplers.LocalOnly
+	}
+	return m.Scope
+}
+
 // ImportMetric receives a metric from another veneur instance
 func (w *Worker) ImportMetric(other samplers.JSONMetric) {
 	w.mutex.Lock()
@@ -494,24 +705,59 @@ func (w *Worker) ImportMetricGRPC(other *metricpb.Metric) (err error) {
 	return err
 }
 
+// keepExemplar keeps a sample's trace as the metric's exemplar, if the
+// sample is the largest of the metric so far.
+func (wm WorkerMetrics) keepExemplar(mk samplers.MetricKey, traceID int64, value float64) {
+	if e, ok := wm.exemplars[mk]; ok && e.value >= value {
+		return
+	}
+	wm.exemplars[mk] = exemplar{traceID: traceID, value: value}
+}
+
+// withExemplar sets the metric's exemplar, if it has one, on the metrics
+// its sampler flushed.
+func (wm WorkerMetrics) withExemplar(mk samplers.MetricKey, metrics []samplers.InterMetric) []samplers.InterMetric {
+	if e, ok := wm.exemplars[mk]; ok {
+		for i := range metrics {
+			metrics[i].Exemplar = e.traceID
+		}
+	}
+	return metrics
+}
+
 // Flush resets the worker's internal metrics and returns their contents.
 func (w *Worker) Flush() WorkerMetrics {
 	// This is a critical spot. The worker can't process metrics while this
 	// mutex is held! So we try and minimize it by copying the maps of values
 	// and assigning new ones.
 	wm := NewWorkerMetrics()
+	wm.pool = w.pool
 	w.mutex.Lock()
 	ret := w.wm
-	processed := w.processed
-	imported := w.imported
+	stats := w.snapshotStats()
 
 	w.wm = wm
+	w.pool.rotate()
+	// the pool stays with the worker, which goes on interning into it
+	// while the flushed metrics are merged and sent
+	ret.pool = nil
 	w.processed = 0
 	w.imported = 0
+	w.stale = 0
 	w.mutex.Unlock()
 
-	w.stats.Count("worker.metrics_processed_total", processed, []string{}, 1.0)
-	w.stats.Count("worker.metrics_imported_total", imported, []string{}, 1.0)
+	w.stats.Count("worker.metrics_processed_total", stats.Processed, []string{}, 1.0)
+	w.stats.Count("worker.metrics_imported_total", stats.Imported, []string{}, 1.0)
+	if stats.Stale > 0 {
+		w.stats.Count("worker.metrics_stale_dropped_total", stats.Stale, []string{}, 1.0)
+	}
+
+	// The per-worker gauges let us tell whether a single worker is
+	// saturated before its queue fills up and we start dropping metrics.
+	workerTags := []string{fmt.Sprintf("worker:%d", w.id)}
+	w.stats.Gauge("worker.packet_chan_length", float64(stats.QueueLength), workerTags, 1.0)
+	w.stats.Gauge("worker.import_chan_length", float64(stats.ImportQueueLength), workerTags, 1.0)
+	w.stats.Gauge("worker.metrics_processed", float64(stats.Processed), workerTags, 1.0)
 
 	return ret
 }
@@ -523,6 +769,75 @@ func (w *Worker) Stop() {
 	close(w.QuitChan)
 }
 
+// WorkerPool shards metrics across a set of Workers. Metrics are routed by
+// their digest, so that all samples of a timeseries end up in the same
+// Worker and are aggregated together.
+type WorkerPool []*Worker
+
+// NewWorkerPool creates n Workers, numbered from 1, and returns them as a
+// pool. The workers aren't started. A pool needs at least one Worker.
+func NewWorkerPool(n int, isLocal bool, countUniqueTimeseries bool, cl *trace.Client, logger *logrus.Logger, stats scopedstatsd.Client) (WorkerPool, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("a worker pool needs at least one worker, got %d", n)
+	}
+	wp := make(WorkerPool, n)
+	for i := range wp {
+		wp[i] = NewWorker(i+1, isLocal, countUniqueTimeseries, cl, logger, stats)
+	}
+	return wp, nil
+}
+
+// WorkerIndex returns the index of the Worker responsible for the
+// timeseries with the given digest. The pool must not be empty.
+func (wp WorkerPool) WorkerIndex(digest uint32) int {
+	return int(digest % uint32(len(wp)))
+}
+
+// IngestUDP feeds the metric into the PacketChan of the Worker
+// responsible for it.
+func (wp WorkerPool) IngestUDP(metric samplers.UDPMetric) {
+	wp[wp.WorkerIndex(metric.Digest)].IngestUDP(metric)
+}
+
+// Flush flushes every Worker in the pool at the same time, and returns
+// their contents, in the order of the pool's Workers, along with the
+// number of metrics of every kind they hold together.
+func (wp WorkerPool) Flush() ([]WorkerMetrics, metricsSummary) {
+	wms := make([]WorkerMetrics, len(wp))
+	var wg sync.WaitGroup
+	for i, w := range wp {
+		wg.Add(1)
+		go func(i int, w *Worker) {
+			defer wg.Done()
+			log.WithField("worker", i).Debug("Flushing")
+			wms[i] = w.Flush()
+		}(i, w)
+	}
+	wg.Wait()
+
+	ms := metricsSummary{}
+	for _, wm := range wms {
+		ms.totalCounters += len(wm.counters)
+		ms.totalGauges += len(wm.gauges)
+		ms.totalHistograms += len(wm.histograms)
+		ms.totalSets += len(wm.sets)
+		ms.totalTimers += len(wm.timers)
+
+		ms.totalGlobalCounters += len(wm.globalCounters)
+		ms.totalGlobalGauges += len(wm.globalGauges)
+		ms.totalGlobalHistograms += len(wm.globalHistograms)
+		ms.totalGlobalTimers += len(wm.globalTimers)
+
+		ms.totalLocalHistograms += len(wm.localHistograms)
+		ms.totalLocalSets += len(wm.localSets)
+		ms.totalLocalTimers += len(wm.localTimers)
+
+		ms.totalLocalStatusChecks += len(wm.localStatusChecks)
+	}
+
+	return wms, ms
+}
+
 // EventWorker is similar to a Worker but it collects events and service checks instead of metrics.
 type EventWorker struct {
 	sampleChan  chan ssf.SSFSample
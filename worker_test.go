@@ -1,10 +1,14 @@
 package veneur
 
 import (
+	"fmt"
+	"reflect"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/stripe/veneur/sinks"
 	"github.com/stripe/veneur/ssf"
@@ -16,6 +20,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/samplers/metricpb"
+	"github.com/stripe/veneur/scopedstatsd"
 )
 
 func TestWorker(t *testing.T) {
@@ -39,6 +44,131 @@ func TestWorker(t *testing.T) {
 	assert.Len(t, nometrics.counters, 0, "Should flush no metrics")
 }
 
+func TestWorkerPoolRoutesByDigest(t *testing.T) {
+	wp, err := NewWorkerPool(4, true, false, nil, logrus.New(), nil)
+	require.NoError(t, err)
+	require.Len(t, wp, 4)
+
+	m := samplers.UDPMetric{
+		MetricKey: samplers.MetricKey{
+			Name: "a.b.c",
+			Type: "counter",
+		},
+		Value:      1.0,
+		Digest:     12345,
+		SampleRate: 1.0,
+	}
+	idx := wp.WorkerIndex(m.Digest)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, idx, wp.WorkerIndex(m.Digest), "the same digest should always map to the same worker")
+		wp.IngestUDP(m)
+	}
+	for i, w := range wp {
+		if i == idx {
+			assert.Len(t, w.PacketChan, 10)
+		} else {
+			assert.Len(t, w.PacketChan, 0)
+		}
+	}
+}
+
+func TestWorkerPoolFlush(t *testing.T) {
+	wp, err := NewWorkerPool(2, true, false, nil, logrus.New(), nil)
+	require.NoError(t, err)
+	for digest := uint32(0); digest < 4; digest++ {
+		wp[wp.WorkerIndex(digest)].ProcessMetric(&samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{
+				Name: "a.b.c",
+				Type: "counter",
+				// distinct keys, so that every metric is its own series
+				JoinedTags: strings.Repeat("x", int(digest)),
+			},
+			Value:      1.0,
+			Digest:     digest,
+			SampleRate: 1.0,
+		})
+	}
+
+	wms, ms := wp.Flush()
+	require.Len(t, wms, 2)
+	assert.Len(t, wms[0].counters, 2)
+	assert.Len(t, wms[1].counters, 2)
+	assert.Equal(t, 4, ms.totalCounters, "the workers' metrics should be counted together")
+	wms, ms = wp.Flush()
+	for _, wm := range wms {
+		assert.Len(t, wm.counters, 0, "flushing should reset the workers")
+	}
+	assert.Zero(t, ms.totalCounters)
+}
+
+func TestNewWorkerPoolEmpty(t *testing.T) {
+	_, err := NewWorkerPool(0, true, false, nil, logrus.New(), nil)
+	assert.Error(t, err)
+}
+
+// gaugeRecorder is a statsd client that remembers the last value of every
+// gauge.
+type gaugeRecorder struct {
+	scopedstatsd.Client
+	gauges map[string]float64
+}
+
+func (gr *gaugeRecorder) Gauge(name string, value float64, tags []string, rate float64) error {
+	gr.gauges[name+"|"+strings.Join(tags, ",")] = value
+	return nil
+}
+
+func TestWorkerStats(t *testing.T) {
+	stats := &gaugeRecorder{Client: scopedstatsd.Ensure(nil), gauges: map[string]float64{}}
+	w := NewWorker(3, true, false, nil, logrus.New(), stats)
+
+	m := samplers.UDPMetric{
+		MetricKey: samplers.MetricKey{
+			Name: "a.b.c",
+			Type: "counter",
+		},
+		Value:      1.0,
+		Digest:     12345,
+		SampleRate: 1.0,
+	}
+	w.ProcessMetric(&m)
+	w.ProcessMetric(&m)
+	w.IngestUDP(m)
+
+	assert.Equal(t, WorkerStats{QueueLength: 1, Processed: 2}, w.Stats())
+
+	w.Flush()
+	assert.Equal(t, float64(1), stats.gauges["worker.packet_chan_length|worker:3"])
+	assert.Equal(t, float64(0), stats.gauges["worker.import_chan_length|worker:3"])
+	assert.Equal(t, float64(2), stats.gauges["worker.metrics_processed|worker:3"])
+	assert.Equal(t, int64(0), w.Stats().Processed, "flushing should reset the processed count")
+}
+
+func TestWorkerProcessMetrics(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+
+	ms := []*samplers.UDPMetric{
+		{
+			MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: "counter"},
+			Value:      1.0,
+			Digest:     12345,
+			SampleRate: 1.0,
+		},
+		{
+			MetricKey:  samplers.MetricKey{Name: "a.b.d", Type: "gauge"},
+			Value:      2.0,
+			Digest:     12346,
+			SampleRate: 1.0,
+		},
+	}
+	w.ProcessMetrics(ms)
+	assert.Equal(t, int64(2), w.MetricsProcessedCount())
+
+	wm := w.Flush()
+	assert.Len(t, wm.counters, 1)
+	assert.Len(t, wm.gauges, 1)
+}
+
 func TestWorkerLocal(t *testing.T) {
 	w := NewWorker(1, true, false, nil, logrus.New(), nil)
 
@@ -59,6 +189,69 @@ func TestWorkerLocal(t *testing.T) {
 	assert.Len(t, wm.histograms, 0, "number of global histograms")
 }
 
+func TestWorkerLocalOnlyHistograms(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.LocalOnlyHistograms = map[string]struct{}{"a.b.c": {}}
+
+	for _, name := range []string{"a.b.c", "a.b.d"} {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{
+				Name: name,
+				Type: "histogram",
+			},
+			Value:      1.0,
+			Digest:     12345,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+
+	wm := w.Flush()
+	assert.Len(t, wm.localHistograms, 1, "number of local histograms")
+	assert.Len(t, wm.histograms, 1, "number of mixed histograms")
+
+	forwarded := wm.ForwardableMetrics(nil)
+	require.Len(t, forwarded, 1, "only the other histogram should be forwarded")
+	assert.Equal(t, "a.b.d", forwarded[0].Name)
+}
+
+func TestWorkerMaxSampleAge(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.MaxSampleAge = time.Minute
+	now := time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	for _, ts := range []int64{0, now.Add(-time.Minute).Unix(), now.Add(-time.Minute - time.Second).Unix()} {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{
+				Name: "a.b.c",
+				Type: "gauge",
+			},
+			Value:      1.0,
+			Digest:     12345,
+			SampleRate: 1.0,
+			Timestamp:  ts,
+		})
+	}
+
+	stats := w.Stats()
+	assert.Equal(t, int64(2), stats.Processed, "samples without a timestamp or recent ones should be processed")
+	assert.Equal(t, int64(1), stats.Stale, "the sample just over a minute old should be dropped")
+	w.Flush()
+	assert.Zero(t, w.Stats().Stale, "flushing should reset the count of stale samples")
+}
+
+func TestWorkerMaxSampleAgeOff(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: "gauge"},
+		Value:      1.0,
+		SampleRate: 1.0,
+		Timestamp:  time.Now().Add(-24 * time.Hour).Unix(),
+	})
+	assert.Equal(t, int64(1), w.Stats().Processed, "samples shouldn't be dropped by default")
+}
+
 func TestWorkerGlobal(t *testing.T) {
 	w := NewWorker(1, false, false, nil, logrus.New(), nil)
 
@@ -503,6 +696,104 @@ func TestGlobalWorkerSampleTimeseries(t *testing.T) {
 	assert.Equal(t, uint64(2), w.uniqueMTS.Estimate())
 }
 
+// stringData returns the address of the bytes of s.
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
+func TestWorkerMetricsInterning(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	process := func(packets ...string) {
+		for _, packet := range packets {
+			m, err := samplers.ParseMetric([]byte(packet))
+			require.NoError(t, err)
+			w.ProcessMetric(m)
+		}
+	}
+	process(
+		"a.b.c:1|c|#env:prod,host:a",
+		"a.b.c:1|c|#env:prod,host:b",
+		"a.b.c:1|g|#env:prod,host:a",
+		"d.e.f:1|c|#env:prod,host:a",
+	)
+	// a.b.c, d.e.f, env:prod, host:a and host:b
+	assert.Len(t, w.pool.current, 5, "every name and tag should be interned once")
+
+	key := samplers.MetricKey{Name: "a.b.c", Type: counterTypeName, JoinedTags: "env:prod,host:b"}
+	first := w.Flush().counters[key]
+	require.NotNil(t, first)
+	assert.Equal(t, []string{"env:prod", "host:b"}, first.Tags)
+
+	process("a.b.c:1|c|#env:prod,host:b")
+	second := w.Flush().counters[key]
+	require.NotNil(t, second)
+	assert.Equal(t, stringData(first.Name), stringData(second.Name),
+		"the name should be shared with the previous interval's metric")
+	assert.Equal(t, stringData(first.Tags[1]), stringData(second.Tags[1]),
+		"the tags should be shared with the previous interval's metric")
+	assert.Len(t, w.pool.previous, 3, "strings that weren't interned last interval should be evicted")
+
+	w.Flush()
+	assert.Empty(t, w.pool.previous)
+	assert.Empty(t, w.pool.current)
+}
+
+// BenchmarkWorkerMetricsHeap reports the heap a worker holds on to over
+// several flush intervals' worth of series, parsed from packets as they
+// would be: a few hundred names, each sent from many hosts with a handful
+// of common tags, every interval. The interval that was just flushed is
+// held on to, as it is while the flush sends it. The series are
+// counters, whose names and tags take up most of their heap; the digests
+// of histograms and timers dwarf them.
+func BenchmarkWorkerMetricsHeap(b *testing.B) {
+	const names, hosts, intervals = 200, 50, 5
+	packets := make([][]byte, 0, names*hosts)
+	for host := 0; host < hosts; host++ {
+		for name := 0; name < names; name++ {
+			packets = append(packets, []byte(fmt.Sprintf(
+				"service.component%d.requests_total:1|c|#env:production,region:us-east-1,service:api,host:i-%08x",
+				name, host)))
+		}
+	}
+
+	for _, interned := range []bool{false, true} {
+		b.Run(fmt.Sprintf("interned=%v", interned), func(b *testing.B) {
+			var heap uint64
+			for i := 0; i < b.N; i++ {
+				w := NewWorker(1, true, false, nil, logrus.New(), nil)
+				if !interned {
+					w.pool = nil
+					w.wm.pool = nil
+				}
+				var (
+					before, after runtime.MemStats
+					flushed       WorkerMetrics
+				)
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				for interval := 0; interval < intervals; interval++ {
+					if interval > 0 {
+						flushed = w.Flush()
+					}
+					for _, packet := range packets {
+						m, err := samplers.ParseMetric(packet)
+						if err != nil {
+							b.Fatal(err)
+						}
+						w.ProcessMetric(m)
+					}
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				heap += after.HeapAlloc - before.HeapAlloc
+				runtime.KeepAlive(w)
+				runtime.KeepAlive(flushed)
+			}
+			b.ReportMetric(float64(heap)/float64(b.N)/float64(len(packets)), "heap-bytes/series")
+		})
+	}
+}
+
 func BenchmarkWork(b *testing.B) {
 	w := NewWorker(1, true, false, nil, logrus.New(), nil)
 
@@ -625,3 +916,39 @@ func BenchmarkSampleTimeseries(b *testing.B) {
 		w.SampleTimeseries(input[i%Len])
 	}
 }
+
+func benchmarkMetrics(n int) []*samplers.UDPMetric {
+	ms := make([]*samplers.UDPMetric, n)
+	for i := range ms {
+		ms[i] = &samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{
+				Name: "a.b.c",
+				Type: "counter",
+			},
+			Value:      1.0,
+			Digest:     12345,
+			SampleRate: 1.0,
+		}
+	}
+	return ms
+}
+
+func BenchmarkWorkerProcessMetric(b *testing.B) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	ms := benchmarkMetrics(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, m := range ms {
+			w.ProcessMetric(m)
+		}
+	}
+}
+
+func BenchmarkWorkerProcessMetrics(b *testing.B) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	ms := benchmarkMetrics(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.ProcessMetrics(ms)
+	}
+}